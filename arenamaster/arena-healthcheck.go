@@ -1,13 +1,13 @@
 package arenamaster
 
 import (
+	"context"
 	"fmt"
 	"sync"
 	"time"
 
 	"github.com/xtuc/schaloop"
 
-	"github.com/bytearena/core/common/types"
 	"github.com/bytearena/core/common/utils"
 	"github.com/bytearena/backends/common/mq"
 )
@@ -17,6 +17,11 @@ const (
 	HEALTHCHECK_FREQ     = 5 * time.Second
 )
 
+var (
+	// The replies of the arenas are collected for that long
+	HEALTHCHECK_TIMEOUT = 3 * time.Second
+)
+
 func timerToGeneric(old *time.Ticker) chan interface{} {
 	new := make(chan interface{})
 	go func() {
@@ -36,17 +41,17 @@ type ArenaHealthCheck struct {
 	gameHealthcheckRes Res
 	ticker             *time.Ticker
 	mutex              sync.Mutex
-	mqclient           *mq.Client
+	rpc                *mq.RPC
 
 	lastSeen LastSeenNodes
 	cache    MemorizedHealtchecks
 }
 
-func NewArenaHealthcheck(gameHealthcheckRes Res, mqclient *mq.Client) *ArenaHealthCheck {
+func NewArenaHealthcheck(rpc *mq.RPC) *ArenaHealthCheck {
 
 	instance := &ArenaHealthCheck{
-		gameHealthcheckRes: gameHealthcheckRes,
-		mqclient:           mqclient,
+		gameHealthcheckRes: make(Res),
+		rpc:                rpc,
 
 		cache:    make(MemorizedHealtchecks),
 		lastSeen: make(LastSeenNodes),
@@ -64,54 +69,108 @@ func (s *ArenaHealthCheck) StartChecks(eventloop *schaloop.EventLoop) {
 
 func (s *ArenaHealthCheck) startTicker(eventloop *schaloop.EventLoop) {
 	eventloop.QueueWorkFromChannel("healtcheck-ticker", timerToGeneric(s.ticker), func(data interface{}) {
-		err := s.mqclient.Publish("game", "healthcheck", types.MQPayload{})
+		go s.gather()
+	})
+}
+
+// gather broadcasts a healthcheck and passes the replies to the consumer
+func (s *ArenaHealthCheck) gather() {
+	ctx, cancel := context.WithTimeout(context.Background(), HEALTHCHECK_TIMEOUT)
+	defer cancel()
+
+	request, err := mq.EncodeMessage(MASTER_COMPONENT, "game", "healthcheck", &mq.HealthcheckRequest{})
+
+	if err != nil {
+		utils.RecoverableError("healtcheck", "error: "+err.Error())
+		return
+	}
+
+	replies, err := s.rpc.Gather(ctx, "game", "healthcheck", request, 0)
+
+	if err != nil {
+		utils.RecoverableError("healtcheck", "error: "+err.Error())
+		return
+	}
+
+	s.expire(time.Now())
+
+	for _, reply := range replies {
+		if reply.Error != "" {
+			utils.RecoverableError("healthcheck", reply.Responder+" failed: "+reply.Error)
+			continue
+		}
+
+		msg, err := mq.DecodeMessage("game", "healthcheck-res", reply.Payload)
 
 		if err != nil {
-			utils.RecoverableError("healtcheck", "error: "+err.Error())
-		} else {
-
-			// Check last seen nodes
-			now := time.Now()
-			for k, date := range s.GetLastSeen() {
-				if now.Sub(date) >= TIME_AFTER_UNHEALTHY {
-					s.mutex.Lock()
-					s.cache[k] = false
-					s.mutex.Unlock()
-				}
-			}
+			utils.RecoverableError("healthcheck", "Invalid reply from "+reply.Responder+": "+err.Error())
+			continue
 		}
-	})
+
+		// An arena can't report the health of another one
+		if arenaId := msg.(*mq.GameHealth).Id; reply.Signer != "" && reply.Signer != mq.ARENA_SERVER_COMPONENT_PREFIX+arenaId {
+			utils.RecoverableError("healthcheck", reply.Signer+" can't report the health of arena "+arenaId)
+			continue
+		}
+
+		s.gameHealthcheckRes <- msg
+	}
 }
 
 func (s *ArenaHealthCheck) startConsumer(eventloop *schaloop.EventLoop) {
 
 	eventloop.QueueWorkFromChannel("healtcheck-consumer", resToGeneric(s.gameHealthcheckRes), func(data interface{}) {
-		msg := data.(types.MQMessage)
+		s.record(data.(*mq.GameHealth), time.Now())
+	})
+}
 
-		mac, _ := (*msg.Payload)["id"].(string)
-		res, _ := (*msg.Payload)["health"].(string)
+// expire marks the arenas which didn't reply for too long as unhealthy
+func (s *ArenaHealthCheck) expire(now time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 
-		utils.Debug("healthcheck", fmt.Sprintf("Arena %s reported health %s", mac, res))
+	for k, date := range s.lastSeen {
+		if now.Sub(date) >= TIME_AFTER_UNHEALTHY {
+			s.cache[k] = false
+		}
+	}
+}
 
-		s.mutex.Lock()
+func (s *ArenaHealthCheck) record(msg *mq.GameHealth, now time.Time) {
+	arenaId, res := msg.Id, msg.Health
 
-		s.lastSeen[mac] = time.Now()
+	utils.Debug("healthcheck", fmt.Sprintf("Arena %s reported health %s", arenaId, res))
 
-		if res == "NOK" {
-			s.cache[mac] = false
-		} else {
-			s.cache[mac] = true
-		}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 
-		s.mutex.Unlock()
-	})
+	s.lastSeen[arenaId] = now
+	s.cache[arenaId] = res != "NOK"
 }
 
-// We want to copy here
-func (s ArenaHealthCheck) GetCache() MemorizedHealtchecks {
-	return s.cache
+// GetCache returns a copy, the healthchecks keep updating it
+func (s *ArenaHealthCheck) GetCache() MemorizedHealtchecks {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	res := make(MemorizedHealtchecks, len(s.cache))
+
+	for k, v := range s.cache {
+		res[k] = v
+	}
+
+	return res
 }
 
-func (s ArenaHealthCheck) GetLastSeen() LastSeenNodes {
-	return s.lastSeen
+func (s *ArenaHealthCheck) GetLastSeen() LastSeenNodes {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	res := make(LastSeenNodes, len(s.lastSeen))
+
+	for k, v := range s.lastSeen {
+		res[k] = v
+	}
+
+	return res
 }
@@ -0,0 +1,138 @@
+package arenamaster
+
+import (
+	"encoding/json"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	coremq "github.com/bytearena/core/common/mq"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bytearena/backends/common/mq"
+)
+
+// loopbackBroker delivers the messages to the subscribers of the process
+type loopbackBroker struct {
+	mq.Broker
+
+	mutex         sync.Mutex
+	subscriptions map[string][]coremq.SubscriptionCallback
+}
+
+func newLoopbackBroker() *loopbackBroker {
+	return &loopbackBroker{
+		subscriptions: make(map[string][]coremq.SubscriptionCallback),
+	}
+}
+
+func (b *loopbackBroker) Subscribe(channel, topic string, onmessage coremq.SubscriptionCallback) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	key := channel + "." + topic
+	b.subscriptions[key] = append(b.subscriptions[key], onmessage)
+
+	return nil
+}
+
+func (b *loopbackBroker) SubscribeVerified(channel, topic string, onmessage mq.VerifiedCallback) error {
+	return b.Subscribe(channel, topic, func(msg coremq.BrokerMessage) {
+		onmessage(msg, "")
+	})
+}
+
+func (b *loopbackBroker) Publish(channel, topic string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+
+	if err != nil {
+		return err
+	}
+
+	b.mutex.Lock()
+	callbacks := b.subscriptions[channel+"."+topic]
+	b.mutex.Unlock()
+
+	for _, callback := range callbacks {
+		go callback(coremq.BrokerMessage{
+			Channel: channel,
+			Topic:   topic,
+			Data:    data,
+		})
+	}
+
+	return nil
+}
+
+func TestConcurrentHealthchecks(t *testing.T) {
+	defer func(timeout time.Duration) { HEALTHCHECK_TIMEOUT = timeout }(HEALTHCHECK_TIMEOUT)
+	HEALTHCHECK_TIMEOUT = 50 * time.Millisecond
+
+	broker := newLoopbackBroker()
+
+	masterRPC, err := mq.NewRPC(broker, MASTER_COMPONENT)
+	assert.Nil(t, err)
+
+	for i := 0; i < 3; i++ {
+		arenaId := "arena-" + strconv.Itoa(i)
+
+		arenaRPC, err := mq.NewRPC(broker, mq.ARENA_SERVER_COMPONENT_PREFIX+arenaId)
+		assert.Nil(t, err)
+
+		assert.Nil(t, arenaRPC.Handle("game", "healthcheck", func(payload json.RawMessage) (interface{}, error) {
+			return mq.EncodeMessage(mq.ARENA_SERVER_COMPONENT_PREFIX+arenaId, "game", "healthcheck-res", &mq.GameHealth{
+				Id:     arenaId,
+				Health: "OK",
+			})
+		}))
+	}
+
+	healthchecks := NewArenaHealthcheck(masterRPC)
+
+	// Stands for the eventloop
+	done := make(chan struct{})
+	consumed := make(chan struct{})
+
+	go func() {
+		defer close(consumed)
+
+		for {
+			select {
+			case msg := <-healthchecks.gameHealthcheckRes:
+				healthchecks.record(msg.(*mq.GameHealth), time.Now().Add(-TIME_AFTER_UNHEALTHY))
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 5; i++ {
+		wg.Add(2)
+
+		go func() {
+			defer wg.Done()
+			healthchecks.gather()
+		}()
+
+		go func() {
+			defer wg.Done()
+
+			for arenaId := range healthchecks.GetLastSeen() {
+				healthchecks.GetCache()[arenaId] = true
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(done)
+	<-consumed
+
+	// The copies didn't change the healthchecks
+	healthchecks.expire(time.Now())
+
+	assert.Len(t, healthchecks.GetLastSeen(), 3)
+	assert.Equal(t, MemorizedHealtchecks{"arena-0": false, "arena-1": false, "arena-2": false}, healthchecks.GetCache())
+}
@@ -0,0 +1,64 @@
+package arenamaster
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+
+	uuid "github.com/satori/go.uuid"
+
+	"github.com/bytearena/backends/common/mq"
+)
+
+// replyError answers a message which could not be handled on <topic>-error
+// of the same channel, the message is sent back
+func (server *Server) replyError(channel, topic string, msg mq.Message, reason string) {
+	data, _ := json.Marshal(msg)
+
+	mq.Reject(server.brokerclient, channel, topic, string(data), reason)
+}
+
+// findArena returns the id of the VM running the arena-server which sent a
+// message, the arena id must be a known UUID
+func (server *Server) findArena(arenaId string) (int, error) {
+	if arenaId == "" {
+		return 0, errors.New("Missing arena id")
+	}
+
+	if _, err := uuid.FromString(arenaId); err != nil {
+		return 0, errors.New("Invalid arena id " + arenaId)
+	}
+
+	id, found := server.state.GetIdByArenaId(arenaId)
+
+	if !found {
+		return 0, errors.New("Unknown arena " + arenaId)
+	}
+
+	return id, nil
+}
+
+// findSigningArena is findArena for the messages of an arena-server, it
+// can't speak for another arena
+func (server *Server) findSigningArena(arenaId, component string) (int, error) {
+	if component != "" && component != mq.ARENA_SERVER_COMPONENT_PREFIX+arenaId {
+		return 0, errors.New(component + " can't publish for arena " + arenaId)
+	}
+
+	return server.findArena(arenaId)
+}
+
+// findVMId validates the id of a VM sent by an operator
+func (server *Server) findVMId(rawId string) (int, error) {
+	id, err := strconv.Atoi(rawId)
+
+	if err != nil {
+		return 0, errors.New("Invalid VM id " + rawId)
+	}
+
+	if server.state.GetVM(id) == nil {
+		return 0, errors.New("Unknown VM (" + rawId + ")")
+	}
+
+	return id, nil
+}
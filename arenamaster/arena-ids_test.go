@@ -0,0 +1,69 @@
+package arenamaster
+
+import (
+	"testing"
+
+	"github.com/bytearena/schnapps"
+	vmtypes "github.com/bytearena/schnapps/types"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bytearena/backends/arenamaster/state"
+	"github.com/bytearena/backends/common/mq"
+)
+
+func TestFindArena(t *testing.T) {
+	server := &Server{
+		state: state.NewState(),
+	}
+
+	arenaId := newArenaId()
+
+	assert.Nil(t, server.state.UpdateStateAddBootingVM(3, vm.NewVM(vmtypes.VMConfig{
+		Id:       3,
+		Metadata: vmtypes.VMMetadata{state.ARENA_ID_METADATA: arenaId},
+	})))
+
+	id, err := server.findArena(arenaId)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 3, id)
+
+	examples := []string{
+		"",
+		"02:42:ac:11:00:02",
+		newArenaId(),
+	}
+
+	for _, arenaId := range examples {
+		_, err := server.findArena(arenaId)
+		assert.Error(t, err)
+	}
+}
+
+func TestFindSigningArena(t *testing.T) {
+	server := &Server{
+		state: state.NewState(),
+	}
+
+	arenaA, arenaB := newArenaId(), newArenaId()
+
+	for id, arenaId := range map[int]string{1: arenaA, 2: arenaB} {
+		assert.Nil(t, server.state.UpdateStateAddBootingVM(id, vm.NewVM(vmtypes.VMConfig{
+			Id:       id,
+			Metadata: vmtypes.VMMetadata{state.ARENA_ID_METADATA: arenaId},
+		})))
+	}
+
+	id, err := server.findSigningArena(arenaB, mq.ARENA_SERVER_COMPONENT_PREFIX+arenaB)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, id)
+
+	// Signed with the key of arena A
+	_, err = server.findSigningArena(arenaB, mq.ARENA_SERVER_COMPONENT_PREFIX+arenaA)
+	assert.Error(t, err)
+
+	// Not verified
+	id, err = server.findSigningArena(arenaB, "")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, id)
+}
@@ -0,0 +1,78 @@
+package autoscaler
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/bytearena/core/common/utils"
+)
+
+// Metrics are the inputs of a scaling policy
+type Metrics struct {
+	// VMs which will have an idle arena soon
+	Booting int
+
+	// Ids of the VMs with an idle arena
+	Idle []int
+
+	// VMs with a pending or running arena
+	Busy int
+
+	// Launch requests waiting for an idle arena
+	PendingLaunches int
+}
+
+func (m Metrics) Total() int {
+	return m.Booting + len(m.Idle) + m.Busy
+}
+
+type Decision struct {
+	Spawn int
+	Halt  []int
+}
+
+type Policy interface {
+	Plan(metrics Metrics, now time.Time) Decision
+}
+
+// Spawner provisions and halts the VMs. Spawn must register the VM as booting
+// in the state before returning so that the next metrics account for it.
+type Spawner interface {
+	Spawn() error
+	Halt(id int) error
+}
+
+type MetricsFunc func() Metrics
+
+type Autoscaler struct {
+	policy  Policy
+	spawner Spawner
+	metrics MetricsFunc
+}
+
+func NewAutoscaler(policy Policy, spawner Spawner, metrics MetricsFunc) *Autoscaler {
+	return &Autoscaler{
+		policy:  policy,
+		spawner: spawner,
+		metrics: metrics,
+	}
+}
+
+// Tick applies the decision of the policy for the current metrics
+func (a *Autoscaler) Tick(now time.Time) Decision {
+	decision := a.policy.Plan(a.metrics(), now)
+
+	for i := 0; i < decision.Spawn; i++ {
+		if err := a.spawner.Spawn(); err != nil {
+			utils.RecoverableError("autoscaler", "Could not spawn VM: "+err.Error())
+		}
+	}
+
+	for _, id := range decision.Halt {
+		if err := a.spawner.Halt(id); err != nil {
+			utils.RecoverableError("autoscaler", "Could not halt VM ("+strconv.Itoa(id)+"): "+err.Error())
+		}
+	}
+
+	return decision
+}
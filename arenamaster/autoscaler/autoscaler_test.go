@@ -0,0 +1,208 @@
+package autoscaler
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeSpawner records the VMs as a scheduler would in the state
+type fakeSpawner struct {
+	inc     int
+	booting []int
+	idle    []int
+	busy    int
+	halted  []int
+	failing bool
+}
+
+func (s *fakeSpawner) Spawn() error {
+	if s.failing {
+		return errors.New("no IP available")
+	}
+
+	s.inc++
+	s.booting = append(s.booting, s.inc)
+
+	return nil
+}
+
+func (s *fakeSpawner) Halt(id int) error {
+	for i, idle := range s.idle {
+		if idle == id {
+			s.idle = append(s.idle[:i], s.idle[i+1:]...)
+			s.halted = append(s.halted, id)
+
+			return nil
+		}
+	}
+
+	return errors.New("Arena is not idle anymore")
+}
+
+// boot turns every booting VM into an idle arena
+func (s *fakeSpawner) boot() {
+	s.idle = append(s.idle, s.booting...)
+	s.booting = nil
+}
+
+// launch uses the oldest idle arena
+func (s *fakeSpawner) launch() {
+	s.idle = s.idle[1:]
+	s.busy++
+}
+
+func newFakeAutoscaler(policy Policy, pending *int) (*Autoscaler, *fakeSpawner) {
+	spawner := &fakeSpawner{}
+
+	metrics := func() Metrics {
+		return Metrics{
+			Booting:         len(spawner.booting),
+			Idle:            append([]int{}, spawner.idle...),
+			Busy:            spawner.busy,
+			PendingLaunches: *pending,
+		}
+	}
+
+	return NewAutoscaler(policy, spawner, metrics), spawner
+}
+
+type testCase struct {
+	Metrics  Metrics
+	Expected Decision
+}
+
+func TestElasticPolicyPlan(t *testing.T) {
+	cases := []testCase{
+		{
+			Metrics:  Metrics{Idle: []int{}},
+			Expected: Decision{Spawn: 2},
+		},
+		{
+			Metrics:  Metrics{Idle: []int{1, 2}},
+			Expected: Decision{},
+		},
+		{
+			// Booting VMs will be idle soon
+			Metrics:  Metrics{Booting: 1, Idle: []int{1}},
+			Expected: Decision{},
+		},
+		{
+			Metrics:  Metrics{Idle: []int{1}, Busy: 1, PendingLaunches: 2},
+			Expected: Decision{Spawn: 2},
+		},
+		{
+			// Capped by MaxVMs
+			Metrics:  Metrics{Idle: []int{}, Busy: 4, PendingLaunches: 5},
+			Expected: Decision{Spawn: 1},
+		},
+		{
+			Metrics:  Metrics{Idle: []int{}, Busy: 5, PendingLaunches: 5},
+			Expected: Decision{},
+		},
+	}
+
+	for _, c := range cases {
+		policy := &ElasticPolicy{
+			MinVMs:       2,
+			MaxVMs:       5,
+			IdleHeadroom: 1,
+		}
+
+		assert.Equal(t, c.Expected, policy.Plan(c.Metrics, time.Now()))
+	}
+}
+
+func TestElasticPolicyScaleDownDelay(t *testing.T) {
+	policy := &ElasticPolicy{
+		MinVMs:         2,
+		MaxVMs:         5,
+		ScaleDownDelay: time.Minute,
+	}
+
+	now := time.Now()
+	metrics := Metrics{Idle: []int{1, 2, 3}, Busy: 1}
+
+	assert.Equal(t, Decision{}, policy.Plan(metrics, now))
+	assert.Equal(t, Decision{}, policy.Plan(metrics, now.Add(30*time.Second)))
+
+	// The surplus disappeared, the delay starts again
+	assert.Equal(t, Decision{}, policy.Plan(Metrics{Idle: []int{1}, Busy: 1}, now.Add(40*time.Second)))
+	assert.Equal(t, Decision{}, policy.Plan(metrics, now.Add(50*time.Second)))
+	assert.Equal(t, Decision{}, policy.Plan(metrics, now.Add(90*time.Second)))
+
+	// Busy VMs are never halted
+	assert.Equal(t, Decision{Halt: []int{2, 3}}, policy.Plan(metrics, now.Add(110*time.Second)))
+}
+
+func TestFixedPolicy(t *testing.T) {
+	policy := NewFixedPolicy(3)
+
+	assert.Equal(t, Decision{Spawn: 3}, policy.Plan(Metrics{PendingLaunches: 10}, time.Now()))
+	assert.Equal(t, Decision{Spawn: 1}, policy.Plan(Metrics{Idle: []int{1}, Busy: 1}, time.Now()))
+	assert.Equal(t, Decision{}, policy.Plan(Metrics{Booting: 1, Idle: []int{1}, Busy: 1}, time.Now()))
+}
+
+func TestAutoscalerFollowsQueueDepth(t *testing.T) {
+	pending := 0
+	now := time.Now()
+
+	autoscaler, spawner := newFakeAutoscaler(&ElasticPolicy{
+		MinVMs:         1,
+		MaxVMs:         4,
+		IdleHeadroom:   1,
+		ScaleDownDelay: time.Minute,
+	}, &pending)
+
+	autoscaler.Tick(now)
+	assert.Len(t, spawner.booting, 1)
+
+	// Booting VMs are not spawned twice
+	autoscaler.Tick(now)
+	assert.Len(t, spawner.booting, 1)
+
+	spawner.boot()
+	pending = 3
+
+	autoscaler.Tick(now)
+	assert.Len(t, spawner.booting, 3)
+
+	spawner.boot()
+
+	// Capped by MaxVMs
+	pending = 5
+	autoscaler.Tick(now)
+	assert.Len(t, spawner.booting, 0)
+
+	pending = 0
+
+	autoscaler.Tick(now)
+	assert.Empty(t, spawner.halted)
+
+	autoscaler.Tick(now.Add(time.Minute))
+	assert.Equal(t, []int{2, 3, 4}, spawner.halted)
+	assert.Equal(t, []int{1}, spawner.idle)
+
+	// Busy arenas count towards MaxVMs
+	spawner.launch()
+	pending = 5
+
+	autoscaler.Tick(now)
+	assert.Len(t, spawner.booting, 3)
+}
+
+func TestAutoscalerSpawnErrors(t *testing.T) {
+	pending := 0
+	autoscaler, spawner := newFakeAutoscaler(NewFixedPolicy(2), &pending)
+
+	spawner.failing = true
+	assert.Equal(t, Decision{Spawn: 2}, autoscaler.Tick(time.Now()))
+	assert.Len(t, spawner.booting, 0)
+
+	// Retried on the next tick
+	spawner.failing = false
+	autoscaler.Tick(time.Now())
+	assert.Len(t, spawner.booting, 2)
+}
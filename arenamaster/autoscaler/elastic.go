@@ -0,0 +1,85 @@
+package autoscaler
+
+import (
+	"time"
+)
+
+// ElasticPolicy keeps enough idle arenas for the pending launches plus some
+// headroom, within MinVMs and MaxVMs. Only idle arenas are halted, once the
+// surplus lasted for ScaleDownDelay.
+type ElasticPolicy struct {
+	MinVMs         int
+	MaxVMs         int
+	IdleHeadroom   int
+	ScaleDownDelay time.Duration
+
+	surplusSince time.Time
+}
+
+// NewFixedPolicy keeps the same amount of VMs, replacing the halted ones
+func NewFixedPolicy(size int) *ElasticPolicy {
+	return &ElasticPolicy{
+		MinVMs: size,
+		MaxVMs: size,
+	}
+}
+
+func (p *ElasticPolicy) desiredTotal(metrics Metrics) int {
+	available := metrics.Booting + len(metrics.Idle)
+	wanted := metrics.PendingLaunches + p.IdleHeadroom
+
+	desired := metrics.Total() + wanted - available
+
+	if desired > p.MaxVMs {
+		desired = p.MaxVMs
+	}
+
+	if desired < p.MinVMs {
+		desired = p.MinVMs
+	}
+
+	return desired
+}
+
+func (p *ElasticPolicy) Plan(metrics Metrics, now time.Time) Decision {
+	total := metrics.Total()
+	desired := p.desiredTotal(metrics)
+
+	if desired > total {
+		p.surplusSince = time.Time{}
+
+		return Decision{
+			Spawn: desired - total,
+		}
+	}
+
+	surplus := total - desired
+
+	if surplus > len(metrics.Idle) {
+		surplus = len(metrics.Idle)
+	}
+
+	if surplus == 0 {
+		p.surplusSince = time.Time{}
+
+		return Decision{}
+	}
+
+	if p.surplusSince.IsZero() {
+		p.surplusSince = now
+	}
+
+	if now.Sub(p.surplusSince) < p.ScaleDownDelay {
+		return Decision{}
+	}
+
+	p.surplusSince = time.Time{}
+
+	// The most recent idle arenas are halted first
+	halt := make([]int, surplus)
+	copy(halt, metrics.Idle[len(metrics.Idle)-surplus:])
+
+	return Decision{
+		Halt: halt,
+	}
+}
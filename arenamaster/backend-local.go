@@ -0,0 +1,280 @@
+package arenamaster
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/bytearena/schnapps"
+	vmtypes "github.com/bytearena/schnapps/types"
+
+	"github.com/bytearena/backends/arenamaster/state"
+
+	"github.com/bytearena/core/common/utils"
+)
+
+type LocalBackendConfig struct {
+	// Path to the arena-server binary
+	Binary string
+
+	MQHost       string
+	APIUrl       string
+	RegistryAddr string
+	ArenaAddr    string
+	GameTimeout  int
+
+	// Each arena logs in <LogDir>/arena-<id>.log, empty to log on stdout
+	LogDir string
+}
+
+// localBackend runs the arenas as arena-server processes on the host, it
+// doesn't need any privilege and is meant for development and CI
+type localBackend struct {
+	config LocalBackendConfig
+
+	mutex     sync.Mutex
+	processes map[int]*os.Process
+	spawned   map[int]bool
+	// The arenas spawned by a previous arena-master, read at recovery: the
+	// metadata of the VMs can't be read without the state lock
+	recovered map[int]recoveredArena
+}
+
+type recoveredArena struct {
+	pid     string
+	arenaId string
+}
+
+func NewLocalBackend(config LocalBackendConfig) ArenaBackend {
+	return &localBackend{
+		config:    config,
+		processes: make(map[int]*os.Process),
+		spawned:   make(map[int]bool),
+		recovered: make(map[int]recoveredArena),
+	}
+}
+
+func (b *localBackend) Start() error {
+	if _, err := exec.LookPath(b.config.Binary); err != nil {
+		return errors.New("Could not find arena-server binary: " + err.Error())
+	}
+
+	if b.config.LogDir != "" {
+		if err := os.MkdirAll(b.config.LogDir, 0755); err != nil {
+			return errors.New("Could not create log directory: " + err.Error())
+		}
+	}
+
+	return nil
+}
+
+// The processes are left running, like the VMs, so that they can be
+// recovered by the next arena-master
+func (b *localBackend) Stop() {}
+
+func (b *localBackend) output(id int) (io.WriteCloser, error) {
+	if b.config.LogDir == "" {
+		return os.Stdout, nil
+	}
+
+	path := filepath.Join(b.config.LogDir, "arena-"+strconv.Itoa(id)+".log")
+
+	return os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+}
+
+// The keys of arena-master are not given to the arenas
+var ARENA_ENV_BLACKLIST = []string{"MQ_KEY", "MQ_KEYS", "MQ_DERIVED_KEYS", "ARENA_SERVER_KEY"}
+
+func arenaEnv(environ []string) []string {
+	res := make([]string, 0, len(environ))
+
+	for _, variable := range environ {
+		blacklisted := false
+
+		for _, name := range ARENA_ENV_BLACKLIST {
+			if strings.HasPrefix(variable, name+"=") {
+				blacklisted = true
+			}
+		}
+
+		if !blacklisted {
+			res = append(res, variable)
+		}
+	}
+
+	return res
+}
+
+// The resources of the profile are not enforced on the host
+func (b *localBackend) Spawn(id int, arenaId string, profile ResourceProfile) (*vm.VM, error) {
+	output, err := b.output(id)
+
+	if err != nil {
+		return nil, errors.New("Could not open arena log: " + err.Error())
+	}
+
+	args := []string{
+		"--mqhost", b.config.MQHost,
+		"--apiurl", b.config.APIUrl,
+		"--id", arenaId,
+		"--timeout", strconv.Itoa(b.config.GameTimeout),
+		"--registryAddr", b.config.RegistryAddr,
+		"--arenaAddr", b.config.ArenaAddr,
+	}
+
+	cmd := exec.Command(b.config.Binary, args...)
+
+	// The secrets are not in the arguments, which are visible to the other
+	// users
+	cmd.Env = arenaEnv(os.Environ())
+
+	if key, hasKey := arenaKey(arenaId); hasKey {
+		cmd.Env = append(cmd.Env, "MQ_KEY="+key)
+	}
+
+	if ARENA_API_TOKEN != "" {
+		cmd.Env = append(cmd.Env, "GRAPHQL_TOKEN="+ARENA_API_TOKEN)
+	}
+
+	cmd.Stdout = output
+	cmd.Stderr = output
+
+	if err := cmd.Start(); err != nil {
+		if output != os.Stdout {
+			output.Close()
+		}
+
+		return nil, err
+	}
+
+	b.mutex.Lock()
+	b.processes[id] = cmd.Process
+	b.spawned[id] = true
+	b.mutex.Unlock()
+
+	go func() {
+		err := cmd.Wait()
+
+		b.mutex.Lock()
+		delete(b.processes, id)
+		b.mutex.Unlock()
+
+		if output != os.Stdout {
+			output.Close()
+		}
+
+		if err != nil {
+			utils.RecoverableError("local-backend", "arena-server ("+arenaId+") exited: "+err.Error())
+		} else {
+			utils.Debug("local-backend", "arena-server ("+arenaId+") exited")
+		}
+	}()
+
+	utils.Debug("local-backend", "Started arena-server ("+arenaId+")")
+
+	meta := vmtypes.VMMetadata{
+		"pid":                   strconv.Itoa(cmd.Process.Pid),
+		"profile":               profile.Name,
+		state.ARENA_ID_METADATA: arenaId,
+	}
+
+	return vm.NewVM(arenaVMConfig(id, meta)), nil
+}
+
+// A started process is considered booted, the arena handshakes when ready
+func (b *localBackend) WaitUntilBooted(arena *vm.VM) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if _, isRunning := b.processes[arena.Config.Id]; !isRunning {
+		return errors.New("arena-server is not running")
+	}
+
+	return nil
+}
+
+func (b *localBackend) Quit(arena *vm.VM) error {
+	id := arena.Config.Id
+
+	b.mutex.Lock()
+	process, isRunning := b.processes[id]
+	wasSpawned := b.spawned[id]
+	recovered := b.recovered[id]
+	delete(b.spawned, id)
+	delete(b.recovered, id)
+	b.mutex.Unlock()
+
+	if isRunning {
+		return process.Kill()
+	}
+
+	// Already exited
+	if wasSpawned {
+		return nil
+	}
+
+	// Spawned by a previous arena-master, only its pid is known
+	pid, err := strconv.Atoi(recovered.pid)
+
+	if err != nil {
+		return errors.New("Unknown pid for arena (" + strconv.Itoa(id) + ")")
+	}
+
+	// The pid may have been reused since the arena exited
+	if !isArenaServer(pid, b.config.Binary, recovered.arenaId) {
+		utils.Debug("local-backend", "arena-server ("+recovered.arenaId+") is gone, pid "+recovered.pid+" is not its process anymore")
+		return nil
+	}
+
+	process, err = os.FindProcess(pid)
+
+	if err != nil {
+		return err
+	}
+
+	return process.Kill()
+}
+
+// isArenaServer tells whether the process runs the binary for the arena,
+// according to its command line
+func isArenaServer(pid int, binary, arenaId string) bool {
+	cmdline, err := ioutil.ReadFile("/proc/" + strconv.Itoa(pid) + "/cmdline")
+
+	if err != nil || arenaId == "" {
+		return false
+	}
+
+	args := strings.Split(strings.TrimRight(string(cmdline), "\x00"), "\x00")
+
+	if filepath.Base(args[0]) != filepath.Base(binary) {
+		return false
+	}
+
+	for i := 1; i < len(args)-1; i++ {
+		if (args[i] == "--id" || args[i] == "-id") && args[i+1] == arenaId {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (b *localBackend) Recover(id int, mac string, metadata vmtypes.VMMetadata) *vm.VM {
+	pid, hasPid := metadata["pid"]
+	arenaId, hasArenaId := metadata[state.ARENA_ID_METADATA]
+
+	if hasPid && hasArenaId {
+		b.mutex.Lock()
+		b.recovered[id] = recoveredArena{pid: fmt.Sprint(pid), arenaId: fmt.Sprint(arenaId)}
+		b.mutex.Unlock()
+	}
+
+	return vm.NewVM(arenaVMConfig(id, metadata))
+}
@@ -0,0 +1,57 @@
+package arenamaster
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsArenaServer(t *testing.T) {
+	if _, err := os.Stat("/proc/self/cmdline"); err != nil {
+		t.Skip("/proc is not available")
+	}
+
+	// The trailing command keeps the shell from replacing itself by sleep
+	cmd := exec.Command("sh", "-c", "sleep 5; true", "arena-server", "--id", "arena-1")
+	assert.Nil(t, cmd.Start())
+
+	defer cmd.Process.Kill()
+
+	pid := cmd.Process.Pid
+
+	// The command line is only set once the child has exec'd the shell
+	deadline := time.Now().Add(time.Second)
+
+	for !isArenaServer(pid, "/bin/sh", "arena-1") && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	assert.True(t, isArenaServer(pid, "/bin/sh", "arena-1"))
+	assert.False(t, isArenaServer(pid, "/bin/sh", "arena-2"))
+	assert.False(t, isArenaServer(pid, "/usr/bin/arena-server", "arena-1"))
+	assert.False(t, isArenaServer(pid, "/bin/sh", ""))
+
+	// A recovered arena which isn't running anymore is not killed
+	backend := NewLocalBackend(LocalBackendConfig{Binary: "/usr/bin/arena-server"})
+	arena := backend.Recover(1, "", map[string]interface{}{"pid": pid, "arenaid": "arena-1"})
+
+	assert.Nil(t, backend.Quit(arena))
+	assert.Nil(t, cmd.Process.Signal(syscall.Signal(0)))
+}
+
+func TestArenaEnv(t *testing.T) {
+	env := arenaEnv([]string{
+		"PATH=/usr/bin",
+		"MQ_KEY=master-key",
+		"MQ_KEYS=arena-master-cli=cli-key",
+		"MQ_DERIVED_KEYS=arena-server.=secret",
+		"ARENA_SERVER_KEY=secret",
+		"MQ_KEYRING=kept",
+	})
+
+	assert.Equal(t, []string{"PATH=/usr/bin", "MQ_KEYRING=kept"}, env)
+}
@@ -0,0 +1,182 @@
+package arenamaster
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/bytearena/schnapps"
+	vmid "github.com/bytearena/schnapps/id"
+	vmtypes "github.com/bytearena/schnapps/types"
+
+	"github.com/bytearena/backends/arenamaster/federation"
+	"github.com/bytearena/backends/arenamaster/state"
+)
+
+// remoteBackend places the arenas on the arena-worker agents of other hosts,
+// the worker of each arena is kept in the "worker" metadata
+type remoteBackend struct {
+	coordinator *federation.Coordinator
+
+	// The metadata sent by the worker is only known once the arena started
+	updateMetadata func(id int, metadata map[string]string)
+}
+
+func NewRemoteBackend(coordinator *federation.Coordinator) ArenaBackend {
+	return &remoteBackend{
+		coordinator:    coordinator,
+		updateMetadata: func(id int, metadata map[string]string) {},
+	}
+}
+
+func (b *remoteBackend) Start() error {
+	return b.coordinator.Start()
+}
+
+// The arenas are left running on the workers
+func (b *remoteBackend) Stop() {}
+
+func (b *remoteBackend) onMetadata(fn func(id int, metadata map[string]string)) {
+	b.updateMetadata = fn
+}
+
+// Spawn doesn't wait for the worker, the arena is booting until it replied
+func (b *remoteBackend) Spawn(id int, arenaId string, profile ResourceProfile) (*vm.VM, error) {
+	worker, err := b.coordinator.Spawn(id, arenaId, profile.Name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	meta := vmtypes.VMMetadata{
+		"worker":                worker,
+		"profile":               profile.Name,
+		state.ARENA_ID_METADATA: arenaId,
+	}
+
+	return vm.NewVM(arenaVMConfig(id, meta)), nil
+}
+
+func (b *remoteBackend) WaitUntilBooted(arena *vm.VM) error {
+	id := arena.Config.Id
+	reply, err := b.coordinator.WaitUntilSpawned(id)
+
+	if err != nil {
+		return err
+	}
+
+	b.updateMetadata(id, reply.Metadata)
+
+	return b.coordinator.WaitUntilBooted(id)
+}
+
+func (b *remoteBackend) Quit(arena *vm.VM) error {
+	return b.coordinator.Quit(arena.Config.Id)
+}
+
+func (b *remoteBackend) Recover(id int, mac string, metadata vmtypes.VMMetadata) *vm.VM {
+	if worker, hasWorker := metadata["worker"]; hasWorker {
+		b.coordinator.Adopt(id, fmt.Sprint(worker))
+	}
+
+	return vm.NewVM(arenaVMConfig(id, metadata))
+}
+
+// BackendSpawner runs the arenas placed on an arena-worker with one of the
+// local backends
+type BackendSpawner struct {
+	backend ArenaBackend
+
+	mutex  sync.Mutex
+	arenas map[int]*vm.VM
+}
+
+// The backend is built by the caller since it might need to find the arenas
+// by MAC, like the schnapps one
+func NewBackendSpawner(createBackend func(findArena func(mac string) *vm.VM) ArenaBackend) *BackendSpawner {
+	spawner := &BackendSpawner{
+		arenas: make(map[int]*vm.VM),
+	}
+
+	spawner.backend = createBackend(spawner.FindArena)
+
+	return spawner
+}
+
+func (s *BackendSpawner) Backend() ArenaBackend {
+	return s.backend
+}
+
+func (s *BackendSpawner) FindArena(mac string) *vm.VM {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, arena := range s.arenas {
+		if found, hasMAC := vmid.GetVMMAC(arena); hasMAC && found == mac {
+			return arena
+		}
+	}
+
+	return nil
+}
+
+func (s *BackendSpawner) getArena(id int) (*vm.VM, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	arena, ok := s.arenas[id]
+
+	if !ok {
+		return nil, errors.New("Unknown arena (" + strconv.Itoa(id) + ")")
+	}
+
+	return arena, nil
+}
+
+func (s *BackendSpawner) Spawn(id int, arenaId string, profile string) (map[string]string, error) {
+	arena, err := s.backend.Spawn(id, arenaId, getProfile(profile))
+
+	if err != nil {
+		return nil, err
+	}
+
+	s.mutex.Lock()
+	s.arenas[id] = arena
+	s.mutex.Unlock()
+
+	metadata := make(map[string]string)
+
+	// The key of the arena is only known by its host
+	for k, v := range arena.Config.Metadata {
+		if !isSecretMetadata(k) {
+			metadata[k] = fmt.Sprint(v)
+		}
+	}
+
+	return metadata, nil
+}
+
+func (s *BackendSpawner) WaitUntilBooted(id int) error {
+	arena, err := s.getArena(id)
+
+	if err != nil {
+		return err
+	}
+
+	return s.backend.WaitUntilBooted(arena)
+}
+
+func (s *BackendSpawner) Quit(id int) error {
+	arena, err := s.getArena(id)
+
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	delete(s.arenas, id)
+	s.mutex.Unlock()
+
+	return s.backend.Quit(arena)
+}
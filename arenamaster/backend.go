@@ -0,0 +1,69 @@
+package arenamaster
+
+import (
+	"strconv"
+
+	"github.com/bytearena/schnapps"
+	vmtypes "github.com/bytearena/schnapps/types"
+
+	"github.com/bytearena/core/common/utils"
+)
+
+// ArenaBackend runs the arenas. Whatever the backend, an arena is described
+// by a schnapps VM: its config holds the id and the metadata, including the
+// arena id the arena-server uses on the MQ.
+type ArenaBackend interface {
+	// Start prepares the host before the first arena is spawned
+	Start() error
+	Stop()
+
+	// Spawn starts an arena sized by the profile, the arena id and the name
+	// of the profile are kept in the "arenaid" and "profile" metadata
+	Spawn(id int, arenaId string, profile ResourceProfile) (*vm.VM, error)
+	WaitUntilBooted(arena *vm.VM) error
+	Quit(arena *vm.VM) error
+
+	// Recover rebuilds an arena recorded in the state journal by a previous
+	// arena-master process, the MAC is empty for arenas which are not VMs
+	Recover(id int, mac string, metadata vmtypes.VMMetadata) *vm.VM
+}
+
+// Backends which learn the metadata of an arena after Spawn
+type metadataReporter interface {
+	onMetadata(fn func(id int, metadata map[string]string))
+}
+
+func (server *Server) UseBackend(backend ArenaBackend) {
+	server.backend = backend
+
+	if reporter, ok := backend.(metadataReporter); ok {
+		reporter.onMetadata(server.addArenaMetadata)
+	}
+}
+
+// addArenaMetadata keeps the metadata set by arena-master, like the arena id
+func (server *Server) addArenaMetadata(id int, metadata map[string]string) {
+	arena := server.state.GetVM(id)
+
+	if arena == nil {
+		return
+	}
+
+	for k, v := range metadata {
+		if _, isSet := arena.Config.Metadata[k]; isSet {
+			continue
+		}
+
+		if err := server.state.UpdateVMMetadata(id, k, v); err != nil {
+			utils.RecoverableError("vm", "Could not update metadata of ("+strconv.Itoa(id)+"): "+err.Error())
+		}
+	}
+}
+
+// Describes an arena which is not a VM on this host
+func arenaVMConfig(id int, meta vmtypes.VMMetadata) vmtypes.VMConfig {
+	return vmtypes.VMConfig{
+		Id:       id,
+		Metadata: meta,
+	}
+}
@@ -12,27 +12,28 @@ import (
 	"github.com/bytearena/backends/common/mq"
 
 	"github.com/bytearena/core/common/types"
+	"github.com/bytearena/core/common/utils"
 )
 
-func handleDebugGetVMStatus(mqClient *mq.Client, s *state.State, healthchecks *ArenaHealthCheck) {
+func handleDebugGetVMStatus(s *state.State, healthchecks *ArenaHealthCheck) types.MQPayload {
 	debugState := make(map[int]map[string]string)
 
-	s.Map(func(element *state.DataContainer) {
-		vm := element.Data.(*vm.VM)
-		id := vm.Config.Id
+	cache := healthchecks.GetCache()
+	lastSeen := healthchecks.GetLastSeen()
 
-		debugState[id] = make(map[string]string)
-		debugState[id]["state"] = strings.Join(s.DebugGetStatus(id), ",")
+	for _, arena := range s.GetVMs() {
+		id := arena.Config.Id
 
-		mac, found := vmid.GetVMMAC(vm)
+		debugState[id] = make(map[string]string)
+		debugState[id]["state"] = strings.Join(s.DebugFlagToString(arena.Status), ",")
 
-		if found {
+		if mac, found := vmid.GetVMMAC(&vm.VM{Config: arena.Config}); found {
 			debugState[id]["mac"] = mac
 		}
 
-		cache := healthchecks.GetCache()
+		arenaId, _ := arenaIdOf(arena.Config.Metadata)
 
-		if res, hasRes := cache[mac]; hasRes {
+		if res, hasRes := cache[arenaId]; hasRes {
 			if res {
 				debugState[id]["health"] = "OK"
 			} else {
@@ -40,22 +41,57 @@ func handleDebugGetVMStatus(mqClient *mq.Client, s *state.State, healthchecks *A
 			}
 		}
 
-		lastSeen := healthchecks.GetLastSeen()
-
-		if res, hasRes := lastSeen[mac]; hasRes {
+		if res, hasRes := lastSeen[arenaId]; hasRes {
 			debugState[id]["lastseen"] = res.Format(time.RFC3339)
 		}
 
-		metadatajson, err := json.Marshal(vm.Config.Metadata)
+		metadata := make(map[string]interface{})
+
+		for k, v := range arena.Config.Metadata {
+			if !isSecretMetadata(k) {
+				metadata[k] = v
+			}
+		}
+
+		metadatajson, err := json.Marshal(metadata)
 
 		if err != nil {
 			debugState[id]["metadata"] = err.Error()
 		} else {
 			debugState[id]["metadata"] = string(metadatajson)
 		}
-	})
+	}
 
-	mqClient.Publish("debug", "getvmstatus-res", types.MQPayload{
+	return types.MQPayload{
 		"state": debugState,
+	}
+}
+
+// handleDebugRequests answers the requests of arena-master-cli
+func (server *Server) handleDebugRequests(rpc *mq.RPC, healthchecks *ArenaHealthCheck) {
+	handleDebugRequest(rpc, "getvmstatus", func(msg *mq.DebugRequest) interface{} {
+		return handleDebugGetVMStatus(server.state, healthchecks)
+	})
+
+	handleDebugRequest(rpc, "getstateevents", func(msg *mq.DebugRequest) interface{} {
+		return handleDebugGetStateEvents(server.state, server.stateEvents, msg)
+	})
+
+	handleDebugRequest(rpc, "getlaunchqueue", func(msg *mq.DebugRequest) interface{} {
+		return handleDebugGetLaunchQueue(server.launchQueue)
 	})
 }
+
+func handleDebugRequest(rpc *mq.RPC, topic string, handler func(msg *mq.DebugRequest) interface{}) {
+	err := rpc.Handle("debug", topic, func(payload json.RawMessage) (interface{}, error) {
+		msg, err := mq.DecodeMessage("debug", topic, payload)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return handler(msg.(*mq.DebugRequest)), nil
+	})
+
+	utils.Check(err, "Could not subscribe to mq")
+}
@@ -6,10 +6,10 @@ import (
 	"github.com/bytearena/core/common/utils"
 )
 
-func (server *Server) createDHCPServer() {
+func (b *schnappsBackend) createDHCPServer() {
 	var err error
-	cidr := server.vmSubnet
+	cidr := b.config.Subnet
 
-	server.DHCPServer, err = vmdhcp.NewDHCPServer(cidr)
+	b.DHCPServer, err = vmdhcp.NewDHCPServer(cidr)
 	utils.Check(err, "Could not create DHCP server")
 }
@@ -10,16 +10,17 @@ var (
 	dnsZone = "bytearena.com."
 )
 
-func (server *Server) createDNSServer() {
+func (b *schnappsBackend) createDNSServer() {
 
 	dnsRecords := map[string]string{
-		"static." + dnsZone:       server.vmBridgeIP,
-		"redis.net." + dnsZone:    server.vmBridgeIP,
-		"graphql.net." + dnsZone:  server.vmBridgeIP,
-		"registry.net." + dnsZone: server.vmBridgeIP,
+		"static." + dnsZone:       b.config.BridgeIP,
+		"redis.net." + dnsZone:    b.config.BridgeIP,
+		"graphql.net." + dnsZone:  b.config.BridgeIP,
+		"registry.net." + dnsZone: b.config.BridgeIP,
+		"metadata.net." + dnsZone: b.config.BridgeIP,
 	}
 
-	DNSServer := vmdns.MakeServer(server.vmBridgeIP+":53", dnsZone, dnsRecords)
+	DNSServer := vmdns.MakeServer(b.config.BridgeIP+":53", dnsZone, dnsRecords)
 
 	// DNSServer.SetOnRequestHook(func(addr string) {
 	// 	utils.Debug("dns-server", "query for "+addr)
@@ -29,6 +30,6 @@ func (server *Server) createDNSServer() {
 		err := DNSServer.Start()
 		utils.Check(err, "Could not start DNS server")
 
-		server.DNSServer = &DNSServer
+		b.DNSServer = &DNSServer
 	}()
 }
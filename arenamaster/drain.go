@@ -0,0 +1,185 @@
+package arenamaster
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/bytearena/core/common/utils"
+
+	"github.com/bytearena/backends/arenamaster/state"
+	"github.com/bytearena/backends/common/mq"
+)
+
+var (
+	DRAIN_DEADLINE = 30 * time.Minute
+)
+
+type DrainStatus struct {
+	Draining bool
+	Done     bool
+	Started  time.Time
+	Deadline time.Time
+
+	// Arenas with a pending or running game
+	Remaining int
+}
+
+func (s DrainStatus) String() string {
+	if !s.Draining {
+		return "not draining"
+	}
+
+	if s.Done {
+		return "drained"
+	}
+
+	return "draining, " + strconv.Itoa(s.Remaining) + " arenas remaining, deadline " + s.Deadline.Format(time.RFC3339)
+}
+
+func (server *Server) GetDrainStatus() DrainStatus {
+	server.drainMutex.Lock()
+	defer server.drainMutex.Unlock()
+
+	return server.drainStatus
+}
+
+func (server *Server) IsDraining() bool {
+	return server.GetDrainStatus().Draining
+}
+
+// The deadline of a drain message is optional, as a duration
+func drainDeadline(raw string) time.Duration {
+	if raw == "" {
+		return DRAIN_DEADLINE
+	}
+
+	deadline, err := time.ParseDuration(raw)
+
+	if err != nil {
+		utils.RecoverableError("drain", "Invalid deadline "+raw+", using "+DRAIN_DEADLINE.String())
+		return DRAIN_DEADLINE
+	}
+
+	return deadline
+}
+
+// startDrain stops dispatching launches and spawning VMs, the idle VMs are
+// halted and new launches are refused. Queued launches are kept for the next
+// arena-master when the queue is persistent, otherwise they are refused too.
+func (server *Server) startDrain(deadline time.Duration) {
+	server.drainMutex.Lock()
+
+	if server.drainStatus.Draining {
+		server.drainMutex.Unlock()
+		utils.RecoverableError("drain", "Already draining")
+
+		return
+	}
+
+	now := time.Now()
+
+	server.drainStatus = DrainStatus{
+		Draining: true,
+		Started:  now,
+		Deadline: now.Add(deadline),
+	}
+
+	server.drainMutex.Unlock()
+
+	utils.Debug("drain", "Draining, deadline in "+deadline.String())
+
+	server.refuseQueuedLaunches()
+	server.checkDrain()
+}
+
+// An in-memory queue is lost when arena-master stops, the API has to be told
+// that its launches won't happen
+func (server *Server) refuseQueuedLaunches() {
+	if server.launchQueue.Persistent() {
+		return
+	}
+
+	for {
+		launch, err := server.launchQueue.Pop()
+
+		if err != nil {
+			utils.RecoverableError("launch-queue", err.Error())
+		}
+
+		if launch == nil {
+			return
+		}
+
+		server.replyError("game", "launch", &mq.GameLaunch{
+			Id:       launch.GameId,
+			Priority: mq.OptionalInt{Value: launch.Priority, IsSet: true},
+			Profile:  launch.Profile,
+		}, "arena-master is draining, game "+launch.GameId+" is refused")
+	}
+}
+
+// checkDrain halts the VMs without a game and updates the progress of the
+// drain, arena-master stops once the games are over or the deadline passed.
+// Games still running then are re-adopted by the next arena-master.
+func (server *Server) checkDrain() {
+	if !server.IsDraining() {
+		return
+	}
+
+	remaining := 0
+
+	for _, vm := range server.state.GetVMs() {
+		id := vm.Config.Id
+
+		if server.state.GetStatus(id)&(state.STATE_PENDING_ARENA|state.STATE_RUNNING_ARENA) != 0 {
+			remaining++
+			continue
+		}
+
+		if err := server.haltVM(id); err != nil {
+			utils.RecoverableError("drain", "Could not halt ("+strconv.Itoa(id)+"): "+err.Error())
+		}
+	}
+
+	server.drainMutex.Lock()
+
+	if server.drainStatus.Done {
+		server.drainMutex.Unlock()
+		return
+	}
+
+	server.drainStatus.Remaining = remaining
+	expired := time.Now().After(server.drainStatus.Deadline)
+	server.drainStatus.Done = remaining == 0 || expired
+
+	status := server.drainStatus
+	server.drainMutex.Unlock()
+
+	server.publishDrainStatus(status)
+
+	if !status.Done {
+		return
+	}
+
+	if expired && remaining > 0 {
+		utils.RecoverableError("drain", "Deadline passed with "+strconv.Itoa(remaining)+" arenas running")
+	}
+
+	utils.Debug("drain", "Drained, stopping")
+
+	go server.Stop()
+}
+
+func (server *Server) publishDrainStatus(status DrainStatus) {
+	err := mq.PublishMessage(server.brokerclient, MASTER_COMPONENT, "arenamaster", "drain-status", &mq.DrainStatus{
+		Draining:  status.Draining,
+		Done:      status.Done,
+		Remaining: status.Remaining,
+		Deadline:  status.Deadline.Format(time.RFC3339),
+		Status:    status.String(),
+	})
+
+	if err != nil {
+		utils.RecoverableError("drain", "Could not publish: "+err.Error())
+	}
+}
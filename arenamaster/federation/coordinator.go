@@ -0,0 +1,231 @@
+package federation
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	bamq "github.com/bytearena/core/common/mq"
+	"github.com/bytearena/core/common/utils"
+
+	"github.com/bytearena/backends/common/mq"
+)
+
+const (
+	COORDINATOR_COMPONENT = "arena-master"
+)
+
+var (
+	SPAWN_TIMEOUT  = 30 * time.Second
+	BOOT_TIMEOUT   = 5 * time.Minute
+	WORKER_TIMEOUT = 3 * ADVERTISE_FREQ
+)
+
+// Coordinator places the arenas on the workers and forwards them the spawn
+// and quit requests
+type Coordinator struct {
+	broker   bamq.ClientInterface
+	registry *Registry
+
+	mutex        sync.Mutex
+	spawnWaiters map[int]chan SpawnReply
+	bootWaiters  map[int]chan BootReply
+}
+
+func NewCoordinator(broker bamq.ClientInterface) *Coordinator {
+	return &Coordinator{
+		broker:       broker,
+		registry:     NewRegistry(WORKER_TIMEOUT),
+		spawnWaiters: make(map[int]chan SpawnReply),
+		bootWaiters:  make(map[int]chan BootReply),
+	}
+}
+
+func (c *Coordinator) Start() error {
+	err := mq.SubscribeMessages(c.broker, CHANNEL, TOPIC_ADVERTISE, func(message mq.Message) {
+		advertisement := *message.(*Advertisement)
+
+		c.registry.Advertise(advertisement, time.Now())
+	})
+
+	if err != nil {
+		return err
+	}
+
+	err = mq.SubscribeMessages(c.broker, CHANNEL, TOPIC_SPAWNED, func(message mq.Message) {
+		reply := *message.(*SpawnReply)
+
+		if !c.isPlacedOn(reply.Id, reply.Worker) {
+			utils.RecoverableError("coordinator", "Ignored spawn reply of "+reply.Worker+" for arena ("+strconv.Itoa(reply.Id)+")")
+			return
+		}
+
+		c.mutex.Lock()
+		waiter, hasWaiter := c.spawnWaiters[reply.Id]
+		c.mutex.Unlock()
+
+		if hasWaiter {
+			// Buffered, the spawned message might come before WaitUntilSpawned
+			waiter <- reply
+		}
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return mq.SubscribeMessages(c.broker, CHANNEL, TOPIC_BOOTED, func(message mq.Message) {
+		reply := *message.(*BootReply)
+
+		if !c.isPlacedOn(reply.Id, reply.Worker) {
+			utils.RecoverableError("coordinator", "Ignored boot reply of "+reply.Worker+" for arena ("+strconv.Itoa(reply.Id)+")")
+			return
+		}
+
+		c.mutex.Lock()
+		waiter, hasWaiter := c.bootWaiters[reply.Id]
+		c.mutex.Unlock()
+
+		if hasWaiter {
+			// Buffered, the booted message might come before WaitUntilBooted
+			waiter <- reply
+		}
+	})
+}
+
+// Only the worker an arena is placed on can reply for it
+func (c *Coordinator) isPlacedOn(id int, worker string) bool {
+	placed, isPlaced := c.registry.WorkerOf(id)
+
+	return isPlaced && placed == worker
+}
+
+// Spawn places the arena and asks its worker to start it, it doesn't wait
+// for the worker, see WaitUntilSpawned
+func (c *Coordinator) Spawn(id int, arenaId, profile string) (string, error) {
+	worker, err := c.registry.Place(id, profile, time.Now())
+
+	if err != nil {
+		return "", err
+	}
+
+	c.mutex.Lock()
+	c.spawnWaiters[id] = make(chan SpawnReply, 1)
+	c.bootWaiters[id] = make(chan BootReply, 1)
+	c.mutex.Unlock()
+
+	err = mq.PublishMessage(c.broker, COORDINATOR_COMPONENT, CHANNEL, spawnTopic(worker), &SpawnRequest{
+		Id:      id,
+		ArenaId: arenaId,
+		Profile: profile,
+	})
+
+	if err != nil {
+		c.release(id)
+		return "", err
+	}
+
+	return worker, nil
+}
+
+// release forgets an arena which could not be spawned
+func (c *Coordinator) release(id int) {
+	c.mutex.Lock()
+	delete(c.spawnWaiters, id)
+	delete(c.bootWaiters, id)
+	c.mutex.Unlock()
+
+	c.registry.Release(id)
+}
+
+// WaitUntilSpawned waits until the worker started the arena, the placement
+// is released if it didn't
+func (c *Coordinator) WaitUntilSpawned(id int) (SpawnReply, error) {
+	c.mutex.Lock()
+	spawned, hasWaiter := c.spawnWaiters[id]
+	c.mutex.Unlock()
+
+	if !hasWaiter {
+		return SpawnReply{}, errors.New("Arena (" + strconv.Itoa(id) + ") was not spawned by this coordinator")
+	}
+
+	worker, _ := c.registry.WorkerOf(id)
+
+	select {
+	case reply := <-spawned:
+		if reply.Error != "" {
+			c.release(id)
+			return SpawnReply{}, errors.New("Worker " + worker + " could not spawn arena (" + strconv.Itoa(id) + "): " + reply.Error)
+		}
+
+		c.mutex.Lock()
+		delete(c.spawnWaiters, id)
+		c.mutex.Unlock()
+
+		utils.Debug("coordinator", "Arena ("+strconv.Itoa(id)+") spawned on "+worker)
+
+		return reply, nil
+
+	case <-time.After(SPAWN_TIMEOUT):
+		c.release(id)
+		return SpawnReply{}, errors.New("Worker " + worker + " did not spawn arena (" + strconv.Itoa(id) + ") in time")
+	}
+}
+
+func (c *Coordinator) WaitUntilBooted(id int) error {
+	c.mutex.Lock()
+	booted, hasWaiter := c.bootWaiters[id]
+	c.mutex.Unlock()
+
+	if !hasWaiter {
+		return errors.New("Arena (" + strconv.Itoa(id) + ") was not spawned by this coordinator")
+	}
+
+	defer func() {
+		c.mutex.Lock()
+		delete(c.bootWaiters, id)
+		c.mutex.Unlock()
+	}()
+
+	select {
+	case reply := <-booted:
+		if reply.Error != "" {
+			return errors.New("Arena (" + strconv.Itoa(id) + ") did not boot: " + reply.Error)
+		}
+
+		return nil
+
+	case <-time.After(BOOT_TIMEOUT):
+		return errors.New("Arena (" + strconv.Itoa(id) + ") did not boot in time")
+	}
+}
+
+// Quit asks the worker of the arena to stop it, the placement is released
+// right away
+func (c *Coordinator) Quit(id int) error {
+	worker, isPlaced := c.registry.WorkerOf(id)
+
+	if !isPlaced {
+		return errors.New("Arena (" + strconv.Itoa(id) + ") is not placed on any worker")
+	}
+
+	c.registry.Release(id)
+
+	return mq.PublishMessage(c.broker, COORDINATOR_COMPONENT, CHANNEL, quitTopic(worker), &QuitRequest{
+		Id: id,
+	})
+}
+
+// Adopt records the worker of an arena spawned by a previous coordinator
+func (c *Coordinator) Adopt(id int, worker string) {
+	c.registry.Assign(id, worker)
+}
+
+func (c *Coordinator) WorkerOf(id int) (string, bool) {
+	return c.registry.WorkerOf(id)
+}
+
+func (c *Coordinator) Workers() []WorkerStatus {
+	return c.registry.Workers(time.Now())
+}
@@ -0,0 +1,212 @@
+package federation
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	bamq "github.com/bytearena/core/common/mq"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bytearena/backends/common/mq"
+)
+
+// memoryBroker delivers the messages synchronously to the subscribers
+type memoryBroker struct {
+	mutex         sync.Mutex
+	subscriptions map[string][]bamq.SubscriptionCallback
+}
+
+func newMemoryBroker() *memoryBroker {
+	return &memoryBroker{
+		subscriptions: make(map[string][]bamq.SubscriptionCallback),
+	}
+}
+
+func (b *memoryBroker) Subscribe(channel, topic string, onmessage bamq.SubscriptionCallback) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	key := channel + "." + topic
+	b.subscriptions[key] = append(b.subscriptions[key], onmessage)
+
+	return nil
+}
+
+func (b *memoryBroker) Publish(channel, topic string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+
+	if err != nil {
+		return err
+	}
+
+	b.mutex.Lock()
+	callbacks := b.subscriptions[channel+"."+topic]
+	b.mutex.Unlock()
+
+	for _, callback := range callbacks {
+		callback(bamq.BrokerMessage{
+			Channel: channel,
+			Topic:   topic,
+			Data:    data,
+		})
+	}
+
+	return nil
+}
+
+type fakeSpawner struct {
+	mutex   sync.Mutex
+	running map[int]string
+	fail    bool
+}
+
+func newFakeSpawner() *fakeSpawner {
+	return &fakeSpawner{
+		running: make(map[int]string),
+	}
+}
+
+func (s *fakeSpawner) Spawn(id int, arenaId string, profile string) (map[string]string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.fail {
+		return nil, errors.New("no more IP")
+	}
+
+	s.running[id] = profile
+
+	return map[string]string{"profile": profile, "arenaid": arenaId}, nil
+}
+
+func (s *fakeSpawner) WaitUntilBooted(id int) error {
+	return nil
+}
+
+func (s *fakeSpawner) Quit(id int) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.running, id)
+
+	return nil
+}
+
+func (s *fakeSpawner) count() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return len(s.running)
+}
+
+func startFakeWorker(t *testing.T, broker bamq.ClientInterface, id string, capacity int) *fakeSpawner {
+	spawner := newFakeSpawner()
+	worker := NewWorker(id, capacity, nil, broker, spawner)
+
+	assert.Nil(t, worker.Start())
+
+	return spawner
+}
+
+func TestCoordinatorSpawnsOnWorkers(t *testing.T) {
+	broker := newMemoryBroker()
+	coordinator := NewCoordinator(broker)
+
+	assert.Nil(t, coordinator.Start())
+
+	first := startFakeWorker(t, broker, "first", 2)
+	second := startFakeWorker(t, broker, "second", 1)
+
+	for id := 1; id <= 3; id++ {
+		_, err := coordinator.Spawn(id, "arena-"+strconv.Itoa(id), "default")
+		assert.Nil(t, err)
+
+		reply, err := coordinator.WaitUntilSpawned(id)
+
+		assert.Nil(t, err)
+		assert.Equal(t, "arena-"+strconv.Itoa(id), reply.Metadata["arenaid"])
+		assert.Equal(t, "default", reply.Metadata["profile"])
+		assert.Nil(t, coordinator.WaitUntilBooted(id))
+	}
+
+	assert.Equal(t, 2, first.count())
+	assert.Equal(t, 1, second.count())
+
+	_, err := coordinator.Spawn(4, "arena-4", "default")
+	assert.Error(t, err)
+
+	worker, _ := coordinator.WorkerOf(3)
+	assert.Equal(t, "first", worker)
+
+	assert.Nil(t, coordinator.Quit(3))
+	assert.Error(t, coordinator.Quit(3))
+	assert.Equal(t, 1, first.count())
+
+	_, err = coordinator.Spawn(4, "arena-4", "default")
+	assert.Nil(t, err)
+
+	_, err = coordinator.WaitUntilSpawned(4)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, first.count())
+}
+
+func TestCoordinatorReleasesFailedSpawn(t *testing.T) {
+	broker := newMemoryBroker()
+	coordinator := NewCoordinator(broker)
+
+	assert.Nil(t, coordinator.Start())
+
+	spawner := startFakeWorker(t, broker, "worker", 1)
+	spawner.mutex.Lock()
+	spawner.fail = true
+	spawner.mutex.Unlock()
+
+	_, err := coordinator.Spawn(1, "arena-1", "default")
+	assert.Nil(t, err)
+
+	_, err = coordinator.WaitUntilSpawned(1)
+	assert.Error(t, err)
+
+	_, isPlaced := coordinator.WorkerOf(1)
+	assert.False(t, isPlaced)
+
+	spawner.mutex.Lock()
+	spawner.fail = false
+	spawner.mutex.Unlock()
+
+	_, err = coordinator.Spawn(1, "arena-1", "default")
+	assert.Nil(t, err)
+
+	_, err = coordinator.WaitUntilSpawned(1)
+	assert.Nil(t, err)
+}
+
+func TestCoordinatorSpawnDoesNotWait(t *testing.T) {
+	defer func(timeout time.Duration) { SPAWN_TIMEOUT = timeout }(SPAWN_TIMEOUT)
+	SPAWN_TIMEOUT = 50 * time.Millisecond
+
+	broker := newMemoryBroker()
+	coordinator := NewCoordinator(broker)
+
+	assert.Nil(t, coordinator.Start())
+
+	// A worker which never replies
+	coordinator.registry.Advertise(Advertisement{Worker: "silent", Capacity: 1}, time.Now())
+
+	worker, err := coordinator.Spawn(1, "arena-1", "default")
+	assert.Nil(t, err)
+	assert.Equal(t, "silent", worker)
+
+	// Another worker can't reply for the arena
+	assert.Nil(t, mq.PublishMessage(broker, "other", CHANNEL, TOPIC_SPAWNED, &SpawnReply{Worker: "other", Id: 1}))
+
+	_, err = coordinator.WaitUntilSpawned(1)
+	assert.Error(t, err)
+
+	_, isPlaced := coordinator.WorkerOf(1)
+	assert.False(t, isPlaced)
+}
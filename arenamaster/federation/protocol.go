@@ -0,0 +1,132 @@
+package federation
+
+import (
+	"errors"
+
+	"github.com/bytearena/backends/common/mq"
+)
+
+// Coordinator and workers talk on a single MQ channel, the topics addressed
+// to a single worker are prefixed by its id
+const (
+	CHANNEL = "arenaworker"
+
+	TOPIC_ADVERTISE = "advertise"
+	TOPIC_SPAWNED   = "spawned"
+	TOPIC_BOOTED    = "booted"
+
+	// The workers sign their messages as WORKER_COMPONENT_PREFIX + their id
+	WORKER_COMPONENT_PREFIX = "arena-worker."
+)
+
+func WorkerComponent(worker string) string {
+	return WORKER_COMPONENT_PREFIX + worker
+}
+
+// AuthorizeCoordinator restricts the advertisements and replies to the
+// workers, it only applies once the messages are verified
+func AuthorizeCoordinator(client mq.Broker) {
+	for _, topic := range []string{TOPIC_ADVERTISE, TOPIC_SPAWNED, TOPIC_BOOTED} {
+		client.Authorize(CHANNEL, topic, WORKER_COMPONENT_PREFIX)
+	}
+}
+
+// AuthorizeWorker restricts the requests of a worker to the coordinator
+func AuthorizeWorker(client mq.Broker, worker string) {
+	client.Authorize(CHANNEL, spawnTopic(worker), COORDINATOR_COMPONENT)
+	client.Authorize(CHANNEL, quitTopic(worker), COORDINATOR_COMPONENT)
+}
+
+func spawnTopic(worker string) string {
+	return worker + ".spawn"
+}
+
+func quitTopic(worker string) string {
+	return worker + ".quit"
+}
+
+// Advertisement is published periodically by each worker
+type Advertisement struct {
+	Worker   string   `json:"worker"`
+	Capacity int      `json:"capacity"`
+	Arenas   []int    `json:"arenas"`
+	Profiles []string `json:"profiles"`
+}
+
+// SpawnRequest carries the arena id issued by the coordinator, the
+// arena-server uses it on the MQ
+type SpawnRequest struct {
+	Id      int    `json:"id"`
+	ArenaId string `json:"arenaid"`
+	Profile string `json:"profile"`
+}
+
+// SpawnReply is published as soon as the arena is started
+type SpawnReply struct {
+	Worker   string            `json:"worker"`
+	Id       int               `json:"id"`
+	Metadata map[string]string `json:"metadata"`
+	Error    string            `json:"error,omitempty"`
+}
+
+type BootReply struct {
+	Worker string `json:"worker"`
+	Id     int    `json:"id"`
+	Error  string `json:"error,omitempty"`
+}
+
+type QuitRequest struct {
+	Id int `json:"id"`
+}
+
+func (m *Advertisement) Validate() error {
+	if m.Worker == "" {
+		return errors.New("Missing worker")
+	}
+
+	if m.Capacity < 0 {
+		return errors.New("Invalid capacity")
+	}
+
+	return nil
+}
+
+func (m *SpawnRequest) Validate() error {
+	if m.ArenaId == "" {
+		return errors.New("Missing arena id")
+	}
+
+	return nil
+}
+
+func (m *SpawnReply) Validate() error {
+	if m.Worker == "" {
+		return errors.New("Missing worker")
+	}
+
+	return nil
+}
+
+func (m *BootReply) Validate() error {
+	if m.Worker == "" {
+		return errors.New("Missing worker")
+	}
+
+	return nil
+}
+
+func (m *QuitRequest) Validate() error {
+	return nil
+}
+
+func init() {
+	for _, schema := range []mq.Schema{
+		{Channel: CHANNEL, Topic: TOPIC_ADVERTISE, Version: 1, New: func() mq.Message { return &Advertisement{} }},
+		{Channel: CHANNEL, Topic: TOPIC_SPAWNED, Version: 1, New: func() mq.Message { return &SpawnReply{} }},
+		{Channel: CHANNEL, Topic: TOPIC_BOOTED, Version: 1, New: func() mq.Message { return &BootReply{} }},
+		{Channel: CHANNEL, Topic: spawnTopic("*"), Version: 1, New: func() mq.Message { return &SpawnRequest{} }},
+		{Channel: CHANNEL, Topic: quitTopic("*"), Version: 1, New: func() mq.Message { return &QuitRequest{} }},
+	} {
+		mq.RegisterSchema(schema)
+	}
+}
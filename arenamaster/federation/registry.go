@@ -0,0 +1,186 @@
+package federation
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type workerEntry struct {
+	advertisement Advertisement
+	lastSeen      time.Time
+}
+
+// WorkerStatus is the view of a worker by the coordinator, Load counts the
+// arenas placed on it and the ones it advertised
+type WorkerStatus struct {
+	Worker   string
+	Capacity int
+	Load     int
+	Alive    bool
+	Profiles []string
+}
+
+// Registry keeps the workers which advertised themselves and on which worker
+// each arena is placed
+type Registry struct {
+	mutex      sync.Mutex
+	timeout    time.Duration
+	workers    map[string]*workerEntry
+	placements map[int]string
+}
+
+// Workers which didn't advertise for timeout don't get new arenas
+func NewRegistry(timeout time.Duration) *Registry {
+	return &Registry{
+		timeout:    timeout,
+		workers:    make(map[string]*workerEntry),
+		placements: make(map[int]string),
+	}
+}
+
+func (r *Registry) Advertise(advertisement Advertisement, now time.Time) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.workers[advertisement.Worker] = &workerEntry{
+		advertisement: advertisement,
+		lastSeen:      now,
+	}
+}
+
+func (r *Registry) load(worker string) int {
+	arenas := make(map[int]bool)
+
+	for id, placedOn := range r.placements {
+		if placedOn == worker {
+			arenas[id] = true
+		}
+	}
+
+	if entry, ok := r.workers[worker]; ok {
+		for _, id := range entry.advertisement.Arenas {
+			arenas[id] = true
+		}
+	}
+
+	return len(arenas)
+}
+
+func (r *Registry) isAlive(entry *workerEntry, now time.Time) bool {
+	return now.Sub(entry.lastSeen) <= r.timeout
+}
+
+func supportsProfile(advertisement Advertisement, profile string) bool {
+	// Workers which don't advertise profiles run any of them
+	if len(advertisement.Profiles) == 0 {
+		return true
+	}
+
+	for _, supported := range advertisement.Profiles {
+		if supported == profile {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Place assigns the arena to the alive worker with the least arenas which
+// still has capacity for it and runs the profile
+func (r *Registry) Place(id int, profile string, now time.Time) (string, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if worker, isPlaced := r.placements[id]; isPlaced {
+		return "", errors.New("Arena (" + strconv.Itoa(id) + ") is already placed on " + worker)
+	}
+
+	names := make([]string, 0, len(r.workers))
+
+	for name := range r.workers {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	best := ""
+	bestLoad := 0
+
+	for _, name := range names {
+		entry := r.workers[name]
+
+		if !r.isAlive(entry, now) || !supportsProfile(entry.advertisement, profile) {
+			continue
+		}
+
+		load := r.load(name)
+
+		if load >= entry.advertisement.Capacity {
+			continue
+		}
+
+		if best == "" || load < bestLoad {
+			best = name
+			bestLoad = load
+		}
+	}
+
+	if best == "" {
+		return "", errors.New("No worker has capacity for a " + profile + " arena")
+	}
+
+	r.placements[id] = best
+
+	return best, nil
+}
+
+// Assign records an arena placed without the registry, after a restart
+func (r *Registry) Assign(id int, worker string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.placements[id] = worker
+}
+
+func (r *Registry) Release(id int) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	delete(r.placements, id)
+}
+
+func (r *Registry) WorkerOf(id int) (string, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	worker, isPlaced := r.placements[id]
+
+	return worker, isPlaced
+}
+
+// Workers returns every known worker, ordered by name
+func (r *Registry) Workers(now time.Time) []WorkerStatus {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	res := make([]WorkerStatus, 0, len(r.workers))
+
+	for name, entry := range r.workers {
+		res = append(res, WorkerStatus{
+			Worker:   name,
+			Capacity: entry.advertisement.Capacity,
+			Load:     r.load(name),
+			Alive:    r.isAlive(entry, now),
+			Profiles: entry.advertisement.Profiles,
+		})
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Worker < res[j].Worker
+	})
+
+	return res
+}
@@ -0,0 +1,82 @@
+package federation
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlacementSpreadsArenas(t *testing.T) {
+	now := time.Now()
+	r := NewRegistry(time.Minute)
+
+	r.Advertise(Advertisement{Worker: "b", Capacity: 2}, now)
+	r.Advertise(Advertisement{Worker: "a", Capacity: 2}, now)
+
+	expected := []string{"a", "b", "a", "b"}
+
+	for id, worker := range expected {
+		placed, err := r.Place(id+1, "default", now)
+
+		assert.Nil(t, err)
+		assert.Equal(t, worker, placed)
+	}
+
+	_, err := r.Place(5, "default", now)
+	assert.Error(t, err)
+
+	r.Release(2)
+
+	placed, err := r.Place(5, "default", now)
+	assert.Nil(t, err)
+	assert.Equal(t, "b", placed)
+
+	_, err = r.Place(5, "default", now)
+	assert.Error(t, err, "an arena is only placed once")
+}
+
+func TestPlacementCountsAdvertisedArenas(t *testing.T) {
+	now := time.Now()
+	r := NewRegistry(time.Minute)
+
+	r.Advertise(Advertisement{Worker: "a", Capacity: 2, Arenas: []int{7}}, now)
+	r.Advertise(Advertisement{Worker: "b", Capacity: 2}, now)
+
+	placed, err := r.Place(1, "default", now)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "b", placed)
+
+	r.Assign(7, "a")
+
+	worker, isPlaced := r.WorkerOf(7)
+	assert.True(t, isPlaced)
+	assert.Equal(t, "a", worker)
+
+	statuses := r.Workers(now)
+
+	assert.Len(t, statuses, 2)
+	assert.Equal(t, 1, statuses[0].Load)
+	assert.Equal(t, 1, statuses[1].Load)
+}
+
+func TestPlacementSkipsUnavailableWorkers(t *testing.T) {
+	now := time.Now()
+	r := NewRegistry(time.Minute)
+
+	r.Advertise(Advertisement{Worker: "gone", Capacity: 10}, now.Add(-2*time.Minute))
+	r.Advertise(Advertisement{Worker: "small", Capacity: 10, Profiles: []string{"small"}}, now)
+
+	_, err := r.Place(1, "large", now)
+	assert.Error(t, err)
+
+	placed, err := r.Place(1, "small", now)
+	assert.Nil(t, err)
+	assert.Equal(t, "small", placed)
+
+	statuses := r.Workers(now)
+
+	assert.False(t, statuses[0].Alive)
+	assert.True(t, statuses[1].Alive)
+}
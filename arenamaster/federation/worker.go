@@ -0,0 +1,197 @@
+package federation
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	bamq "github.com/bytearena/core/common/mq"
+	"github.com/bytearena/core/common/utils"
+
+	"github.com/bytearena/backends/common/mq"
+)
+
+var (
+	ADVERTISE_FREQ = 5 * time.Second
+)
+
+// Spawner runs the arenas of a worker on its host
+type Spawner interface {
+	// Spawn starts the arena and returns its metadata
+	Spawn(id int, arenaId string, profile string) (metadata map[string]string, err error)
+	WaitUntilBooted(id int) error
+	Quit(id int) error
+}
+
+// Worker runs the arenas placed on its host by the coordinator
+type Worker struct {
+	id       string
+	capacity int
+	profiles []string
+	broker   bamq.ClientInterface
+	spawner  Spawner
+
+	mutex    sync.Mutex
+	arenas   map[int]bool
+	stopChan chan struct{}
+	stopOnce sync.Once
+}
+
+func NewWorker(id string, capacity int, profiles []string, broker bamq.ClientInterface, spawner Spawner) *Worker {
+	return &Worker{
+		id:       id,
+		capacity: capacity,
+		profiles: profiles,
+		broker:   broker,
+		spawner:  spawner,
+		arenas:   make(map[int]bool),
+		stopChan: make(chan struct{}),
+	}
+}
+
+func (w *Worker) Start() error {
+	err := mq.SubscribeMessages(w.broker, CHANNEL, spawnTopic(w.id), func(message mq.Message) {
+		go w.handleSpawn(*message.(*SpawnRequest))
+	})
+
+	if err != nil {
+		return err
+	}
+
+	err = mq.SubscribeMessages(w.broker, CHANNEL, quitTopic(w.id), func(message mq.Message) {
+		w.handleQuit(*message.(*QuitRequest))
+	})
+
+	if err != nil {
+		return err
+	}
+
+	w.advertise()
+
+	go func() {
+		ticker := time.NewTicker(ADVERTISE_FREQ)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				w.advertise()
+			case <-w.stopChan:
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+// Stop stops advertising, the arenas are left running
+func (w *Worker) Stop() {
+	w.stopOnce.Do(func() {
+		close(w.stopChan)
+	})
+}
+
+func (w *Worker) Advertisement() Advertisement {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	arenas := make([]int, 0, len(w.arenas))
+
+	for id := range w.arenas {
+		arenas = append(arenas, id)
+	}
+
+	sort.Ints(arenas)
+
+	return Advertisement{
+		Worker:   w.id,
+		Capacity: w.capacity,
+		Arenas:   arenas,
+		Profiles: w.profiles,
+	}
+}
+
+func (w *Worker) advertise() {
+	advertisement := w.Advertisement()
+
+	if err := mq.PublishMessage(w.broker, w.id, CHANNEL, TOPIC_ADVERTISE, &advertisement); err != nil {
+		utils.RecoverableError("worker", "Could not advertise: "+err.Error())
+	}
+}
+
+// Reserves a slot for the arena, the coordinator might have placed it with
+// an outdated advertisement
+func (w *Worker) reserve(id int) bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if len(w.arenas) >= w.capacity {
+		return false
+	}
+
+	w.arenas[id] = true
+
+	return true
+}
+
+func (w *Worker) release(id int) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	delete(w.arenas, id)
+}
+
+func (w *Worker) publish(topic string, message mq.Message) {
+	if err := mq.PublishMessage(w.broker, w.id, CHANNEL, topic, message); err != nil {
+		utils.RecoverableError("worker", "Could not publish "+topic+": "+err.Error())
+	}
+}
+
+func (w *Worker) handleSpawn(req SpawnRequest) {
+	reply := SpawnReply{
+		Worker: w.id,
+		Id:     req.Id,
+	}
+
+	if !w.reserve(req.Id) {
+		reply.Error = "worker is at capacity"
+		w.publish(TOPIC_SPAWNED, &reply)
+		return
+	}
+
+	metadata, err := w.spawner.Spawn(req.Id, req.ArenaId, req.Profile)
+
+	if err != nil {
+		w.release(req.Id)
+
+		reply.Error = err.Error()
+		w.publish(TOPIC_SPAWNED, &reply)
+		return
+	}
+
+	utils.Debug("worker", "Spawned arena ("+strconv.Itoa(req.Id)+")")
+
+	reply.Metadata = metadata
+	w.publish(TOPIC_SPAWNED, &reply)
+
+	boot := BootReply{
+		Worker: w.id,
+		Id:     req.Id,
+	}
+
+	if err := w.spawner.WaitUntilBooted(req.Id); err != nil {
+		boot.Error = err.Error()
+	}
+
+	w.publish(TOPIC_BOOTED, &boot)
+}
+
+func (w *Worker) handleQuit(req QuitRequest) {
+	w.release(req.Id)
+
+	if err := w.spawner.Quit(req.Id); err != nil {
+		utils.RecoverableError("worker", "Could not quit arena ("+strconv.Itoa(req.Id)+"): "+err.Error())
+	}
+}
@@ -0,0 +1,37 @@
+package graphql
+
+import (
+	"context"
+	"time"
+
+	"github.com/bytearena/backends/common/graphql"
+	"github.com/bytearena/backends/common/graphql/api"
+
+	coretypes "github.com/bytearena/core/common/types"
+	"github.com/bytearena/core/common/utils"
+)
+
+// ReportGameLaunchFailed puts the game back to pending while it's launched
+// again, or marks it as finished once it ran out of attempts
+func ReportGameLaunchFailed(gameid, mac string, retrying bool, gql *graphql.Client) {
+	game := api.GameInputUpdate{
+		RunStatus:       graphql.Int(coretypes.GameRunStatus.Pending),
+		ArenaServerUUID: graphql.String(mac),
+	}
+
+	if !retrying {
+		game.RunStatus = graphql.Int(coretypes.GameRunStatus.Finished)
+		game.EndedAt = graphql.String(time.Now().Format(time.RFC822Z))
+	}
+
+	_, err := api.UpdateGame(context.Background(), gql, api.UpdateGameVariables{
+		Id:   graphql.String(gameid),
+		Game: game,
+	})
+
+	if err != nil {
+		utils.Debug("master", "ERROR: could not report failed launch for Game "+gameid+" on server "+mac)
+	} else {
+		utils.Debug("master", "Reported failed launch for Game "+gameid+" on server "+mac)
+	}
+}
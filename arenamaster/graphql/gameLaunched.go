@@ -1,9 +1,11 @@
 package graphql
 
 import (
+	"context"
 	"time"
 
 	"github.com/bytearena/backends/common/graphql"
+	"github.com/bytearena/backends/common/graphql/api"
 
 	coretypes "github.com/bytearena/core/common/types"
 	"github.com/bytearena/core/common/utils"
@@ -12,16 +14,14 @@ import (
 func ReportGameLaunched(gameid, mac string, gql *graphql.Client) {
 
 	// syncing state in graphql db
-	_, err := gql.RequestSync(
-		graphql.NewQuery(updateGameStateMutation).SetVariables(graphql.Variables{
-			"id": gameid,
-			"game": graphql.Variables{
-				"runStatus":       coretypes.GameRunStatus.Running,
-				"launchedAt":      time.Now().Format(time.RFC822Z),
-				"arenaServerUUID": mac,
-			},
-		}),
-	)
+	_, err := api.UpdateGame(context.Background(), gql, api.UpdateGameVariables{
+		Id: graphql.String(gameid),
+		Game: api.GameInputUpdate{
+			RunStatus:       graphql.Int(coretypes.GameRunStatus.Running),
+			LaunchedAt:      graphql.String(time.Now().Format(time.RFC822Z)),
+			ArenaServerUUID: graphql.String(mac),
+		},
+	})
 
 	if err != nil {
 		utils.Debug("master", "ERROR: could not set game state to running for Game "+gameid+" on server "+mac)
@@ -0,0 +1,29 @@
+package graphql
+
+import (
+	"context"
+	"errors"
+
+	"github.com/bytearena/backends/common/graphql"
+	"github.com/bytearena/backends/common/graphql/api"
+)
+
+// FetchGameContestants returns the number of contestants of the game and the
+// maximum its arena allows
+func FetchGameContestants(gameid string, gql *graphql.Client) (int, int, error) {
+	response, err := api.GameResources(context.Background(), gql, api.GameResourcesVariables{
+		Gameid: graphql.String(gameid),
+	})
+
+	if err != nil {
+		return 0, 0, errors.New("Could not fetch game '" + gameid + "' from GraphQL")
+	}
+
+	if len(response.Games) == 0 {
+		return 0, 0, errors.New("Game '" + gameid + "' does not exist")
+	}
+
+	game := response.Games[0]
+
+	return len(game.Contestants), game.Arena.MaxContestants, nil
+}
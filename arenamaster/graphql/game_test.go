@@ -0,0 +1,67 @@
+package graphql
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bytearena/backends/common/graphql"
+	"github.com/bytearena/backends/common/graphql/api"
+	"github.com/bytearena/backends/common/graphql/mock"
+	coretypes "github.com/bytearena/core/common/types"
+	"github.com/stretchr/testify/assert"
+)
+
+const fixtures = `{
+	"games": [{
+		"id": "1",
+		"runStatus": 0,
+		"arena": {"maxContestants": 4},
+		"contestants": [{"id": "2"}, {"id": "3"}]
+	}]
+}`
+
+func startServer(t *testing.T) (*httptest.Server, *graphql.Client) {
+	records, err := mock.ParseFixtures([]byte(fixtures))
+	assert.Nil(t, err)
+
+	server := httptest.NewServer(mock.NewServer(records))
+
+	return server, graphql.NewClient(server.URL)
+}
+
+func fetchGame(t *testing.T, gql *graphql.Client) api.GamesResponseGames {
+	response, err := api.Games(context.Background(), gql, api.GamesVariables{Gameid: graphql.String("1")})
+	assert.Nil(t, err)
+
+	return response.Games[0]
+}
+
+func TestFetchGameContestants(t *testing.T) {
+	server, gql := startServer(t)
+	defer server.Close()
+
+	contestants, maxContestants, err := FetchGameContestants("1", gql)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, contestants)
+	assert.Equal(t, 4, maxContestants)
+
+	_, _, err = FetchGameContestants("404", gql)
+	assert.EqualError(t, err, "Game '404' does not exist")
+}
+
+func TestReportGameRunStatus(t *testing.T) {
+	server, gql := startServer(t)
+	defer server.Close()
+
+	ReportGameLaunched("1", "mac", gql)
+	assert.Equal(t, coretypes.GameRunStatus.Running, fetchGame(t, gql).RunStatus)
+
+	ReportGameLaunchFailed("1", "mac", true, gql)
+	assert.Equal(t, coretypes.GameRunStatus.Pending, fetchGame(t, gql).RunStatus)
+
+	ReportGameLaunchFailed("1", "mac", false, gql)
+	game := fetchGame(t, gql)
+	assert.Equal(t, coretypes.GameRunStatus.Finished, game.RunStatus)
+	assert.NotEqual(t, "", game.EndedAt)
+}
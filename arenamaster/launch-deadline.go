@@ -0,0 +1,96 @@
+package arenamaster
+
+import (
+	"strconv"
+	"time"
+
+	arenamasterGraphql "github.com/bytearena/backends/arenamaster/graphql"
+	"github.com/bytearena/backends/arenamaster/queue"
+	"github.com/bytearena/backends/arenamaster/state"
+
+	"github.com/bytearena/core/common/utils"
+)
+
+var (
+	LAUNCH_DEADLINE     = 1 * time.Minute
+	LAUNCH_MAX_ATTEMPTS = 3
+)
+
+type launchDeadline struct {
+	id      int
+	gameid  string
+	attempt int
+}
+
+// startLaunchDeadline checks after LAUNCH_DEADLINE that the arena confirmed
+// the launch, the check runs in the eventloop
+func (server *Server) startLaunchDeadline(id int, gameid string, attempt int) {
+	go func() {
+		<-time.After(LAUNCH_DEADLINE)
+		server.launchDeadlines <- launchDeadline{id, gameid, attempt}
+	}()
+}
+
+func (server *Server) handleLaunchDeadline(deadline launchDeadline) {
+	id, gameid, attempt := deadline.id, deadline.gameid, deadline.attempt
+
+	// The launch was confirmed, or the VM was halted or reused since
+	if server.state.GetStatus(id)&state.STATE_PENDING_ARENA == 0 {
+		return
+	}
+
+	currentGameId, _ := server.state.GetVMMetadata(id, "gameid")
+	currentAttempt, _ := server.state.GetVMMetadata(id, "launchattempt")
+
+	if currentGameId != gameid || currentAttempt != strconv.Itoa(attempt) {
+		return
+	}
+
+	if err := server.state.UpdateStateErroredArena(id); err != nil {
+		checkTransition(err)
+		return
+	}
+
+	utils.RecoverableError("launch-deadline", "Game "+gameid+" was not launched on VM ("+strconv.Itoa(id)+") after "+LAUNCH_DEADLINE.String()+" (attempt "+strconv.Itoa(attempt)+"/"+strconv.Itoa(LAUNCH_MAX_ATTEMPTS)+")")
+
+	arenaId := ""
+	priority := queue.PRIORITY_NORMAL
+
+	if vm := server.state.GetVM(id); vm != nil {
+		arenaId, _ = GetArenaId(vm)
+	}
+
+	if rawPriority, hasPriority := server.state.GetVMMetadata(id, "launchpriority"); hasPriority {
+		priority, _ = strconv.Atoi(rawPriority)
+	}
+
+	profile := server.getVMProfile(id)
+
+	if err := server.haltVM(id); err != nil {
+		utils.RecoverableError("vm", "Could not halt ("+strconv.Itoa(id)+"): "+err.Error())
+	}
+
+	retrying := attempt < LAUNCH_MAX_ATTEMPTS
+
+	arenamasterGraphql.ReportGameLaunchFailed(gameid, arenaId, retrying, server.graphqlclient)
+
+	if !retrying {
+		utils.RecoverableError("launch-deadline", "Giving up on game "+gameid)
+		return
+	}
+
+	// Retried launches go first among the launches of the same priority
+	err := server.launchQueue.Requeue(queue.Launch{
+		GameId:   gameid,
+		Priority: priority,
+		Attempts: attempt,
+		Queued:   time.Now(),
+		Profile:  profile,
+	})
+
+	if err != nil {
+		utils.RecoverableError("launch-queue", err.Error())
+	}
+
+	server.dispatchLaunches()
+}
@@ -0,0 +1,150 @@
+package arenamaster
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/bytearena/backends/arenamaster/queue"
+	"github.com/bytearena/backends/arenamaster/state"
+	"github.com/bytearena/backends/common/mq"
+
+	"github.com/bytearena/core/common/types"
+	"github.com/bytearena/core/common/utils"
+)
+
+// The priority of a game:launch is optional
+func launchPriority(priority mq.OptionalInt) int {
+	if !priority.IsSet {
+		return queue.PRIORITY_NORMAL
+	}
+
+	return priority.Value
+}
+
+// queueLaunch queues a game unless it's already queued, launched or running
+func (server *Server) queueLaunch(gameid string, priority int, profile string) {
+	if server.state.FindVMByMetadata(state.STATE_PENDING_ARENA|state.STATE_RUNNING_ARENA, "gameid", gameid) != nil {
+		utils.RecoverableError("vm", "Could not launch game: Game is already running")
+		return
+	}
+
+	queued, err := server.launchQueue.Push(gameid, priority, profile)
+
+	if err != nil {
+		utils.RecoverableError("launch-queue", err.Error())
+	}
+
+	if !queued {
+		utils.RecoverableError("launch-queue", "Game "+gameid+" is already queued")
+		return
+	}
+
+	utils.Debug("launch-queue", "Queued game "+gameid+" with priority "+strconv.Itoa(priority)+" on profile "+profile)
+}
+
+// Launches queued before profiles existed use the default one
+func getLaunchProfile(launch queue.Launch) string {
+	if launch.Profile == "" {
+		return DEFAULT_PROFILE
+	}
+
+	return launch.Profile
+}
+
+// One idle arena of each profile
+func (server *Server) findIdleArenas() map[string]*state.VMSnapshot {
+	res := make(map[string]*state.VMSnapshot)
+
+	for _, vm := range server.state.GetVMs() {
+		id := vm.Config.Id
+
+		if vm.Status != state.STATUS_IDLE {
+			continue
+		}
+
+		if _, hasArena := res[server.getVMProfile(id)]; !hasArena {
+			res[server.getVMProfile(id)] = vm
+		}
+	}
+
+	return res
+}
+
+// dispatchLaunches launches the queued games on the idle arenas of their
+// profile, the remaining ones wait for the next handshake
+func (server *Server) dispatchLaunches() {
+	if server.IsDraining() {
+		return
+	}
+
+	for {
+		idleArenas := server.findIdleArenas()
+
+		if len(idleArenas) == 0 {
+			return
+		}
+
+		launch, err := server.launchQueue.PopFirst(func(launch queue.Launch) bool {
+			_, hasArena := idleArenas[getLaunchProfile(launch)]
+			return hasArena
+		})
+
+		// The launch is still dispatched, it just won't survive a restart
+		if err != nil {
+			utils.RecoverableError("launch-queue", err.Error())
+		}
+
+		if launch == nil {
+			return
+		}
+
+		vm := idleArenas[getLaunchProfile(*launch)]
+		id := vm.Config.Id
+		attempt := launch.Attempts + 1
+
+		if err := server.state.UpdateStateTriedLaunchArena(id); err != nil {
+			utils.RecoverableError("vm", "Could not launch game: "+err.Error())
+
+			if err := server.launchQueue.Requeue(*launch); err != nil {
+				utils.RecoverableError("launch-queue", err.Error())
+			}
+
+			return
+		}
+
+		checkTransition(server.state.UpdateVMMetadata(id, "gameid", launch.GameId))
+		checkTransition(server.state.UpdateVMMetadata(id, "launchattempt", strconv.Itoa(attempt)))
+		checkTransition(server.state.UpdateVMMetadata(id, "launchpriority", strconv.Itoa(launch.Priority)))
+
+		utils.Debug("launch-queue", "Dispatching game "+launch.GameId+" to VM ("+strconv.Itoa(id)+"), attempt "+strconv.Itoa(attempt))
+
+		arenaId, _ := GetArenaId(vm)
+
+		onGameLaunch(
+			launch.GameId,
+			server.brokerclient,
+			server.graphqlclient,
+			arenaId,
+		)
+
+		server.startLaunchDeadline(id, launch.GameId, attempt)
+	}
+}
+
+func handleDebugGetLaunchQueue(launchQueue *queue.Queue) types.MQPayload {
+	launches := make([]types.MQPayload, 0)
+
+	for _, launch := range launchQueue.List() {
+		launches = append(launches, types.MQPayload{
+			"gameid":   launch.GameId,
+			"priority": launch.Priority,
+			"attempts": launch.Attempts,
+			"profile":  getLaunchProfile(launch),
+			"queued":   launch.Queued.Format(time.RFC3339),
+		})
+	}
+
+	return types.MQPayload{
+		"launches": launches,
+	}
+}
@@ -13,19 +13,17 @@ var (
 	PORT = 8080
 )
 
-func (server *Server) createMetadataServer() {
+func (b *schnappsBackend) createMetadataServer() {
 	retrieveVMFn := func(id string) *vm.VM {
-		vm := FindVMByMAC(server.state, id)
-
-		return vm
+		return b.findVM(id)
 	}
 
-	metadataServer := vmmeta.NewServer(fmt.Sprintf("%s:%d", server.vmBridgeIP, PORT), retrieveVMFn)
+	metadataServer := vmmeta.NewServer(fmt.Sprintf("%s:%d", b.config.BridgeIP, PORT), retrieveVMFn)
 
 	go func() {
 		err := metadataServer.Start()
 		utils.Check(err, "Could not start metadata server")
 
-		server.MetadataServer = metadataServer
+		b.MetadataServer = metadataServer
 	}()
 }
@@ -1,57 +1,65 @@
 package arenamaster
 
 import (
-	"encoding/json"
-
-	bamq "github.com/bytearena/core/common/mq"
-	"github.com/bytearena/core/common/types"
 	"github.com/bytearena/core/common/utils"
 
 	"github.com/bytearena/backends/common/mq"
 )
 
-type Res chan types.MQMessage
+type Res chan mq.Message
+
+// arenaMessage is published by an arena-server, the component which signed
+// it is empty when the messages are not verified
+type arenaMessage struct {
+	message   mq.Message
+	component string
+}
 
 type Listener struct {
-	arenaAdd           Res
-	arenaHalt          Res
-	gameLaunch         Res
-	gameLaunched       Res
-	gameHandshake      Res
-	gameStopped        Res
-	gameHealthcheckRes Res
-
-	debugGetVMStatus Res
+	arenaAdd   Res
+	arenaHalt  Res
+	gameLaunch Res
+
+	// Of arenaMessage
+	gameLaunched  chan interface{}
+	gameHandshake chan interface{}
+	gameStopped   chan interface{}
+
+	masterDrain Res
 }
 
-func MakeListener(mqClient *mq.Client) Listener {
+func MakeListener(mqClient mq.Broker) Listener {
 	return Listener{
 		arenaAdd:  subscribeToChannelAndGetChan(mqClient, "arena", "add"),
 		arenaHalt: subscribeToChannelAndGetChan(mqClient, "arena", "halt"),
 
-		gameLaunch:         subscribeToChannelAndGetChan(mqClient, "game", "launch"),
-		gameLaunched:       subscribeToChannelAndGetChan(mqClient, "game", "launched"),
-		gameHandshake:      subscribeToChannelAndGetChan(mqClient, "game", "handshake"),
-		gameStopped:        subscribeToChannelAndGetChan(mqClient, "game", "stopped"),
-		gameHealthcheckRes: subscribeToChannelAndGetChan(mqClient, "game", "healthcheck-res"),
-
-		debugGetVMStatus: subscribeToChannelAndGetChan(mqClient, "debug", "getvmstatus"),
+		gameLaunch:    subscribeToChannelAndGetChan(mqClient, "game", "launch"),
+		gameLaunched:  subscribeToArenaChannel(mqClient, "game", "launched"),
+		gameHandshake: subscribeToArenaChannel(mqClient, "game", "handshake"),
+		gameStopped:   subscribeToArenaChannel(mqClient, "game", "stopped"),
+		masterDrain:   subscribeToChannelAndGetChan(mqClient, "arenamaster", "drain"),
 	}
 }
 
-func subscribeToChannelAndGetChan(mqClient *mq.Client, channel, topic string) Res {
+// Invalid messages are rejected on <topic>-error before reaching the channel
+func subscribeToChannelAndGetChan(mqClient mq.Broker, channel, topic string) Res {
 	res := make(Res)
 
-	err := mqClient.Subscribe(channel, topic, func(msg bamq.BrokerMessage) {
-		var message types.MQMessage
-		err := json.Unmarshal(msg.Data, &message)
+	err := mq.SubscribeMessages(mqClient, channel, topic, func(message mq.Message) {
+		res <- message
+	})
+
+	utils.Check(err, "Could not subscribe to mq")
 
-		if err != nil {
-			utils.RecoverableError("event listener", err.Error())
-			return
-		}
+	return res
+}
 
-		res <- message
+// The handlers check that the arena of the message is the one which signed it
+func subscribeToArenaChannel(mqClient mq.Broker, channel, topic string) chan interface{} {
+	res := make(chan interface{})
+
+	err := mq.SubscribeSignedMessages(mqClient, channel, topic, func(message mq.Message, component string) {
+		res <- arenaMessage{message, component}
 	})
 
 	utils.Check(err, "Could not subscribe to mq")
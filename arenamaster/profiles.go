@@ -0,0 +1,240 @@
+package arenamaster
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bytearena/core/common/utils"
+
+	arenamasterGraphql "github.com/bytearena/backends/arenamaster/graphql"
+	"github.com/bytearena/backends/common/mq"
+)
+
+// ResourceProfile sizes the VMs of an arena, each profile has its own pool
+type ResourceProfile struct {
+	Name          string
+	MegMemory     int
+	CPUAmount     int
+	CPUCoreAmount int
+
+	// Biggest game the profile is picked for, 0 for any game
+	MaxContestants int
+}
+
+var (
+	DEFAULT_PROFILE = "default"
+
+	// Launches whose profile is being picked
+	LAUNCH_PROFILES_PENDING = 64
+
+	RESOURCE_PROFILES = map[string]ResourceProfile{
+		"small": {
+			Name:           "small",
+			MegMemory:      1024,
+			CPUAmount:      1,
+			CPUCoreAmount:  1,
+			MaxContestants: 2,
+		},
+		"default": {
+			Name:           "default",
+			MegMemory:      2048,
+			CPUAmount:      1,
+			CPUCoreAmount:  1,
+			MaxContestants: 8,
+		},
+		"large": {
+			Name:          "large",
+			MegMemory:     4096,
+			CPUAmount:     2,
+			CPUCoreAmount: 2,
+		},
+	}
+)
+
+// ParseResourceProfiles reads profiles written as
+// name:megMemory:cpuAmount:cpuCoreAmount:maxContestants, separated by commas
+func ParseResourceProfiles(spec string) (map[string]ResourceProfile, error) {
+	profiles := make(map[string]ResourceProfile)
+
+	for _, field := range strings.Split(spec, ",") {
+		parts := strings.Split(strings.TrimSpace(field), ":")
+
+		if len(parts) != 5 || parts[0] == "" {
+			return nil, errors.New("Invalid resource profile " + field)
+		}
+
+		values := make([]int, 4)
+
+		for i, part := range parts[1:] {
+			value, err := strconv.Atoi(part)
+
+			if err != nil || value < 0 {
+				return nil, errors.New("Invalid resource profile " + field)
+			}
+
+			values[i] = value
+		}
+
+		if _, exists := profiles[parts[0]]; exists {
+			return nil, errors.New("Resource profile " + parts[0] + " is defined twice")
+		}
+
+		profiles[parts[0]] = ResourceProfile{
+			Name:           parts[0],
+			MegMemory:      values[0],
+			CPUAmount:      values[1],
+			CPUCoreAmount:  values[2],
+			MaxContestants: values[3],
+		}
+	}
+
+	if _, ok := profiles[DEFAULT_PROFILE]; !ok {
+		return nil, errors.New("The resource profiles must define " + DEFAULT_PROFILE)
+	}
+
+	return profiles, nil
+}
+
+func GetProfileNames() []string {
+	names := make([]string, 0, len(RESOURCE_PROFILES))
+
+	for name := range RESOURCE_PROFILES {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+func getProfile(name string) ResourceProfile {
+	if profile, ok := RESOURCE_PROFILES[name]; ok {
+		return profile
+	}
+
+	return RESOURCE_PROFILES[DEFAULT_PROFILE]
+}
+
+// profileForContestants picks the smallest profile fitting the game
+func profileForContestants(contestants int) string {
+	best := ""
+
+	for _, name := range GetProfileNames() {
+		profile := RESOURCE_PROFILES[name]
+
+		if profile.MaxContestants != 0 && profile.MaxContestants < contestants {
+			continue
+		}
+
+		if best == "" || isSmallerProfile(profile, RESOURCE_PROFILES[best]) {
+			best = name
+		}
+	}
+
+	if best == "" {
+		return DEFAULT_PROFILE
+	}
+
+	return best
+}
+
+func isSmallerProfile(a, b ResourceProfile) bool {
+	if a.MaxContestants == 0 {
+		return false
+	}
+
+	return b.MaxContestants == 0 || a.MaxContestants < b.MaxContestants
+}
+
+// launchProfile reads the profile of a game:launch, or picks it according to
+// the contestants of the game
+func (server *Server) launchProfile(gameid, name string) string {
+	if name != "" {
+		if _, ok := RESOURCE_PROFILES[name]; ok {
+			return name
+		}
+
+		utils.RecoverableError("profiles", "Unknown profile "+name+" for game "+gameid)
+	}
+
+	contestants, maxContestants, err := arenamasterGraphql.FetchGameContestants(gameid, server.graphqlclient)
+
+	if err != nil {
+		utils.RecoverableError("profiles", err.Error())
+		return DEFAULT_PROFILE
+	}
+
+	if contestants == 0 {
+		contestants = maxContestants
+	}
+
+	profile := profileForContestants(contestants)
+	utils.Debug("profiles", "Game "+gameid+" with "+strconv.Itoa(contestants)+" contestants uses profile "+profile)
+
+	return profile
+}
+
+type resolvedLaunch struct {
+	msg     *mq.GameLaunch
+	profile string
+}
+
+// resolveLaunchProfiles picks the profile of each game:launch before it
+// reaches the eventloop, launchProfile may wait for the GraphQL API. The
+// profiles are picked concurrently but the launches keep their order.
+func (server *Server) resolveLaunchProfiles(launches Res, stop chan bool) chan interface{} {
+	resolved := make(chan interface{})
+	pending := make(chan chan interface{}, LAUNCH_PROFILES_PENDING)
+
+	go func() {
+		for {
+			select {
+			case message := <-launches:
+				msg := message.(*mq.GameLaunch)
+				future := make(chan interface{}, 1)
+
+				go func() {
+					future <- resolvedLaunch{msg, server.launchProfile(msg.Id, msg.Profile)}
+				}()
+
+				select {
+				case pending <- future:
+				case <-stop:
+					return
+				}
+
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	go func() {
+		for {
+			select {
+			case future := <-pending:
+				select {
+				case resolved <- <-future:
+				case <-stop:
+					return
+				}
+
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	return resolved
+}
+
+// Arenas spawned before profiles existed are in the default one
+func (server *Server) getVMProfile(id int) string {
+	if name, hasProfile := server.state.GetVMMetadata(id, "profile"); hasProfile {
+		return name
+	}
+
+	return DEFAULT_PROFILE
+}
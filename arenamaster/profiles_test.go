@@ -0,0 +1,97 @@
+package arenamaster
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bytearena/backends/common/graphql"
+	"github.com/bytearena/backends/common/mq"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProfileForContestants(t *testing.T) {
+	cases := map[int]string{
+		0:  "small",
+		2:  "small",
+		3:  "default",
+		8:  "default",
+		9:  "large",
+		64: "large",
+	}
+
+	for contestants, expected := range cases {
+		assert.Equal(t, expected, profileForContestants(contestants))
+	}
+}
+
+func TestUnknownProfileIsDefault(t *testing.T) {
+	assert.Equal(t, DEFAULT_PROFILE, getProfile("unknown").Name)
+	assert.Equal(t, "large", getProfile("large").Name)
+}
+
+// A slow GraphQL API delays the launches after the one whose profile it
+// picks, the launches keep their order
+func TestResolveLaunchProfiles(t *testing.T) {
+	release := make(chan bool)
+
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.Write([]byte(`{"data": {"games": [{"arena": {"maxContestants": 16}, "contestants": []}]}}`))
+	}))
+	defer api.Close()
+
+	server := &Server{graphqlclient: graphql.NewClient(api.URL)}
+
+	launches := make(Res)
+	stop := make(chan bool)
+	defer close(stop)
+
+	resolved := server.resolveLaunchProfiles(launches, stop)
+
+	launches <- &mq.GameLaunch{Id: "1"}
+	launches <- &mq.GameLaunch{Id: "2", Profile: "small"}
+	launches <- &mq.GameLaunch{Id: "3", Profile: "default"}
+
+	select {
+	case launch := <-resolved:
+		t.Fatalf("Game %s was resolved before game 1", launch.(resolvedLaunch).msg.Id)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+
+	var ids, profiles []string
+
+	for i := 0; i < 3; i++ {
+		launch := (<-resolved).(resolvedLaunch)
+
+		ids = append(ids, launch.msg.Id)
+		profiles = append(profiles, launch.profile)
+	}
+
+	assert.Equal(t, []string{"1", "2", "3"}, ids)
+	assert.Equal(t, []string{"large", "small", "default"}, profiles)
+}
+
+func TestParseResourceProfiles(t *testing.T) {
+	profiles, err := ParseResourceProfiles("default:2048:1:1:0, gpu:8192:4:4:32")
+	assert.Nil(t, err)
+
+	assert.Equal(t, map[string]ResourceProfile{
+		"default": {Name: "default", MegMemory: 2048, CPUAmount: 1, CPUCoreAmount: 1},
+		"gpu":     {Name: "gpu", MegMemory: 8192, CPUAmount: 4, CPUCoreAmount: 4, MaxContestants: 32},
+	}, profiles)
+
+	for _, spec := range []string{
+		"",
+		"small:1024:1:1:2",
+		"default:2048:1:1",
+		"default:2048:one:1:0",
+		"default:2048:1:1:0,default:4096:2:2:0",
+	} {
+		_, err := ParseResourceProfiles(spec)
+		assert.Error(t, err, spec)
+	}
+}
@@ -0,0 +1,227 @@
+package queue
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	PRIORITY_NORMAL = 0
+	PRIORITY_RANKED = 10
+)
+
+// Launch is a game waiting for an idle arena. Launches are dispatched by
+// descending priority, then in the order they were queued.
+type Launch struct {
+	GameId   string    `json:"gameid"`
+	Priority int       `json:"priority"`
+	Seq      uint64    `json:"seq"`
+	Queued   time.Time `json:"queued"`
+
+	// Launches which were dispatched but never confirmed
+	Attempts int `json:"attempts,omitempty"`
+
+	// Resource profile of the arena the game needs
+	Profile string `json:"profile,omitempty"`
+}
+
+// Store persists the whole queue after each change
+type Store interface {
+	Save(launches []Launch) error
+	Load() ([]Launch, error)
+}
+
+type Queue struct {
+	mutex    sync.Mutex
+	launches []Launch
+	nextSeq  uint64
+	store    Store
+}
+
+// NewQueue loads the launches of the store, a nil store keeps the queue in
+// memory only
+func NewQueue(store Store) (*Queue, error) {
+	// Seq 0 is left for the launches which are retried
+	q := &Queue{
+		launches: make([]Launch, 0),
+		nextSeq:  1,
+		store:    store,
+	}
+
+	if store == nil {
+		return q, nil
+	}
+
+	launches, err := store.Load()
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, launch := range launches {
+		if launch.Seq >= q.nextSeq {
+			q.nextSeq = launch.Seq + 1
+		}
+
+		q.insert(launch)
+	}
+
+	return q, nil
+}
+
+// Must be called with the queue locked
+func (q *Queue) insert(launch Launch) {
+	i := sort.Search(len(q.launches), func(i int) bool {
+		other := q.launches[i]
+
+		if other.Priority != launch.Priority {
+			return other.Priority < launch.Priority
+		}
+
+		return other.Seq > launch.Seq
+	})
+
+	q.launches = append(q.launches, Launch{})
+	copy(q.launches[i+1:], q.launches[i:])
+	q.launches[i] = launch
+}
+
+// Must be called with the queue locked
+func (q *Queue) indexOf(gameid string) int {
+	for i, launch := range q.launches {
+		if launch.GameId == gameid {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// Must be called with the queue locked
+func (q *Queue) save() error {
+	if q.store == nil {
+		return nil
+	}
+
+	if err := q.store.Save(q.launches); err != nil {
+		return errors.New("Could not save launch queue: " + err.Error())
+	}
+
+	return nil
+}
+
+// Push queues a game, it returns false if the game is already queued
+func (q *Queue) Push(gameid string, priority int, profile string) (bool, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.indexOf(gameid) != -1 {
+		return false, nil
+	}
+
+	q.insert(Launch{
+		GameId:   gameid,
+		Priority: priority,
+		Seq:      q.nextSeq,
+		Queued:   time.Now(),
+		Profile:  profile,
+	})
+
+	q.nextSeq++
+
+	return true, q.save()
+}
+
+// Requeue puts back a launch which could not be dispatched, it keeps its
+// place in the queue
+func (q *Queue) Requeue(launch Launch) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.indexOf(launch.GameId) != -1 {
+		return nil
+	}
+
+	q.insert(launch)
+
+	return q.save()
+}
+
+// Pop removes the next launch, it returns nil if the queue is empty
+func (q *Queue) Pop() (*Launch, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if len(q.launches) == 0 {
+		return nil, nil
+	}
+
+	launch := q.launches[0]
+	q.launches = q.launches[1:]
+
+	return &launch, q.save()
+}
+
+// PopFirst removes the next launch matching fn, it returns nil if none does
+func (q *Queue) PopFirst(fn func(launch Launch) bool) (*Launch, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	for i, launch := range q.launches {
+		if fn(launch) {
+			q.launches = append(q.launches[:i], q.launches[i+1:]...)
+
+			return &launch, q.save()
+		}
+	}
+
+	return nil, nil
+}
+
+// Remove cancels a queued launch, it returns false if the game wasn't queued
+func (q *Queue) Remove(gameid string) (bool, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	i := q.indexOf(gameid)
+
+	if i == -1 {
+		return false, nil
+	}
+
+	q.launches = append(q.launches[:i], q.launches[i+1:]...)
+
+	return true, q.save()
+}
+
+func (q *Queue) Contains(gameid string) bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	return q.indexOf(gameid) != -1
+}
+
+func (q *Queue) Len() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	return len(q.launches)
+}
+
+// List returns the queued launches in dispatch order
+func (q *Queue) List() []Launch {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	res := make([]Launch, len(q.launches))
+	copy(res, q.launches)
+
+	return res
+}
+
+// Persistent tells whether the launches survive a restart
+func (q *Queue) Persistent() bool {
+	return q.store != nil
+}
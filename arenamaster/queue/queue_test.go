@@ -0,0 +1,181 @@
+package queue
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func createTestStore(t *testing.T) (*FileStore, func()) {
+	dir, err := ioutil.TempDir("", "arenamaster-queue")
+	assert.Nil(t, err)
+
+	store, err := NewFileStore(filepath.Join(dir, "launch-queue.json"))
+	assert.Nil(t, err)
+
+	return store, func() {
+		os.RemoveAll(dir)
+	}
+}
+
+func gameIds(launches []Launch) []string {
+	res := make([]string, 0)
+
+	for _, launch := range launches {
+		res = append(res, launch.GameId)
+	}
+
+	return res
+}
+
+func popGameId(t *testing.T, q *Queue) string {
+	launch, err := q.Pop()
+	assert.Nil(t, err)
+
+	if launch == nil {
+		return ""
+	}
+
+	return launch.GameId
+}
+
+func TestQueueOrder(t *testing.T) {
+	q, err := NewQueue(nil)
+	assert.Nil(t, err)
+
+	q.Push("a", PRIORITY_NORMAL, "")
+	q.Push("b", PRIORITY_NORMAL, "")
+	q.Push("c", PRIORITY_RANKED, "")
+	q.Push("d", PRIORITY_NORMAL, "")
+	q.Push("e", PRIORITY_RANKED, "")
+
+	assert.Equal(t, []string{"c", "e", "a", "b", "d"}, gameIds(q.List()))
+
+	assert.Equal(t, "c", popGameId(t, q))
+	assert.Equal(t, "e", popGameId(t, q))
+	assert.Equal(t, "a", popGameId(t, q))
+	assert.Equal(t, 2, q.Len())
+}
+
+func TestQueueDeduplication(t *testing.T) {
+	q, _ := NewQueue(nil)
+
+	queued, err := q.Push("a", PRIORITY_NORMAL, "")
+	assert.Nil(t, err)
+	assert.True(t, queued)
+
+	queued, err = q.Push("a", PRIORITY_RANKED, "")
+	assert.Nil(t, err)
+	assert.False(t, queued)
+
+	assert.Equal(t, 1, q.Len())
+	assert.True(t, q.Contains("a"))
+
+	removed, _ := q.Remove("a")
+	assert.True(t, removed)
+
+	removed, _ = q.Remove("a")
+	assert.False(t, removed)
+
+	assert.Equal(t, "", popGameId(t, q))
+}
+
+func TestQueueRequeueKeepsPlace(t *testing.T) {
+	q, _ := NewQueue(nil)
+
+	q.Push("a", PRIORITY_NORMAL, "")
+	q.Push("b", PRIORITY_NORMAL, "")
+
+	launch, _ := q.Pop()
+	q.Push("c", PRIORITY_NORMAL, "")
+
+	assert.Nil(t, q.Requeue(*launch))
+	assert.Nil(t, q.Requeue(*launch))
+
+	assert.Equal(t, []string{"a", "b", "c"}, gameIds(q.List()))
+}
+
+func TestQueueSurvivesRestart(t *testing.T) {
+	store, cleanup := createTestStore(t)
+	defer cleanup()
+
+	q, err := NewQueue(store)
+	assert.Nil(t, err)
+
+	q.Push("a", PRIORITY_NORMAL, "")
+	q.Push("b", PRIORITY_RANKED, "")
+	q.Push("c", PRIORITY_NORMAL, "")
+	q.Pop()
+
+	restarted, err := NewQueue(store)
+	assert.Nil(t, err)
+
+	assert.Equal(t, []string{"a", "c"}, gameIds(restarted.List()))
+
+	// New launches are queued after the recovered ones
+	restarted.Push("d", PRIORITY_NORMAL, "")
+	assert.Equal(t, []string{"a", "c", "d"}, gameIds(restarted.List()))
+}
+
+func TestFileStoreRejectsCorruptedFile(t *testing.T) {
+	store, cleanup := createTestStore(t)
+	defer cleanup()
+
+	assert.Nil(t, ioutil.WriteFile(store.path, []byte("[{\"gameid\""), 0644))
+
+	_, err := NewQueue(store)
+	assert.Error(t, err)
+}
+
+func TestQueueRetriedLaunchGoesFirst(t *testing.T) {
+	q, _ := NewQueue(nil)
+
+	q.Push("a", PRIORITY_NORMAL, "")
+	q.Push("b", PRIORITY_RANKED, "")
+
+	assert.Nil(t, q.Requeue(Launch{GameId: "c", Priority: PRIORITY_NORMAL, Attempts: 1}))
+
+	assert.Equal(t, []string{"b", "c", "a"}, gameIds(q.List()))
+	assert.Equal(t, 1, q.List()[1].Attempts)
+}
+
+func TestQueuePopFirst(t *testing.T) {
+	q, _ := NewQueue(nil)
+
+	q.Push("a", PRIORITY_NORMAL, "large")
+	q.Push("b", PRIORITY_NORMAL, "small")
+	q.Push("c", PRIORITY_RANKED, "large")
+	q.Push("d", PRIORITY_NORMAL, "small")
+
+	isSmall := func(launch Launch) bool {
+		return launch.Profile == "small"
+	}
+
+	launch, err := q.PopFirst(isSmall)
+	assert.Nil(t, err)
+	assert.Equal(t, "b", launch.GameId)
+
+	launch, _ = q.PopFirst(isSmall)
+	assert.Equal(t, "d", launch.GameId)
+
+	launch, _ = q.PopFirst(isSmall)
+	assert.Nil(t, launch)
+
+	assert.Equal(t, []string{"c", "a"}, gameIds(q.List()))
+}
+
+func TestQueuePersistent(t *testing.T) {
+	store, cleanup := createTestStore(t)
+	defer cleanup()
+
+	persistent, err := NewQueue(store)
+	assert.Nil(t, err)
+	assert.True(t, persistent.Persistent())
+
+	inMemory, err := NewQueue(nil)
+	assert.Nil(t, err)
+	assert.False(t, inMemory.Persistent())
+}
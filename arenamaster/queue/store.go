@@ -0,0 +1,74 @@
+package queue
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// FileStore writes the queue as a JSON file, replaced atomically
+type FileStore struct {
+	path string
+}
+
+func NewFileStore(path string) (*FileStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, errors.New("Could not create launch queue directory: " + err.Error())
+	}
+
+	return &FileStore{
+		path: path,
+	}, nil
+}
+
+func (s *FileStore) Save(launches []Launch) error {
+	data, err := json.Marshal(launches)
+
+	if err != nil {
+		return err
+	}
+
+	tmpPath := s.path + ".tmp"
+	file, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+
+	if err != nil {
+		return err
+	}
+
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, s.path)
+}
+
+func (s *FileStore) Load() ([]Launch, error) {
+	launches := make([]Launch, 0)
+	data, err := ioutil.ReadFile(s.path)
+
+	if os.IsNotExist(err) {
+		return launches, nil
+	}
+
+	if err != nil {
+		return nil, errors.New("Could not read launch queue: " + err.Error())
+	}
+
+	if err := json.Unmarshal(data, &launches); err != nil {
+		return nil, errors.New("Could not read launch queue: " + err.Error())
+	}
+
+	return launches, nil
+}
@@ -0,0 +1,166 @@
+package arenamaster
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/bytearena/schnapps"
+	vmid "github.com/bytearena/schnapps/id"
+	vmtypes "github.com/bytearena/schnapps/types"
+
+	arenamasterGraphql "github.com/bytearena/backends/arenamaster/graphql"
+	"github.com/bytearena/backends/arenamaster/queue"
+	"github.com/bytearena/backends/arenamaster/state"
+
+	"github.com/bytearena/core/common/utils"
+)
+
+var (
+	TIME_BEFORE_RECOVERED_ARENA_CHECK = TIME_AFTER_UNHEALTHY + HEALTHCHECK_FREQ
+)
+
+// Persisted along with each state transition, used to re-adopt the VM after
+// a restart
+func describeVM(data state.Data) map[string]string {
+	res := make(map[string]string)
+
+	vm, isVm := data.(*vm.VM)
+
+	if !isVm {
+		return res
+	}
+
+	for k, v := range vm.Config.Metadata {
+		if !isSecretMetadata(k) {
+			res[k] = fmt.Sprint(v)
+		}
+	}
+
+	if mac, found := vmid.GetVMMAC(vm); found {
+		res["mac"] = mac
+	}
+
+	return res
+}
+
+func (server *Server) recoveredVM(entry state.JournalEntry) *vm.VM {
+	meta := vmtypes.VMMetadata{}
+
+	for k, v := range entry.Metadata {
+		if k != "mac" {
+			meta[k] = v
+		}
+	}
+
+	return server.backend.Recover(entry.Id, entry.Metadata["mac"], meta)
+}
+
+// recoveredLaunch is the launch which was pending on a VM, it keeps its
+// priority and the attempt which was not confirmed counts
+func recoveredLaunch(gameid string, metadata map[string]string) queue.Launch {
+	launch := queue.Launch{
+		GameId:   gameid,
+		Priority: queue.PRIORITY_NORMAL,
+		Queued:   time.Now(),
+		Profile:  getProfile(metadata["profile"]).Name,
+	}
+
+	if priority, err := strconv.Atoi(metadata["launchpriority"]); err == nil {
+		launch.Priority = priority
+	}
+
+	if attempt, err := strconv.Atoi(metadata["launchattempt"]); err == nil {
+		launch.Attempts = attempt
+	}
+
+	return launch
+}
+
+// recoverState re-adopts the VMs recorded in the state journal by a previous
+// arena-master process.
+//
+// Arenas which were running a game are kept until they emit game:stopped, or
+// reported as stopped if they don't answer healthchecks anymore. Every other
+// VM is halted and games which were not confirmed yet are queued again.
+func (server *Server) recoverState(healthchecks *ArenaHealthCheck) {
+	if server.journal == nil {
+		return
+	}
+
+	entries, err := server.journal.Replay()
+
+	if err != nil {
+		utils.RecoverableError("recover", "Could not replay state journal: "+err.Error())
+		return
+	}
+
+	latest, lastId := state.LatestEntries(entries)
+
+	if lastId > inc {
+		inc = lastId
+	}
+
+	for _, entry := range latest {
+		id := entry.Id
+		arenaId := entry.Metadata[state.ARENA_ID_METADATA]
+		gameid, hasGameId := entry.Metadata["gameid"]
+
+		recoveredVM := server.recoveredVM(entry)
+		err := server.state.UpdateStateAddRecoveredVM(id, recoveredVM, entry.Status)
+
+		if err != nil {
+			utils.RecoverableError("recover", "Could not re-adopt VM ("+strconv.Itoa(id)+"): "+err.Error())
+			continue
+		}
+
+		isErrored := entry.Status&(state.STATE_ERRORED_VM|state.STATE_ERRORED_ARENA) != 0
+		isRunningArena := entry.Status&state.STATE_RUNNING_ARENA != 0
+		isPendingArena := entry.Status&state.STATE_PENDING_ARENA != 0
+
+		if isRunningArena && !isErrored && hasGameId && arenaId != "" {
+			utils.Debug("recover", "Re-adopted VM ("+strconv.Itoa(id)+") running game "+gameid)
+
+			go server.checkRecoveredArena(id, arenaId, gameid, healthchecks)
+			continue
+		}
+
+		utils.Debug("recover", "Halting orphaned VM ("+strconv.Itoa(id)+")")
+
+		if quitErr := server.backend.Quit(recoveredVM); quitErr != nil {
+			utils.RecoverableError("recover", "Could not quit ("+strconv.Itoa(id)+"): "+quitErr.Error())
+		}
+
+		server.deleteArenaTopics(id)
+		checkTransition(server.state.UpdateStateVMHalted(id))
+
+		if isPendingArena && hasGameId {
+			if err := server.launchQueue.Requeue(recoveredLaunch(gameid, entry.Metadata)); err != nil {
+				utils.RecoverableError("launch-queue", err.Error())
+			}
+		}
+	}
+}
+
+// The previous arena-master process might have missed the game:stopped of
+// this arena, if it doesn't report healthy we consider the game as stopped
+func (server *Server) checkRecoveredArena(id int, arenaId, gameid string, healthchecks *ArenaHealthCheck) {
+	<-time.After(TIME_BEFORE_RECOVERED_ARENA_CHECK)
+
+	if server.state.GetStatus(id)&state.STATE_RUNNING_ARENA == 0 {
+		return
+	}
+
+	if healthy, hasRes := healthchecks.GetCache()[arenaId]; hasRes && healthy {
+		return
+	}
+
+	utils.RecoverableError("recover", "Recovered arena ("+arenaId+") is not healthy, stopping game "+gameid)
+
+	checkTransition(server.state.UpdateStateStoppedArena(id))
+	arenamasterGraphql.ReportGameStopped(server.state, arenaId, gameid, server.graphqlclient)
+
+	if err := server.haltVM(id); err != nil {
+		utils.RecoverableError("recover", "Could not halt ("+strconv.Itoa(id)+"): "+err.Error())
+	}
+}
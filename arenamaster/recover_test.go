@@ -0,0 +1,29 @@
+package arenamaster
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bytearena/backends/arenamaster/queue"
+)
+
+func TestRecoveredLaunch(t *testing.T) {
+	launch := recoveredLaunch("42", map[string]string{
+		"profile":        "large",
+		"launchpriority": "10",
+		"launchattempt":  "2",
+	})
+
+	assert.Equal(t, "42", launch.GameId)
+	assert.Equal(t, 10, launch.Priority)
+	assert.Equal(t, 2, launch.Attempts)
+	assert.Equal(t, "large", launch.Profile)
+
+	// Launches journaled before the priorities and the attempts
+	launch = recoveredLaunch("43", map[string]string{})
+
+	assert.Equal(t, queue.PRIORITY_NORMAL, launch.Priority)
+	assert.Equal(t, 0, launch.Attempts)
+	assert.Equal(t, DEFAULT_PROFILE, launch.Profile)
+}
@@ -1,160 +1,253 @@
 package arenamaster
 
 import (
+	"errors"
 	"strconv"
-	"sync"
 	"time"
 
-	"github.com/bytearena/schnapps"
-	vmid "github.com/bytearena/schnapps/id"
-	vmscheduler "github.com/bytearena/schnapps/scheduler"
 	"github.com/xtuc/schaloop"
 
-	"github.com/bytearena/core/common/types"
 	"github.com/bytearena/core/common/utils"
 
+	"github.com/bytearena/backends/arenamaster/autoscaler"
 	"github.com/bytearena/backends/arenamaster/state"
+	"github.com/bytearena/backends/common/mq"
 )
 
 var (
 	inc = 0
+
+	POOL_TICK_FREQ = 5 * time.Second
 )
 
-func (server *Server) createScheduler(eventloop *schaloop.EventLoop, listener Listener, healthchecks *ArenaHealthCheck) (*vmscheduler.Pool, chan bool) {
-	ready := make(chan bool)
+// arenaSpawner provisions the VMs of a profile for the autoscaler, it's only
+// used from the eventloop
+type arenaSpawner struct {
+	server   *Server
+	listener Listener
+	profile  ResourceProfile
+}
+
+func (s arenaSpawner) Spawn() error {
+	server := s.server
+
+	inc++
+	id := inc
+
+	utils.Debug("master", "Provisioning new "+s.profile.Name+" VM ("+strconv.Itoa(id)+")")
+
+	vm, err := server.backend.Spawn(id, newArenaId(), s.profile)
+
+	if err != nil {
+		return errors.New("Could not start (" + strconv.Itoa(id) + "): " + err.Error())
+	}
 
-	provisionVmFn := func() *vm.VM {
-		inc++
-		id := inc
+	if err := server.state.UpdateStateAddBootingVM(id, vm); err != nil {
+		return err
+	}
 
-		vm, err := server.SpawnArena(id)
-		server.state.UpdateStateAddBootingVM(id, vm)
+	go func() {
+		err := server.backend.WaitUntilBooted(vm)
 
 		if err != nil {
-			utils.RecoverableError("vm", "Could not start ("+strconv.Itoa(id)+"): "+err.Error())
-			server.state.UpdateStateVMErrored(id)
-
-			return nil
-		} else {
-			err := vm.WaitUntilBooted()
-
-			if err != nil {
-				utils.RecoverableError("vm", "Could not wait until VM is booted")
-				server.state.UpdateStateVMErrored(id)
-			} else {
-				server.state.UpdateStateVMBooted(id)
-				utils.Debug("vm", "VM ("+strconv.Itoa(id)+") booted")
-			}
+			utils.RecoverableError("vm", "Could not wait until VM is booted")
+			checkTransition(server.state.UpdateStateVMErrored(id))
+			s.halt(id)
 
-			// Start timer between VM running and arena idle
-			// If the VM has no arena running or idle, we better halt it
-			go func() {
-				<-time.After(TIME_BETWEEN_VM_RUNNING_AND_ARENA_IDLE)
+			return
+		}
 
-				status := server.state.GetStatus(vm.Config.Id)
-				isIdle := status&state.STATE_IDLE_ARENA == 0
-				isRunning := status&state.STATE_RUNNING_ARENA == 0
+		// The arena might have handshaked already, which implies that the VM booted
+		if server.state.GetStatus(id) == state.STATUS_BOOTING {
+			checkTransition(server.state.UpdateStateVMBooted(id))
+		}
 
-				if !isIdle && !isRunning {
+		utils.Debug("vm", "VM ("+strconv.Itoa(id)+") booted")
 
-					haltMsg := types.NewMQMessage(
-						"arena-master",
-						"halt",
-					).SetPayload(types.MQPayload{
-						"id": strconv.Itoa(id),
-					})
+		// Start timer between VM running and arena idle
+		// If the VM has no arena idle, pending or running, we better halt it
+		<-time.After(TIME_BETWEEN_VM_RUNNING_AND_ARENA_IDLE)
 
-					listener.arenaHalt <- *haltMsg
-				}
-			}()
+		status := server.state.GetStatus(id)
+		hasArena := status&(state.STATE_IDLE_ARENA|state.STATE_PENDING_ARENA|state.STATE_RUNNING_ARENA) != 0
 
-			return vm
+		if status != 0 && !hasArena {
+			utils.RecoverableError("vm", "VM ("+strconv.Itoa(id)+") has no arena, halting")
+			s.halt(id)
 		}
+	}()
+
+	return nil
+}
+
+// Only idle arenas are halted, the arena could have been used since the
+// autoscaler took its decision
+func (s arenaSpawner) Halt(id int) error {
+	if status := s.server.state.GetStatus(id); status != state.STATUS_IDLE {
+		return errors.New("Arena is not idle anymore")
+	}
+
+	return s.server.haltVM(id)
+}
+
+func (s arenaSpawner) halt(id int) {
+	go func() {
+		s.listener.arenaHalt <- &mq.ArenaHalt{Id: strconv.Itoa(id)}
+	}()
+}
+
+func (server *Server) haltVM(id int) error {
+	runningVM := server.state.GetVM(id)
+
+	if runningVM == nil || runningVM.Status&(state.STATE_BOOTING_VM|state.STATE_RUNNING_VM) == 0 {
+		return errors.New("VM is not running")
 	}
 
-	var healthcheckFnMutex sync.Mutex
-	healtcheckVmFn := func(vm *vm.VM) bool {
-		healthcheckFnMutex.Lock()
-		defer healthcheckFnMutex.Unlock()
+	if quitErr := server.backend.Quit(runningVM.VM); quitErr != nil {
+		utils.RecoverableError("vm", "Could not quit ("+strconv.Itoa(id)+"): "+quitErr.Error())
+	}
 
-		cache := healthchecks.GetCache()
-		mac, found := vmid.GetVMMAC(vm)
+	server.deleteArenaTopics(id)
 
-		if !found {
-			utils.RecoverableError("healthcheck", "Error during healthcheck: mac not found")
-			return false
+	return server.state.UpdateStateVMHalted(id)
+}
+
+// deleteArenaTopics drops the streams only the arena of a halted VM consumed
+func (server *Server) deleteArenaTopics(id int) {
+	arenaId, hasArenaId := server.state.GetVMMetadata(id, state.ARENA_ID_METADATA)
+
+	if !hasArenaId || arenaId == "" {
+		return
+	}
+
+	topics := map[string]string{
+		"game":         arenaId + ".launch",
+		mq.RPC_CHANNEL: mq.RPCReplyTopic(mq.ARENA_SERVER_COMPONENT_PREFIX + arenaId),
+	}
+
+	for channel, topic := range topics {
+		if err := server.brokerclient.DeleteTopic(channel, topic); err != nil {
+			utils.RecoverableError("vm", "Could not delete "+channel+":"+topic+": "+err.Error())
 		}
+	}
+}
+
+// Errored VMs are being halted, they are not counted and will be replaced
+func (server *Server) poolMetrics(profile string) autoscaler.Metrics {
+	metrics := autoscaler.Metrics{
+		Idle: make([]int, 0),
+	}
+
+	for _, launch := range server.launchQueue.List() {
+		if getLaunchProfile(launch) == profile {
+			metrics.PendingLaunches++
+		}
+	}
+
+	for _, vm := range server.state.GetVMs() {
+		id := vm.Config.Id
+		status := server.state.GetStatus(id)
+
+		if server.getVMProfile(id) != profile {
+			continue
+		}
+
+		switch {
+		case status&(state.STATE_ERRORED_VM|state.STATE_ERRORED_ARENA) != 0:
+			continue
+
+		case status == state.STATUS_IDLE:
+			metrics.Idle = append(metrics.Idle, id)
+
+		case status&(state.STATE_PENDING_ARENA|state.STATE_RUNNING_ARENA) != 0:
+			metrics.Busy++
+
+		default:
+			metrics.Booting++
+		}
+	}
+
+	return metrics
+}
+
+func (server *Server) checkVMsHealth(listener Listener, healthchecks *ArenaHealthCheck) {
+	cache := healthchecks.GetCache()
+
+	for _, vm := range server.state.GetVMs() {
+		id := vm.Config.Id
+		status := server.state.GetStatus(id)
 
 		// Ignore healthcheck if the VM is currenly booting
-		isBooting := server.state.GetStatus(vm.Config.Id)&state.STATE_BOOTING_VM != 0
+		if status&state.STATE_RUNNING_VM == 0 || status&state.STATE_ERRORED_VM != 0 {
+			continue
+		}
 
-		if isBooting {
-			return true
+		arenaId, found := GetArenaId(vm)
+
+		if !found {
+			utils.RecoverableError("healthcheck", "Error during healthcheck: arena id not found")
+			continue
 		}
 
-		if res, hasRes := cache[mac]; hasRes {
-			return res
-		} else {
-			return false
+		// Arenas which never answered are halted if they don't handshake in time
+		if healthy, hasRes := cache[arenaId]; hasRes && !healthy {
+			utils.RecoverableError("healthcheck", "VM ("+strconv.Itoa(id)+") is unhealthy, halting")
+
+			checkTransition(server.state.UpdateStateVMErrored(id))
+			arenaSpawner{server: server, listener: listener}.halt(id)
 		}
 	}
+}
 
-	pool, schedulerErr := vmscheduler.NewFixedVMPool(3)
+// createScheduler keeps a warm pool of VMs for each resource profile
+func (server *Server) createScheduler(eventloop *schaloop.EventLoop, listener Listener, healthchecks *ArenaHealthCheck) map[string]*autoscaler.Autoscaler {
+	pools := make(map[string]*autoscaler.Autoscaler)
 
-	if schedulerErr != nil {
-		panic(schedulerErr)
+	for _, name := range GetProfileNames() {
+		profile := RESOURCE_PROFILES[name]
+		policy, hasPolicy := server.poolPolicies[name]
+
+		if !hasPolicy {
+			utils.RecoverableError("autoscaler", "No pool policy for profile "+name)
+			continue
+		}
+
+		pools[name] = autoscaler.NewAutoscaler(
+			policy,
+			arenaSpawner{server, listener, profile},
+			func() autoscaler.Metrics {
+				return server.poolMetrics(profile.Name)
+			},
+		)
 	}
 
-	events := chan interface{}(pool.Events())
-	eventloop.QueueWorkFromChannel("pool-events", events, func(data interface{}) {
+	ticker := time.NewTicker(POOL_TICK_FREQ)
 
-		switch msg := data.(type) {
-		case vmscheduler.HEALTHCHECK:
-			{
-				res := healtcheckVmFn(msg.VM)
+	eventloop.QueueWorkFromChannel("pool-ticker", timerToGeneric(ticker), func(data interface{}) {
+		server.checkVMsHealth(listener, healthchecks)
 
-				go func() {
-					pool.Consumer() <- vmscheduler.HEALTHCHECK_RESULT{
-						VM:  msg.VM,
-						Res: res,
-					}
-				}()
-			}
+		if server.IsDraining() {
+			server.checkDrain()
+			return
+		}
 
-		case vmscheduler.PROVISION:
-			{
-				utils.Debug("master", "Provisioning new VM")
-				vm := provisionVmFn()
+		for _, name := range GetProfileNames() {
+			pool, hasPool := pools[name]
 
-				go func() {
-					pool.Consumer() <- vmscheduler.PROVISION_RESULT{vm}
-				}()
+			if !hasPool {
+				continue
 			}
 
-		case vmscheduler.READY:
-			{
-				ready <- true
-				close(ready)
-			}
+			decision := pool.Tick(time.Now())
 
-		case vmscheduler.VM_UNHEALTHY:
-			{
-				id := msg.VM.Config.Id
-				server.state.UpdateStateVMErrored(id)
-
-				haltMsg := types.NewMQMessage(
-					"arena-master",
-					"halt",
-				).SetPayload(types.MQPayload{
-					"id": strconv.Itoa(id),
-				})
-
-				go func() {
-					listener.arenaHalt <- *haltMsg
-				}()
+			if decision.Spawn > 0 || len(decision.Halt) > 0 {
+				utils.Debug("autoscaler", name+": spawned "+strconv.Itoa(decision.Spawn)+" VMs, halted "+strconv.Itoa(len(decision.Halt)))
 			}
 		}
+
+		// In case an arena became idle without handshaking again
+		server.dispatchLaunches()
 	})
 
-	return pool, ready
+	return pools
 }
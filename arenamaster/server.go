@@ -1,25 +1,22 @@
 package arenamaster
 
 import (
-	"encoding/json"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/bytearena/schnapps"
-	vmdhcp "github.com/bytearena/schnapps/dhcp"
-	vmdns "github.com/bytearena/schnapps/dns"
-	vmmeta "github.com/bytearena/schnapps/metadata"
 
 	"github.com/xtuc/schaloop"
 
+	"github.com/bytearena/backends/arenamaster/autoscaler"
 	arenamasterGraphql "github.com/bytearena/backends/arenamaster/graphql"
+	"github.com/bytearena/backends/arenamaster/queue"
 	"github.com/bytearena/backends/arenamaster/state"
 	"github.com/bytearena/backends/common/graphql"
 	"github.com/bytearena/backends/common/influxdb"
 	"github.com/bytearena/backends/common/mq"
 
-	bamq "github.com/bytearena/core/common/mq"
-	"github.com/bytearena/core/common/types"
 	"github.com/bytearena/core/common/utils"
 )
 
@@ -30,38 +27,76 @@ var (
 )
 
 type Server struct {
-	stopChan           chan bool
-	brokerclient       *mq.Client
-	graphqlclient      *graphql.Client
-	state              *state.State
-	influxdbClient     *influxdb.Client
-	DNSServer          *vmdns.Server
-	MetadataServer     *vmmeta.MetadataHTTPServer
-	DHCPServer         *vmdhcp.DHCPServer
-	vmRawImageLocation string
-	vmBridgeName       string
-	vmBridgeIP         string
-	vmSubnet           string
+	stopChan        chan bool
+	brokerclient    mq.Broker
+	graphqlclient   *graphql.Client
+	state           *state.State
+	stateEvents     *state.EventLog
+	journal         state.Journal
+	launchQueue     *queue.Queue
+	influxdbClient  *influxdb.Client
+	poolPolicies    map[string]autoscaler.Policy
+	launchDeadlines chan interface{}
+	backend         ArenaBackend
+	drainMutex      sync.Mutex
+	drainStatus     DrainStatus
+	stopOnce        sync.Once
 }
 
-func NewServer(mq *mq.Client, gql *graphql.Client, vmRawImageLocation, vmBridgeName, vmBridgeIP, vmSubnet string) *Server {
+func NewServer(mq mq.Broker, gql *graphql.Client, vmRawImageLocation, vmBridgeName, vmBridgeIP, vmSubnet, stateJournalLocation, launchQueueLocation string, poolPolicies map[string]autoscaler.Policy) *Server {
 	stopChan := make(chan bool)
 
 	influxdbClient, influxdbClientErr := influxdb.NewClient("arenamaster")
 	utils.Check(influxdbClientErr, "Unable to create influxdb client")
 
+	var journal state.Journal
+	arenaState := state.NewState()
+
+	if stateJournalLocation != "" {
+		fileJournal, journalErr := state.NewFileJournal(stateJournalLocation)
+		utils.Check(journalErr, "Unable to open state journal")
+
+		journal = fileJournal
+		arenaState = state.NewStateWithJournal(journal, describeVM)
+	}
+
+	var launchQueueStore queue.Store
+
+	if launchQueueLocation != "" {
+		fileStore, storeErr := queue.NewFileStore(launchQueueLocation)
+		utils.Check(storeErr, "Unable to open launch queue")
+
+		launchQueueStore = fileStore
+	}
+
+	launchQueue, launchQueueErr := queue.NewQueue(launchQueueStore)
+	utils.Check(launchQueueErr, "Unable to load launch queue")
+
 	s := &Server{
-		brokerclient:       mq,
-		graphqlclient:      gql,
-		state:              state.NewState(),
-		stopChan:           stopChan,
-		influxdbClient:     influxdbClient,
-		vmRawImageLocation: vmRawImageLocation,
-		vmBridgeName:       vmBridgeName,
-		vmBridgeIP:         vmBridgeIP,
-		vmSubnet:           vmSubnet,
+		brokerclient:    mq,
+		graphqlclient:   gql,
+		state:           arenaState,
+		stateEvents:     state.NewEventLog(STATE_EVENTS_LOG_SIZE),
+		journal:         journal,
+		launchQueue:     launchQueue,
+		stopChan:        stopChan,
+		influxdbClient:  influxdbClient,
+		poolPolicies:    poolPolicies,
+		launchDeadlines: make(chan interface{}),
 	}
 
+	authorizeTopics(mq)
+
+	s.backend = NewSchnappsBackend(SchnappsBackendConfig{
+		RawImageLocation: vmRawImageLocation,
+		BridgeName:       vmBridgeName,
+		BridgeIP:         vmBridgeIP,
+		Subnet:           vmSubnet,
+	}, func(mac string) *vm.VM {
+		return FindVMByMAC(s.state, mac)
+	})
+	s.startStateEvents()
+
 	err := s.startStateReporting()
 
 	utils.CheckWithFunc(err, func() string {
@@ -90,16 +125,6 @@ func (server *Server) startStateReporting() error {
 	return nil
 }
 
-func unmarshalMQMessage(msg bamq.BrokerMessage) (error, *types.MQMessage) {
-	var message types.MQMessage
-	err := json.Unmarshal(msg.Data, &message)
-	if err != nil {
-		return err, nil
-	} else {
-		return nil, &message
-	}
-}
-
 func resToGeneric(old Res) chan interface{} {
 	new := make(chan interface{})
 
@@ -130,171 +155,126 @@ func (server *Server) Run() {
 	waitChan := make(chan bool)
 	listener := MakeListener(server.brokerclient)
 
+	// Closed once the eventloop stops
+	stopped := make(chan bool)
+
 	eventloop := schaloop.NewEventLoop()
 	eventloop.StartWithTimeout(time.Duration(2 * time.Minute))
 
-	server.createDHCPServer()
-	server.createDNSServer()
-	server.createMetadataServer()
+	utils.Check(server.backend.Start(), "Could not start arena backend")
+
+	rpc, err := mq.NewRPC(server.brokerclient, MASTER_COMPONENT)
+	utils.Check(err, "Could not subscribe to mq")
 
-	healthchecks := NewArenaHealthcheck(listener.gameHealthcheckRes, server.brokerclient)
+	healthchecks := NewArenaHealthcheck(rpc)
+	server.handleDebugRequests(rpc, healthchecks)
 
-	pool, waitUntilReady := server.createScheduler(eventloop, listener, healthchecks)
+	server.recoverState(healthchecks)
+
+	server.createScheduler(eventloop, listener, healthchecks)
 	utils.Debug("vm", "Scheduler running and initialized")
 
-	<-waitUntilReady
 	healthchecks.StartChecks(eventloop)
 
 	eventloop.QueueWorkFromChannel("arena-halt", resToGeneric(listener.arenaHalt), func(data interface{}) {
-		msg := data.(types.MQMessage)
-		id, _ := strconv.Atoi((*msg.Payload)["id"].(string))
-
-		if data := server.state.QueryState(id, state.STATE_RUNNING_VM); data != nil {
-			runningVM := data.(*vm.VM)
-			quitErr := runningVM.Quit()
-
-			if quitErr != nil {
-				utils.RecoverableError("vm", "Could not quit ("+strconv.Itoa(id)+"): "+quitErr.Error())
-			}
-
-			err := pool.Delete(runningVM)
+		msg := data.(*mq.ArenaHalt)
+		id, err := server.findVMId(msg.Id)
 
-			if err != nil {
-				utils.RecoverableError("vm", "Could not halt ("+strconv.Itoa(id)+"): "+err.Error())
-			}
+		if err != nil {
+			server.replyError("arena", "halt", msg, err.Error())
+			return
+		}
 
-			server.state.UpdateStateVMHalted(id)
-		} else {
-			utils.RecoverableError("vm", "Could not halt ("+strconv.Itoa(id)+"): VM is not running")
+		if err := server.haltVM(id); err != nil {
+			utils.RecoverableError("vm", "Could not halt ("+strconv.Itoa(id)+"): "+err.Error())
 		}
 	})
 
-	eventloop.QueueWorkFromChannel("game-launch", resToGeneric(listener.gameLaunch), func(data interface{}) {
-		msg := data.(types.MQMessage)
-		gameid, _ := (*msg.Payload)["id"].(string)
+	eventloop.QueueWorkFromChannel("game-launch", server.resolveLaunchProfiles(listener.gameLaunch, stopped), func(data interface{}) {
+		launch := data.(resolvedLaunch)
+		msg := launch.msg
 
-		// Check if the gameid isn't running already
-		var isGameAlreadyRunning bool
-		server.state.Map(func(element *state.DataContainer) {
-			if isGameAlreadyRunning == true {
-				return
-			}
+		if server.IsDraining() {
+			server.replyError("game", "launch", msg, "arena-master is draining, game "+msg.Id+" is refused")
+			return
+		}
 
-			vm := element.Data.(*vm.VM)
-			isRunning := element.Status&state.STATE_RUNNING_ARENA != 0
+		server.queueLaunch(msg.Id, launchPriority(msg.Priority), launch.profile)
+		server.dispatchLaunches()
+	})
 
-			vmGameId, hasVmGameId := vm.Config.Metadata["gameid"]
+	eventloop.QueueWorkFromChannel("launch-deadline", server.launchDeadlines, func(data interface{}) {
+		server.handleLaunchDeadline(data.(launchDeadline))
+	})
 
-			if isRunning && hasVmGameId && vmGameId == gameid {
-				isGameAlreadyRunning = true
-			}
-		})
+	eventloop.QueueWorkFromChannel("game-launched", listener.gameLaunched, func(data interface{}) {
+		signed := data.(arenaMessage)
+		msg := signed.message.(*mq.GameLaunched)
+		id, err := server.findSigningArena(msg.ArenaServerUUID, signed.component)
 
-		if isGameAlreadyRunning == true {
-			utils.RecoverableError("vm", "Could not launch game: Game is already running")
+		if err != nil {
+			server.replyError("game", "launched", msg, err.Error())
 			return
 		}
 
-		vm, err := pool.Pop()
-
-		if vm != nil && err == nil {
-			server.state.UpdateStateTriedLaunchArena(vm.Config.Id)
-
-			onGameLaunch(
-				gameid,
-				server.brokerclient,
-				server.graphqlclient,
-				vm,
-			)
-		} else if vm == nil {
-			utils.RecoverableError("vm", "Could not launch game: no arena available")
-		} else {
-			utils.RecoverableError("vm", "Could not launch game: "+err.Error())
+		if err := server.state.UpdateStateConfirmedLaunchArena(id); err != nil {
+			server.replyError("game", "launched", msg, err.Error())
+			return
+		}
 
-			err := pool.Release(vm)
+		arenamasterGraphql.ReportGameLaunched(msg.Id, msg.ArenaServerUUID, server.graphqlclient)
+		utils.Debug("master", msg.ArenaServerUUID+" launched")
+	})
 
-			if err != nil {
-				utils.RecoverableError("vm", "Could not release ("+strconv.Itoa(vm.Config.Id)+"): "+err.Error())
-			}
+	eventloop.QueueWorkFromChannel("game-handshake", listener.gameHandshake, func(data interface{}) {
+		signed := data.(arenaMessage)
+		msg := signed.message.(*mq.GameHandshake)
+		id, err := server.findSigningArena(msg.ArenaServerUUID, signed.component)
 
-			go func() {
-				// Retry in 30sec
-				<-time.After(30 * time.Second)
-				listener.gameLaunch <- msg
-			}()
+		if err != nil {
+			server.replyError("game", "handshake", msg, err.Error())
+			return
 		}
-	})
-
-	eventloop.QueueWorkFromChannel("game-launched", resToGeneric(listener.gameLaunched), func(data interface{}) {
-		msg := data.(types.MQMessage)
-		mac, _ := (*msg.Payload)["arenaserveruuid"].(string)
-		gameid, _ := (*msg.Payload)["id"].(string)
-		vm := FindVMByMAC(server.state, mac)
 
-		if vm != nil {
-			server.state.UpdateStateConfirmedLaunchArena(vm.Config.Id)
+		// Refuses handshakes from already running arenas
+		if err := server.state.UpdateStateAddIdleArena(id); err != nil {
+			server.replyError("game", "handshake", msg, err.Error())
+			return
+		}
 
-			arenamasterGraphql.ReportGameLaunched(gameid, mac, server.graphqlclient)
-			utils.Debug("master", mac+" launched")
+		utils.Debug("master", msg.ArenaServerUUID+" joined")
 
-		} else {
-			utils.RecoverableError("game-launched", "VM with MAC ("+mac+") does not exists")
-		}
+		server.dispatchLaunches()
 	})
 
-	eventloop.QueueWorkFromChannel("game-handshake", resToGeneric(listener.gameHandshake), func(data interface{}) {
-		msg := data.(types.MQMessage)
-		mac, _ := (*msg.Payload)["arenaserveruuid"].(string)
-		vm := FindVMByMAC(server.state, mac)
+	eventloop.QueueWorkFromChannel("game-stopped", listener.gameStopped, func(data interface{}) {
+		signed := data.(arenaMessage)
+		msg := signed.message.(*mq.GameStopped)
+		id, err := server.findSigningArena(msg.ArenaServerUUID, signed.component)
 
-		// Refuse handshakes from already running arenas
-		if server.state.GetStatus(vm.Config.Id)&state.STATE_RUNNING_ARENA != 0 {
+		if err != nil {
+			server.replyError("game", "stopped", msg, err.Error())
 			return
 		}
 
-		if vm != nil {
-			server.state.UpdateStateAddIdleArena(vm.Config.Id)
-			utils.Debug("master", mac+" joined")
-		} else {
-			utils.RecoverableError("game-handshake", "VM with MAC ("+mac+") does not exists")
-		}
-	})
+		checkTransition(server.state.UpdateStateStoppedArena(id))
 
-	eventloop.QueueWorkFromChannel("game-stopped", resToGeneric(listener.gameStopped), func(data interface{}) {
-		msg := data.(types.MQMessage)
-		gameid, _ := (*msg.Payload)["id"].(string)
-		mac, _ := (*msg.Payload)["arenaserveruuid"].(string)
-
-		vm := FindVMByMAC(server.state, mac)
-
-		if vm != nil {
-			id := vm.Config.Id
-			server.state.UpdateStateStoppedArena(id)
-
-			arenamasterGraphql.ReportGameStopped(
-				server.state,
-				mac,
-				gameid,
-				server.graphqlclient,
-			)
-
-			haltMsg := types.NewMQMessage(
-				"arena-master",
-				"halt",
-			).SetPayload(types.MQPayload{
-				"id": strconv.Itoa(id),
-			})
-
-			go func() {
-				listener.arenaHalt <- *haltMsg
-			}()
-		} else {
-			utils.RecoverableError("game-stopped", "VM with MAC ("+mac+") does not exists")
-		}
+		arenamasterGraphql.ReportGameStopped(
+			server.state,
+			msg.ArenaServerUUID,
+			msg.Id,
+			server.graphqlclient,
+		)
+
+		go func() {
+			listener.arenaHalt <- &mq.ArenaHalt{Id: strconv.Itoa(id)}
+		}()
+
+		server.checkDrain()
 	})
 
-	eventloop.QueueWorkFromChannel("debug-getvmstatus", resToGeneric(listener.debugGetVMStatus), func(data interface{}) {
-		go handleDebugGetVMStatus(server.brokerclient, server.state, healthchecks)
+	eventloop.QueueWorkFromChannel("arenamaster-drain", resToGeneric(listener.masterDrain), func(data interface{}) {
+		server.startDrain(drainDeadline(data.(*mq.MasterDrain).Deadline))
 	})
 
 	eventloop.QueueWorkFromChannel("stop", boolToGeneric(server.stopChan), func(data interface{}) {
@@ -302,20 +282,27 @@ func (server *Server) Run() {
 	})
 
 	<-waitChan
+	close(stopped)
 	eventloop.Stop()
 }
 
+// Stop can be called by a drain and by the signal handler, only the first
+// call stops the server
 func (server *Server) Stop() {
-	server.stopChan <- true
-	server.influxdbClient.TearDown()
+	server.stopOnce.Do(func() {
+		server.stopChan <- true
+		server.influxdbClient.TearDown()
 
-	if server.DNSServer != nil {
-		server.DNSServer.Stop()
-	}
+		server.backend.Stop()
 
-	if server.MetadataServer != nil {
-		server.MetadataServer.Stop()
-	}
+		if server.journal != nil {
+			err := server.journal.Close()
 
-	close(server.stopChan)
+			if err != nil {
+				utils.RecoverableError("state", "Could not close journal: "+err.Error())
+			}
+		}
+
+		close(server.stopChan)
+	})
 }
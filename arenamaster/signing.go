@@ -0,0 +1,81 @@
+package arenamaster
+
+import (
+	"github.com/bytearena/backends/arenamaster/federation"
+	"github.com/bytearena/backends/common/mq"
+)
+
+const (
+	// Served to the arena by the metadata server, never journaled
+	ARENA_KEY_METADATA = "mqkey"
+
+	// Token of the arenas for the GraphQL API, also never journaled
+	ARENA_API_TOKEN_METADATA = "apitoken"
+
+	CLI_COMPONENT    = "arena-master-cli"
+	MASTER_COMPONENT = mq.ARENA_MASTER_COMPONENT
+)
+
+var (
+	// Master key of the arena-server keys, nil to let arenas publish unsigned
+	ARENA_SERVER_KEY []byte
+
+	// GraphQL token given to the arenas, empty to let them use their own
+	ARENA_API_TOKEN string
+)
+
+// arenaKey is the key an arena-server signs its messages with
+func arenaKey(arenaId string) (string, bool) {
+	if len(ARENA_SERVER_KEY) == 0 {
+		return "", false
+	}
+
+	return string(mq.DeriveKey(ARENA_SERVER_KEY, mq.ARENA_SERVER_COMPONENT_PREFIX+arenaId)), true
+}
+
+// publishToArena signs the message with the key of the arena when the arenas
+// have one
+func publishToArena(client mq.Broker, arenaId, channel, topic string, message mq.Message) error {
+	data, err := mq.EncodeMessage(MASTER_COMPONENT, channel, topic, message)
+
+	if err != nil {
+		return err
+	}
+
+	key, hasKey := arenaKey(arenaId)
+
+	if !hasKey {
+		return client.Publish(channel, topic, data)
+	}
+
+	return client.PublishSigned(mq.SigningConfig{
+		Component: MASTER_COMPONENT,
+		Key:       []byte(key),
+	}, channel, topic, data)
+}
+
+func isSecretMetadata(key string) bool {
+	return key == ARENA_KEY_METADATA || key == ARENA_API_TOKEN_METADATA
+}
+
+// authorizeTopics restricts the components publishing on the topics of
+// arena-master, it only applies once the messages are verified
+func authorizeTopics(client mq.Broker) {
+	client.Authorize("arena", "add", MASTER_COMPONENT, CLI_COMPONENT)
+	client.Authorize("arena", "halt", MASTER_COMPONENT, CLI_COMPONENT)
+
+	for _, topic := range []string{"launched", "handshake", "stopped"} {
+		client.Authorize("game", topic, mq.ARENA_SERVER_COMPONENT_PREFIX)
+	}
+
+	// Replies to the healthchecks
+	client.Authorize(mq.RPC_CHANNEL, mq.RPCReplyTopic(MASTER_COMPONENT), mq.ARENA_SERVER_COMPONENT_PREFIX)
+
+	client.Authorize("arenamaster", "drain", CLI_COMPONENT)
+
+	federation.AuthorizeCoordinator(client)
+
+	for _, topic := range []string{"getvmstatus", "getstateevents", "getlaunchqueue"} {
+		client.Authorize("debug", topic, CLI_COMPONENT)
+	}
+}
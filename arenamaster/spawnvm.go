@@ -1,47 +1,105 @@
 package arenamaster
 
 import (
+	"fmt"
+
 	"github.com/bytearena/schnapps"
+	vmdhcp "github.com/bytearena/schnapps/dhcp"
+	vmdns "github.com/bytearena/schnapps/dns"
 	vmid "github.com/bytearena/schnapps/id"
+	vmmeta "github.com/bytearena/schnapps/metadata"
 	vmtypes "github.com/bytearena/schnapps/types"
 
+	"github.com/bytearena/backends/arenamaster/state"
+
 	"github.com/bytearena/core/common/utils"
 )
 
-var (
-	MEG_MEMORY      = 2048
-	CPU_AMOUNT      = 1
-	CPU_CORE_AMOUNT = 1
-)
+type SchnappsBackendConfig struct {
+	RawImageLocation string
+	BridgeName       string
+	BridgeIP         string
+	Subnet           string
+}
 
-func (server *Server) SpawnArena(id int) (*vm.VM, error) {
-	mac := vmid.GenerateRandomMAC()
-	ip, ipErr := server.DHCPServer.Pop()
+// schnappsBackend runs the arenas in QEMU VMs on a bridge of the host, with
+// its own DHCP, DNS and metadata servers
+type schnappsBackend struct {
+	config SchnappsBackendConfig
+	findVM func(identity string) *vm.VM
 
-	if ipErr != nil {
-		return nil, ipErr
+	DNSServer      *vmdns.Server
+	MetadataServer *vmmeta.MetadataHTTPServer
+	DHCPServer     *vmdhcp.DHCPServer
+}
+
+// NewSchnappsBackend needs to find the VMs by identity for the metadata server
+func NewSchnappsBackend(config SchnappsBackendConfig, findVM func(identity string) *vm.VM) ArenaBackend {
+	return &schnappsBackend{
+		config: config,
+		findVM: findVM,
 	}
+}
 
-	meta := vmtypes.VMMetadata{
-		"IP": ip,
+func (b *schnappsBackend) Start() error {
+	b.createDHCPServer()
+	b.createDNSServer()
+	b.createMetadataServer()
+
+	return nil
+}
+
+func (b *schnappsBackend) Stop() {
+	if b.DNSServer != nil {
+		b.DNSServer.Stop()
 	}
 
-	config := vmtypes.VMConfig{
+	if b.MetadataServer != nil {
+		b.MetadataServer.Stop()
+	}
+}
+
+func (b *schnappsBackend) vmConfig(id int, mac string, profile ResourceProfile, meta vmtypes.VMMetadata) vmtypes.VMConfig {
+	return vmtypes.VMConfig{
 		NICs: []interface{}{
 			vmtypes.NICBridge{
-				Bridge: server.vmBridgeName,
+				Bridge: b.config.BridgeName,
 				MAC:    mac,
 			},
 		},
 		Id:            id,
-		MegMemory:     MEG_MEMORY,
-		CPUAmount:     CPU_AMOUNT,
-		CPUCoreAmount: CPU_CORE_AMOUNT,
-		ImageLocation: server.vmRawImageLocation,
+		MegMemory:     profile.MegMemory,
+		CPUAmount:     profile.CPUAmount,
+		CPUCoreAmount: profile.CPUCoreAmount,
+		ImageLocation: b.config.RawImageLocation,
 		Metadata:      meta,
 	}
+}
+
+// The arena id is served to the VM by the metadata server
+func (b *schnappsBackend) Spawn(id int, arenaId string, profile ResourceProfile) (*vm.VM, error) {
+	mac := vmid.GenerateRandomMAC()
+	ip, ipErr := b.DHCPServer.Pop()
+
+	if ipErr != nil {
+		return nil, ipErr
+	}
 
-	arenaVm := vm.NewVM(config)
+	meta := vmtypes.VMMetadata{
+		"IP":                    ip,
+		"profile":               profile.Name,
+		state.ARENA_ID_METADATA: arenaId,
+	}
+
+	if key, hasKey := arenaKey(arenaId); hasKey {
+		meta[ARENA_KEY_METADATA] = key
+	}
+
+	if ARENA_API_TOKEN != "" {
+		meta[ARENA_API_TOKEN_METADATA] = ARENA_API_TOKEN
+	}
+
+	arenaVm := vm.NewVM(b.vmConfig(id, mac, profile, meta))
 
 	startErr := arenaVm.Start()
 
@@ -49,7 +107,21 @@ func (server *Server) SpawnArena(id int) (*vm.VM, error) {
 		return nil, startErr
 	}
 
-	utils.Debug("vm", "Started new VM ("+mac+")")
+	utils.Debug("vm", "Started new "+profile.Name+" VM ("+mac+") for arena "+arenaId)
 
 	return arenaVm, nil
 }
+
+func (b *schnappsBackend) WaitUntilBooted(arena *vm.VM) error {
+	return arena.WaitUntilBooted()
+}
+
+func (b *schnappsBackend) Quit(arena *vm.VM) error {
+	return arena.Quit()
+}
+
+func (b *schnappsBackend) Recover(id int, mac string, metadata vmtypes.VMMetadata) *vm.VM {
+	profile := getProfile(fmt.Sprint(metadata["profile"]))
+
+	return vm.NewVM(b.vmConfig(id, mac, profile, metadata))
+}
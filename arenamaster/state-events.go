@@ -0,0 +1,59 @@
+package arenamaster
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bytearena/backends/arenamaster/state"
+	"github.com/bytearena/backends/common/mq"
+
+	"github.com/bytearena/core/common/types"
+	"github.com/bytearena/core/common/utils"
+)
+
+var (
+	STATE_EVENTS_LOG_SIZE = 256
+)
+
+func stateEventToMessage(s *state.State, event state.TransitionEvent) *mq.StateTransition {
+	return &mq.StateTransition{
+		Id:    strconv.Itoa(event.Id),
+		From:  strings.Join(s.DebugFlagToString(event.From), ","),
+		To:    strings.Join(s.DebugFlagToString(event.To), ","),
+		Cause: string(event.Event),
+		Time:  event.Time.Format(time.RFC3339Nano),
+	}
+}
+
+// Keeps the last transitions for arena-master-cli and publishes each of them
+// on arenamaster:state
+func (server *Server) startStateEvents() {
+	server.state.Subscribe(func(event state.TransitionEvent) {
+		server.stateEvents.Push(event)
+
+		err := mq.PublishMessage(server.brokerclient, MASTER_COMPONENT, "arenamaster", "state", stateEventToMessage(server.state, event))
+
+		if err != nil {
+			utils.RecoverableError("state-events", "Could not publish: "+err.Error())
+		}
+	})
+}
+
+func handleDebugGetStateEvents(s *state.State, log *state.EventLog, msg *mq.DebugRequest) types.MQPayload {
+	id := 0
+
+	if msg.Id != "" {
+		id, _ = strconv.Atoi(msg.Id)
+	}
+
+	events := make([]*mq.StateTransition, 0)
+
+	for _, event := range log.Entries(id) {
+		events = append(events, stateEventToMessage(s, event))
+	}
+
+	return types.MQPayload{
+		"events": events,
+	}
+}
@@ -0,0 +1,274 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/bytearena/schnapps"
+	vmtypes "github.com/bytearena/schnapps/types"
+)
+
+const (
+	// Journaled when the metadata of a VM changes, not a transition
+	EVENT_VM_METADATA_UPDATED Event = "vm-metadata-updated"
+
+	// The id used by the arena-server on the MQ, issued at spawn and indexed
+	ARENA_ID_METADATA = "arenaid"
+)
+
+func asVM(data Data) *vm.VM {
+	res, _ := data.(*vm.VM)
+
+	return res
+}
+
+// VMSnapshot is a VM as it was read from the state, with a copy of its
+// config. VM is the handle for the backend, its config must not be read: the
+// metadata is replaced while the state is locked.
+type VMSnapshot struct {
+	Config vmtypes.VMConfig
+	Status byte
+	VM     *vm.VM
+}
+
+// Must be called with the state locked, returns nil if the element isn't a VM
+func snapshotVM(element *DataContainer) *VMSnapshot {
+	v := asVM(element.Data)
+
+	if v == nil {
+		return nil
+	}
+
+	config := v.Config
+	config.Metadata = make(vmtypes.VMMetadata, len(v.Config.Metadata))
+
+	for k, value := range v.Config.Metadata {
+		config.Metadata[k] = value
+	}
+
+	return &VMSnapshot{
+		Config: config,
+		Status: element.Status,
+		VM:     v,
+	}
+}
+
+func copyContainer(element *DataContainer) DataContainer {
+	history := make([]Transition, len(element.History))
+	copy(history, element.History)
+
+	return DataContainer{
+		Data:    element.Data,
+		Status:  element.Status,
+		History: history,
+	}
+}
+
+// Snapshot returns a copy of every element of the state, the data itself is
+// shared
+func (s *State) Snapshot() map[int]DataContainer {
+	s.lockState()
+	defer s.unlockState()
+
+	res := make(map[int]DataContainer, len(s.state))
+
+	for id, element := range s.state {
+		res[id] = copyContainer(element)
+	}
+
+	return res
+}
+
+func (s *State) GetSnapshot(id int) (DataContainer, bool) {
+	s.lockState()
+	defer s.unlockState()
+
+	if element, ok := s.state[id]; ok {
+		return copyContainer(element), true
+	}
+
+	return DataContainer{}, false
+}
+
+// GetVM returns the VM whatever its status, nil if it doesn't exist
+func (s *State) GetVM(id int) *VMSnapshot {
+	s.lockState()
+	defer s.unlockState()
+
+	if element, ok := s.state[id]; ok {
+		return snapshotVM(element)
+	}
+
+	return nil
+}
+
+func getArenaId(data Data) (string, bool) {
+	vm := asVM(data)
+
+	if vm == nil {
+		return "", false
+	}
+
+	arenaId, hasArenaId := vm.Config.Metadata[ARENA_ID_METADATA]
+
+	if !hasArenaId || fmt.Sprint(arenaId) == "" {
+		return "", false
+	}
+
+	return fmt.Sprint(arenaId), true
+}
+
+// GetIdByArenaId returns the id of the VM running the arena
+func (s *State) GetIdByArenaId(arenaId string) (int, bool) {
+	s.lockState()
+	defer s.unlockState()
+
+	id, ok := s.arenaIds[arenaId]
+
+	return id, ok
+}
+
+func (s *State) GetVMByArenaId(arenaId string) *VMSnapshot {
+	s.lockState()
+	defer s.unlockState()
+
+	if id, ok := s.arenaIds[arenaId]; ok {
+		return snapshotVM(s.state[id])
+	}
+
+	return nil
+}
+
+// QueryVM is QueryState returning a VM
+func (s *State) QueryVM(id int, flag byte) *VMSnapshot {
+	s.lockState()
+	defer s.unlockState()
+
+	if element, ok := s.state[id]; ok && isQueryable(element, flag) {
+		return snapshotVM(element)
+	}
+
+	return nil
+}
+
+// FindVM is FindState returning a VM
+func (s *State) FindVM(flag byte) *VMSnapshot {
+	s.lockState()
+	defer s.unlockState()
+
+	for _, element := range s.state {
+		if isQueryable(element, flag) && asVM(element.Data) != nil {
+			return snapshotVM(element)
+		}
+	}
+
+	return nil
+}
+
+// FindVMByMetadata returns a VM in the given state with the given metadata
+func (s *State) FindVMByMetadata(flag byte, key, value string) *VMSnapshot {
+	s.lockState()
+	defer s.unlockState()
+
+	for _, element := range s.state {
+		vm := asVM(element.Data)
+
+		if vm == nil || element.Status&flag == 0 {
+			continue
+		}
+
+		if metadata, hasMetadata := vm.Config.Metadata[key]; hasMetadata && fmt.Sprint(metadata) == value {
+			return snapshotVM(element)
+		}
+	}
+
+	return nil
+}
+
+// GetVMs returns every VM, ordered by id
+func (s *State) GetVMs() []*VMSnapshot {
+	s.lockState()
+	defer s.unlockState()
+
+	ids := make([]int, 0, len(s.state))
+
+	for id := range s.state {
+		ids = append(ids, id)
+	}
+
+	sort.Ints(ids)
+
+	res := make([]*VMSnapshot, 0, len(ids))
+
+	for _, id := range ids {
+		if vm := snapshotVM(s.state[id]); vm != nil {
+			res = append(res, vm)
+		}
+	}
+
+	return res
+}
+
+// UpdateVMMetadata sets a metadata of a VM while the state is locked, the
+// change is journaled
+func (s *State) UpdateVMMetadata(id int, key, value string) error {
+	s.lockState()
+	defer s.unlockState()
+
+	element, ok := s.state[id]
+
+	if !ok {
+		return unknownIdError(id)
+	}
+
+	vm := asVM(element.Data)
+
+	if vm == nil {
+		return errors.New("VM (" + strconv.Itoa(id) + ") has no metadata")
+	}
+
+	if key == ARENA_ID_METADATA {
+		return errors.New("The arena id of VM (" + strconv.Itoa(id) + ") can't change")
+	}
+
+	// Replaced, not changed: the backends read the config of the VM handles
+	metadata := make(vmtypes.VMMetadata, len(vm.Config.Metadata)+1)
+
+	for k, v := range vm.Config.Metadata {
+		metadata[k] = v
+	}
+
+	metadata[key] = value
+
+	vm.Config.Metadata = metadata
+	s.record(id, EVENT_VM_METADATA_UPDATED)
+
+	return nil
+}
+
+func (s *State) GetVMMetadata(id int, key string) (string, bool) {
+	s.lockState()
+	defer s.unlockState()
+
+	element, ok := s.state[id]
+
+	if !ok {
+		return "", false
+	}
+
+	vm := asVM(element.Data)
+
+	if vm == nil {
+		return "", false
+	}
+
+	value, hasValue := vm.Config.Metadata[key]
+
+	if !hasValue {
+		return "", false
+	}
+
+	return fmt.Sprint(value), true
+}
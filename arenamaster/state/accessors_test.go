@@ -0,0 +1,156 @@
+package state
+
+import (
+	"testing"
+
+	"github.com/bytearena/schnapps"
+	vmtypes "github.com/bytearena/schnapps/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestVM(id int) *vm.VM {
+	return vm.NewVM(vmtypes.VMConfig{
+		Id:       id,
+		Metadata: vmtypes.VMMetadata{},
+	})
+}
+
+func TestTypedAccessors(t *testing.T) {
+	s := NewState()
+
+	first := newTestVM(1)
+	second := newTestVM(2)
+
+	assert.Nil(t, s.UpdateStateAddBootingVM(2, second))
+	assert.Nil(t, s.UpdateStateAddBootingVM(1, first))
+	assert.Nil(t, s.UpdateStateAddBootingVM(3, new(struct{})))
+
+	assert.Nil(t, s.UpdateStateVMBooted(1))
+	assert.Nil(t, s.UpdateStateAddIdleArena(1))
+
+	assert.Equal(t, first, s.GetVM(1).VM)
+	assert.Equal(t, STATUS_IDLE, s.GetVM(1).Status)
+	assert.Nil(t, s.GetVM(3))
+	assert.Nil(t, s.GetVM(4))
+
+	assert.Equal(t, first, s.QueryVM(1, STATE_IDLE_ARENA).VM)
+	assert.Nil(t, s.QueryVM(2, STATE_IDLE_ARENA))
+
+	assert.Equal(t, first, s.FindVM(STATE_IDLE_ARENA).VM)
+	assert.Nil(t, s.FindVM(STATE_RUNNING_ARENA))
+
+	assert.Nil(t, s.UpdateStateVMErrored(1))
+	assert.Nil(t, s.FindVM(STATE_IDLE_ARENA))
+
+	vms := s.GetVMs()
+
+	assert.Len(t, vms, 2)
+	assert.Equal(t, first, vms[0].VM)
+	assert.Equal(t, second, vms[1].VM)
+}
+
+func TestVMSnapshotIsACopy(t *testing.T) {
+	s := NewState()
+
+	assert.Nil(t, s.UpdateStateAddBootingVM(1, newTestVM(1)))
+	assert.Nil(t, s.UpdateVMMetadata(1, "gameid", "42"))
+
+	snapshot := s.GetVM(1)
+	snapshot.Config.Metadata["gameid"] = "43"
+
+	value, _ := s.GetVMMetadata(1, "gameid")
+	assert.Equal(t, "42", value)
+
+	assert.Nil(t, s.UpdateStateVMBooted(1))
+	assert.Nil(t, s.UpdateVMMetadata(1, "gameid", "44"))
+
+	assert.Equal(t, STATUS_BOOTING, snapshot.Status)
+	assert.Equal(t, "43", snapshot.Config.Metadata["gameid"])
+}
+
+func TestSnapshotIsACopy(t *testing.T) {
+	s := NewState()
+
+	assert.Nil(t, s.UpdateStateAddBootingVM(1, newTestVM(1)))
+
+	snapshot := s.Snapshot()
+	element, hasElement := s.GetSnapshot(1)
+
+	assert.True(t, hasElement)
+	assert.Equal(t, STATUS_BOOTING, snapshot[1].Status)
+
+	assert.Nil(t, s.UpdateStateVMBooted(1))
+
+	assert.Equal(t, STATUS_BOOTING, snapshot[1].Status)
+	assert.Len(t, snapshot[1].History, 1)
+	assert.Equal(t, STATUS_BOOTING, element.Status)
+	assert.Equal(t, STATUS_BOOTED, s.GetStatus(1))
+
+	_, hasElement = s.GetSnapshot(2)
+	assert.False(t, hasElement)
+}
+
+func TestVMMetadata(t *testing.T) {
+	s := NewState()
+
+	assert.Nil(t, s.UpdateStateAddBootingVM(1, vm.NewVM(vmtypes.VMConfig{Id: 1})))
+	assert.Nil(t, s.UpdateStateAddBootingVM(2, nil))
+
+	_, hasValue := s.GetVMMetadata(1, "gameid")
+	assert.False(t, hasValue)
+
+	assert.Nil(t, s.UpdateVMMetadata(1, "gameid", "42"))
+	assert.Error(t, s.UpdateVMMetadata(2, "gameid", "42"))
+	assert.Error(t, s.UpdateVMMetadata(3, "gameid", "42"))
+
+	value, hasValue := s.GetVMMetadata(1, "gameid")
+	assert.True(t, hasValue)
+	assert.Equal(t, "42", value)
+
+	// The previous metadata isn't changed
+	metadata := s.GetVM(1).Config.Metadata
+	assert.Nil(t, s.UpdateVMMetadata(1, "gameid", "43"))
+	assert.Equal(t, "42", metadata["gameid"])
+	assert.Nil(t, s.UpdateVMMetadata(1, "gameid", "42"))
+
+	assert.NotNil(t, s.FindVMByMetadata(STATE_BOOTING_VM, "gameid", "42"))
+	assert.Nil(t, s.FindVMByMetadata(STATE_BOOTING_VM, "gameid", "43"))
+	assert.Nil(t, s.FindVMByMetadata(STATE_RUNNING_ARENA, "gameid", "42"))
+}
+
+func TestArenaIdIndex(t *testing.T) {
+	s := NewState()
+
+	arena := vm.NewVM(vmtypes.VMConfig{
+		Id:       1,
+		Metadata: vmtypes.VMMetadata{ARENA_ID_METADATA: "a"},
+	})
+
+	duplicate := vm.NewVM(vmtypes.VMConfig{
+		Id:       2,
+		Metadata: vmtypes.VMMetadata{ARENA_ID_METADATA: "a"},
+	})
+
+	assert.Nil(t, s.UpdateStateAddBootingVM(1, arena))
+	assert.Error(t, s.UpdateStateAddBootingVM(2, duplicate))
+	assert.Nil(t, s.UpdateStateAddBootingVM(3, newTestVM(3)))
+
+	id, found := s.GetIdByArenaId("a")
+	assert.True(t, found)
+	assert.Equal(t, 1, id)
+	assert.Equal(t, arena, s.GetVMByArenaId("a").VM)
+
+	_, found = s.GetIdByArenaId("")
+	assert.False(t, found)
+	assert.Nil(t, s.GetVMByArenaId("b"))
+
+	assert.Error(t, s.UpdateVMMetadata(1, ARENA_ID_METADATA, "b"))
+
+	assert.Nil(t, s.UpdateStateVMHalted(1))
+
+	_, found = s.GetIdByArenaId("a")
+	assert.False(t, found)
+
+	assert.Nil(t, s.UpdateStateAddBootingVM(2, duplicate))
+	assert.Equal(t, duplicate, s.GetVMByArenaId("a").VM)
+}
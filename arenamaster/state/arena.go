@@ -1,69 +1,21 @@
 package state
 
-func (s *State) UpdateStateAddIdleArena(id int) (stateUpdated bool) {
-	stateUpdated = false
-
-	s.lockState()
-
-	if state, ok := s.state[id]; ok {
-		state.Status &^= STATE_BOOTING_VM
-		state.Status |= STATE_IDLE_ARENA
-
-		stateUpdated = true
-	}
-
-	s.unlockState()
-
-	return stateUpdated
+func (s *State) UpdateStateAddIdleArena(id int) error {
+	return s.transition(id, EVENT_ARENA_IDLE)
 }
 
-func (s *State) UpdateStateTriedLaunchArena(id int) (stateUpdated bool) {
-	stateUpdated = false
-
-	s.lockState()
-
-	if state, ok := s.state[id]; ok {
-		state.Status ^= STATE_IDLE_ARENA
-		state.Status |= STATE_PENDING_ARENA
-
-		stateUpdated = true
-	}
-
-	s.unlockState()
-
-	return stateUpdated
+func (s *State) UpdateStateTriedLaunchArena(id int) error {
+	return s.transition(id, EVENT_ARENA_TRIED_LAUNCH)
 }
 
-func (s *State) UpdateStateConfirmedLaunchArena(id int) (stateUpdated bool) {
-	stateUpdated = false
-
-	s.lockState()
-
-	if state, ok := s.state[id]; ok {
-		state.Status ^= STATE_PENDING_ARENA
-		state.Status |= STATE_RUNNING_ARENA
-
-		stateUpdated = true
-	}
-
-	s.unlockState()
-
-	return stateUpdated
+func (s *State) UpdateStateConfirmedLaunchArena(id int) error {
+	return s.transition(id, EVENT_ARENA_CONFIRMED_LAUNCH)
 }
 
-func (s *State) UpdateStateStoppedArena(id int) (stateUpdated bool) {
-	stateUpdated = false
-
-	s.lockState()
-
-	if state, ok := s.state[id]; ok {
-		state.Status &^= STATE_IDLE_ARENA
-		state.Status &^= STATE_RUNNING_ARENA
-
-		stateUpdated = true
-	}
-
-	s.unlockState()
+func (s *State) UpdateStateStoppedArena(id int) error {
+	return s.transition(id, EVENT_ARENA_STOPPED)
+}
 
-	return stateUpdated
+func (s *State) UpdateStateErroredArena(id int) error {
+	return s.transition(id, EVENT_ARENA_ERRORED)
 }
@@ -14,55 +14,142 @@ func TestArenaState(t *testing.T) {
 		{
 			Name: "Should add an idle arena",
 
+			InitialState: STATUS_BOOTED,
 			Mutations: func(s *State, id int) {
-				assert.True(t, s.UpdateStateAddIdleArena(id))
+				assert.Nil(t, s.UpdateStateAddIdleArena(id))
 			},
-			ResultState: STATE_IDLE_ARENA,
+			ResultState: STATE_RUNNING_VM | STATE_IDLE_ARENA,
 		},
 		{
 			Name: "Should add an idle arena and remove booting",
 
-			InitialState: STATE_BOOTING_VM,
+			InitialState: STATUS_BOOTING,
 			Mutations: func(s *State, id int) {
-				assert.True(t, s.UpdateStateAddIdleArena(id))
+				assert.Nil(t, s.UpdateStateAddIdleArena(id))
 			},
-			ResultState: STATE_IDLE_ARENA,
+			ResultState: STATE_RUNNING_VM | STATE_IDLE_ARENA,
+		},
+		{
+			Name: "Should refuse an idle arena while running",
+
+			InitialState: STATUS_RUNNING,
+			Mutations: func(s *State, id int) {
+				assert.Error(t, s.UpdateStateAddIdleArena(id))
+			},
+			ResultState: STATE_RUNNING_VM | STATE_RUNNING_ARENA,
 		},
 		{
 			Name: "Should add an launched arena",
 
-			InitialState: STATE_IDLE_ARENA,
+			InitialState: STATUS_IDLE,
+			Mutations: func(s *State, id int) {
+				assert.Nil(t, s.UpdateStateTriedLaunchArena(id))
+			},
+			ResultState: STATE_RUNNING_VM | STATE_PENDING_ARENA,
+		},
+		{
+			Name: "Should refuse to launch an arena twice",
+
+			InitialState: STATUS_IDLE,
 			Mutations: func(s *State, id int) {
-				assert.True(t, s.UpdateStateTriedLaunchArena(id))
+				assert.Nil(t, s.UpdateStateTriedLaunchArena(id))
+				assert.Error(t, s.UpdateStateTriedLaunchArena(id))
 			},
-			ResultState: STATE_PENDING_ARENA,
+			ResultState: STATE_RUNNING_VM | STATE_PENDING_ARENA,
 		},
 		{
 			Name: "Should add an launched and confirmed arena",
 
-			InitialState: STATE_PENDING_ARENA,
+			InitialState: STATUS_PENDING,
 			Mutations: func(s *State, id int) {
-				assert.True(t, s.UpdateStateConfirmedLaunchArena(id))
+				assert.Nil(t, s.UpdateStateConfirmedLaunchArena(id))
 			},
-			ResultState: STATE_RUNNING_ARENA,
+			ResultState: STATE_RUNNING_VM | STATE_RUNNING_ARENA,
+		},
+		{
+			Name: "Should refuse a second launch confirmation",
+
+			InitialState: STATUS_PENDING,
+			Mutations: func(s *State, id int) {
+				assert.Nil(t, s.UpdateStateConfirmedLaunchArena(id))
+				assert.Error(t, s.UpdateStateConfirmedLaunchArena(id))
+			},
+			ResultState: STATE_RUNNING_VM | STATE_RUNNING_ARENA,
+		},
+		{
+			Name: "Should refuse a launch confirmation of an idle arena",
+
+			InitialState: STATUS_IDLE,
+			Mutations: func(s *State, id int) {
+				assert.Error(t, s.UpdateStateConfirmedLaunchArena(id))
+			},
+			ResultState: STATE_RUNNING_VM | STATE_IDLE_ARENA,
 		},
 		{
 			Name: "Should add an stopped arena while running",
 
-			InitialState: STATE_RUNNING_ARENA,
+			InitialState: STATUS_RUNNING,
 			Mutations: func(s *State, id int) {
-				assert.True(t, s.UpdateStateStoppedArena(id))
+				assert.Nil(t, s.UpdateStateStoppedArena(id))
 			},
-			ResultState: 0,
+			ResultState: STATE_RUNNING_VM,
 		},
 		{
 			Name: "Should add an stopped arena while idle",
 
-			InitialState: STATE_IDLE_ARENA,
+			InitialState: STATUS_IDLE,
+			Mutations: func(s *State, id int) {
+				assert.Nil(t, s.UpdateStateStoppedArena(id))
+			},
+			ResultState: STATE_RUNNING_VM,
+		},
+		{
+			Name: "Should add an stopped arena while errored",
+
+			InitialState: STATUS_RUNNING | STATE_ERRORED_VM,
+			Mutations: func(s *State, id int) {
+				assert.Nil(t, s.UpdateStateStoppedArena(id))
+			},
+			ResultState: STATE_RUNNING_VM | STATE_ERRORED_VM,
+		},
+		{
+			Name: "Should refuse to stop an arena twice",
+
+			InitialState: STATUS_RUNNING,
+			Mutations: func(s *State, id int) {
+				assert.Nil(t, s.UpdateStateStoppedArena(id))
+				assert.Error(t, s.UpdateStateStoppedArena(id))
+			},
+			ResultState: STATE_RUNNING_VM,
+		},
+		{
+			Name: "Should mark an unconfirmed launch as errored",
+
+			InitialState: STATUS_PENDING,
+			Mutations: func(s *State, id int) {
+				assert.Nil(t, s.UpdateStateErroredArena(id))
+			},
+			ResultState: STATE_RUNNING_VM | STATE_PENDING_ARENA | STATE_ERRORED_ARENA,
+		},
+		{
+			Name: "Should refuse a late launch confirmation",
+
+			InitialState: STATUS_PENDING,
+			Mutations: func(s *State, id int) {
+				assert.Nil(t, s.UpdateStateErroredArena(id))
+				assert.Error(t, s.UpdateStateConfirmedLaunchArena(id))
+				assert.Error(t, s.UpdateStateStoppedArena(id))
+			},
+			ResultState: STATE_RUNNING_VM | STATE_PENDING_ARENA | STATE_ERRORED_ARENA,
+		},
+		{
+			Name: "Should refuse to mark a running arena as errored",
+
+			InitialState: STATUS_RUNNING,
 			Mutations: func(s *State, id int) {
-				assert.True(t, s.UpdateStateStoppedArena(id))
+				assert.Error(t, s.UpdateStateErroredArena(id))
 			},
-			ResultState: 0,
+			ResultState: STATE_RUNNING_VM | STATE_RUNNING_ARENA,
 		},
 	}
 
@@ -0,0 +1,101 @@
+package state
+
+import (
+	"sync"
+)
+
+// TransitionEvent is emitted to the subscribers of the state for every
+// transition, the cause of the transition is its Event
+type TransitionEvent struct {
+	Id int `json:"id"`
+	Transition
+}
+
+type Subscriber func(event TransitionEvent)
+
+// Subscribe registers a function called after each transition, in the order
+// of the transitions. Subscribers can read the state but must not update it.
+func (s *State) Subscribe(fn Subscriber) {
+	s.dispatchMutex.Lock()
+	s.subscribers = append(s.subscribers, fn)
+	s.dispatchMutex.Unlock()
+}
+
+// Must be called with the state locked
+func (s *State) queueEvent(id int, transition Transition) {
+	s.pendingEvents = append(s.pendingEvents, TransitionEvent{
+		Id:         id,
+		Transition: transition,
+	})
+}
+
+// Must be called with the state unlocked, the events are queued while the
+// state is locked and dispatched one batch after the other to keep their order
+func (s *State) dispatch() {
+	s.dispatchMutex.Lock()
+	defer s.dispatchMutex.Unlock()
+
+	s.lockState()
+	events := s.pendingEvents
+	s.pendingEvents = nil
+	s.unlockState()
+
+	for _, event := range events {
+		for _, fn := range s.subscribers {
+			fn(event)
+		}
+	}
+}
+
+// EventLog keeps the last transitions in a ring buffer
+type EventLog struct {
+	mutex  sync.Mutex
+	events []TransitionEvent
+	next   int
+	full   bool
+}
+
+func NewEventLog(size int) *EventLog {
+	return &EventLog{
+		events: make([]TransitionEvent, size),
+	}
+}
+
+func (l *EventLog) Push(event TransitionEvent) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if len(l.events) == 0 {
+		return
+	}
+
+	l.events[l.next] = event
+	l.next = (l.next + 1) % len(l.events)
+
+	if l.next == 0 {
+		l.full = true
+	}
+}
+
+// Entries returns the logged events of a VM, oldest first. All the events are
+// returned when id is 0.
+func (l *EventLog) Entries(id int) []TransitionEvent {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	ordered := l.events[:l.next]
+
+	if l.full {
+		ordered = append(append([]TransitionEvent{}, l.events[l.next:]...), l.events[:l.next]...)
+	}
+
+	res := make([]TransitionEvent, 0, len(ordered))
+
+	for _, event := range ordered {
+		if id == 0 || event.Id == id {
+			res = append(res, event)
+		}
+	}
+
+	return res
+}
@@ -0,0 +1,64 @@
+package state
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubscribersReceiveTransitions(t *testing.T) {
+	s := NewState()
+	received := make([]TransitionEvent, 0)
+
+	s.Subscribe(func(event TransitionEvent) {
+		// Subscribers can read the state
+		s.GetStatus(event.Id)
+
+		received = append(received, event)
+	})
+
+	assert.Nil(t, s.UpdateStateAddBootingVM(1, nil))
+	assert.Nil(t, s.UpdateStateVMBooted(1))
+	assert.Error(t, s.UpdateStateVMBooted(1))
+	assert.Nil(t, s.UpdateStateVMHalted(1))
+
+	assert.Len(t, received, 3)
+
+	assert.Equal(t, 1, received[0].Id)
+	assert.Equal(t, byte(0), received[0].From)
+	assert.Equal(t, STATUS_BOOTING, received[0].To)
+	assert.Equal(t, EVENT_VM_ADDED, received[0].Event)
+
+	assert.Equal(t, STATUS_BOOTING, received[1].From)
+	assert.Equal(t, STATUS_BOOTED, received[1].To)
+	assert.Equal(t, EVENT_VM_BOOTED, received[1].Event)
+
+	assert.Equal(t, STATUS_BOOTED, received[2].From)
+	assert.Equal(t, byte(0), received[2].To)
+	assert.Equal(t, EVENT_VM_HALTED, received[2].Event)
+}
+
+func TestEventLog(t *testing.T) {
+	log := NewEventLog(3)
+
+	assert.Len(t, log.Entries(0), 0)
+
+	log.Push(TransitionEvent{Id: 1})
+	log.Push(TransitionEvent{Id: 2})
+
+	assert.Len(t, log.Entries(0), 2)
+	assert.Len(t, log.Entries(2), 1)
+
+	log.Push(TransitionEvent{Id: 1})
+	log.Push(TransitionEvent{Id: 3})
+
+	entries := log.Entries(0)
+
+	assert.Len(t, entries, 3)
+	assert.Equal(t, 2, entries[0].Id)
+	assert.Equal(t, 1, entries[1].Id)
+	assert.Equal(t, 3, entries[2].Id)
+
+	assert.Len(t, log.Entries(1), 1)
+	assert.Len(t, log.Entries(4), 0)
+}
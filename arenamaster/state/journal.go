@@ -0,0 +1,366 @@
+package state
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/bytearena/core/common/utils"
+)
+
+// Journal records every state transition so that the state can be rebuilt
+// after arena-master restarts
+type Journal interface {
+	Append(entry JournalEntry) error
+	Replay() ([]JournalEntry, error)
+	Close() error
+}
+
+type JournalEntry struct {
+	Id       int               `json:"id"`
+	Status   byte              `json:"status"`
+	Event    Event             `json:"event,omitempty"`
+	Removed  bool              `json:"removed,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	Time     time.Time         `json:"time"`
+}
+
+// Describes the data stored in the state, the result is persisted with each
+// transition
+type DescribeFunc func(data Data) map[string]string
+
+// LatestEntries folds a journal into the last known entry of each element
+// still present in the state, and returns the highest id ever recorded
+func LatestEntries(entries []JournalEntry) ([]JournalEntry, int) {
+	latest := make(map[int]JournalEntry)
+	lastId := 0
+
+	for _, entry := range entries {
+		if entry.Id > lastId {
+			lastId = entry.Id
+		}
+
+		if entry.Removed {
+			delete(latest, entry.Id)
+		} else {
+			latest[entry.Id] = entry
+		}
+	}
+
+	res := make([]JournalEntry, 0, len(latest))
+
+	for _, entry := range latest {
+		res = append(res, entry)
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Id < res[j].Id
+	})
+
+	return res, lastId
+}
+
+var (
+	// Entries waiting for the writer, Append blocks once the queue is full
+	JOURNAL_QUEUE_SIZE = 1024
+
+	// The journal is compacted after that many entries were appended
+	JOURNAL_COMPACT_AFTER = 10000
+)
+
+// journalOp is an entry to write, or a request to be notified once the
+// entries queued before it are written
+type journalOp struct {
+	line    []byte
+	flushed chan struct{}
+}
+
+// FileJournal is an append-only journal of JSON lines. The entries are
+// written and synced by a goroutine, the journal is compacted when opened and
+// after JOURNAL_COMPACT_AFTER entries.
+type FileJournal struct {
+	mutex    sync.Mutex
+	path     string
+	file     *os.File
+	appended int
+
+	queueMutex sync.RWMutex
+	queue      chan journalOp
+	closed     bool
+	stopped    chan struct{}
+}
+
+func NewFileJournal(path string) (*FileJournal, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, errors.New("Could not create journal directory: " + err.Error())
+	}
+
+	journal := &FileJournal{
+		path:    path,
+		queue:   make(chan journalOp, JOURNAL_QUEUE_SIZE),
+		stopped: make(chan struct{}),
+	}
+
+	if err := journal.compact(); err != nil {
+		return nil, err
+	}
+
+	if err := journal.open(); err != nil {
+		return nil, err
+	}
+
+	go journal.run()
+
+	return journal, nil
+}
+
+func (j *FileJournal) open() error {
+	file, err := os.OpenFile(j.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+
+	if err != nil {
+		return errors.New("Could not open journal: " + err.Error())
+	}
+
+	j.file = file
+
+	return nil
+}
+
+// Append queues the entry, it's written after the ones appended before
+func (j *FileJournal) Append(entry JournalEntry) error {
+	line, err := json.Marshal(entry)
+
+	if err != nil {
+		return err
+	}
+
+	return j.push(journalOp{line: append(line, '\n')})
+}
+
+func (j *FileJournal) push(op journalOp) error {
+	j.queueMutex.RLock()
+	defer j.queueMutex.RUnlock()
+
+	if j.closed {
+		return errors.New("Journal is closed")
+	}
+
+	j.queue <- op
+
+	return nil
+}
+
+// run writes the entries queued in the meantime with a single sync
+func (j *FileJournal) run() {
+	defer close(j.stopped)
+
+	for op := range j.queue {
+		ops := []journalOp{op}
+
+	batch:
+		for {
+			select {
+			case next, ok := <-j.queue:
+				if !ok {
+					break batch
+				}
+
+				ops = append(ops, next)
+			default:
+				break batch
+			}
+		}
+
+		j.write(ops)
+	}
+}
+
+func (j *FileJournal) write(ops []journalOp) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	written := 0
+
+	for _, op := range ops {
+		if op.line == nil {
+			continue
+		}
+
+		if j.file == nil {
+			utils.RecoverableError("state", "Could not journal transition: journal is closed")
+			continue
+		}
+
+		if _, err := j.file.Write(op.line); err != nil {
+			utils.RecoverableError("state", "Could not journal transition: "+err.Error())
+			continue
+		}
+
+		written++
+	}
+
+	if written > 0 {
+		if err := j.file.Sync(); err != nil {
+			utils.RecoverableError("state", "Could not sync journal: "+err.Error())
+		}
+
+		j.appended += written
+	}
+
+	if j.appended >= JOURNAL_COMPACT_AFTER {
+		j.reopenCompacted()
+	}
+
+	for _, op := range ops {
+		if op.flushed != nil {
+			close(op.flushed)
+		}
+	}
+}
+
+// Must be called with the journal locked
+func (j *FileJournal) reopenCompacted() {
+	j.appended = 0
+
+	if err := j.file.Close(); err != nil {
+		utils.RecoverableError("state", "Could not close journal: "+err.Error())
+	}
+
+	j.file = nil
+
+	if err := j.compact(); err != nil {
+		utils.RecoverableError("state", err.Error())
+	}
+
+	if err := j.open(); err != nil {
+		utils.RecoverableError("state", err.Error())
+	}
+}
+
+// Replay reads the journal once the queued entries are written
+func (j *FileJournal) Replay() ([]JournalEntry, error) {
+	flushed := make(chan struct{})
+
+	if err := j.push(journalOp{flushed: flushed}); err == nil {
+		<-flushed
+	}
+
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	return readJournalFile(j.path)
+}
+
+// Close writes the queued entries before closing the file
+func (j *FileJournal) Close() error {
+	j.queueMutex.Lock()
+
+	if j.closed {
+		j.queueMutex.Unlock()
+		return nil
+	}
+
+	j.closed = true
+	close(j.queue)
+	j.queueMutex.Unlock()
+
+	<-j.stopped
+
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	if j.file == nil {
+		return nil
+	}
+
+	err := j.file.Close()
+	j.file = nil
+
+	return err
+}
+
+// Rewrites the journal with only the latest entry of each element, the
+// highest id is kept so that ids are never reused
+func (j *FileJournal) compact() error {
+	entries, err := readJournalFile(j.path)
+
+	if err != nil {
+		return err
+	}
+
+	latest, lastId := LatestEntries(entries)
+
+	if lastId != 0 && (len(latest) == 0 || latest[len(latest)-1].Id != lastId) {
+		latest = append(latest, JournalEntry{
+			Id:      lastId,
+			Removed: true,
+			Time:    time.Now(),
+		})
+	}
+
+	tmpPath := j.path + ".tmp"
+	file, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+
+	if err != nil {
+		return errors.New("Could not compact journal: " + err.Error())
+	}
+
+	writer := bufio.NewWriter(file)
+
+	for _, entry := range latest {
+		line, err := json.Marshal(entry)
+
+		if err != nil {
+			file.Close()
+			return err
+		}
+
+		writer.Write(append(line, '\n'))
+	}
+
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return errors.New("Could not compact journal: " + err.Error())
+	}
+
+	if err := file.Close(); err != nil {
+		return errors.New("Could not compact journal: " + err.Error())
+	}
+
+	return os.Rename(tmpPath, j.path)
+}
+
+func readJournalFile(path string) ([]JournalEntry, error) {
+	entries := make([]JournalEntry, 0)
+	file, err := os.Open(path)
+
+	if os.IsNotExist(err) {
+		return entries, nil
+	}
+
+	if err != nil {
+		return nil, errors.New("Could not read journal: " + err.Error())
+	}
+
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+
+	for scanner.Scan() {
+		var entry JournalEntry
+
+		// A crash during a write can leave a truncated line, skip it
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, scanner.Err()
+}
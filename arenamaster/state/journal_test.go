@@ -0,0 +1,145 @@
+package state
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func createTestJournal(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "arenamaster-state")
+	assert.Nil(t, err)
+
+	return filepath.Join(dir, "state.journal"), func() {
+		os.RemoveAll(dir)
+	}
+}
+
+func describeTestData(data Data) map[string]string {
+	return map[string]string{
+		"gameid": strconv.Itoa(data.(int)),
+	}
+}
+
+func TestJournalRecordsTransitions(t *testing.T) {
+	path, cleanup := createTestJournal(t)
+	defer cleanup()
+
+	journal, err := NewFileJournal(path)
+	assert.Nil(t, err)
+
+	s := NewStateWithJournal(journal, describeTestData)
+
+	s.UpdateStateAddBootingVM(1, 42)
+	s.UpdateStateVMBooted(1)
+	s.UpdateStateAddIdleArena(1)
+
+	s.UpdateStateAddBootingVM(2, 43)
+	s.UpdateStateVMHalted(2)
+
+	assert.Error(t, s.UpdateStateVMBooted(3))
+
+	entries, err := journal.Replay()
+	assert.Nil(t, err)
+	assert.Len(t, entries, 5)
+
+	latest, lastId := LatestEntries(entries)
+
+	assert.Equal(t, 2, lastId)
+	assert.Len(t, latest, 1)
+	assert.Equal(t, 1, latest[0].Id)
+	assert.Equal(t, STATE_RUNNING_VM|STATE_IDLE_ARENA, latest[0].Status)
+	assert.Equal(t, "42", latest[0].Metadata["gameid"])
+
+	assert.Nil(t, journal.Close())
+}
+
+func TestJournalCompactionOnOpen(t *testing.T) {
+	path, cleanup := createTestJournal(t)
+	defer cleanup()
+
+	journal, err := NewFileJournal(path)
+	assert.Nil(t, err)
+
+	s := NewStateWithJournal(journal, describeTestData)
+
+	s.UpdateStateAddBootingVM(1, 1)
+	s.UpdateStateVMBooted(1)
+	s.UpdateStateAddBootingVM(2, 2)
+	s.UpdateStateVMHalted(2)
+
+	assert.Nil(t, journal.Close())
+
+	reopened, err := NewFileJournal(path)
+	assert.Nil(t, err)
+	defer reopened.Close()
+
+	entries, err := reopened.Replay()
+	assert.Nil(t, err)
+
+	// Latest entry of VM 1 and the removal of VM 2 which holds the last id
+	assert.Len(t, entries, 2)
+
+	latest, lastId := LatestEntries(entries)
+
+	assert.Equal(t, 2, lastId)
+	assert.Len(t, latest, 1)
+	assert.Equal(t, STATE_RUNNING_VM, latest[0].Status)
+}
+
+func TestJournalCompactionAfterAppends(t *testing.T) {
+	defer func(compactAfter int) { JOURNAL_COMPACT_AFTER = compactAfter }(JOURNAL_COMPACT_AFTER)
+	JOURNAL_COMPACT_AFTER = 10
+
+	path, cleanup := createTestJournal(t)
+	defer cleanup()
+
+	journal, err := NewFileJournal(path)
+	assert.Nil(t, err)
+	defer journal.Close()
+
+	s := NewStateWithJournal(journal, describeTestData)
+
+	s.UpdateStateAddBootingVM(1, 1)
+	s.UpdateStateVMBooted(1)
+
+	for id := 2; id <= 21; id++ {
+		assert.Nil(t, s.UpdateStateAddBootingVM(id, id))
+		assert.Nil(t, s.UpdateStateVMHalted(id))
+	}
+
+	entries, err := journal.Replay()
+	assert.Nil(t, err)
+	// 42 entries were appended, the last compaction kept 2 of them and at
+	// most 9 were appended since
+	assert.True(t, len(entries) <= 11)
+
+	latest, lastId := LatestEntries(entries)
+
+	assert.Equal(t, 21, lastId)
+	assert.Len(t, latest, 1)
+	assert.Equal(t, STATE_RUNNING_VM, latest[0].Status)
+}
+
+func TestJournalSkipsTruncatedEntries(t *testing.T) {
+	path, cleanup := createTestJournal(t)
+	defer cleanup()
+
+	content := `{"id":1,"status":2,"time":"2017-12-01T10:00:00Z"}
+{"id":2,"sta`
+
+	assert.Nil(t, ioutil.WriteFile(path, []byte(content), 0644))
+
+	journal, err := NewFileJournal(path)
+	assert.Nil(t, err)
+	defer journal.Close()
+
+	entries, err := journal.Replay()
+	assert.Nil(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, 1, entries[0].Id)
+}
@@ -0,0 +1,212 @@
+package state
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Event string
+
+const (
+	EVENT_VM_ADDED     Event = "vm-added"
+	EVENT_VM_RECOVERED Event = "vm-recovered"
+	EVENT_VM_BOOTED    Event = "vm-booted"
+	EVENT_VM_ERRORED   Event = "vm-errored"
+	EVENT_VM_HALTED    Event = "vm-halted"
+
+	EVENT_ARENA_IDLE             Event = "arena-idle"
+	EVENT_ARENA_TRIED_LAUNCH     Event = "arena-tried-launch"
+	EVENT_ARENA_CONFIRMED_LAUNCH Event = "arena-confirmed-launch"
+	EVENT_ARENA_STOPPED          Event = "arena-stopped"
+	EVENT_ARENA_ERRORED          Event = "arena-errored"
+
+	MAX_HISTORY_LENGTH = 64
+)
+
+const (
+	STATUS_BOOTING = STATE_BOOTING_VM
+	STATUS_BOOTED  = STATE_RUNNING_VM
+	STATUS_IDLE    = STATE_RUNNING_VM | STATE_IDLE_ARENA
+	STATUS_PENDING = STATE_RUNNING_VM | STATE_PENDING_ARENA
+	STATUS_RUNNING = STATE_RUNNING_VM | STATE_RUNNING_ARENA
+
+	// The arena never confirmed its launch, the VM is about to be halted
+	STATUS_FAILED_LAUNCH = STATUS_PENDING | STATE_ERRORED_ARENA
+)
+
+// Every state a VM can be in, any of them can also be flagged with
+// STATE_ERRORED_VM
+var declaredStates = []byte{
+	STATUS_BOOTING,
+	STATUS_BOOTED,
+	STATUS_IDLE,
+	STATUS_PENDING,
+	STATUS_RUNNING,
+	STATUS_FAILED_LAUNCH,
+}
+
+// Allowed transitions for each event, from a state to the next one.
+// STATE_ERRORED_VM and halting are handled in nextStatus since they are
+// allowed from any state.
+var transitions = map[Event]map[byte]byte{
+	EVENT_VM_BOOTED: {
+		STATUS_BOOTING: STATUS_BOOTED,
+	},
+	EVENT_ARENA_IDLE: {
+		// The arena can handshake before we noticed that the VM has booted
+		STATUS_BOOTING: STATUS_IDLE,
+		STATUS_BOOTED:  STATUS_IDLE,
+	},
+	EVENT_ARENA_TRIED_LAUNCH: {
+		STATUS_IDLE: STATUS_PENDING,
+	},
+	EVENT_ARENA_CONFIRMED_LAUNCH: {
+		STATUS_PENDING: STATUS_RUNNING,
+	},
+	EVENT_ARENA_STOPPED: {
+		STATUS_IDLE:    STATUS_BOOTED,
+		STATUS_PENDING: STATUS_BOOTED,
+		STATUS_RUNNING: STATUS_BOOTED,
+
+		// An unhealthy arena can still report the end of its game
+		STATUS_IDLE | STATE_ERRORED_VM:    STATUS_BOOTED | STATE_ERRORED_VM,
+		STATUS_PENDING | STATE_ERRORED_VM: STATUS_BOOTED | STATE_ERRORED_VM,
+		STATUS_RUNNING | STATE_ERRORED_VM: STATUS_BOOTED | STATE_ERRORED_VM,
+	},
+	EVENT_ARENA_ERRORED: {
+		STATUS_PENDING:                    STATUS_FAILED_LAUNCH,
+		STATUS_PENDING | STATE_ERRORED_VM: STATUS_FAILED_LAUNCH | STATE_ERRORED_VM,
+	},
+}
+
+type Transition struct {
+	From  byte      `json:"from"`
+	To    byte      `json:"to"`
+	Event Event     `json:"event"`
+	Time  time.Time `json:"time"`
+}
+
+type IllegalTransitionError struct {
+	Id    int
+	From  byte
+	Event Event
+}
+
+func (e IllegalTransitionError) Error() string {
+	from := strings.Join(flagToString(e.From), ",")
+
+	return "Illegal transition " + string(e.Event) + " from " + from + " for VM (" + strconv.Itoa(e.Id) + ")"
+}
+
+func unknownIdError(id int) error {
+	return errors.New("VM (" + strconv.Itoa(id) + ") does not exist")
+}
+
+func isDeclaredState(status byte) bool {
+	for _, declared := range declaredStates {
+		if status == declared || status == declared|STATE_ERRORED_VM {
+			return true
+		}
+	}
+
+	return false
+}
+
+func nextStatus(from byte, event Event) (byte, bool) {
+	switch event {
+	case EVENT_VM_HALTED:
+		return 0, true
+
+	case EVENT_VM_ERRORED:
+		if isDeclaredState(from) && from&STATE_ERRORED_VM == 0 {
+			return from | STATE_ERRORED_VM, true
+		}
+
+		return 0, false
+	}
+
+	to, allowed := transitions[event][from]
+
+	return to, allowed
+}
+
+// transition applies an event to a VM, halting removes it from the state
+func (s *State) transition(id int, event Event) error {
+	s.lockState()
+	err := s.applyTransition(id, event)
+	s.unlockState()
+
+	s.dispatch()
+
+	return err
+}
+
+// Must be called with the state locked
+func (s *State) applyTransition(id int, event Event) error {
+	element, ok := s.state[id]
+
+	if !ok {
+		return unknownIdError(id)
+	}
+
+	from := element.Status
+	to, allowed := nextStatus(from, event)
+
+	if !allowed {
+		return IllegalTransitionError{
+			Id:    id,
+			From:  from,
+			Event: event,
+		}
+	}
+
+	transition := newTransition(from, to, event)
+
+	if event == EVENT_VM_HALTED {
+		s.remove(id)
+	} else {
+		element.Status = to
+		element.appendHistory(transition)
+	}
+
+	s.record(id, event)
+	s.queueEvent(id, transition)
+
+	return nil
+}
+
+func newTransition(from, to byte, event Event) Transition {
+	return Transition{
+		From:  from,
+		To:    to,
+		Event: event,
+		Time:  time.Now(),
+	}
+}
+
+func (element *DataContainer) appendHistory(transition Transition) {
+	element.History = append(element.History, transition)
+
+	if len(element.History) > MAX_HISTORY_LENGTH {
+		element.History = element.History[len(element.History)-MAX_HISTORY_LENGTH:]
+	}
+}
+
+// GetHistory returns the transitions which led a VM to its current state
+func (s *State) GetHistory(id int) []Transition {
+	s.lockState()
+	defer s.unlockState()
+
+	element, ok := s.state[id]
+
+	if !ok {
+		return nil
+	}
+
+	res := make([]Transition, len(element.History))
+	copy(res, element.History)
+
+	return res
+}
@@ -0,0 +1,135 @@
+package state
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Meant to be run with go test -race
+func TestConcurrentTransitions(t *testing.T) {
+	s := NewState()
+	log := NewEventLog(64)
+
+	s.Subscribe(func(event TransitionEvent) {
+		log.Push(event)
+		s.GetStatus(event.Id)
+	})
+
+	workers := 8
+	lifecycles := 50
+
+	var wg sync.WaitGroup
+	stop := make(chan bool)
+
+	for worker := 0; worker < workers; worker++ {
+		wg.Add(1)
+
+		go func(worker int) {
+			defer wg.Done()
+
+			for i := 0; i < lifecycles; i++ {
+				id := worker*lifecycles + i + 1
+
+				assert.Nil(t, s.UpdateStateAddBootingVM(id, newTestVM(id)))
+				assert.Nil(t, s.UpdateStateVMBooted(id))
+				assert.Nil(t, s.UpdateStateAddIdleArena(id))
+				assert.Nil(t, s.UpdateVMMetadata(id, "gameid", strconv.Itoa(id)))
+				assert.Nil(t, s.UpdateStateTriedLaunchArena(id))
+				assert.Nil(t, s.UpdateStateConfirmedLaunchArena(id))
+				assert.Nil(t, s.UpdateStateStoppedArena(id))
+				assert.Nil(t, s.UpdateStateVMHalted(id))
+			}
+		}(worker)
+	}
+
+	// Every worker fights for the same VM, only one of them can launch it
+	assert.Nil(t, s.UpdateStateAddBootingVM(0, newTestVM(0)))
+	assert.Nil(t, s.UpdateStateAddIdleArena(0))
+
+	launched := make(chan bool, workers)
+
+	for worker := 0; worker < workers; worker++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			if s.UpdateStateTriedLaunchArena(0) == nil {
+				launched <- true
+			}
+		}()
+	}
+
+	readers := sync.WaitGroup{}
+	readers.Add(1)
+
+	go func() {
+		defer readers.Done()
+
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+
+			for id := 0; id < workers*lifecycles; id++ {
+				s.GetStatus(id)
+				s.QueryState(id, STATE_RUNNING_VM)
+				s.GetVM(id)
+				s.GetVMMetadata(id, "gameid")
+				s.GetHistory(id)
+			}
+
+			s.FindVM(STATE_IDLE_ARENA)
+			s.GetVMs()
+			s.Snapshot()
+			s.DebugGetStateDistribution()
+			s.Map(func(element *DataContainer) {})
+			log.Entries(0)
+		}
+	}()
+
+	wg.Wait()
+	close(stop)
+	readers.Wait()
+
+	assert.Len(t, launched, 1)
+	assert.Equal(t, STATUS_PENDING, s.GetStatus(0))
+	assert.Len(t, s.Snapshot(), 1)
+}
+
+// The snapshots are read unlocked while the metadata is updated
+func TestConcurrentVMMetadata(t *testing.T) {
+	s := NewState()
+
+	assert.Nil(t, s.UpdateStateAddBootingVM(1, newTestVM(1)))
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	go func() {
+		defer wg.Done()
+
+		for i := 0; i < 200; i++ {
+			assert.Nil(t, s.UpdateVMMetadata(1, "launchattempt", strconv.Itoa(i)))
+		}
+	}()
+
+	go func() {
+		defer wg.Done()
+
+		for i := 0; i < 200; i++ {
+			for k, v := range s.GetVM(1).Config.Metadata {
+				_, _ = k, v
+			}
+		}
+	}()
+
+	wg.Wait()
+
+	assert.Equal(t, "199", s.GetVM(1).Config.Metadata["launchattempt"])
+}
@@ -1,7 +1,12 @@
 package state
 
 import (
+	"errors"
+	"strconv"
 	"sync"
+	"time"
+
+	"github.com/bytearena/core/common/utils"
 )
 
 const (
@@ -17,14 +22,27 @@ const (
 
 type Data interface{}
 type DataContainer struct {
-	Data   Data
-	Status byte
+	Data    Data
+	Status  byte
+	History []Transition
 }
 
 type State struct {
 	mutex sync.Mutex
 
 	state map[int]*DataContainer
+
+	// Index of the ids by arena id
+	arenaIds map[string]int
+
+	journal  Journal
+	describe DescribeFunc
+
+	// Transitions waiting to be dispatched to the subscribers
+	pendingEvents []TransitionEvent
+
+	dispatchMutex sync.Mutex
+	subscribers   []Subscriber
 }
 
 func (s *State) DebugGetStateDistribution() map[string]int {
@@ -39,8 +57,8 @@ func (s *State) DebugGetStateDistribution() map[string]int {
 	res["STATE_PENDING_ARENA"] = 0
 	res["STATE_ERRORED_ARENA"] = 0
 
-	for k, _ := range s.state {
-		for _, status := range s.DebugGetStatus(k) {
+	for _, element := range s.Snapshot() {
+		for _, status := range flagToString(element.Status) {
 			res[status]++
 		}
 	}
@@ -49,6 +67,9 @@ func (s *State) DebugGetStateDistribution() map[string]int {
 }
 
 func (s *State) GetStatus(id int) byte {
+	s.lockState()
+	defer s.unlockState()
+
 	if state, hasInState := s.state[id]; hasInState {
 		return state.Status
 	} else {
@@ -57,6 +78,10 @@ func (s *State) GetStatus(id int) byte {
 }
 
 func (s *State) DebugFlagToString(bin byte) []string {
+	return flagToString(bin)
+}
+
+func flagToString(bin byte) []string {
 	res := make([]string, 0)
 
 	if bin&STATE_BOOTING_VM != 0 {
@@ -100,37 +125,52 @@ func (s *State) DebugGetStatus(id int) []string {
 
 func NewState() *State {
 	return &State{
-		state: make(map[int]*DataContainer),
+		state:    make(map[int]*DataContainer),
+		arenaIds: make(map[string]int),
 	}
 }
 
+// NewStateWithJournal creates a state which records each transition in the
+// given journal
+func NewStateWithJournal(journal Journal, describe DescribeFunc) *State {
+	s := NewState()
+	s.journal = journal
+	s.describe = describe
+
+	return s
+}
+
 func (s *State) QueryState(id int, flag byte) Data {
-	if data, ok := s.state[id]; ok {
-		if data.Status&flag != 0 && data.Status&STATE_ERRORED_ARENA == 0 && data.Status&STATE_ERRORED_VM == 0 {
-			return data.Data
-		} else {
-			return nil
-		}
+	s.lockState()
+	defer s.unlockState()
+
+	if element, ok := s.state[id]; ok && isQueryable(element, flag) {
+		return element.Data
 	}
 
 	return nil
 }
 
+// FindState returns any element with the flag, errored ones are skipped like
+// in QueryState
 func (s *State) FindState(flag byte) Data {
 	s.lockState()
+	defer s.unlockState()
 
-	for _, data := range s.state {
-
-		if data.Status&flag != 0 {
-			s.unlockState()
-			return data.Data
+	for _, element := range s.state {
+		if isQueryable(element, flag) {
+			return element.Data
 		}
 	}
 
-	s.unlockState()
 	return nil
 }
 
+// Must be called with the state locked
+func isQueryable(element *DataContainer, flag byte) bool {
+	return element.Status&flag != 0 && element.Status&STATE_ERRORED_ARENA == 0 && element.Status&STATE_ERRORED_VM == 0
+}
+
 func (s *State) lockState() {
 	s.mutex.Lock()
 }
@@ -139,6 +179,8 @@ func (s *State) unlockState() {
 	s.mutex.Unlock()
 }
 
+// Map calls fn with each element while the state is locked, fn must not
+// access the state
 func (s *State) Map(fn func(element *DataContainer)) {
 	s.lockState()
 
@@ -154,8 +196,68 @@ func (s *State) create(id int, data interface{}, status byte) {
 		Data:   data,
 		Status: status,
 	}
+
+	if arenaId, hasArenaId := getArenaId(data); hasArenaId {
+		s.arenaIds[arenaId] = id
+	}
+}
+
+// Must be called with the state locked
+func (s *State) add(id int, data interface{}, status byte, event Event) error {
+	if _, exists := s.state[id]; exists {
+		return errors.New("VM (" + strconv.Itoa(id) + ") already exists")
+	}
+
+	if arenaId, hasArenaId := getArenaId(data); hasArenaId {
+		if _, exists := s.arenaIds[arenaId]; exists {
+			return errors.New("Arena id " + arenaId + " is already used")
+		}
+	}
+
+	transition := newTransition(0, status, event)
+
+	s.create(id, data, status)
+	s.state[id].appendHistory(transition)
+	s.record(id, event)
+	s.queueEvent(id, transition)
+
+	return nil
 }
 
 func (s *State) remove(id int) {
+	if element, ok := s.state[id]; ok {
+		if arenaId, hasArenaId := getArenaId(element.Data); hasArenaId {
+			delete(s.arenaIds, arenaId)
+		}
+	}
+
 	delete(s.state, id)
 }
+
+// Must be called with the state locked so that the entries are appended in
+// the order of the transitions, the journal writes them asynchronously
+func (s *State) record(id int, event Event) {
+	if s.journal == nil {
+		return
+	}
+
+	entry := JournalEntry{
+		Id:    id,
+		Event: event,
+		Time:  time.Now(),
+	}
+
+	if element, ok := s.state[id]; ok {
+		entry.Status = element.Status
+
+		if s.describe != nil {
+			entry.Metadata = s.describe(element.Data)
+		}
+	} else {
+		entry.Removed = true
+	}
+
+	if err := s.journal.Append(entry); err != nil {
+		utils.RecoverableError("state", "Could not journal transition: "+err.Error())
+	}
+}
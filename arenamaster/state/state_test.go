@@ -2,6 +2,8 @@ package state
 
 import (
 	"testing"
+
+	"github.com/stretchr/testify/assert"
 )
 
 type testCase struct {
@@ -11,6 +13,52 @@ type testCase struct {
 	Mutations    func(s *State, id int)
 }
 
+type edge struct {
+	From  byte
+	Event Event
+}
+
+var mutationsByEvent = map[Event]func(s *State, id int) error{
+	EVENT_VM_BOOTED:              (*State).UpdateStateVMBooted,
+	EVENT_VM_ERRORED:             (*State).UpdateStateVMErrored,
+	EVENT_VM_HALTED:              (*State).UpdateStateVMHalted,
+	EVENT_ARENA_IDLE:             (*State).UpdateStateAddIdleArena,
+	EVENT_ARENA_TRIED_LAUNCH:     (*State).UpdateStateTriedLaunchArena,
+	EVENT_ARENA_CONFIRMED_LAUNCH: (*State).UpdateStateConfirmedLaunchArena,
+	EVENT_ARENA_STOPPED:          (*State).UpdateStateStoppedArena,
+	EVENT_ARENA_ERRORED:          (*State).UpdateStateErroredArena,
+}
+
+// Every legal edge of the state machine, halting is legal from every state
+// and removes the VM
+var legalEdges = map[edge]byte{
+	{STATUS_BOOTING, EVENT_VM_BOOTED}:  STATUS_BOOTED,
+	{STATUS_BOOTING, EVENT_ARENA_IDLE}: STATUS_IDLE,
+	{STATUS_BOOTING, EVENT_VM_ERRORED}: STATUS_BOOTING | STATE_ERRORED_VM,
+
+	{STATUS_BOOTED, EVENT_ARENA_IDLE}: STATUS_IDLE,
+	{STATUS_BOOTED, EVENT_VM_ERRORED}: STATUS_BOOTED | STATE_ERRORED_VM,
+
+	{STATUS_IDLE, EVENT_ARENA_TRIED_LAUNCH}: STATUS_PENDING,
+	{STATUS_IDLE, EVENT_ARENA_STOPPED}:      STATUS_BOOTED,
+	{STATUS_IDLE, EVENT_VM_ERRORED}:         STATUS_IDLE | STATE_ERRORED_VM,
+
+	{STATUS_PENDING, EVENT_ARENA_CONFIRMED_LAUNCH}: STATUS_RUNNING,
+	{STATUS_PENDING, EVENT_ARENA_STOPPED}:          STATUS_BOOTED,
+	{STATUS_PENDING, EVENT_VM_ERRORED}:             STATUS_PENDING | STATE_ERRORED_VM,
+	{STATUS_PENDING, EVENT_ARENA_ERRORED}:          STATUS_FAILED_LAUNCH,
+
+	{STATUS_FAILED_LAUNCH, EVENT_VM_ERRORED}: STATUS_FAILED_LAUNCH | STATE_ERRORED_VM,
+
+	{STATUS_RUNNING, EVENT_ARENA_STOPPED}: STATUS_BOOTED,
+	{STATUS_RUNNING, EVENT_VM_ERRORED}:    STATUS_RUNNING | STATE_ERRORED_VM,
+
+	{STATUS_IDLE | STATE_ERRORED_VM, EVENT_ARENA_STOPPED}:    STATUS_BOOTED | STATE_ERRORED_VM,
+	{STATUS_PENDING | STATE_ERRORED_VM, EVENT_ARENA_STOPPED}: STATUS_BOOTED | STATE_ERRORED_VM,
+	{STATUS_RUNNING | STATE_ERRORED_VM, EVENT_ARENA_STOPPED}: STATUS_BOOTED | STATE_ERRORED_VM,
+	{STATUS_PENDING | STATE_ERRORED_VM, EVENT_ARENA_ERRORED}: STATUS_FAILED_LAUNCH | STATE_ERRORED_VM,
+}
+
 func TestQueryState(t *testing.T) {
 	data := new(struct{})
 	state := NewState()
@@ -32,10 +80,10 @@ func TestQueryStateNotErrored(t *testing.T) {
 
 	state.UpdateStateAddBootingVM(id, data)
 
-	updated := state.UpdateStateVMBooted(id)
-	updated2 := state.UpdateStateVMErrored(id)
+	err := state.UpdateStateVMBooted(id)
+	err2 := state.UpdateStateVMErrored(id)
 
-	if updated == false || updated2 == false {
+	if err != nil || err2 != nil {
 		panic("State should have been updated")
 	}
 
@@ -45,3 +93,94 @@ func TestQueryStateNotErrored(t *testing.T) {
 		panic("Should not return data")
 	}
 }
+
+func TestEveryTransition(t *testing.T) {
+	id := 1
+
+	for _, declared := range declaredStates {
+		for _, from := range []byte{declared, declared | STATE_ERRORED_VM} {
+			for event, mutation := range mutationsByEvent {
+				s := NewState()
+				s.create(id, nil, from)
+
+				err := mutation(s, id)
+				to, isLegal := legalEdges[edge{from, event}]
+
+				name := string(event) + " from " + s.DebugFlagToString(from)[0]
+
+				if event == EVENT_VM_HALTED {
+					assert.Nil(t, err, name)
+					assert.Nil(t, s.QueryState(id, 0xff), name)
+
+					continue
+				}
+
+				if isLegal {
+					assert.Nil(t, err, name)
+					assert.Equal(t, to, s.GetStatus(id), name)
+					assert.Len(t, s.GetHistory(id), 1, name)
+				} else {
+					assert.IsType(t, IllegalTransitionError{}, err, name)
+					assert.Equal(t, from, s.GetStatus(id), name)
+					assert.Len(t, s.GetHistory(id), 0, name)
+				}
+			}
+		}
+	}
+}
+
+func TestUnknownVM(t *testing.T) {
+	s := NewState()
+
+	for event, mutation := range mutationsByEvent {
+		err := mutation(s, 1)
+
+		assert.Error(t, err, string(event))
+		assert.Equal(t, "VM (1) does not exist", err.Error())
+	}
+}
+
+func TestTransitionHistory(t *testing.T) {
+	s := NewState()
+	id := 1
+
+	assert.Nil(t, s.UpdateStateAddBootingVM(id, nil))
+	assert.Nil(t, s.UpdateStateVMBooted(id))
+	assert.Nil(t, s.UpdateStateAddIdleArena(id))
+	assert.Error(t, s.UpdateStateConfirmedLaunchArena(id))
+	assert.Nil(t, s.UpdateStateTriedLaunchArena(id))
+
+	history := s.GetHistory(id)
+	assert.Len(t, history, 4)
+
+	expected := []Transition{
+		{From: 0, To: STATUS_BOOTING, Event: EVENT_VM_ADDED},
+		{From: STATUS_BOOTING, To: STATUS_BOOTED, Event: EVENT_VM_BOOTED},
+		{From: STATUS_BOOTED, To: STATUS_IDLE, Event: EVENT_ARENA_IDLE},
+		{From: STATUS_IDLE, To: STATUS_PENDING, Event: EVENT_ARENA_TRIED_LAUNCH},
+	}
+
+	for i, transition := range history {
+		assert.Equal(t, expected[i].From, transition.From)
+		assert.Equal(t, expected[i].To, transition.To)
+		assert.Equal(t, expected[i].Event, transition.Event)
+		assert.False(t, transition.Time.IsZero())
+	}
+
+	assert.Nil(t, s.UpdateStateVMHalted(id))
+	assert.Nil(t, s.GetHistory(id))
+}
+
+func TestTransitionHistoryIsBounded(t *testing.T) {
+	s := NewState()
+	id := 1
+
+	s.create(id, nil, STATUS_BOOTED)
+
+	for i := 0; i < MAX_HISTORY_LENGTH; i++ {
+		assert.Nil(t, s.UpdateStateAddIdleArena(id))
+		assert.Nil(t, s.UpdateStateStoppedArena(id))
+	}
+
+	assert.Len(t, s.GetHistory(id), MAX_HISTORY_LENGTH)
+}
@@ -1,55 +1,42 @@
 package state
 
-func (s *State) UpdateStateAddBootingVM(id int, data interface{}) (stateUpdated bool) {
+func (s *State) UpdateStateAddBootingVM(id int, data interface{}) error {
 	s.lockState()
-	s.create(id, data, STATE_BOOTING_VM)
+	err := s.add(id, data, STATUS_BOOTING, EVENT_VM_ADDED)
 	s.unlockState()
 
-	stateUpdated = true
+	s.dispatch()
 
-	return stateUpdated
+	return err
 }
 
-func (s *State) UpdateStateVMErrored(id int) (stateUpdated bool) {
-	s.lockState()
-
-	if state, ok := s.state[id]; ok {
-		state.Status |= STATE_ERRORED_VM
-		stateUpdated = true
+// Re-adopts a VM recorded by a previous arena-master process
+func (s *State) UpdateStateAddRecoveredVM(id int, data interface{}, status byte) error {
+	if !isDeclaredState(status) {
+		return IllegalTransitionError{
+			Id:    id,
+			From:  status,
+			Event: EVENT_VM_RECOVERED,
+		}
 	}
 
-	s.unlockState()
-
-	return stateUpdated
-}
-
-func (s *State) UpdateStateVMHalted(id int) (stateUpdated bool) {
 	s.lockState()
-
-	if _, ok := s.state[id]; ok {
-		s.remove(id)
-
-		stateUpdated = true
-	}
-
+	err := s.add(id, data, status, EVENT_VM_RECOVERED)
 	s.unlockState()
 
-	return stateUpdated
-}
-
-func (s *State) UpdateStateVMBooted(id int) (stateUpdated bool) {
-	stateUpdated = false
+	s.dispatch()
 
-	s.lockState()
-
-	if state, ok := s.state[id]; ok {
-		state.Status ^= STATE_BOOTING_VM
-		state.Status |= STATE_RUNNING_VM
+	return err
+}
 
-		stateUpdated = true
-	}
+func (s *State) UpdateStateVMErrored(id int) error {
+	return s.transition(id, EVENT_VM_ERRORED)
+}
 
-	s.unlockState()
+func (s *State) UpdateStateVMHalted(id int) error {
+	return s.transition(id, EVENT_VM_HALTED)
+}
 
-	return stateUpdated
+func (s *State) UpdateStateVMBooted(id int) error {
+	return s.transition(id, EVENT_VM_BOOTED)
 }
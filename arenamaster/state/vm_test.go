@@ -12,39 +12,76 @@ func TestVMState(t *testing.T) {
 
 	examples := []testCase{
 		{
-			Name: "Should add a booting VM",
+			Name: "Should add an errored VM while booting",
 
+			InitialState: STATUS_BOOTING,
 			Mutations: func(s *State, id int) {
-				assert.True(t, s.UpdateStateAddBootingVM(id, data))
+				assert.Nil(t, s.UpdateStateVMErrored(id))
 			},
-			ResultState: STATE_BOOTING_VM,
+			ResultState: STATE_BOOTING_VM | STATE_ERRORED_VM,
 		},
 		{
-			Name: "Should add a errored VM while booting",
+			Name: "Should add an errored VM while running an arena",
 
-			InitialState: STATE_BOOTING_VM,
+			InitialState: STATUS_RUNNING,
 			Mutations: func(s *State, id int) {
-				assert.True(t, s.UpdateStateVMErrored(id))
+				assert.Nil(t, s.UpdateStateVMErrored(id))
 			},
-			ResultState: STATE_BOOTING_VM | STATE_ERRORED_VM,
+			ResultState: STATE_RUNNING_VM | STATE_RUNNING_ARENA | STATE_ERRORED_VM,
+		},
+		{
+			Name: "Should refuse to error an errored VM",
+
+			InitialState: STATUS_BOOTED | STATE_ERRORED_VM,
+			Mutations: func(s *State, id int) {
+				assert.Error(t, s.UpdateStateVMErrored(id))
+			},
+			ResultState: STATE_RUNNING_VM | STATE_ERRORED_VM,
 		},
 		{
 			Name: "Should remove from state a halted VM",
 
-			InitialState: STATE_BOOTING_VM,
+			InitialState: STATUS_BOOTING,
+			Mutations: func(s *State, id int) {
+				assert.Nil(t, s.UpdateStateVMHalted(id))
+			},
+		},
+		{
+			Name: "Should remove from state a halted errored VM",
+
+			InitialState: STATUS_RUNNING | STATE_ERRORED_VM,
 			Mutations: func(s *State, id int) {
-				assert.True(t, s.UpdateStateVMHalted(id))
+				assert.Nil(t, s.UpdateStateVMHalted(id))
 			},
 		},
 		{
 			Name: "Should add a booted VM",
 
-			InitialState: STATE_BOOTING_VM,
+			InitialState: STATUS_BOOTING,
+			Mutations: func(s *State, id int) {
+				assert.Nil(t, s.UpdateStateVMBooted(id))
+			},
+			ResultState: STATE_RUNNING_VM,
+		},
+		{
+			Name: "Should refuse to boot a VM twice",
+
+			InitialState: STATUS_BOOTING,
 			Mutations: func(s *State, id int) {
-				assert.True(t, s.UpdateStateVMBooted(id))
+				assert.Nil(t, s.UpdateStateVMBooted(id))
+				assert.Error(t, s.UpdateStateVMBooted(id))
 			},
 			ResultState: STATE_RUNNING_VM,
 		},
+		{
+			Name: "Should refuse to boot an errored VM",
+
+			InitialState: STATUS_BOOTING | STATE_ERRORED_VM,
+			Mutations: func(s *State, id int) {
+				assert.Error(t, s.UpdateStateVMBooted(id))
+			},
+			ResultState: STATE_BOOTING_VM | STATE_ERRORED_VM,
+		},
 	}
 
 	for _, example := range examples {
@@ -62,3 +99,22 @@ func TestVMState(t *testing.T) {
 		})
 	}
 }
+
+func TestAddBootingVM(t *testing.T) {
+	s := NewState()
+
+	assert.Nil(t, s.UpdateStateAddBootingVM(1, nil))
+	assert.Equal(t, STATUS_BOOTING, s.GetStatus(1))
+
+	assert.Error(t, s.UpdateStateAddBootingVM(1, nil))
+}
+
+func TestAddRecoveredVM(t *testing.T) {
+	s := NewState()
+
+	assert.Nil(t, s.UpdateStateAddRecoveredVM(1, nil, STATUS_RUNNING))
+	assert.Equal(t, STATUS_RUNNING, s.GetStatus(1))
+
+	assert.Error(t, s.UpdateStateAddRecoveredVM(2, nil, STATE_IDLE_ARENA))
+	assert.Equal(t, byte(0), s.GetStatus(2))
+}
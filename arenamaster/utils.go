@@ -1,25 +1,55 @@
 package arenamaster
 
 import (
+	"fmt"
+
 	"github.com/bytearena/schnapps"
 	vmid "github.com/bytearena/schnapps/id"
+	vmtypes "github.com/bytearena/schnapps/types"
+
+	uuid "github.com/satori/go.uuid"
 
 	"github.com/bytearena/backends/arenamaster/state"
+
+	"github.com/bytearena/core/common/utils"
 )
 
-func FindVMByMAC(s *state.State, searchMac string) *vm.VM {
-	var res *vm.VM
+func newArenaId() string {
+	return uuid.NewV4().String()
+}
+
+// GetArenaId returns the id the arena-server of the VM uses on the MQ
+func GetArenaId(arena *state.VMSnapshot) (string, bool) {
+	return arenaIdOf(arena.Config.Metadata)
+}
 
-	s.Map(func(element *state.DataContainer) {
-		if vm, isVm := element.Data.(*vm.VM); isVm {
-			mac, found := vmid.GetVMMAC(vm)
+func arenaIdOf(metadata vmtypes.VMMetadata) (string, bool) {
+	arenaId, hasArenaId := metadata[state.ARENA_ID_METADATA]
 
-			if searchMac == mac && found {
-				res = vm
-				return
-			}
+	if !hasArenaId {
+		return "", false
+	}
+
+	return fmt.Sprint(arenaId), true
+}
+
+// The metadata server identifies the VMs by MAC. It reads the VM without the
+// state lock, it's given a copy.
+func FindVMByMAC(s *state.State, searchMac string) *vm.VM {
+	for _, arena := range s.GetVMs() {
+		arenaVM := &vm.VM{Config: arena.Config}
+
+		if mac, found := vmid.GetVMMAC(arenaVM); found && searchMac == mac {
+			return arenaVM
 		}
-	})
+	}
+
+	return nil
+}
 
-	return res
+// Reports transitions refused by the state machine
+func checkTransition(err error) {
+	if err != nil {
+		utils.RecoverableError("state", err.Error())
+	}
 }
@@ -1,21 +1,28 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
+	"strconv"
+	"time"
 
 	"github.com/abiosoft/ishell"
 
-	bamq "github.com/bytearena/core/common/mq"
 	"github.com/bytearena/backends/common/mq"
 
-	"github.com/bytearena/core/common/types"
 	"github.com/bytearena/core/common/utils"
 )
 
+const (
+	COMPONENT       = "arena-master-cli"
+	REQUEST_TIMEOUT = 10 * time.Second
+)
+
 type Session struct {
-	mqClient *mq.Client
+	mqClient mq.Broker
+	rpc      *mq.RPC
 }
 
 func main() {
@@ -27,25 +34,33 @@ func main() {
 
 	shell := ishell.New()
 
-	mqClient, err := mq.NewClient(*mqHost)
+	mqClient, err := mq.NewBroker(*mqHost, COMPONENT)
 	utils.Check(err, "ERROR: could not connect to messagebroker at "+string(*mqHost))
 
+	utils.Check(mqClient.EnableSigningFromEnv(COMPONENT), "Invalid MQ keys")
+
+	rpc, err := mq.NewRPC(mqClient, COMPONENT)
+	utils.Check(err, "ERROR: could not subscribe to the replies")
+
 	session := Session{
 		mqClient: mqClient,
+		rpc:      rpc,
 	}
 
-	session.mqClient.Subscribe("debug", "getvmstatus-res", func(msg bamq.BrokerMessage) {
-		var dat map[string]interface{}
-
-		if err := json.Unmarshal(msg.Data, &dat); err != nil {
-			panic(err)
-		}
-
-		b, err := json.MarshalIndent(dat, "", "  ")
-		utils.Check(err, "Could not prettify JSON")
+	subscriptions := [][2]string{
+		{"arenamaster", "drain-status"},
+		{"arenamaster", "drain-error"},
+		{"arena", "halt-error"},
+		{"game", "launch-error"},
+		{"game", "launched-error"},
+		{"game", "handshake-error"},
+		{"game", "stopped-error"},
+	}
 
-		fmt.Println(string(b))
-	})
+	for _, subscription := range subscriptions {
+		err := mq.SubscribeMessages(session.mqClient, subscription[0], subscription[1], printMessage)
+		utils.Check(err, "ERROR: could not subscribe to "+subscription[0]+":"+subscription[1])
+	}
 
 	shell.Println("arena-master cli")
 
@@ -55,6 +70,24 @@ func main() {
 		Func: session.handleDebugGetVmStatus,
 	})
 
+	shell.AddCmd(&ishell.Cmd{
+		Name: "debug/GetStateEvents",
+		Help: "Get the last state transitions",
+		Func: session.handleDebugGetStateEvents,
+	})
+
+	shell.AddCmd(&ishell.Cmd{
+		Name: "debug/GetLaunchQueue",
+		Help: "Get the games waiting for an arena",
+		Func: session.handleDebugGetLaunchQueue,
+	})
+
+	shell.AddCmd(&ishell.Cmd{
+		Name: "master/drain",
+		Help: "Stop launching games and stop arena-master once they are over",
+		Func: session.handleMasterDrainCommand,
+	})
+
 	shell.AddCmd(&ishell.Cmd{
 		Name: "arena/add",
 		Help: "Add arena VM",
@@ -73,12 +106,6 @@ func main() {
 		Func: session.handleStartGameCommand,
 	})
 
-	shell.AddCmd(&ishell.Cmd{
-		Name: "game/stop",
-		Help: "Fake stop game",
-		Func: session.handleStopGameCommand,
-	})
-
 	shell.AddCmd(&ishell.Cmd{
 		Name: "arena/game/start",
 		Help: "Start game on a given arena",
@@ -88,8 +115,28 @@ func main() {
 	shell.Run()
 }
 
+func printMessage(message mq.Message) {
+	b, err := json.MarshalIndent(message, "", "  ")
+	utils.Check(err, "Could not prettify JSON")
+
+	fmt.Println(string(b))
+}
+
+func printJSON(data json.RawMessage) {
+	var dat map[string]interface{}
+
+	if err := json.Unmarshal(data, &dat); err != nil {
+		panic(err)
+	}
+
+	b, err := json.MarshalIndent(dat, "", "  ")
+	utils.Check(err, "Could not prettify JSON")
+
+	fmt.Println(string(b))
+}
+
 func (s Session) handleArenaAddCommand(c *ishell.Context) {
-	err := s.mqClient.Publish("arena", "add", types.MQPayload{})
+	err := s.publish("arena", "add", &mq.ArenaAdd{})
 
 	if err != nil {
 		c.Println("MQ error: " + err.Error())
@@ -102,12 +149,9 @@ func (s Session) handleArenaHaltCommand(c *ishell.Context) {
 	c.Print("VM ID: ")
 	vmId := c.ReadLine()
 
-	err := s.mqClient.Publish("arena", "halt", types.NewMQMessage(
-		"arena-master",
-		"halt",
-	).SetPayload(types.MQPayload{
-		"id": vmId,
-	}))
+	err := s.publish("arena", "halt", &mq.ArenaHalt{
+		Id: vmId,
+	})
 
 	if err != nil {
 		c.Println("MQ error: " + err.Error())
@@ -123,8 +167,8 @@ func (s Session) handleStartGameOnArenaCommand(c *ishell.Context) {
 	c.Print("Arena ID: ")
 	arenaId := c.ReadLine()
 
-	err := s.mqClient.Publish("game", arenaId+".launch", types.MQPayload{
-		"id": gameId,
+	err := s.publish("game", arenaId+".launch", &mq.ArenaLaunch{
+		Id: gameId,
 	})
 
 	if err != nil {
@@ -138,12 +182,30 @@ func (s Session) handleStartGameCommand(c *ishell.Context) {
 	c.Print("Game ID: ")
 	gameId := c.ReadLine()
 
-	err := s.mqClient.Publish("game", "launch", types.NewMQMessage(
-		"arena-master",
-		"launch",
-	).SetPayload(types.MQPayload{
-		"id": gameId,
-	}))
+	c.Print("Priority (empty for normal): ")
+	rawPriority := c.ReadLine()
+
+	c.Print("Profile (empty to pick by contestants): ")
+	profile := c.ReadLine()
+
+	priority := mq.OptionalInt{}
+
+	if rawPriority != "" {
+		value, err := strconv.Atoi(rawPriority)
+
+		if err != nil {
+			c.Println("Invalid priority " + rawPriority)
+			return
+		}
+
+		priority = mq.OptionalInt{Value: value, IsSet: true}
+	}
+
+	err := s.publish("game", "launch", &mq.GameLaunch{
+		Id:       gameId,
+		Priority: priority,
+		Profile:  profile,
+	})
 
 	if err != nil {
 		c.Println("MQ error: " + err.Error())
@@ -152,33 +214,48 @@ func (s Session) handleStartGameCommand(c *ishell.Context) {
 	}
 }
 
-func (s Session) handleStopGameCommand(c *ishell.Context) {
-	c.Print("Game ID: ")
-	gameId := c.ReadLine()
+func (s Session) handleDebugGetVmStatus(c *ishell.Context) {
+	res, err := s.request("debug", "getvmstatus", &mq.DebugRequest{})
+
+	if err != nil {
+		c.Println("MQ error: " + err.Error())
+	} else {
+		printJSON(res)
+	}
+}
 
-	c.Print("Arena MAC: ")
-	arenaserveruuid := c.ReadLine()
+func (s Session) handleDebugGetStateEvents(c *ishell.Context) {
+	c.Print("VM ID (empty for all VMs): ")
+	vmId := c.ReadLine()
 
-	err := s.mqClient.Publish("game", "stopped", types.NewMQMessage(
-		"arena-master",
-		"launch",
-	).SetPayload(types.MQPayload{
-		"id":              gameId,
-		"arenaserveruuid": arenaserveruuid,
-	}))
+	res, err := s.request("debug", "getstateevents", &mq.DebugRequest{
+		Id: vmId,
+	})
 
 	if err != nil {
 		c.Println("MQ error: " + err.Error())
 	} else {
-		c.Println("OK")
+		printJSON(res)
 	}
 }
 
-func (s Session) handleDebugGetVmStatus(c *ishell.Context) {
-	err := s.mqClient.Publish("debug", "getvmstatus", types.NewMQMessage(
-		"arena-master",
-		"debug",
-	))
+func (s Session) handleDebugGetLaunchQueue(c *ishell.Context) {
+	res, err := s.request("debug", "getlaunchqueue", &mq.DebugRequest{})
+
+	if err != nil {
+		c.Println("MQ error: " + err.Error())
+	} else {
+		printJSON(res)
+	}
+}
+
+func (s Session) handleMasterDrainCommand(c *ishell.Context) {
+	c.Print("Deadline (empty for the default, e.g. 30m): ")
+	deadline := c.ReadLine()
+
+	err := s.publish("arenamaster", "drain", &mq.MasterDrain{
+		Deadline: deadline,
+	})
 
 	if err != nil {
 		c.Println("MQ error: " + err.Error())
@@ -186,3 +263,20 @@ func (s Session) handleDebugGetVmStatus(c *ishell.Context) {
 		c.Println("OK")
 	}
 }
+
+func (s Session) publish(channel, topic string, message mq.Message) error {
+	return mq.PublishMessage(s.mqClient, COMPONENT, channel, topic, message)
+}
+
+func (s Session) request(channel, topic string, message mq.Message) (json.RawMessage, error) {
+	payload, err := mq.EncodeMessage(COMPONENT, channel, topic, message)
+
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), REQUEST_TIMEOUT)
+	defer cancel()
+
+	return s.rpc.Request(ctx, channel, topic, payload)
+}
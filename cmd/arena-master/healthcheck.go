@@ -1,16 +1,17 @@
 package main
 
 import (
+	"github.com/bytearena/backends/arenamaster"
 	"github.com/bytearena/backends/common/graphql"
 	"github.com/bytearena/backends/common/healthcheck"
 	"github.com/bytearena/backends/common/mq"
 )
 
-func NewHealthCheck(brokerclient *mq.Client, graphqlclient *graphql.Client) *healthcheck.HealthCheckServer {
+func NewHealthCheck(brokerclient mq.Broker, graphqlclient *graphql.Client, server *arenamaster.Server) *healthcheck.HealthCheckServer {
 	healthCheckServer := healthcheck.NewHealthCheckServer()
 
 	healthCheckServer.Register("mq", func() error {
-		pingErr := brokerclient.Ping()
+		pingErr := brokerclient.Health()
 
 		if pingErr != nil {
 			return pingErr
@@ -29,5 +30,10 @@ func NewHealthCheck(brokerclient *mq.Client, graphqlclient *graphql.Client) *hea
 		}
 	})
 
+	// Polled by the deploy tooling while draining
+	healthCheckServer.RegisterWithDetail("drain", func() (string, error) {
+		return server.GetDrainStatus().String(), nil
+	})
+
 	return healthCheckServer
 }
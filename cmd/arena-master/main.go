@@ -2,8 +2,13 @@ package main
 
 import (
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/bytearena/backends/arenamaster"
+	"github.com/bytearena/backends/arenamaster/autoscaler"
+	"github.com/bytearena/backends/arenamaster/federation"
 	"github.com/bytearena/backends/common/graphql"
 	"github.com/bytearena/backends/common/healthcheck"
 	"github.com/bytearena/backends/common/mq"
@@ -20,23 +25,171 @@ var (
 	vmBridgeName       = utils.GetenvOrDefault("VM_BRIDGE_NAME", "brtest")
 	vmBridgeIP         = utils.GetenvOrDefault("VM_BRIDGE_IP", "172.19.0.1")
 	vmSubnet           = utils.GetenvOrDefault("VM_SUBNET", "172.19.0.10/24")
+
+	// Empty to keep the state in memory only
+	stateJournalLocation = utils.GetenvOrDefault("STATE_JOURNAL_LOCATION", "/var/lib/arena-master/state.journal")
+	launchQueueLocation  = utils.GetenvOrDefault("LAUNCH_QUEUE_LOCATION", "/var/lib/arena-master/launch-queue.json")
+
+	// Keeps the builtin profiles when empty, see arenamaster.ParseResourceProfiles
+	resourceProfiles = os.Getenv("RESOURCE_PROFILES")
+
+	poolMinVMs         = utils.GetenvOrDefault("POOL_MIN_VMS", "3")
+	poolMaxVMs         = utils.GetenvOrDefault("POOL_MAX_VMS", "10")
+	poolIdleHeadroom   = utils.GetenvOrDefault("POOL_IDLE_HEADROOM", "1")
+	poolScaleDownDelay = utils.GetenvOrDefault("POOL_SCALE_DOWN_DELAY", "5m")
+
+	launchDeadline    = utils.GetenvOrDefault("LAUNCH_DEADLINE", "1m")
+	launchMaxAttempts = utils.GetenvOrDefault("LAUNCH_MAX_ATTEMPTS", "3")
+	drainDeadline     = utils.GetenvOrDefault("DRAIN_DEADLINE", "30m")
+
+	// "schnapps" runs the arenas in VMs, "local" as arena-server processes and
+	// "remote" places them on arena-worker agents
+	arenaBackend            = utils.GetenvOrDefault("ARENA_BACKEND", "schnapps")
+	arenaServerBinary       = utils.GetenvOrDefault("ARENA_SERVER_BINARY", "arena-server")
+	arenaServerRegistryAddr = utils.GetenvOrDefault("ARENA_SERVER_REGISTRY_ADDR", "127.0.0.1:5000")
+	arenaServerAddr         = utils.GetenvOrDefault("ARENA_SERVER_ADDR", "127.0.0.1")
+	arenaServerGameTimeout  = utils.GetenvOrDefault("ARENA_SERVER_GAME_TIMEOUT", "60")
+	arenaServerLogDir       = os.Getenv("ARENA_SERVER_LOG_DIR")
+
+	// Master key of the MQ keys of the arenas, see mq.SigningConfigFromEnv
+	// for the keys of arena-master itself
+	arenaServerKey = os.Getenv("ARENA_SERVER_KEY")
+
+	// GraphQL token of the arenas, see graphql.AuthenticatorFromEnv for the
+	// one of arena-master itself
+	arenaServerAPIToken = os.Getenv("ARENA_SERVER_API_TOKEN")
 )
 
+func configureBackend(server *arenamaster.Server, brokerclient mq.Broker) {
+	switch arenaBackend {
+	case "schnapps":
+		return
+
+	case "local":
+		gameTimeout, err := strconv.Atoi(arenaServerGameTimeout)
+		utils.Check(err, "ARENA_SERVER_GAME_TIMEOUT must be a number")
+
+		server.UseBackend(arenamaster.NewLocalBackend(arenamaster.LocalBackendConfig{
+			Binary:       arenaServerBinary,
+			MQHost:       mqHost,
+			APIUrl:       apiUrl,
+			RegistryAddr: arenaServerRegistryAddr,
+			ArenaAddr:    arenaServerAddr,
+			GameTimeout:  gameTimeout,
+			LogDir:       arenaServerLogDir,
+		}))
+
+	case "remote":
+		server.UseBackend(arenamaster.NewRemoteBackend(federation.NewCoordinator(brokerclient)))
+
+	default:
+		utils.Assert(false, "ARENA_BACKEND must be schnapps, local or remote")
+	}
+}
+
+func configureDeadlines() {
+	deadline, err := time.ParseDuration(launchDeadline)
+	utils.Check(err, "LAUNCH_DEADLINE must be a duration")
+
+	maxAttempts, err := strconv.Atoi(launchMaxAttempts)
+	utils.Check(err, "LAUNCH_MAX_ATTEMPTS must be a number")
+
+	utils.Assert(maxAttempts > 0, "LAUNCH_MAX_ATTEMPTS must be positive")
+
+	drain, err := time.ParseDuration(drainDeadline)
+	utils.Check(err, "DRAIN_DEADLINE must be a duration")
+
+	arenamaster.LAUNCH_DEADLINE = deadline
+	arenamaster.LAUNCH_MAX_ATTEMPTS = maxAttempts
+	arenamaster.DRAIN_DEADLINE = drain
+}
+
+func configureProfiles() {
+	if resourceProfiles == "" {
+		return
+	}
+
+	profiles, err := arenamaster.ParseResourceProfiles(resourceProfiles)
+	utils.Check(err, "Invalid RESOURCE_PROFILES")
+
+	arenamaster.RESOURCE_PROFILES = profiles
+}
+
+// The pool of the default profile is configured by POOL_*, the other ones by
+// POOL_<PROFILE>_* and are only spawned on demand unless configured otherwise
+func getPoolPolicy(profile string) autoscaler.Policy {
+	prefix := "POOL_"
+	minVMs, maxVMs, idleHeadroom := poolMinVMs, poolMaxVMs, poolIdleHeadroom
+
+	if profile != arenamaster.DEFAULT_PROFILE {
+		prefix = "POOL_" + strings.ToUpper(profile) + "_"
+		minVMs = utils.GetenvOrDefault(prefix+"MIN_VMS", "0")
+		maxVMs = utils.GetenvOrDefault(prefix+"MAX_VMS", poolMaxVMs)
+		idleHeadroom = utils.GetenvOrDefault(prefix+"IDLE_HEADROOM", "0")
+	}
+
+	min, err := strconv.Atoi(minVMs)
+	utils.Check(err, prefix+"MIN_VMS must be a number")
+
+	max, err := strconv.Atoi(maxVMs)
+	utils.Check(err, prefix+"MAX_VMS must be a number")
+
+	headroom, err := strconv.Atoi(idleHeadroom)
+	utils.Check(err, prefix+"IDLE_HEADROOM must be a number")
+
+	scaleDownDelay, err := time.ParseDuration(poolScaleDownDelay)
+	utils.Check(err, "POOL_SCALE_DOWN_DELAY must be a duration")
+
+	utils.Assert(min <= max, prefix+"MIN_VMS must not exceed "+prefix+"MAX_VMS")
+
+	return &autoscaler.ElasticPolicy{
+		MinVMs:         min,
+		MaxVMs:         max,
+		IdleHeadroom:   headroom,
+		ScaleDownDelay: scaleDownDelay,
+	}
+}
+
+func getPoolPolicies() map[string]autoscaler.Policy {
+	policies := make(map[string]autoscaler.Policy)
+
+	for _, profile := range arenamaster.GetProfileNames() {
+		policies[profile] = getPoolPolicy(profile)
+	}
+
+	return policies
+}
+
 func main() {
 	utils.Assert(mqHost != "", "MQ must be set")
 	utils.Assert(apiUrl != "", "APIURL must be set")
 
-	brokerclient, err := mq.NewClient(mqHost)
+	brokerclient, err := mq.NewBroker(mqHost, arenamaster.MASTER_COMPONENT)
 	utils.Check(err, "ERROR: could not connect to messagebroker at "+string(mqHost))
 
-	graphqlclient := graphql.NewClient(apiUrl)
+	utils.Check(brokerclient.EnableSigningFromEnv(arenamaster.MASTER_COMPONENT), "Invalid MQ keys")
+
+	if arenaServerKey != "" {
+		arenamaster.ARENA_SERVER_KEY = []byte(arenaServerKey)
+	}
+
+	arenamaster.ARENA_API_TOKEN = arenaServerAPIToken
+
+	graphqlauth, err := graphql.AuthenticatorFromEnv()
+	utils.Check(err, "Invalid GraphQL credentials")
+
+	graphqlclient := graphql.NewClient(apiUrl).SetAuthenticator(graphqlauth)
+
+	configureDeadlines()
+	configureProfiles()
 
-	server := arenamaster.NewServer(brokerclient, graphqlclient, vmRawImageLocation, vmBridgeName, vmBridgeIP, vmSubnet)
+	server := arenamaster.NewServer(brokerclient, graphqlclient, vmRawImageLocation, vmBridgeName, vmBridgeIP, vmSubnet, stateJournalLocation, launchQueueLocation, getPoolPolicies())
+	configureBackend(server, brokerclient)
 
 	// handling signals
 	var hc *healthcheck.HealthCheckServer
 	if env == "prod" {
-		hc = NewHealthCheck(brokerclient, graphqlclient)
+		hc = NewHealthCheck(brokerclient, graphqlclient, server)
 		hc.Start()
 	}
 
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"encoding/json"
 	"flag"
 	"math/rand"
 	"os"
@@ -12,21 +11,17 @@ import (
 	"github.com/bytearena/backends/common/container"
 	"github.com/bytearena/backends/common/graphql"
 	apiqueries "github.com/bytearena/backends/common/graphql/queries"
+	"github.com/bytearena/backends/common/maps"
 	"github.com/bytearena/backends/common/mq"
 
 	"github.com/bytearena/core/arenaserver"
 	"github.com/bytearena/core/common"
-	coremq "github.com/bytearena/core/common/mq"
 	"github.com/bytearena/core/common/types"
 	arenaservertypes "github.com/bytearena/core/common/types"
 	"github.com/bytearena/core/common/utils"
 	"github.com/bytearena/core/game/deathmatch"
 )
 
-type messageArenaLaunch struct {
-	Id string `json:"id"`
-}
-
 func main() {
 	rand.Seed(time.Now().UnixNano())
 
@@ -37,22 +32,72 @@ func main() {
 	timeout := flag.Int("timeout", 60, "Limit the time of the game (in minutes)")
 	registryAddr := flag.String("registryAddr", "", "Docker registry address")
 	arenaAddr := flag.String("arenaAddr", "", "Address of this arena server, resolvable by the agent")
+	metadataUrl := flag.String("metadataUrl", "", "URL of the metadata of this arena, provides its id, key and API token")
+	mapSource := flag.String("maps", maps.DEFAULT_SOURCE, "Base URL or directory of the maps of the arenas")
+	mapCache := flag.String("mapCache", "", "Directory caching the maps by checksum; none by default")
 
 	flag.Parse()
 
+	// Not flags, the arguments are visible to the other users
+	apiToken := ""
+	mqKey := os.Getenv("MQ_KEY")
+
+	if *metadataUrl != "" {
+		metadata, err := fetchMetadata(*metadataUrl)
+		utils.Check(err, "Could not fetch metadata from "+*metadataUrl)
+
+		if arenaId, hasArenaId := metadata["arenaid"]; hasArenaId {
+			*arenaServerUUID = arenaId
+		}
+
+		if key, hasKey := metadata["mqkey"]; hasKey {
+			mqKey = key
+		}
+
+		if token, hasToken := metadata["apitoken"]; hasToken {
+			apiToken = token
+		}
+	}
+
 	utils.Assert((*arenaServerUUID) != "", "id must be set")
 	utils.Assert((*registryAddr) != "", "Docker registry address must be set")
 	utils.Assert((*arenaAddr) != "", "Arena address must be set")
 
 	utils.Debug("arena-server", "Byte Arena Server v0.1 ID#"+(*arenaServerUUID))
 
+	mapstore, err := maps.OpenStore(*mapSource, *mapCache)
+	utils.Check(err, "Invalid map source "+*mapSource)
+
 	// Make GraphQL client
 	graphqlclient := graphql.MakeClient(*apiurl)
 
+	if apiToken != "" {
+		graphqlclient.SetAuthenticator(graphql.BearerToken(apiToken))
+	} else {
+		graphqlauth, err := graphql.AuthenticatorFromEnv()
+		utils.Check(err, "Invalid GraphQL credentials")
+
+		graphqlclient.SetAuthenticator(graphqlauth)
+	}
+
 	// Make message broker client
-	brokerclient, err := mq.NewClient(*mqhost)
+	brokerclient, err := mq.NewBroker(*mqhost, mq.ARENA_SERVER_COMPONENT_PREFIX+(*arenaServerUUID))
 	utils.Check(err, "ERROR: Could not connect to messagebroker on "+*mqhost)
 
+	// Arenas don't get the key of arena-master, it signs their launches with
+	// their own key
+	if mqKey != "" {
+		brokerclient.EnableSigning(mq.SigningConfig{
+			Component: mq.ARENA_SERVER_COMPONENT_PREFIX + (*arenaServerUUID),
+			Key:       []byte(mqKey),
+		})
+
+		launchTopic := (*arenaServerUUID) + ".launch"
+
+		brokerclient.Trust("game", launchTopic, mq.StaticKeyring{mq.ARENA_MASTER_COMPONENT: []byte(mqKey)})
+		brokerclient.Authorize("game", launchTopic, mq.ARENA_MASTER_COMPONENT)
+	}
+
 	// For some reasons we can't handshake straight after the start.
 	// Just delay it a bit for now
 	// FIXME(sven): find a better solution
@@ -64,12 +109,9 @@ func main() {
 			for {
 				utils.Debug("arena-server", "send handshake")
 
-				handshakeErr := brokerclient.Publish("game", "handshake", types.NewMQMessage(
-					"arena-server",
-					"Arena Server "+(*arenaServerUUID)+" reporting for duty.",
-				).SetPayload(types.MQPayload{
-					"arenaserveruuid": (*arenaServerUUID),
-				}))
+				handshakeErr := mq.PublishMessage(brokerclient, mq.ARENA_SERVER_COMPONENT_PREFIX+(*arenaServerUUID), "game", "handshake", &mq.GameHandshake{
+					ArenaServerUUID: (*arenaServerUUID),
+				})
 
 				if handshakeErr != nil {
 					utils.Debug("arena-server", "Cannot handshake: "+handshakeErr.Error())
@@ -84,17 +126,12 @@ func main() {
 
 	StartMQHealthCheckServer(brokerclient, graphqlclient, *arenaServerUUID, time.Duration(*timeout*2)*time.Minute)
 
-	brokerclient.Subscribe("game", (*arenaServerUUID)+".launch", func(msg coremq.BrokerMessage) {
+	err = mq.SubscribeMessages(brokerclient, "game", (*arenaServerUUID)+".launch", func(message mq.Message) {
 		utils.Debug("from-master", "Received launching order")
 
-		var payload messageArenaLaunch
-		err := json.Unmarshal(msg.Data, &payload)
-		if err != nil {
-			utils.Debug("arena-server", "ERROR:game:launch Invalid payload "+string(msg.Data)+"; "+err.Error())
-			return
-		}
+		payload := message.(*mq.ArenaLaunch)
 
-		gamedescription, err := apiqueries.FetchGameById(graphqlclient, payload.Id)
+		gamedescription, err := apiqueries.FetchGameById(graphqlclient, mapstore, payload.Id)
 		utils.Check(err, "Could not fetch game "+payload.Id)
 		game := deathmatch.NewDeathmatchGame(gamedescription)
 
@@ -110,6 +147,7 @@ func main() {
 		go startGame(payload, orch, gamedescription, srv, *timeout)
 		go common.StreamState(srv, brokerclient, *arenaServerUUID)
 	})
+	utils.Check(err, "Could not subscribe to launches")
 
 	streamArenaStopped := make(chan interface{})
 	notify.Start("game:stopped", streamArenaStopped)
@@ -117,7 +155,7 @@ func main() {
 	<-streamArenaStopped
 }
 
-func startGame(arenaSubmitted messageArenaLaunch, orch arenaservertypes.ContainerOrchestrator, gameDescription types.GameDescriptionInterface, srv *arenaserver.Server, timeout int) {
+func startGame(arenaSubmitted *mq.ArenaLaunch, orch arenaservertypes.ContainerOrchestrator, gameDescription types.GameDescriptionInterface, srv *arenaserver.Server, timeout int) {
 	for _, contestant := range gameDescription.GetContestants() {
 		srv.RegisterAgent(contestant.AgentRegistry+"/"+contestant.AgentImage, contestant)
 	}
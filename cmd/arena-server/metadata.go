@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// fetchMetadata reads the metadata arena-master gave to this VM, through the
+// metadata server of its host
+func fetchMetadata(url string) (map[string]string, error) {
+	client := http.Client{
+		Timeout: 10 * time.Second,
+	}
+
+	res, err := client.Get(url)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, errors.New("Metadata server replied " + res.Status)
+	}
+
+	var raw map[string]interface{}
+
+	if err := json.NewDecoder(res.Body).Decode(&raw); err != nil {
+		return nil, errors.New("Invalid metadata: " + err.Error())
+	}
+
+	metadata := make(map[string]string)
+
+	for k, v := range raw {
+		metadata[k] = fmt.Sprint(v)
+	}
+
+	return metadata, nil
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
 	"os/exec"
 	"time"
@@ -8,8 +9,6 @@ import (
 	"github.com/bytearena/backends/common/graphql"
 	"github.com/bytearena/backends/common/mq"
 
-	coremq "github.com/bytearena/core/common/mq"
-	"github.com/bytearena/core/common/types"
 	"github.com/bytearena/core/common/utils"
 )
 
@@ -17,7 +16,7 @@ var (
 	startedAt = time.Now()
 )
 
-func StartMQHealthCheckServer(brokerclient *mq.Client, graphqlclient graphql.Client, id string, duration time.Duration) {
+func StartMQHealthCheckServer(brokerclient mq.Broker, graphqlclient graphql.Client, id string, duration time.Duration) {
 
 	testTimeElapsed := func() error {
 		now := time.Now()
@@ -30,7 +29,7 @@ func StartMQHealthCheckServer(brokerclient *mq.Client, graphqlclient graphql.Cli
 	}
 
 	testMq := func() error {
-		pingErr := brokerclient.Ping()
+		pingErr := brokerclient.Health()
 
 		if pingErr != nil {
 			return pingErr
@@ -67,7 +66,14 @@ func StartMQHealthCheckServer(brokerclient *mq.Client, graphqlclient graphql.Cli
 		}
 	}
 
-	brokerclient.Subscribe("game", "healthcheck", func(msg coremq.BrokerMessage) {
+	rpc, err := mq.NewRPC(brokerclient, mq.ARENA_SERVER_COMPONENT_PREFIX+id)
+	utils.Check(err, "Could not subscribe to mq")
+
+	err = rpc.Handle("game", "healthcheck", func(payload json.RawMessage) (interface{}, error) {
+		if _, err := mq.DecodeMessage("game", "healthcheck", payload); err != nil {
+			return nil, err
+		}
+
 		var status = "OK"
 
 		if err := testTimeElapsed(); err != nil {
@@ -86,14 +92,11 @@ func StartMQHealthCheckServer(brokerclient *mq.Client, graphqlclient graphql.Cli
 			status = "NOK"
 		}
 
-		handshakeErr := brokerclient.Publish("game", "healthcheck-res", types.NewMQMessage(
-			"arena-server",
-			"healthcheck",
-		).SetPayload(types.MQPayload{
-			"health": status,
-			"id":     id,
-		}))
-
-		utils.Check(handshakeErr, "Could not send health")
+		return mq.EncodeMessage(mq.ARENA_SERVER_COMPONENT_PREFIX+id, "game", "healthcheck-res", &mq.GameHealth{
+			Id:     id,
+			Health: status,
+		})
 	})
+
+	utils.Check(err, "Could not subscribe to healthchecks")
 }
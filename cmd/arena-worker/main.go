@@ -0,0 +1,127 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/bytearena/schnapps"
+
+	"github.com/bytearena/backends/arenamaster"
+	"github.com/bytearena/backends/arenamaster/federation"
+	"github.com/bytearena/backends/common/mq"
+
+	"github.com/bytearena/core/common"
+	"github.com/bytearena/core/common/utils"
+)
+
+var (
+	mqHost = os.Getenv("MQ")
+	apiUrl = os.Getenv("APIURL")
+
+	// Defaults to the hostname
+	workerId       = os.Getenv("WORKER_ID")
+	workerCapacity = utils.GetenvOrDefault("WORKER_CAPACITY", "10")
+	// Comma separated, empty to run every profile
+	workerProfiles = os.Getenv("WORKER_PROFILES")
+
+	vmRawImageLocation = utils.GetenvOrDefault("VM_RAW_IMAGE_LOCATION", "/linuxkit.raw")
+	vmBridgeName       = utils.GetenvOrDefault("VM_BRIDGE_NAME", "brtest")
+	vmBridgeIP         = utils.GetenvOrDefault("VM_BRIDGE_IP", "172.19.0.1")
+	vmSubnet           = utils.GetenvOrDefault("VM_SUBNET", "172.19.0.10/24")
+
+	// "schnapps" runs the arenas in VMs, "local" as arena-server processes
+	arenaBackend            = utils.GetenvOrDefault("ARENA_BACKEND", "schnapps")
+	arenaServerBinary       = utils.GetenvOrDefault("ARENA_SERVER_BINARY", "arena-server")
+	arenaServerRegistryAddr = utils.GetenvOrDefault("ARENA_SERVER_REGISTRY_ADDR", "127.0.0.1:5000")
+	arenaServerAddr         = utils.GetenvOrDefault("ARENA_SERVER_ADDR", "127.0.0.1")
+	arenaServerGameTimeout  = utils.GetenvOrDefault("ARENA_SERVER_GAME_TIMEOUT", "60")
+	arenaServerLogDir       = os.Getenv("ARENA_SERVER_LOG_DIR")
+	arenaServerKey          = os.Getenv("ARENA_SERVER_KEY")
+	arenaServerAPIToken     = os.Getenv("ARENA_SERVER_API_TOKEN")
+)
+
+func createBackend(findArena func(identity string) *vm.VM) arenamaster.ArenaBackend {
+	switch arenaBackend {
+	case "schnapps":
+		return arenamaster.NewSchnappsBackend(arenamaster.SchnappsBackendConfig{
+			RawImageLocation: vmRawImageLocation,
+			BridgeName:       vmBridgeName,
+			BridgeIP:         vmBridgeIP,
+			Subnet:           vmSubnet,
+		}, findArena)
+
+	case "local":
+		gameTimeout, err := strconv.Atoi(arenaServerGameTimeout)
+		utils.Check(err, "ARENA_SERVER_GAME_TIMEOUT must be a number")
+
+		return arenamaster.NewLocalBackend(arenamaster.LocalBackendConfig{
+			Binary:       arenaServerBinary,
+			MQHost:       mqHost,
+			APIUrl:       apiUrl,
+			RegistryAddr: arenaServerRegistryAddr,
+			ArenaAddr:    arenaServerAddr,
+			GameTimeout:  gameTimeout,
+			LogDir:       arenaServerLogDir,
+		})
+	}
+
+	utils.Assert(false, "ARENA_BACKEND must be schnapps or local")
+
+	return nil
+}
+
+func getProfiles() []string {
+	if workerProfiles == "" {
+		return arenamaster.GetProfileNames()
+	}
+
+	profiles := strings.Split(workerProfiles, ",")
+
+	for i, profile := range profiles {
+		profiles[i] = strings.TrimSpace(profile)
+	}
+
+	return profiles
+}
+
+func main() {
+	utils.Assert(mqHost != "", "MQ must be set")
+
+	if workerId == "" {
+		hostname, err := os.Hostname()
+		utils.Check(err, "WORKER_ID must be set")
+
+		workerId = hostname
+	}
+
+	capacity, err := strconv.Atoi(workerCapacity)
+	utils.Check(err, "WORKER_CAPACITY must be a number")
+
+	brokerclient, err := mq.NewBroker(mqHost, federation.WorkerComponent(workerId))
+	utils.Check(err, "ERROR: could not connect to messagebroker at "+string(mqHost))
+
+	utils.Check(brokerclient.EnableSigningFromEnv(federation.WorkerComponent(workerId)), "Invalid MQ keys")
+	federation.AuthorizeWorker(brokerclient, workerId)
+
+	if arenaServerKey != "" {
+		arenamaster.ARENA_SERVER_KEY = []byte(arenaServerKey)
+	}
+
+	arenamaster.ARENA_API_TOKEN = arenaServerAPIToken
+
+	spawner := arenamaster.NewBackendSpawner(createBackend)
+	utils.Check(spawner.Backend().Start(), "Could not start arena backend")
+
+	worker := federation.NewWorker(workerId, capacity, getProfiles(), brokerclient, spawner)
+	utils.Check(worker.Start(), "Could not start worker")
+
+	utils.Debug("arena-worker", "Worker "+workerId+" running up to "+workerCapacity+" arenas")
+
+	<-common.SignalHandler()
+	utils.Debug("sighandler", "RECEIVED SHUTDOWN SIGNAL; closing.")
+
+	worker.Stop()
+	spawner.Backend().Stop()
+	brokerclient.Stop()
+}
@@ -1,7 +1,7 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
 	"log"
@@ -11,25 +11,10 @@ import (
 	"time"
 
 	"github.com/bytearena/backends/common/graphql"
+	"github.com/bytearena/backends/common/graphql/api"
 	gqltypes "github.com/bytearena/backends/common/graphql/types"
 )
 
-const createDeploymentMutation = `
-mutation($agentDeployment: AgentDeploymentInputCreate!) {
-	createAgentDeployment(agentDeployment: $agentDeployment) {
-		id
-	}
-}
-`
-
-const updateDeploymentMutation = `
-mutation($id: String!, $agentDeployment: AgentDeploymentInputUpdate!) {
-	updateAgentDeployment(id: $id, agentDeployment: $agentDeployment) {
-		id
-	}
-}
-`
-
 const imageprefix = "bytearena/agent/"
 
 func privateMsg(msg string) {
@@ -98,7 +83,15 @@ func main() {
 	// MQ_HOST := os.Getenv("MQ_HOST")
 	// DOCKER_HOST := os.Getenv("DOCKER_HOST")
 
+	// The credentials are passed by dotgit-ssh, see graphql.AuthenticatorFromEnv
+	gqlauth, err := graphql.AuthenticatorFromEnv()
+	if err != nil {
+		privateMsg("Error: invalid GraphQL credentials; " + err.Error())
+		os.Exit(1)
+	}
+
 	gql := graphql.MakeClient(envAPIURL)
+	gql.SetAuthenticator(gqlauth)
 
 	gitbin, err := exec.LookPath("git")
 	if err != nil {
@@ -129,40 +122,30 @@ func main() {
 	newSha1 := parts[0]
 	message := parts[1]
 
-	createJSON, err := gql.RequestSync(
-		graphql.NewQuery(createDeploymentMutation).SetVariables(graphql.Variables{
-			"agentDeployment": graphql.Variables{
-				"agentId":       envGitRepoID,
-				"pushedAt":      time.Now().Format(time.RFC822Z),
-				"commitSHA1":    newSha1,
-				"commitMessage": message,
-				"buildStatus":   gqltypes.AgentDeployBuildStatus.Pending,
-			},
-		}),
-	)
+	created, err := api.CreateAgentDeployment(context.Background(), &gql, api.CreateAgentDeploymentVariables{
+		AgentDeployment: api.AgentDeploymentInputCreate{
+			AgentId:       envGitRepoID,
+			PushedAt:      graphql.String(time.Now().Format(time.RFC822Z)),
+			CommitSHA1:    graphql.String(newSha1),
+			CommitMessage: graphql.String(message),
+			BuildStatus:   graphql.Int(gqltypes.AgentDeployBuildStatus.Pending),
+		},
+	})
 	if err != nil {
 		privateMsg("Error: Could not create pending agent deployment; " + err.Error())
 		os.Exit(1)
 	}
 
-	var createResponse struct {
-		CreateAgentDeployment struct {
-			ID string `json:"id"`
-		} `json:"createAgentDeployment"`
-	}
-	json.Unmarshal(createJSON, &createResponse)
-	deploymentID := createResponse.CreateAgentDeployment.ID
+	deploymentID := created.CreateAgentDeployment.Id
 
 	updateDeployment := func(deploymentID string, status int, isError bool) error {
-		_, err = gql.RequestSync(
-			graphql.NewQuery(updateDeploymentMutation).SetVariables(graphql.Variables{
-				"id": deploymentID,
-				"agentDeployment": graphql.Variables{
-					"buildStatus": status,
-					"buildError":  isError,
-				},
-			}),
-		)
+		_, err := api.UpdateAgentDeployment(context.Background(), &gql, api.UpdateAgentDeploymentVariables{
+			Id: deploymentID,
+			AgentDeployment: api.AgentDeploymentInputUpdate{
+				BuildStatus: graphql.Int(status),
+				BuildError:  graphql.Bool(isError),
+			},
+		})
 
 		return err
 	}
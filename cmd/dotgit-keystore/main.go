@@ -34,7 +34,18 @@ func main() {
 	fingerprint := os.Args[1]
 	log.Println("Authenticating key with fingerprint " + fingerprint)
 
-	var db protocol.DatabaseInterface = database.NewGraphQLDatabase()
+	dbauth, err := cnf.GetDatabaseAuth()
+	if err != nil {
+		fmt.Println("Invalid database credentials")
+		log.Println("Invalid database credentials", err)
+		f.Close()
+		os.Exit(1)
+	}
+
+	gqldb := database.NewGraphQLDatabase()
+	gqldb.SetAuthenticator(dbauth)
+
+	var db protocol.DatabaseInterface = gqldb
 
 	err = db.Connect(cnf.GetDatabaseURI())
 	if err != nil {
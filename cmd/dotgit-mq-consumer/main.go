@@ -1,7 +1,6 @@
 package main
 
 import (
-	"encoding/json"
 	"log"
 	"os"
 	"time"
@@ -21,10 +20,6 @@ import (
 	dotgitutils "github.com/bytearena/backends/dotgit/utils"
 )
 
-type messageAgentSubmitted struct {
-	Id string `json:"id"`
-}
-
 func main() {
 
 	cnf := config.GetConfig()
@@ -38,7 +33,13 @@ func main() {
 	log.SetOutput(f)
 	utils.Debug("dotgit-mq-consumer", "Starting dotgit-mq-consumer daemon")
 
-	var db protocol.DatabaseInterface = database.NewGraphQLDatabase()
+	dbauth, err := cnf.GetDatabaseAuth()
+	utils.Check(err, "Invalid database credentials")
+
+	gqldb := database.NewGraphQLDatabase()
+	gqldb.SetAuthenticator(dbauth)
+
+	var db protocol.DatabaseInterface = gqldb
 	err = db.Connect(cnf.GetDatabaseURI())
 	if err != nil {
 		utils.Debug("mq-consumer", "Cannot connect to database")
@@ -47,31 +48,27 @@ func main() {
 		os.Exit(1)
 	}
 
-	brokerclient, err := mq.NewClient(cnf.GetMqHost())
+	brokerclient, err := mq.NewBroker(cnf.GetMqHost(), "dotgit-mq-consumer")
 	utils.Check(err, "ERROR: could not connect to messagebroker")
 
+	utils.Check(brokerclient.EnableSigningFromEnv("dotgit-mq-consumer"), "Invalid MQ keys")
+
 	streamAgentSubmitted := make(chan interface{})
 	notify.Start("agent:submitted", streamAgentSubmitted)
 
-	brokerclient.Subscribe("agent", "submitted", func(msg coremq.BrokerMessage) {
+	err = mq.SubscribeMessages(brokerclient, "agent", "submitted", func(message mq.Message) {
 		log.Println("INFO:agent:submitted Received from MESSAGEBROKER")
-		var payload messageAgentSubmitted
-		err := json.Unmarshal(msg.Data, &payload)
-		if err != nil {
-			log.Println(err)
-			log.Println("ERROR:agent:submitted Invalid payload " + string(msg.Data))
-			return
-		}
 
-		notify.PostTimeout("agent:submitted", payload, time.Millisecond)
+		notify.PostTimeout("agent:submitted", message, time.Millisecond)
 	})
+	utils.Check(err, "ERROR: could not subscribe to agent:submitted")
 
 	go func() {
 		for {
 			select {
 			case payload := <-streamAgentSubmitted:
 				{
-					if agentSubmitted, ok := payload.(messageAgentSubmitted); ok {
+					if agentSubmitted, ok := payload.(*mq.AgentSubmitted); ok {
 						go initRepo(db, brokerclient, agentSubmitted.Id)
 					}
 				}
@@ -93,14 +90,7 @@ func initRepo(db protocol.DatabaseInterface, mqclient coremq.ClientInterface, ag
 		errmsg := "ERROR:agent:submitted Could not fetch agent by id '" + agentid + "'"
 		log.Println(errmsg)
 		log.Println(err)
-		mqclient.Publish(
-			"agent", "repo-init-fail", types.NewMQError(
-				"dotgit-mq-consumer",
-				errmsg,
-			).SetPayload(types.MQPayload{
-				"agentid": agentid,
-			}),
-		)
+		publishRepoInit(mqclient, "repo-init-fail", types.NewMQError("dotgit-mq-consumer", errmsg), agentid)
 		return
 	}
 
@@ -109,24 +99,22 @@ func initRepo(db protocol.DatabaseInterface, mqclient coremq.ClientInterface, ag
 	if err != nil {
 		errmsg := "ERROR:agent:submitted Could not fetch agent by id '" + agentid + "'"
 		log.Println(errmsg)
-		mqclient.Publish(
-			"agent", "repo-init-fail", types.NewMQError(
-				"dotgit-mq-consumer",
-				errmsg,
-			).SetPayload(types.MQPayload{
-				"agentid": agentid,
-			}),
-		)
+		publishRepoInit(mqclient, "repo-init-fail", types.NewMQError("dotgit-mq-consumer", errmsg), agentid)
 		return
 	}
 
 	// appel de mq
-	mqclient.Publish(
-		"agent", "repo-init-success", types.NewMQMessage(
-			"dotgit-mq-consumer",
-			"Git Repo "+agent.CloneURL+" has been successfuly initialized.",
-		).SetPayload(types.MQPayload{
-			"agentid": agentid,
-		}),
-	)
+	log.Println("INFO:agent:submitted Git Repo " + agent.CloneURL + " has been successfuly initialized.")
+	publishRepoInit(mqclient, "repo-init-success", types.NewMQMessage(
+		"dotgit-mq-consumer",
+		"Git Repo "+agent.CloneURL+" has been successfuly initialized.",
+	), agentid)
+}
+
+func publishRepoInit(mqclient coremq.ClientInterface, topic string, message *types.MQMessage, agentid string) {
+	err := mq.PublishMessage(mqclient, "dotgit-mq-consumer", "agent", topic, mq.NewAgentRepoInit(message, agentid))
+
+	if err != nil {
+		log.Println("ERROR:agent:" + topic + " Could not publish: " + err.Error())
+	}
 }
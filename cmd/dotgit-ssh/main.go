@@ -43,7 +43,13 @@ func main() {
 	log.SetOutput(f)
 	log.Println("Starting a dotgit-ssh session", os.Args, os.Getenv("SSH_ORIGINAL_COMMAND"))
 
-	var db protocol.DatabaseInterface = database.NewGraphQLDatabase()
+	dbauth, err := cnf.GetDatabaseAuth()
+	errorCheck(err, "Invalid database credentials")
+
+	gqldb := database.NewGraphQLDatabase()
+	gqldb.SetAuthenticator(dbauth)
+
+	var db protocol.DatabaseInterface = gqldb
 
 	err = db.Connect(cnf.GetDatabaseURI())
 	errorCheck(err, "Cannot connect to database")
@@ -173,6 +179,8 @@ func processGitOperation(user protocol.User, repo protocol.GitRepository, gitOpe
 		"DOCKER_BUILD_CPU_PERIOD="+cnf.DockerBuildCpuPeriod,
 	)
 
+	cmd.Env = append(cmd.Env, cnf.GetDatabaseAuthEnv()...)
+
 	err = cmd.Run()
 	if err != nil {
 		return errors.New("Error: error during git operation; " + stderr.String())
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bytearena/backends/common/graphql"
+	"github.com/bytearena/backends/common/graphql/codegen"
+	"github.com/bytearena/core/common/utils"
+)
+
+func main() {
+	schemaFile := flag.String("schema", "schema.graphql", "Schema the operations are checked against, in the GraphQL schema language")
+	apiurl := flag.String("apiurl", "", "Replaces the schema file by the schema of this GQL API before generating; see graphql.AuthenticatorFromEnv for its credentials")
+	out := flag.String("out", "operations.go", "Generated file")
+	pkg := flag.String("package", "", "Package of the generated file; defaults to the name of its directory")
+
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		fmt.Println("Error: the .graphql files of the operations, or their directories, are required.")
+		os.Exit(1)
+	}
+
+	if *pkg == "" {
+		absOut, err := filepath.Abs(*out)
+		utils.Check(err, "Invalid output file")
+
+		*pkg = filepath.Base(filepath.Dir(absOut))
+	}
+
+	if *apiurl != "" {
+		utils.Check(updateSchema(*apiurl, *schemaFile), "Could not update the schema from "+*apiurl)
+	}
+
+	rawSchema, err := ioutil.ReadFile(*schemaFile)
+	utils.Check(err, "Could not read the schema")
+
+	schema, err := codegen.ParseSchema(string(rawSchema))
+	exitOnError(err, *schemaFile)
+
+	files, err := operationFiles(flag.Args(), *schemaFile)
+	utils.Check(err, "Could not list the operations")
+
+	doc := codegen.NewDocument()
+
+	for _, file := range files {
+		src, err := ioutil.ReadFile(file)
+		utils.Check(err, "Could not read "+file)
+
+		exitOnError(doc.Parse(string(src)), file)
+	}
+
+	generated, err := codegen.Generate(schema, doc, *pkg)
+	exitOnError(err, strings.Join(files, ", "))
+
+	utils.Check(ioutil.WriteFile(*out, generated, 0644), "Could not write "+*out)
+}
+
+func updateSchema(apiurl, schemaFile string) error {
+	auth, err := graphql.AuthenticatorFromEnv()
+	if err != nil {
+		return err
+	}
+
+	schema, err := graphql.NewClient(apiurl).SetAuthenticator(auth).FetchSchema(context.Background())
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(schemaFile, schema, 0644)
+}
+
+// operationFiles returns the files, and the .graphql files of the directories
+// but the schema
+func operationFiles(args []string, schemaFile string) ([]string, error) {
+	files := make([]string, 0)
+
+	for _, arg := range args {
+		info, err := os.Stat(arg)
+		if err != nil {
+			return nil, err
+		}
+
+		if !info.IsDir() {
+			files = append(files, arg)
+			continue
+		}
+
+		matches, err := filepath.Glob(filepath.Join(arg, "*.graphql"))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, match := range matches {
+			if filepath.Clean(match) != filepath.Clean(schemaFile) {
+				files = append(files, match)
+			}
+		}
+	}
+
+	sort.Strings(files)
+
+	return files, nil
+}
+
+// The errors of the schema and operations are not bugs, they're printed
+// without a stack
+func exitOnError(err error, file string) {
+	if err != nil {
+		fmt.Println("Error: " + file + ": " + err.Error())
+		os.Exit(1)
+	}
+}
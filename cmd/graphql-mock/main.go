@@ -0,0 +1,35 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/bytearena/backends/common/graphql/mock"
+	"github.com/bytearena/core/common/utils"
+)
+
+func main() {
+	port := flag.String("port", "8081", "Port of the GraphQL API")
+	fixturesFile := flag.String("fixtures", "fixtures.json", "Records served by the API, see mock.Fixtures")
+	schemaFile := flag.String("schema", "", "Schema served on /schema, see common/graphql/schema.graphql")
+	token := flag.String("token", "", "Bearer token the requests must have; none by default")
+
+	flag.Parse()
+
+	fixtures, err := mock.LoadFixtures(*fixturesFile)
+	utils.Check(err, "Could not load the fixtures "+*fixturesFile)
+
+	server := mock.NewServer(fixtures).SetToken(*token)
+
+	if *schemaFile != "" {
+		schema, err := ioutil.ReadFile(*schemaFile)
+		utils.Check(err, "Could not read the schema "+*schemaFile)
+
+		server.SetSchema(schema)
+	}
+
+	utils.Debug("graphql-mock", "Listening on port "+*port)
+
+	utils.Check(http.ListenAndServe(":"+*port, server), "Could not listen on port "+*port)
+}
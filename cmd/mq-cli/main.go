@@ -1,11 +1,13 @@
 package main
 
 import (
+	"bufio"
 	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
 
 	"github.com/ttacon/chalk"
@@ -24,11 +26,43 @@ func splitEventSlug(eventslug string) (channel string, topic string, err error)
 	return strings.TrimSpace(res[0]), strings.TrimSpace(res[1]), nil
 }
 
+// Parses "field=value,field=value"
+func parseFilters(raw string) ([]mq.MessageFilter, error) {
+	filters := make([]mq.MessageFilter, 0)
+
+	if raw == "" {
+		return filters, nil
+	}
+
+	for _, rawFilter := range strings.Split(raw, ",") {
+		res := strings.SplitN(rawFilter, "=", 2)
+		if len(res) != 2 || strings.TrimSpace(res[0]) == "" {
+			return nil, errors.New("Invalid filter \"" + rawFilter + "\"")
+		}
+
+		filters = append(filters, mq.MessageFilter{
+			Field: strings.TrimSpace(res[0]),
+			Value: strings.TrimSpace(res[1]),
+		})
+	}
+
+	return filters, nil
+}
+
 func main() {
 
 	mqHost := flag.String("mqhost", "", "MQ host")
 	publish := flag.String("publish", "", "Published event; example agent:repo.pushed")
-	publishdata := flag.String("data", "", "Published payload, json; example {\"id\": 5}")
+	publishdata := flag.String("data", "", "Published payload, json; example {\"id\": 5}, validated against the schema of the topic if it has one")
+	fromFile := flag.String("from-file", "", "Publishes the events of a file, a line per event; example game:launch {\"id\": \"5\"}")
+	subscribe := flag.String("subscribe", "", "Prints the received events, globs separated by commas; example game:*,arena:halt; Redis PubSub only")
+	filter := flag.String("filter", "", "Only keeps the received events whose payload has these fields; example id=5,health=NOK")
+	count := flag.Int("count", 0, "Stops after receiving that many events, 0 for no limit")
+	timeout := flag.Duration("timeout", 0, "Stops after that long, fails if --count events were not received; 0 for no limit")
+	record := flag.String("record", "", "Records the received events to a file, - for stdout; Redis PubSub only")
+	recordPatterns := flag.String("patterns", "*:*", "Recorded events, globs separated by commas; example game:*,arena:*")
+	replay := flag.String("replay", "", "Publishes the messages of a recording")
+	replaySpeed := flag.Float64("speed", 1, "Speed of the replay, 0 to publish as fast as possible")
 
 	flag.Parse()
 
@@ -37,23 +71,84 @@ func main() {
 		os.Exit(1)
 	}
 
-	if *publish == "" {
-		fmt.Println("Error: --publish is missing.")
+	filters, err := parseFilters(*filter)
+	utils.Check(err, "Error: Invalid --filter")
+
+	if *subscribe != "" {
+		runSubscribe(*mqHost, *subscribe, filters, *count, *timeout)
+		return
+	}
+
+	if *record != "" {
+		runRecord(*mqHost, *record, *recordPatterns, filters, *count, *timeout)
+		return
+	}
+
+	if *publish == "" && *replay == "" && *fromFile == "" {
+		fmt.Println("Error: --publish, --from-file, --subscribe, --record or --replay is required.")
 		os.Exit(1)
 	}
 
-	brokerclient, err := mq.NewClient(*mqHost)
+	brokerclient, err := mq.NewBroker(*mqHost, "mq-cli")
 	utils.Check(err, "Error: could not connect to messagebroker at "+string(*mqHost))
 
-	channel, topic, err := splitEventSlug(*publish)
-	utils.Check(err, "Error: Invalid event slug \""+*publish+"\"")
+	utils.Check(brokerclient.EnableSigningFromEnv("mq-cli"), "Invalid MQ keys")
+
+	switch {
+	case *replay != "":
+		runReplay(brokerclient, *replay, *replaySpeed)
+
+	case *fromFile != "":
+		err = publishFromFile(brokerclient, *fromFile)
+
+	default:
+		err = publishEvent(brokerclient, *publish, *publishdata)
+	}
+
+	brokerclient.Stop()
+
+	if err != nil {
+		fmt.Println("Error: " + err.Error())
+		os.Exit(1)
+	}
+}
+
+// publishEvent validates the data against the schema of the topic if it has
+// one, otherwise it's sent as the payload of a types.MQMessage
+func publishEvent(brokerclient mq.Broker, eventslug, data string) error {
+	channel, topic, err := splitEventSlug(eventslug)
+	if err != nil {
+		return errors.New("Invalid event slug \"" + eventslug + "\"")
+	}
+
+	if schema, hasSchema := mq.LookupSchema(channel, topic); hasSchema {
+		if data == "" {
+			data = "{}"
+		}
+
+		message := schema.New()
+
+		err = json.Unmarshal([]byte(data), message)
+		if err != nil {
+			return errors.New("Invalid json for " + eventslug + ": " + err.Error())
+		}
+
+		err = mq.PublishMessage(brokerclient, "mq-cli", channel, topic, message)
+		if err != nil {
+			return err
+		}
+
+		reencodedpayload, _ := json.Marshal(message)
+		printPublished(channel, topic, reencodedpayload)
+
+		return nil
+	}
 
 	var payload types.MQPayload
-	if *publishdata != "" {
-		err = json.Unmarshal([]byte(*publishdata), &payload)
+	if data != "" {
+		err = json.Unmarshal([]byte(data), &payload)
 		if err != nil {
-			fmt.Println("Error: Invalid json for --data")
-			return
+			return errors.New("Invalid json for " + eventslug)
 		}
 	}
 
@@ -63,19 +158,63 @@ func main() {
 
 	err = brokerclient.Publish(channel, topic, mqmessage)
 	if err != nil {
-		panic(err)
+		return err
+	}
+
+	var reencodedpayload []byte
+	if payload != nil {
+		reencodedpayload, _ = json.Marshal(payload)
+	}
+
+	printPublished(channel, topic, reencodedpayload)
+
+	return nil
+}
+
+// Stops at the first invalid event, the empty lines and the ones starting with
+// # are skipped
+func publishFromFile(brokerclient mq.Broker, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
 	}
 
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	line := 0
+
+	for scanner.Scan() {
+		line++
+		event := strings.TrimSpace(scanner.Text())
+
+		if event == "" || strings.HasPrefix(event, "#") {
+			continue
+		}
+
+		res := strings.SplitN(event, " ", 2)
+		data := ""
+
+		if len(res) == 2 {
+			data = strings.TrimSpace(res[1])
+		}
+
+		if err := publishEvent(brokerclient, res[0], data); err != nil {
+			return errors.New("line " + strconv.Itoa(line) + ": " + err.Error())
+		}
+	}
+
+	return scanner.Err()
+}
+
+func printPublished(channel, topic string, reencodedpayload []byte) {
 	fmt.Print("Message published ")
 	fmt.Print(chalk.Yellow)
 	fmt.Print(channel+":"+topic, chalk.Reset)
-	if payload != nil {
-		reencodedpayload, _ := json.Marshal(payload)
+	if reencodedpayload != nil {
 		fmt.Print(chalk.Cyan, " ")
 		fmt.Print(string(reencodedpayload), chalk.Reset)
 	}
 
 	fmt.Println("")
-
-	brokerclient.Stop()
 }
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ttacon/chalk"
+
+	"github.com/bytearena/backends/common/mq"
+	"github.com/bytearena/core/common"
+	"github.com/bytearena/core/common/utils"
+)
+
+// Cancelled on SIGINT or SIGTERM, or after the timeout if there's one
+func signalContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	var ctx context.Context
+	var cancel context.CancelFunc
+
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), timeout)
+	} else {
+		ctx, cancel = context.WithCancel(context.Background())
+	}
+
+	go func() {
+		select {
+		case <-common.SignalHandler():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, cancel
+}
+
+func newRecorder(mqHost, rawPatterns string) *mq.Recorder {
+	patterns := make([]string, 0)
+
+	for _, slug := range strings.Split(rawPatterns, ",") {
+		channel, topic, err := splitEventSlug(slug)
+		utils.Check(err, "Error: Invalid event pattern \""+slug+"\"")
+
+		patterns = append(patterns, mq.RecordPattern(channel, topic))
+	}
+
+	recorder, err := mq.NewRecorder(mqHost, patterns...)
+	utils.Check(err, "Error: could not connect to messagebroker at "+mqHost)
+
+	return recorder
+}
+
+// Exits with an error if the timeout expired before count messages were
+// received
+func checkReceived(ctx context.Context, received, count int) {
+	if count > 0 && received < count && ctx.Err() == context.DeadlineExceeded {
+		fmt.Fprintln(os.Stderr, "Error: received "+strconv.Itoa(received)+" of "+strconv.Itoa(count)+" messages before the timeout")
+		os.Exit(1)
+	}
+}
+
+func runRecord(mqHost, file, rawPatterns string, filters []mq.MessageFilter, count int, timeout time.Duration) {
+	var w io.Writer = os.Stdout
+
+	if file != "-" {
+		f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		utils.Check(err, "Error: could not open "+file)
+		defer f.Close()
+
+		w = f
+	}
+
+	recorder := newRecorder(mqHost, rawPatterns)
+	defer recorder.Stop()
+
+	ctx, cancel := signalContext(timeout)
+	defer cancel()
+
+	recorded, err := recorder.Record(ctx, w, count, filters...)
+	utils.Check(err, "Error: recording failed after "+strconv.Itoa(recorded)+" messages")
+
+	fmt.Fprintln(os.Stderr, "Recorded "+strconv.Itoa(recorded)+" messages")
+
+	checkReceived(ctx, recorded, count)
+}
+
+func runSubscribe(mqHost, rawPatterns string, filters []mq.MessageFilter, count int, timeout time.Duration) {
+	recorder := newRecorder(mqHost, rawPatterns)
+	defer recorder.Stop()
+
+	ctx, cancel := signalContext(timeout)
+	defer cancel()
+
+	received, err := recorder.Watch(ctx, count, filters, func(message mq.RecordedMessage) error {
+		printReceived(message)
+		return nil
+	})
+	utils.Check(err, "Error: subscription failed after "+strconv.Itoa(received)+" messages")
+
+	checkReceived(ctx, received, count)
+}
+
+func printReceived(message mq.RecordedMessage) {
+	fmt.Print(message.Time.Format("15:04:05.000"), " ")
+	fmt.Print(chalk.Yellow)
+	fmt.Print(message.Channel+":"+message.Topic, chalk.Reset)
+
+	if message.Auth != nil {
+		fmt.Print(chalk.Green, " from ")
+		fmt.Print(message.Auth.Component, chalk.Reset)
+	}
+
+	fmt.Println("")
+
+	var data bytes.Buffer
+
+	if err := json.Indent(&data, message.Data, "", "  "); err != nil {
+		data.Reset()
+		data.Write(message.Data)
+	}
+
+	fmt.Print(chalk.Cyan)
+	fmt.Print(data.String(), chalk.Reset)
+	fmt.Println("")
+}
+
+func runReplay(brokerclient mq.Broker, file string, speed float64) {
+	f, err := os.Open(file)
+	utils.Check(err, "Error: could not open "+file)
+	defer f.Close()
+
+	ctx, cancel := signalContext(0)
+	defer cancel()
+
+	replayed, err := mq.Replay(ctx, brokerclient, f, speed)
+
+	fmt.Print("Replayed ")
+	fmt.Print(chalk.Yellow)
+	fmt.Print(strconv.Itoa(replayed)+" messages", chalk.Reset)
+	fmt.Println("")
+
+	utils.Check(err, "Error: replay failed")
+}
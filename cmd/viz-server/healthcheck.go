@@ -10,11 +10,11 @@ import (
 	"github.com/bytearena/backends/common/mq"
 )
 
-func NewHealthCheck(brokerclient *mq.Client, graphqlclient graphql.Client, vizServerAddr string) *healthcheck.HealthCheckServer {
+func NewHealthCheck(brokerclient mq.Broker, graphqlclient graphql.Client, vizServerAddr string) *healthcheck.HealthCheckServer {
 	healthCheckServer := healthcheck.NewHealthCheckServer()
 
 	healthCheckServer.Register("mq", func() error {
-		pingErr := brokerclient.Ping()
+		pingErr := brokerclient.Health()
 
 		if pingErr != nil {
 			return pingErr
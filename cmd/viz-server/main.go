@@ -1,7 +1,6 @@
 package main
 
 import (
-	"encoding/json"
 	"flag"
 	"os"
 	"runtime"
@@ -12,7 +11,6 @@ import (
 	notify "github.com/bitly/go-notify"
 
 	"github.com/bytearena/core/common"
-	coremq "github.com/bytearena/core/common/mq"
 	corerecording "github.com/bytearena/core/common/recording"
 	"github.com/bytearena/core/common/utils"
 	"github.com/bytearena/core/common/visualization"
@@ -22,34 +20,25 @@ import (
 	apiqueries "github.com/bytearena/backends/common/graphql/queries"
 	"github.com/bytearena/backends/common/healthcheck"
 	"github.com/bytearena/backends/common/influxdb"
+	"github.com/bytearena/backends/common/maps"
 	"github.com/bytearena/backends/common/mq"
 	"github.com/bytearena/backends/common/recording"
 )
 
 var vizMessageReceived = influxdb.NewCounter()
 
-// Simplified version of the VizMessage struct
-type GameIDVizMessage struct {
-	GameID          string
-	ArenaServerUUID string
-}
-
-type GameStoppedMessage struct {
-	Payload struct {
-		ArenaServerUUID string `json:"arenaserveruuid"`
-	} `json:"payload"`
-}
-
 type GameListSynchronizer struct {
 	gql        graphql.Client
+	mapstore   *maps.Store
 	games      map[string]*types.VizGame
 	gamesmutex *sync.RWMutex
 	pollfreq   time.Duration
 }
 
-func NewGameList(gql graphql.Client, pollfreq time.Duration) *GameListSynchronizer {
+func NewGameList(gql graphql.Client, mapstore *maps.Store, pollfreq time.Duration) *GameListSynchronizer {
 	return &GameListSynchronizer{
 		gql:        gql,
+		mapstore:   mapstore,
 		games:      make(map[string]*types.VizGame),
 		gamesmutex: &sync.RWMutex{},
 		pollfreq:   pollfreq,
@@ -87,7 +76,7 @@ func (glist *GameListSynchronizer) StopSync() {
 
 func (glist *GameListSynchronizer) doFetchFromGQL() {
 
-	games, err := apiqueries.FetchGames(glist.gql)
+	games, err := apiqueries.FetchGames(glist.gql, glist.mapstore)
 	if err != nil {
 		utils.Debug("viz-server", "Could not fetch games from GraphQL server")
 		return
@@ -148,13 +137,17 @@ func main() {
 	mqhost := flag.String("mqhost", "mq:5678", "Message queue host:port")
 	apiurl := flag.String("apiurl", "https://graphql.net.bytearena.com", "GQL API URL")
 	recordDirectory := flag.String("record-dir", "", "Record files destination")
+	mapSource := flag.String("maps", maps.DEFAULT_SOURCE, "Base URL or directory of the maps of the arenas")
+	mapCache := flag.String("map-cache", "", "Directory caching the maps by checksum; none by default")
 
 	flag.Parse()
 
 	// Connect to Message broker
-	mqclient, err := mq.NewClient(*mqhost)
+	mqclient, err := mq.NewBroker(*mqhost, "viz-server")
 	utils.Check(err, "ERROR: could not connect to messagebroker")
 
+	utils.Check(mqclient.EnableSigningFromEnv("viz-server"), "Invalid MQ keys")
+
 	var recorder corerecording.RecorderInterface = corerecording.MakeEmptyRecorder()
 	if *recordDirectory != "" {
 		recorder = recording.MakeMultiArenaRecorder(*recordDirectory)
@@ -163,8 +156,16 @@ func main() {
 	// Make GraphQL client
 	graphqlclient := graphql.MakeClient(*apiurl)
 
+	graphqlauth, err := graphql.AuthenticatorFromEnv()
+	utils.Check(err, "Invalid GraphQL credentials")
+
+	graphqlclient.SetAuthenticator(graphqlauth)
+
+	mapstore, err := maps.OpenStore(*mapSource, *mapCache)
+	utils.Check(err, "Invalid map source "+*mapSource)
+
 	// On lance une routine de fetch des games 1x/10 sec
-	gamelist := NewGameList(graphqlclient, time.Second*10)
+	gamelist := NewGameList(graphqlclient, mapstore, time.Second*10)
 	gamelist.StartSync()
 
 	// Make influxdb client
@@ -191,40 +192,29 @@ func main() {
 		return gamelist.GetGames(), nil
 	}, recorder)
 
-	mqclient.Subscribe("viz", "message", func(msg coremq.BrokerMessage) {
-		var vizMessage []GameIDVizMessage
-		err := json.Unmarshal([]byte(msg.Data), &vizMessage)
-
-		if err != nil {
-			utils.Debug("vizserver", "Failed to decode vizmessage: "+err.Error())
-			return
-		}
+	err = mq.SubscribeMessages(mqclient, "viz", "message", func(message mq.Message) {
+		batch := message.(*mq.VizBatch)
 
-		gameID := vizMessage[0].GameID
-		arenaServerUUID := vizMessage[0].ArenaServerUUID
+		gameID := batch.Messages[0].GameID
+		arenaServerUUID := batch.Messages[0].ArenaServerUUID
 		game, ok := gamelist.GetGameById(gameID)
 
 		if ok {
 			recorder.RecordMetadata(arenaServerUUID, game.GetGame().GetMapContainer())
-			recorder.Record(arenaServerUUID, string(msg.Data))
+			recorder.Record(arenaServerUUID, string(batch.Data))
 		}
 
-		utils.Debug("viz:message", "received batch of "+strconv.Itoa(len(vizMessage))+" message(s) for arena server "+arenaServerUUID)
-		notify.PostTimeout("viz:message:"+gameID, string(msg.Data), time.Millisecond)
+		utils.Debug("viz:message", "received batch of "+strconv.Itoa(len(batch.Messages))+" message(s) for arena server "+arenaServerUUID)
+		notify.PostTimeout("viz:message:"+gameID, string(batch.Data), time.Millisecond)
 
-		vizMessageReceived.Add(len(vizMessage))
+		vizMessageReceived.Add(len(batch.Messages))
 	})
+	utils.Check(err, "Could not subscribe to viz:message")
 
-	mqclient.Subscribe("game", "stopped", func(msg coremq.BrokerMessage) {
-		var message GameStoppedMessage
-		err := json.Unmarshal(msg.Data, &message)
-		if err != nil {
-			utils.Debug("viz-server", "Failed to game stop message: "+err.Error())
-			return
-		}
-
-		recorder.Close(message.Payload.ArenaServerUUID)
+	err = mq.SubscribeMessages(mqclient, "game", "stopped", func(message mq.Message) {
+		recorder.Close(message.(*mq.GameStopped).ArenaServerUUID)
 	})
+	utils.Check(err, "Could not subscribe to game:stopped")
 
 	vizservice.Start()
 
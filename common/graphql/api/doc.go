@@ -0,0 +1,6 @@
+// Package api holds the operations the backends send to the GraphQL API,
+// generated from the .graphql files of this directory. They're checked
+// against ../schema.graphql, which is updated with the -apiurl of graphql-gen.
+package api
+
+//go:generate go run ../../../cmd/graphql-gen/main.go -schema ../schema.graphql -out operations.go .
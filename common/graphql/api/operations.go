@@ -0,0 +1,476 @@
+// Code generated by graphql-gen. DO NOT EDIT.
+
+package api
+
+import (
+	"context"
+
+	"github.com/bytearena/backends/common/graphql"
+)
+
+const createAgentDeploymentMutation = `
+mutation CreateAgentDeployment($agentDeployment: AgentDeploymentInputCreate!) {
+	createAgentDeployment(agentDeployment: $agentDeployment) {
+		id
+	}
+}
+`
+
+type CreateAgentDeploymentVariables struct {
+	AgentDeployment AgentDeploymentInputCreate `json:"agentDeployment"`
+}
+
+type CreateAgentDeploymentResponse struct {
+	CreateAgentDeployment CreateAgentDeploymentResponseCreateAgentDeployment `json:"createAgentDeployment"`
+}
+
+type CreateAgentDeploymentResponseCreateAgentDeployment struct {
+	Id string `json:"id"`
+}
+
+// CreateAgentDeployment sends the CreateAgentDeployment mutation
+func CreateAgentDeployment(ctx context.Context, client graphql.Requester, variables CreateAgentDeploymentVariables) (*CreateAgentDeploymentResponse, error) {
+	var response CreateAgentDeploymentResponse
+	err := graphql.Request(ctx, client, graphql.NewQuery(createAgentDeploymentMutation).SetVariablesStruct(variables), &response)
+
+	return &response, err
+}
+
+const updateAgentDeploymentMutation = `
+mutation UpdateAgentDeployment($id: String!, $agentDeployment: AgentDeploymentInputUpdate!) {
+	updateAgentDeployment(id: $id, agentDeployment: $agentDeployment) {
+		id
+	}
+}
+`
+
+type UpdateAgentDeploymentVariables struct {
+	Id              string                     `json:"id"`
+	AgentDeployment AgentDeploymentInputUpdate `json:"agentDeployment"`
+}
+
+type UpdateAgentDeploymentResponse struct {
+	UpdateAgentDeployment UpdateAgentDeploymentResponseUpdateAgentDeployment `json:"updateAgentDeployment"`
+}
+
+type UpdateAgentDeploymentResponseUpdateAgentDeployment struct {
+	Id string `json:"id"`
+}
+
+// UpdateAgentDeployment sends the UpdateAgentDeployment mutation
+func UpdateAgentDeployment(ctx context.Context, client graphql.Requester, variables UpdateAgentDeploymentVariables) (*UpdateAgentDeploymentResponse, error) {
+	var response UpdateAgentDeploymentResponse
+	err := graphql.Request(ctx, client, graphql.NewQuery(updateAgentDeploymentMutation).SetVariablesStruct(variables), &response)
+
+	return &response, err
+}
+
+const usersQuery = `
+query Users($username: String, $email: String) {
+	users(username: $username, email: $email) {
+		...UserFields
+	}
+}
+
+fragment UserFields on User {
+	id
+	name
+	username
+	email
+	universalReader
+	universalWriter
+}
+`
+
+type UsersVariables struct {
+	Username *string `json:"username,omitempty"`
+	Email    *string `json:"email,omitempty"`
+}
+
+type UsersResponse struct {
+	Users []UserFields `json:"users"`
+}
+
+type UserFields struct {
+	Id              string `json:"id"`
+	Name            string `json:"name"`
+	Username        string `json:"username"`
+	Email           string `json:"email"`
+	UniversalReader bool   `json:"universalReader"`
+	UniversalWriter bool   `json:"universalWriter"`
+}
+
+// Users sends the Users query
+func Users(ctx context.Context, client graphql.Requester, variables UsersVariables) (*UsersResponse, error) {
+	var response UsersResponse
+	err := graphql.Request(ctx, client, graphql.NewQuery(usersQuery).SetVariablesStruct(variables), &response)
+
+	return &response, err
+}
+
+const agentsQuery = `
+query Agents($username: String, $reponame: String, $id: String) {
+	agents(username: $username, name: $reponame, id: $id) {
+		id
+		name
+		gitRepository {
+			cloneURL
+			username
+			name
+			ref
+		}
+		image {
+			name
+			tag
+			registry
+		}
+		owner {
+			...UserFields
+		}
+	}
+}
+
+fragment UserFields on User {
+	id
+	name
+	username
+	email
+	universalReader
+	universalWriter
+}
+`
+
+type AgentsVariables struct {
+	Username *string `json:"username,omitempty"`
+	Reponame *string `json:"reponame,omitempty"`
+	Id       *string `json:"id,omitempty"`
+}
+
+type AgentsResponse struct {
+	Agents []AgentsResponseAgents `json:"agents"`
+}
+
+type AgentsResponseAgents struct {
+	Id            string                            `json:"id"`
+	Name          string                            `json:"name"`
+	GitRepository AgentsResponseAgentsGitRepository `json:"gitRepository"`
+	Image         AgentsResponseAgentsImage         `json:"image"`
+	Owner         UserFields                        `json:"owner"`
+}
+
+type AgentsResponseAgentsGitRepository struct {
+	CloneURL string `json:"cloneURL"`
+	Username string `json:"username"`
+	Name     string `json:"name"`
+	Ref      string `json:"ref"`
+}
+
+type AgentsResponseAgentsImage struct {
+	Name     string `json:"name"`
+	Tag      string `json:"tag"`
+	Registry string `json:"registry"`
+}
+
+// Agents sends the Agents query
+func Agents(ctx context.Context, client graphql.Requester, variables AgentsVariables) (*AgentsResponse, error) {
+	var response AgentsResponse
+	err := graphql.Request(ctx, client, graphql.NewQuery(agentsQuery).SetVariablesStruct(variables), &response)
+
+	return &response, err
+}
+
+const sshPublicKeysQuery = `
+query SSHPublicKeys($username: String, $fingerprint: String) {
+	sshpublickeys(username: $username, fingerprint: $fingerprint) {
+		owner {
+			...UserFields
+		}
+		name
+		type
+		key
+		fingerprint
+		comment
+	}
+}
+
+fragment UserFields on User {
+	id
+	name
+	username
+	email
+	universalReader
+	universalWriter
+}
+`
+
+type SSHPublicKeysVariables struct {
+	Username    *string `json:"username,omitempty"`
+	Fingerprint *string `json:"fingerprint,omitempty"`
+}
+
+type SSHPublicKeysResponse struct {
+	Sshpublickeys []SSHPublicKeysResponseSshpublickeys `json:"sshpublickeys"`
+}
+
+type SSHPublicKeysResponseSshpublickeys struct {
+	Owner       UserFields `json:"owner"`
+	Name        string     `json:"name"`
+	Type        string     `json:"type"`
+	Key         string     `json:"key"`
+	Fingerprint string     `json:"fingerprint"`
+	Comment     string     `json:"comment"`
+}
+
+// SSHPublicKeys sends the SSHPublicKeys query
+func SSHPublicKeys(ctx context.Context, client graphql.Requester, variables SSHPublicKeysVariables) (*SSHPublicKeysResponse, error) {
+	var response SSHPublicKeysResponse
+	err := graphql.Request(ctx, client, graphql.NewQuery(sshPublicKeysQuery).SetVariablesStruct(variables), &response)
+
+	return &response, err
+}
+
+const createSSHPublicKeyMutation = `
+mutation CreateSSHPublicKey($key: SSHPublicKeyInputCreate!) {
+	createSSHPublicKey(key: $key) {
+		owner {
+			...UserFields
+		}
+		name
+		type
+		key
+		fingerprint
+		comment
+	}
+}
+
+fragment UserFields on User {
+	id
+	name
+	username
+	email
+	universalReader
+	universalWriter
+}
+`
+
+type CreateSSHPublicKeyVariables struct {
+	Key SSHPublicKeyInputCreate `json:"key"`
+}
+
+type CreateSSHPublicKeyResponse struct {
+	CreateSSHPublicKey CreateSSHPublicKeyResponseCreateSSHPublicKey `json:"createSSHPublicKey"`
+}
+
+type CreateSSHPublicKeyResponseCreateSSHPublicKey struct {
+	Owner       UserFields `json:"owner"`
+	Name        string     `json:"name"`
+	Type        string     `json:"type"`
+	Key         string     `json:"key"`
+	Fingerprint string     `json:"fingerprint"`
+	Comment     string     `json:"comment"`
+}
+
+// CreateSSHPublicKey sends the CreateSSHPublicKey mutation
+func CreateSSHPublicKey(ctx context.Context, client graphql.Requester, variables CreateSSHPublicKeyVariables) (*CreateSSHPublicKeyResponse, error) {
+	var response CreateSSHPublicKeyResponse
+	err := graphql.Request(ctx, client, graphql.NewQuery(createSSHPublicKeyMutation).SetVariablesStruct(variables), &response)
+
+	return &response, err
+}
+
+const gamesQuery = `
+query Games($gameid: String = null) {
+	games(id: $gameid) {
+		id
+		launchedAt
+		endedAt
+		runStatus
+		tps
+		arena {
+			id
+			name
+			kind
+			maxContestants
+		}
+		contestants {
+			id
+			agent {
+				id
+				name
+				owner {
+					id
+					name
+					username
+				}
+				image {
+					name
+					tag
+					registry
+				}
+			}
+		}
+	}
+}
+`
+
+type GamesVariables struct {
+	Gameid *string `json:"gameid,omitempty"`
+}
+
+type GamesResponse struct {
+	Games []GamesResponseGames `json:"games"`
+}
+
+type GamesResponseGames struct {
+	Id          string                          `json:"id"`
+	LaunchedAt  string                          `json:"launchedAt"`
+	EndedAt     string                          `json:"endedAt"`
+	RunStatus   int                             `json:"runStatus"`
+	Tps         int                             `json:"tps"`
+	Arena       GamesResponseGamesArena         `json:"arena"`
+	Contestants []GamesResponseGamesContestants `json:"contestants"`
+}
+
+type GamesResponseGamesArena struct {
+	Id             string `json:"id"`
+	Name           string `json:"name"`
+	Kind           string `json:"kind"`
+	MaxContestants int    `json:"maxContestants"`
+}
+
+type GamesResponseGamesContestants struct {
+	Id    string                             `json:"id"`
+	Agent GamesResponseGamesContestantsAgent `json:"agent"`
+}
+
+type GamesResponseGamesContestantsAgent struct {
+	Id    string                                  `json:"id"`
+	Name  string                                  `json:"name"`
+	Owner GamesResponseGamesContestantsAgentOwner `json:"owner"`
+	Image GamesResponseGamesContestantsAgentImage `json:"image"`
+}
+
+type GamesResponseGamesContestantsAgentOwner struct {
+	Id       string `json:"id"`
+	Name     string `json:"name"`
+	Username string `json:"username"`
+}
+
+type GamesResponseGamesContestantsAgentImage struct {
+	Name     string `json:"name"`
+	Tag      string `json:"tag"`
+	Registry string `json:"registry"`
+}
+
+// Games sends the Games query
+func Games(ctx context.Context, client graphql.Requester, variables GamesVariables) (*GamesResponse, error) {
+	var response GamesResponse
+	err := graphql.Request(ctx, client, graphql.NewQuery(gamesQuery).SetVariablesStruct(variables), &response)
+
+	return &response, err
+}
+
+const gameResourcesQuery = `
+query GameResources($gameid: String = null) {
+	games(id: $gameid) {
+		arena {
+			maxContestants
+		}
+		contestants {
+			id
+		}
+	}
+}
+`
+
+type GameResourcesVariables struct {
+	Gameid *string `json:"gameid,omitempty"`
+}
+
+type GameResourcesResponse struct {
+	Games []GameResourcesResponseGames `json:"games"`
+}
+
+type GameResourcesResponseGames struct {
+	Arena       GameResourcesResponseGamesArena         `json:"arena"`
+	Contestants []GameResourcesResponseGamesContestants `json:"contestants"`
+}
+
+type GameResourcesResponseGamesArena struct {
+	MaxContestants int `json:"maxContestants"`
+}
+
+type GameResourcesResponseGamesContestants struct {
+	Id string `json:"id"`
+}
+
+// GameResources sends the GameResources query
+func GameResources(ctx context.Context, client graphql.Requester, variables GameResourcesVariables) (*GameResourcesResponse, error) {
+	var response GameResourcesResponse
+	err := graphql.Request(ctx, client, graphql.NewQuery(gameResourcesQuery).SetVariablesStruct(variables), &response)
+
+	return &response, err
+}
+
+const updateGameMutation = `
+mutation UpdateGame($id: String, $game: GameInputUpdate!) {
+	updateGame(id: $id, game: $game) {
+		id
+		runStatus
+	}
+}
+`
+
+type UpdateGameVariables struct {
+	Id   *string         `json:"id,omitempty"`
+	Game GameInputUpdate `json:"game"`
+}
+
+type UpdateGameResponse struct {
+	UpdateGame UpdateGameResponseUpdateGame `json:"updateGame"`
+}
+
+type UpdateGameResponseUpdateGame struct {
+	Id        string `json:"id"`
+	RunStatus int    `json:"runStatus"`
+}
+
+// UpdateGame sends the UpdateGame mutation
+func UpdateGame(ctx context.Context, client graphql.Requester, variables UpdateGameVariables) (*UpdateGameResponse, error) {
+	var response UpdateGameResponse
+	err := graphql.Request(ctx, client, graphql.NewQuery(updateGameMutation).SetVariablesStruct(variables), &response)
+
+	return &response, err
+}
+
+type AgentDeploymentInputCreate struct {
+	AgentId       string  `json:"agentId"`
+	PushedAt      *string `json:"pushedAt,omitempty"`
+	CommitSHA1    *string `json:"commitSHA1,omitempty"`
+	CommitMessage *string `json:"commitMessage,omitempty"`
+	BuildStatus   *int    `json:"buildStatus,omitempty"`
+}
+
+type AgentDeploymentInputUpdate struct {
+	BuildStatus    *int    `json:"buildStatus,omitempty"`
+	BuildError     *bool   `json:"buildError,omitempty"`
+	BuildStartedAt *string `json:"buildStartedAt,omitempty"`
+	BuildEndedAt   *string `json:"buildEndedAt,omitempty"`
+	BuildLogId     *string `json:"buildLogId,omitempty"`
+}
+
+type SSHPublicKeyInputCreate struct {
+	Ownerid     string  `json:"ownerid"`
+	Name        *string `json:"name,omitempty"`
+	Type        *string `json:"type,omitempty"`
+	Key         *string `json:"key,omitempty"`
+	Fingerprint *string `json:"fingerprint,omitempty"`
+	Comment     *string `json:"comment,omitempty"`
+}
+
+type GameInputUpdate struct {
+	RunStatus       *int    `json:"runStatus,omitempty"`
+	LaunchedAt      *string `json:"launchedAt,omitempty"`
+	EndedAt         *string `json:"endedAt,omitempty"`
+	ArenaServerUUID *string `json:"arenaServerUUID,omitempty"`
+}
@@ -0,0 +1,148 @@
+package graphql
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// Tokens are renewed that long before they expire
+	TOKEN_EXPIRY_MARGIN = 30 * time.Second
+
+	// Token files are read again after that long, to follow their rotation
+	TOKEN_FILE_TTL = time.Minute
+)
+
+// Authenticator sets the credentials of the requests to the GraphQL server
+type Authenticator interface {
+	Authenticate(req *http.Request) error
+}
+
+// invalidator is implemented by the authenticators whose credentials can be
+// renewed, it's called when the server refuses them
+type invalidator interface {
+	Invalidate()
+}
+
+// BearerToken is a static token
+type BearerToken string
+
+func (token BearerToken) Authenticate(req *http.Request) error {
+	req.Header.Set("Authorization", "Bearer "+string(token))
+	return nil
+}
+
+// TokenSource returns a token and when it expires, the zero time if it
+// doesn't
+type TokenSource func(ctx context.Context) (string, time.Time, error)
+
+// RefreshingToken gets a token from its source the first time it's needed,
+// then again when it expires or is refused by the server
+type RefreshingToken struct {
+	source TokenSource
+
+	mutex  sync.Mutex
+	token  string
+	expiry time.Time
+}
+
+func NewRefreshingToken(source TokenSource) *RefreshingToken {
+	return &RefreshingToken{
+		source: source,
+	}
+}
+
+func (t *RefreshingToken) Authenticate(req *http.Request) error {
+	token, err := t.get(req.Context())
+
+	if err != nil {
+		return err
+	}
+
+	return BearerToken(token).Authenticate(req)
+}
+
+func (t *RefreshingToken) get(ctx context.Context) (string, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.token != "" && (t.expiry.IsZero() || time.Now().Add(TOKEN_EXPIRY_MARGIN).Before(t.expiry)) {
+		return t.token, nil
+	}
+
+	token, expiry, err := t.source(ctx)
+
+	if err != nil {
+		return "", errors.New("Could not refresh token: " + err.Error())
+	}
+
+	if token == "" {
+		return "", errors.New("Could not refresh token: the token is empty")
+	}
+
+	t.token = token
+	t.expiry = expiry
+
+	return t.token, nil
+}
+
+func (t *RefreshingToken) Invalidate() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.token = ""
+}
+
+// TokenFile reads the token in a file, again after TOKEN_FILE_TTL
+func TokenFile(path string) TokenSource {
+	return func(ctx context.Context) (string, time.Time, error) {
+		content, err := ioutil.ReadFile(path)
+
+		if err != nil {
+			return "", time.Time{}, err
+		}
+
+		// The margin would make it expire right away
+		return strings.TrimSpace(string(content)), time.Now().Add(TOKEN_FILE_TTL + TOKEN_EXPIRY_MARGIN), nil
+	}
+}
+
+// MakeAuthenticator returns the authenticator of a static token or of a token
+// file, nil when neither is set
+func MakeAuthenticator(token, tokenFile string) (Authenticator, error) {
+	if token != "" && tokenFile != "" {
+		return nil, errors.New("Only one of the token and the token file can be set")
+	}
+
+	if token != "" {
+		return BearerToken(token), nil
+	}
+
+	if tokenFile != "" {
+		if _, err := os.Stat(tokenFile); err != nil {
+			return nil, errors.New("Invalid token file: " + err.Error())
+		}
+
+		return NewRefreshingToken(TokenFile(tokenFile)), nil
+	}
+
+	return nil, nil
+}
+
+// AuthenticatorFromEnv reads the token of the service in GRAPHQL_TOKEN or in
+// the file of GRAPHQL_TOKEN_FILE. It returns nil when neither is set.
+func AuthenticatorFromEnv() (Authenticator, error) {
+	auth, err := MakeAuthenticator(os.Getenv("GRAPHQL_TOKEN"), os.Getenv("GRAPHQL_TOKEN_FILE"))
+
+	if err != nil {
+		return nil, errors.New("GRAPHQL_TOKEN: " + err.Error())
+	}
+
+	return auth, nil
+}
@@ -0,0 +1,163 @@
+package graphql
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// tokenServer accepts the requests with the valid token
+type tokenServer struct {
+	mutex      sync.Mutex
+	validToken string
+	received   []string
+}
+
+func (s *tokenServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.received = append(s.received, r.Header.Get("Authorization"))
+
+	if r.Header.Get("Authorization") != "Bearer "+s.validToken {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"errors": [{"message": "Invalid token"}]}`))
+		return
+	}
+
+	w.Write([]byte(`{"data": {}}`))
+}
+
+func (s *tokenServer) setValidToken(token string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.validToken = token
+}
+
+func (s *tokenServer) receivedHeaders() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return append([]string{}, s.received...)
+}
+
+func TestBearerToken(t *testing.T) {
+	handler := &tokenServer{validToken: "secret"}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	_, err := NewClient(server.URL).SetAuthenticator(BearerToken("secret")).RequestSync(NewQuery("mutation { updateGame { id } }"))
+	assert.Nil(t, err)
+
+	_, err = NewClient(server.URL).SetAuthenticator(BearerToken("wrong")).RequestSync(NewQuery("query { games { id } }"))
+	assert.EqualError(t, err, "GraphQL server emitted an HTTP status code 401; GraphQL errors: Invalid token")
+
+	// Not retried, the token can't change
+	assert.Equal(t, []string{"Bearer secret", "Bearer wrong"}, handler.receivedHeaders())
+
+	assert.Nil(t, NewClient(server.URL+"/graphql").SetAuthenticator(BearerToken("secret")).Ping())
+}
+
+func TestRefreshingToken(t *testing.T) {
+	handler := &tokenServer{validToken: "token-1"}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	var refreshes int
+
+	auth := NewRefreshingToken(func(ctx context.Context) (string, time.Time, error) {
+		refreshes++
+		return "token-" + strconv.Itoa(refreshes), time.Time{}, nil
+	})
+
+	client := NewClient(server.URL).SetAuthenticator(auth)
+
+	_, err := client.RequestSync(NewQuery("query { games { id } }"))
+	assert.Nil(t, err)
+
+	_, err = client.RequestSync(NewQuery("query { games { id } }"))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, refreshes)
+
+	// Renewed once when refused, mutations included
+	handler.setValidToken("token-2")
+
+	_, err = client.RequestSync(NewQuery("mutation { updateGame { id } }"))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, refreshes)
+
+	handler.setValidToken("never")
+
+	_, err = client.RequestSync(NewQuery("mutation { updateGame { id } }"))
+	assert.Error(t, err)
+	assert.Equal(t, 3, refreshes)
+
+	assert.Equal(t, []string{
+		"Bearer token-1",
+		"Bearer token-1",
+		"Bearer token-1",
+		"Bearer token-2",
+		"Bearer token-2",
+		"Bearer token-3",
+	}, handler.receivedHeaders())
+}
+
+func TestRefreshingTokenExpiry(t *testing.T) {
+	var refreshes int
+
+	auth := NewRefreshingToken(func(ctx context.Context) (string, time.Time, error) {
+		refreshes++
+		return "token", time.Now().Add(TOKEN_EXPIRY_MARGIN + 20*time.Millisecond), nil
+	})
+
+	req, _ := http.NewRequest("POST", "http://graphql", nil)
+
+	assert.Nil(t, auth.Authenticate(req))
+	assert.Nil(t, auth.Authenticate(req))
+	assert.Equal(t, 1, refreshes)
+
+	time.Sleep(30 * time.Millisecond)
+
+	assert.Nil(t, auth.Authenticate(req))
+	assert.Equal(t, 2, refreshes)
+	assert.Equal(t, "Bearer token", req.Header.Get("Authorization"))
+}
+
+func TestMakeAuthenticator(t *testing.T) {
+	auth, err := MakeAuthenticator("", "")
+	assert.Nil(t, err)
+	assert.Nil(t, auth)
+
+	auth, err = MakeAuthenticator("secret", "")
+	assert.Nil(t, err)
+	assert.Equal(t, BearerToken("secret"), auth)
+
+	_, err = MakeAuthenticator("secret", "/etc/token")
+	assert.Error(t, err)
+
+	_, err = MakeAuthenticator("", "/does/not/exist")
+	assert.Error(t, err)
+
+	file, err := ioutil.TempFile("", "graphql-token")
+	assert.Nil(t, err)
+	defer os.Remove(file.Name())
+
+	file.WriteString("from-file\n")
+	file.Close()
+
+	auth, err = MakeAuthenticator("", file.Name())
+	assert.Nil(t, err)
+
+	req, _ := http.NewRequest("POST", "http://graphql", nil)
+	assert.Nil(t, auth.Authenticate(req))
+	assert.Equal(t, "Bearer from-file", req.Header.Get("Authorization"))
+}
@@ -1,34 +1,112 @@
 package graphql
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"io/ioutil"
 	"net/http"
-	"net/url"
 	"strconv"
 	"strings"
+	"time"
+)
+
+var (
+	DEFAULT_TIMEOUT = 10 * time.Second
+
+	// Only queries are retried, mutations could be applied twice
+	DEFAULT_RETRIES = 2
+	DEFAULT_BACKOFF = 500 * time.Millisecond
 )
 
 type Client struct {
-	url string
+	url        string
+	httpclient *http.Client
+	retries    int
+	backoff    time.Duration
+	auth       Authenticator
 }
 
 func MakeClient(url string) Client {
 	return Client{
 		url: url,
+		httpclient: &http.Client{
+			Timeout: DEFAULT_TIMEOUT,
+		},
+		retries: DEFAULT_RETRIES,
+		backoff: DEFAULT_BACKOFF,
 	}
 }
 
 func NewClient(url string) *Client {
-	return &Client{
-		url: url,
+	client := MakeClient(url)
+	return &client
+}
+
+// SetTimeout limits the duration of each attempt of a request, 0 for no limit
+func (client *Client) SetTimeout(timeout time.Duration) *Client {
+	client.httpclient = &http.Client{
+		Timeout: timeout,
 	}
+
+	return client
+}
+
+// SetRetries sets how many times a failed query is attempted again, the
+// delay doubles after each attempt
+func (client *Client) SetRetries(retries int, backoff time.Duration) *Client {
+	client.retries = retries
+	client.backoff = backoff
+
+	return client
+}
+
+// SetAuthenticator sets the credentials of the requests, nil for none
+func (client *Client) SetAuthenticator(auth Authenticator) *Client {
+	client.auth = auth
+
+	return client
 }
 
 type graphqlwrapper struct {
 	Data   json.RawMessage `json:"data"`
-	Errors json.RawMessage `json:"errors"`
+	Errors []GraphQLError  `json:"errors"`
+}
+
+type graphqlrequest struct {
+	Query     string      `json:"query"`
+	Variables interface{} `json:"variables,omitempty"`
+}
+
+type GraphQLErrorLocation struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+// GraphQLError is an error reported by the GraphQL server
+type GraphQLError struct {
+	Message   string                 `json:"message"`
+	Locations []GraphQLErrorLocation `json:"locations,omitempty"`
+	Path      []interface{}          `json:"path,omitempty"`
+}
+
+func (e GraphQLError) Error() string {
+	return e.Message
+}
+
+// GraphQLErrors is returned when the response contains errors, the data can
+// still be partially set
+type GraphQLErrors []GraphQLError
+
+func (errs GraphQLErrors) Error() string {
+	messages := make([]string, len(errs))
+
+	for i, err := range errs {
+		messages[i] = err.Message
+	}
+
+	return "GraphQL errors: " + strings.Join(messages, "; ")
 }
 
 type Response struct {
@@ -40,7 +118,7 @@ type Variables map[string]interface{}
 
 type Query struct {
 	query     string
-	variables Variables
+	variables interface{}
 }
 
 func NewQuery(query string) *Query {
@@ -54,84 +132,252 @@ func (q *Query) SetVariables(variables Variables) *Query {
 	return q
 }
 
+// SetVariablesStruct sets variables encoded as a JSON object, like the ones
+// of the generated operations
+func (q *Query) SetVariablesStruct(variables interface{}) *Query {
+	q.variables = variables
+	return q
+}
+
 func (q *Query) HasVariables() bool {
-	return q.variables != nil && len(q.variables) > 0
+	if variables, isMap := q.variables.(Variables); isMap {
+		return len(variables) > 0
+	}
+
+	return q.variables != nil
+}
+
+// IsMutation tells if the query is a mutation, which isn't retried
+func (q *Query) IsMutation() bool {
+	return strings.HasPrefix(strings.TrimSpace(q.query), "mutation")
+}
+
+// Requester sends queries, it's implemented by Client
+type Requester interface {
+	RequestContext(ctx context.Context, query *Query) (json.RawMessage, error)
+}
+
+// Request decodes the data of the response of the query, even if it's
+// returned with GraphQLErrors
+func Request(ctx context.Context, client Requester, query *Query, response interface{}) error {
+	data, err := client.RequestContext(ctx, query)
+
+	if len(data) > 0 && string(data) != "null" {
+		if decodeErr := json.Unmarshal(data, response); decodeErr != nil && err == nil {
+			err = errors.New("Could not decode GraphQL response: " + decodeErr.Error())
+		}
+	}
+
+	return err
 }
 
 func (client Client) RequestSync(query *Query) (json.RawMessage, error) {
-	future := client.RequestAsync(query)
-	resp := <-future
-	return resp.Body, resp.Error
+	return client.RequestContext(context.Background(), query)
 }
 
 func (client Client) RequestAsync(query *Query) <-chan Response {
 	c := make(chan Response, 1)
 
 	go func() {
-		form := url.Values{}
-		form.Add("query", query.query)
-
-		if query.HasVariables() {
-			jsonvariables, err := json.Marshal(query.variables)
-			if err != nil {
-				c <- Response{Error: err}
-			}
-			form.Add("variables", string(jsonvariables))
+		body, err := client.RequestContext(context.Background(), query)
+		c <- Response{Body: body, Error: err}
+	}()
+
+	return c
+}
+
+// RequestContext sends the query until it succeeds, it isn't retriable or the
+// context is done. The data is returned along GraphQLErrors if the response
+// contains errors.
+func (client Client) RequestContext(ctx context.Context, query *Query) (json.RawMessage, error) {
+	request := graphqlrequest{Query: query.query}
+
+	if query.HasVariables() {
+		request.Variables = query.variables
+	}
+
+	payload, err := json.Marshal(request)
+	if err != nil {
+		return nil, errors.New("Could not encode GraphQL query: " + err.Error())
+	}
+
+	retries := client.retries
+	if query.IsMutation() {
+		retries = 0
+	}
+
+	backoff := client.backoff
+	reauthenticated := false
+
+	for attempt := 0; ; attempt++ {
+		data, retriable, err := client.send(ctx, payload)
+
+		// The refused request wasn't applied, even a mutation can be sent again
+		// with renewed credentials
+		status, isStatus := err.(statusError)
+
+		if isStatus && status.code == http.StatusUnauthorized && !reauthenticated && client.invalidateAuth() {
+			reauthenticated = true
+			attempt--
+			continue
 		}
 
-		req, err := http.NewRequest("POST", client.url, strings.NewReader(form.Encode()))
-		if err != nil {
-			c <- Response{Error: err}
+		if err == nil || !retriable || attempt >= retries {
+			return data, err
 		}
-		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
 
-		httpclient := &http.Client{}
-		resp, err := httpclient.Do(req)
-		if err != nil {
-			c <- Response{Error: err}
-			return
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, ctx.Err()
 		}
 
-		// defered after err because if err, resp is nil
-		defer resp.Body.Close()
+		backoff *= 2
+	}
+}
+
+// send returns whether the request can be attempted again on error
+func (client Client) send(ctx context.Context, payload []byte) (json.RawMessage, bool, error) {
+	req, err := http.NewRequest("POST", client.url, bytes.NewReader(payload))
+	if err != nil {
+		return nil, false, err
+	}
 
-		body, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			c <- Response{Error: err}
-			return
-		}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
 
-		var message graphqlwrapper
+	if err := client.authenticate(req); err != nil {
+		return nil, false, err
+	}
 
-		err = json.Unmarshal(body, &message)
-		if err != nil {
-			c <- Response{Error: errors.New("Could not understand response of graphql server")}
-			return
-		}
+	resp, err := client.getHTTPClient().Do(req)
+	if err != nil {
+		return nil, ctx.Err() == nil, err
+	}
 
+	// defered after err because if err, resp is nil
+	defer resp.Body.Close()
+
+	// Read entirely for the connection to be reused
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, ctx.Err() == nil, err
+	}
+
+	retriable := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+
+	var message graphqlwrapper
+
+	err = json.Unmarshal(body, &message)
+	if err != nil {
 		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-			c <- Response{Error: errors.New("GraphQL server emitted an HTTP status code " + strconv.Itoa(resp.StatusCode) + "; " + string(message.Errors))}
-			return
+			return nil, retriable, statusError{code: resp.StatusCode}
 		}
 
-		c <- Response{Body: message.Data}
-	}()
+		return nil, false, errors.New("Could not understand response of graphql server")
+	}
 
-	return c
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		details := ""
+
+		if len(message.Errors) > 0 {
+			details = GraphQLErrors(message.Errors).Error()
+		}
+
+		return nil, retriable, statusError{code: resp.StatusCode, details: details}
+	}
+
+	if len(message.Errors) > 0 {
+		return message.Data, false, GraphQLErrors(message.Errors)
+	}
+
+	return message.Data, false, nil
+}
+
+// statusError is returned when the server responds with an HTTP error
+type statusError struct {
+	code    int
+	details string
+}
+
+func (e statusError) Error() string {
+	msg := "GraphQL server emitted an HTTP status code " + strconv.Itoa(e.code)
+
+	if e.details != "" {
+		msg += "; " + e.details
+	}
+
+	return msg
+}
+
+func (client Client) authenticate(req *http.Request) error {
+	if client.auth == nil {
+		return nil
+	}
+
+	if err := client.auth.Authenticate(req); err != nil {
+		return errors.New("Could not authenticate GraphQL request: " + err.Error())
+	}
+
+	return nil
+}
+
+// invalidateAuth returns false if the credentials can't be renewed
+func (client Client) invalidateAuth() bool {
+	auth, canInvalidate := client.auth.(invalidator)
+
+	if canInvalidate {
+		auth.Invalidate()
+	}
+
+	return canInvalidate
+}
+
+// The zero value of Client has no http.Client
+func (client Client) getHTTPClient() *http.Client {
+	if client.httpclient == nil {
+		return http.DefaultClient
+	}
+
+	return client.httpclient
 }
 
 func (client Client) Ping() error {
-	resp, err := http.Get(client.url + "/schema")
+	_, err := client.FetchSchema(context.Background())
+	return err
+}
 
+// FetchSchema returns the schema served by the GraphQL server, in the GraphQL
+// schema language
+func (client Client) FetchSchema(ctx context.Context) ([]byte, error) {
+	req, err := http.NewRequest("GET", client.url+"/schema", nil)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	req = req.WithContext(ctx)
+
+	if err := client.authenticate(req); err != nil {
+		return nil, err
+	}
+
+	resp, err := client.getHTTPClient().Do(req)
+
+	if err != nil {
+		return nil, err
 	}
 
 	defer resp.Body.Close()
 
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
 	if resp.StatusCode != 200 {
-		return errors.New("HTTP error, status " + strconv.Itoa(resp.StatusCode))
+		return nil, errors.New("HTTP error, status " + strconv.Itoa(resp.StatusCode))
 	}
 
-	return nil
+	return body, nil
 }
@@ -0,0 +1,103 @@
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestContext(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+
+		var request struct {
+			Query     string    `json:"query"`
+			Variables Variables `json:"variables"`
+		}
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&request))
+		assert.Equal(t, "query { games { id } }", request.Query)
+		assert.Equal(t, Variables{"gameid": "42"}, request.Variables)
+
+		w.Write([]byte(`{"data": {"games": [{"id": "42"}]}}`))
+	}))
+	defer server.Close()
+
+	data, err := NewClient(server.URL).RequestContext(
+		context.Background(),
+		NewQuery("query { games { id } }").SetVariables(Variables{"gameid": "42"}),
+	)
+
+	assert.Nil(t, err)
+	assert.Equal(t, `{"games": [{"id": "42"}]}`, string(data))
+}
+
+func TestRequestErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"data": {"games": null}, "errors": [{"message": "Unknown game", "locations": [{"line": 2, "column": 3}], "path": ["games", 0]}]}`))
+	}))
+	defer server.Close()
+
+	data, err := NewClient(server.URL).RequestSync(NewQuery("query { games { id } }"))
+
+	assert.Equal(t, `{"games": null}`, string(data))
+	assert.Equal(t, GraphQLErrors{{
+		Message:   "Unknown game",
+		Locations: []GraphQLErrorLocation{{Line: 2, Column: 3}},
+		Path:      []interface{}{"games", float64(0)},
+	}}, err)
+	assert.EqualError(t, err, "GraphQL errors: Unknown game")
+}
+
+func TestRequestRetries(t *testing.T) {
+	var attempts int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&attempts, 1) < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+
+		w.Write([]byte(`{"data": {}}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL).SetRetries(2, time.Millisecond)
+
+	_, err := client.RequestSync(NewQuery("query { games { id } }"))
+	assert.Nil(t, err)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&attempts))
+
+	// Mutations are not retried
+	atomic.StoreInt32(&attempts, 0)
+
+	_, err = client.RequestSync(NewQuery("mutation { updateGame(id: \"42\") { id } }"))
+	assert.EqualError(t, err, "GraphQL server emitted an HTTP status code 502")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&attempts))
+}
+
+func TestRequestTimeout(t *testing.T) {
+	release := make(chan struct{})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	client := NewClient(server.URL).SetRetries(0, 0)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	_, err := client.RequestContext(ctx, NewQuery("query { games { id } }"))
+	assert.Error(t, err)
+
+	_, err = client.SetTimeout(20 * time.Millisecond).RequestSync(NewQuery("query { games { id } }"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,285 @@
+package codegen
+
+import (
+	"errors"
+	"strconv"
+)
+
+// Selection is a field, or the spread of a fragment
+type Selection struct {
+	Alias      string
+	Name       string
+	Args       []Argument
+	Selections []*Selection
+	Fragment   string
+
+	line int
+}
+
+// Key is the name of the field in the response
+func (s *Selection) Key() string {
+	if s.Alias != "" {
+		return s.Alias
+	}
+
+	return s.Name
+}
+
+type VariableDef struct {
+	Name    string
+	Type    *TypeRef
+	Default *Value
+}
+
+type Operation struct {
+	Kind       string
+	Name       string
+	Variables  []*VariableDef
+	Selections []*Selection
+	Source     string
+
+	line int
+}
+
+type Fragment struct {
+	Name       string
+	On         string
+	Selections []*Selection
+	Source     string
+
+	line int
+}
+
+// Document holds the operations and fragments of one or several files, the
+// fragments can be used by the operations of the other files
+type Document struct {
+	Operations []*Operation
+	Fragments  map[string]*Fragment
+}
+
+func NewDocument() *Document {
+	return &Document{
+		Operations: make([]*Operation, 0),
+		Fragments:  make(map[string]*Fragment),
+	}
+}
+
+// Parse adds the definitions of the source, the operations must be named
+func (d *Document) Parse(src string) error {
+	l, err := newLexer(src)
+	if err != nil {
+		return err
+	}
+
+	for l.peek().kind != tokenEOF {
+		start := l.peek().start
+		line := l.peek().line
+
+		keyword, err := l.expectName()
+		if err != nil {
+			return err
+		}
+
+		switch keyword {
+		case "query", "mutation":
+			operation, err := parseOperation(l, keyword)
+			if err != nil {
+				return err
+			}
+
+			operation.Source = src[start:l.end]
+			operation.line = line
+
+			for _, other := range d.Operations {
+				if other.Name == operation.Name {
+					return errors.New("line " + strconv.Itoa(line) + ": operation " + operation.Name + " is defined twice")
+				}
+			}
+
+			d.Operations = append(d.Operations, operation)
+
+		case "fragment":
+			fragment, err := parseFragment(l)
+			if err != nil {
+				return err
+			}
+
+			fragment.Source = src[start:l.end]
+			fragment.line = line
+
+			if _, exists := d.Fragments[fragment.Name]; exists {
+				return errors.New("line " + strconv.Itoa(line) + ": fragment " + fragment.Name + " is defined twice")
+			}
+
+			d.Fragments[fragment.Name] = fragment
+
+		default:
+			return errors.New("line " + strconv.Itoa(line) + ": unsupported definition \"" + keyword + "\"")
+		}
+	}
+
+	return l.err
+}
+
+func parseOperation(l *lexer, kind string) (*Operation, error) {
+	if l.peek().kind != tokenName {
+		return nil, l.errorf("the " + kind + " must be named, the name of its function")
+	}
+
+	name, _ := l.expectName()
+	operation := &Operation{Kind: kind, Name: name, Variables: make([]*VariableDef, 0)}
+
+	if opened, err := l.skip("("); err != nil {
+		return nil, err
+	} else if opened {
+		for !l.is(")") {
+			variable, err := parseVariableDef(l)
+			if err != nil {
+				return nil, err
+			}
+
+			operation.Variables = append(operation.Variables, variable)
+		}
+
+		if err := l.expect(")"); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := skipDirectives(l); err != nil {
+		return nil, err
+	}
+
+	selections, err := parseSelectionSet(l)
+	operation.Selections = selections
+
+	return operation, err
+}
+
+func parseVariableDef(l *lexer) (*VariableDef, error) {
+	if err := l.expect("$"); err != nil {
+		return nil, err
+	}
+
+	name, err := l.expectName()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := l.expect(":"); err != nil {
+		return nil, err
+	}
+
+	variable := &VariableDef{Name: name}
+
+	if variable.Type, err = parseTypeRef(l); err != nil {
+		return nil, err
+	}
+
+	if hasDefault, err := l.skip("="); err != nil {
+		return nil, err
+	} else if hasDefault {
+		if variable.Default, err = parseValue(l); err != nil {
+			return nil, err
+		}
+	}
+
+	return variable, skipDirectives(l)
+}
+
+func parseFragment(l *lexer) (*Fragment, error) {
+	name, err := l.expectName()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := l.expect("on"); err != nil {
+		return nil, err
+	}
+
+	on, err := l.expectName()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := skipDirectives(l); err != nil {
+		return nil, err
+	}
+
+	selections, err := parseSelectionSet(l)
+
+	return &Fragment{Name: name, On: on, Selections: selections}, err
+}
+
+func parseSelectionSet(l *lexer) ([]*Selection, error) {
+	if err := l.expect("{"); err != nil {
+		return nil, err
+	}
+
+	selections := make([]*Selection, 0)
+
+	for !l.is("}") {
+		selection, err := parseSelection(l)
+		if err != nil {
+			return nil, err
+		}
+
+		selections = append(selections, selection)
+	}
+
+	return selections, l.expect("}")
+}
+
+func parseSelection(l *lexer) (*Selection, error) {
+	selection := &Selection{line: l.peek().line}
+
+	if spread, err := l.skip("..."); err != nil {
+		return nil, err
+	} else if spread {
+		if l.is("on") || l.is("{") {
+			return nil, l.errorf("inline fragments are not supported")
+		}
+
+		selection.Fragment, err = l.expectName()
+		if err != nil {
+			return nil, err
+		}
+
+		return selection, skipDirectives(l)
+	}
+
+	name, err := l.expectName()
+	if err != nil {
+		return nil, err
+	}
+
+	if aliased, err := l.skip(":"); err != nil {
+		return nil, err
+	} else if aliased {
+		selection.Alias = name
+
+		if name, err = l.expectName(); err != nil {
+			return nil, err
+		}
+	}
+
+	selection.Name = name
+
+	if l.is("(") {
+		if selection.Args, err = parseArguments(l); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := skipDirectives(l); err != nil {
+		return nil, err
+	}
+
+	if l.is("{") {
+		if selection.Selections, err = parseSelectionSet(l); err != nil {
+			return nil, err
+		}
+	}
+
+	return selection, nil
+}
@@ -0,0 +1,521 @@
+package codegen
+
+import (
+	"bytes"
+	"errors"
+	"go/format"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const GRAPHQL_PACKAGE = "github.com/bytearena/backends/common/graphql"
+
+var GO_SCALARS = map[string]string{
+	"Int":     "int",
+	"Float":   "float64",
+	"String":  "string",
+	"Boolean": "bool",
+	"ID":      "string",
+}
+
+type generator struct {
+	schema *Schema
+	doc    *Document
+
+	decls    bytes.Buffer
+	declared map[string]bool
+
+	// Input types used by the variables, declared after the operations
+	inputs []string
+}
+
+// Generate returns the Go source of a function per operation of the document,
+// with the types of its variables and response. The operations are checked
+// against the schema.
+func Generate(schema *Schema, doc *Document, pkg string) ([]byte, error) {
+	g := &generator{
+		schema:   schema,
+		doc:      doc,
+		declared: make(map[string]bool),
+		inputs:   make([]string, 0),
+	}
+
+	for _, operation := range doc.Operations {
+		if err := g.operation(operation); err != nil {
+			return nil, errors.New(operation.Name + ": " + err.Error())
+		}
+	}
+
+	// Input types can reference other ones
+	for i := 0; i < len(g.inputs); i++ {
+		g.inputType(g.schema.Type(g.inputs[i]))
+	}
+
+	var src bytes.Buffer
+
+	src.WriteString("// Code generated by graphql-gen. DO NOT EDIT.\n\n")
+	src.WriteString("package " + pkg + "\n\n")
+
+	if len(doc.Operations) > 0 {
+		src.WriteString("import (\n\t\"context\"\n\n\t\"" + GRAPHQL_PACKAGE + "\"\n)\n\n")
+	}
+
+	src.Write(g.decls.Bytes())
+
+	res, err := format.Source(src.Bytes())
+	if err != nil {
+		return nil, errors.New("Invalid generated code: " + err.Error())
+	}
+
+	return res, nil
+}
+
+func (g *generator) declare(name string) error {
+	if g.declared[name] {
+		return errors.New("the name " + name + " would be declared twice, rename the operation or use an alias")
+	}
+
+	g.declared[name] = true
+
+	return nil
+}
+
+func (g *generator) operation(operation *Operation) error {
+	rootName := g.schema.Query
+	if operation.Kind == "mutation" {
+		rootName = g.schema.Mutation
+	}
+
+	root := g.schema.Type(rootName)
+	if root == nil {
+		return errors.New("the schema has no " + operation.Kind + " type")
+	}
+
+	if strings.Contains(operation.Source, "`") {
+		return errors.New("the " + operation.Kind + " can't contain backquotes")
+	}
+
+	variables := make(map[string]*VariableDef)
+
+	for _, variable := range operation.Variables {
+		def := g.schema.Type(variable.Type.NamedType())
+
+		if def == nil || !def.IsInput() {
+			return errors.New("invalid type " + variable.Type.String() + " of $" + variable.Name)
+		}
+
+		variables[variable.Name] = variable
+	}
+
+	scope := &selectionScope{
+		variables: variables,
+		used:      make(map[string]bool),
+		fragments: make(map[string]bool),
+	}
+
+	funcName := goName(operation.Name)
+
+	if err := g.declare(funcName); err != nil {
+		return err
+	}
+
+	var body bytes.Buffer
+
+	if err := g.structType(&body, funcName+"Response", root, operation.Selections, scope); err != nil {
+		return err
+	}
+
+	for _, variable := range operation.Variables {
+		if !scope.used[variable.Name] {
+			return errors.New("$" + variable.Name + " is not used")
+		}
+	}
+
+	source := operation.Source
+	fragments := make([]string, 0)
+
+	for name := range scope.fragments {
+		fragments = append(fragments, name)
+	}
+
+	sort.Strings(fragments)
+
+	for _, name := range fragments {
+		source += "\n\n" + g.doc.Fragments[name].Source
+	}
+
+	constName := unexported(operation.Name) + goName(operation.Kind)
+
+	g.decls.WriteString("const " + constName + " = `\n" + source + "\n`\n\n")
+
+	query := "graphql.NewQuery(" + constName + ")"
+	params := "ctx context.Context, client graphql.Requester"
+
+	if len(operation.Variables) > 0 {
+		if err := g.declare(funcName + "Variables"); err != nil {
+			return err
+		}
+
+		g.decls.WriteString("type " + funcName + "Variables struct {\n")
+
+		for _, variable := range operation.Variables {
+			g.decls.WriteString(g.inputField(variable.Name, variable.Type))
+		}
+
+		g.decls.WriteString("}\n\n")
+
+		query += ".SetVariablesStruct(variables)"
+		params += ", variables " + funcName + "Variables"
+	}
+
+	g.decls.Write(body.Bytes())
+
+	g.decls.WriteString("// " + funcName + " sends the " + operation.Name + " " + operation.Kind + "\n")
+	g.decls.WriteString("func " + funcName + "(" + params + ") (*" + funcName + "Response, error) {\n")
+	g.decls.WriteString("var response " + funcName + "Response\n")
+	g.decls.WriteString("err := graphql.Request(ctx, client, " + query + ", &response)\n\n")
+	g.decls.WriteString("return &response, err\n}\n\n")
+
+	return nil
+}
+
+type selectionScope struct {
+	variables map[string]*VariableDef
+
+	// The variables and fragments used by the operation
+	used      map[string]bool
+	fragments map[string]bool
+}
+
+// structType declares the struct of the selections on a type, the nested
+// objects are declared as name + field
+func (g *generator) structType(out *bytes.Buffer, name string, on *TypeDef, selections []*Selection, scope *selectionScope) error {
+	if err := g.declare(name); err != nil {
+		return err
+	}
+
+	fields, err := g.expand(on, selections, scope, make(map[string]bool))
+	if err != nil {
+		return err
+	}
+
+	var nested bytes.Buffer
+
+	out.WriteString("type " + name + " struct {\n")
+
+	for _, selection := range fields {
+		fieldType, err := g.fieldType(&nested, name, on, selection, scope)
+		if err != nil {
+			return err
+		}
+
+		out.WriteString(goName(selection.Key()) + " " + fieldType + " `json:\"" + selection.Key() + "\"`\n")
+	}
+
+	out.WriteString("}\n\n")
+	out.Write(nested.Bytes())
+
+	return nil
+}
+
+// expand replaces the fragment spreads by their fields and merges the fields
+// selected several times
+func (g *generator) expand(on *TypeDef, selections []*Selection, scope *selectionScope, expanding map[string]bool) ([]*Selection, error) {
+	fields := make([]*Selection, 0)
+	byKey := make(map[string]*Selection)
+
+	for _, selection := range selections {
+		expanded := []*Selection{selection}
+
+		if selection.Fragment != "" {
+			fragment, err := g.fragment(on, selection, scope, expanding)
+			if err != nil {
+				return nil, err
+			}
+
+			expanding[fragment.Name] = true
+			expanded, err = g.expand(on, fragment.Selections, scope, expanding)
+			delete(expanding, fragment.Name)
+
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		for _, field := range expanded {
+			existing, exists := byKey[field.Key()]
+
+			if !exists {
+				copied := *field
+				byKey[field.Key()] = &copied
+				fields = append(fields, &copied)
+				continue
+			}
+
+			if existing.Name != field.Name {
+				return nil, errors.New(lineOf(field) + "\"" + field.Key() + "\" selects both " + existing.Name + " and " + field.Name)
+			}
+
+			existing.Selections = append(append([]*Selection{}, existing.Selections...), field.Selections...)
+		}
+	}
+
+	return fields, nil
+}
+
+func (g *generator) fragment(on *TypeDef, spread *Selection, scope *selectionScope, expanding map[string]bool) (*Fragment, error) {
+	fragment, exists := g.doc.Fragments[spread.Fragment]
+
+	if !exists {
+		return nil, errors.New(lineOf(spread) + "unknown fragment " + spread.Fragment)
+	}
+
+	if fragment.On != on.Name {
+		return nil, errors.New(lineOf(spread) + "fragment " + fragment.Name + " is on " + fragment.On + ", not " + on.Name)
+	}
+
+	if expanding[fragment.Name] {
+		return nil, errors.New(lineOf(spread) + "fragment " + fragment.Name + " spreads itself")
+	}
+
+	scope.fragments[fragment.Name] = true
+
+	return fragment, nil
+}
+
+// fieldType checks the field and its arguments, and returns its Go type
+func (g *generator) fieldType(out *bytes.Buffer, parent string, on *TypeDef, selection *Selection, scope *selectionScope) (string, error) {
+	if selection.Name == "__typename" {
+		return "string", nil
+	}
+
+	field := on.Field(selection.Name)
+	if field == nil {
+		return "", errors.New(lineOf(selection) + "unknown field " + selection.Name + " on " + on.Name)
+	}
+
+	if err := g.checkArguments(field, selection, scope); err != nil {
+		return "", err
+	}
+
+	def := g.schema.Type(field.Type.NamedType())
+	goType := strings.Repeat("[]", listDepth(field.Type))
+
+	if !def.IsComposite() {
+		if len(selection.Selections) > 0 {
+			return "", errors.New(lineOf(selection) + on.Name + "." + field.Name + " has no fields to select")
+		}
+
+		return goType + scalarType(def), nil
+	}
+
+	if len(selection.Selections) == 0 {
+		return "", errors.New(lineOf(selection) + "the fields of " + on.Name + "." + field.Name + " must be selected")
+	}
+
+	// A selection of a single fragment is typed by the fragment
+	if len(selection.Selections) == 1 && selection.Selections[0].Fragment != "" {
+		fragment, err := g.fragment(def, selection.Selections[0], scope, make(map[string]bool))
+		if err != nil {
+			return "", err
+		}
+
+		typeName := goName(fragment.Name)
+
+		if g.declared[typeName] {
+			// Checked when declared, but by another operation
+			return goType + typeName, g.markUsed(fragment.Selections, scope)
+		}
+
+		if err := g.structType(out, typeName, def, fragment.Selections, scope); err != nil {
+			return "", err
+		}
+
+		return goType + typeName, nil
+	}
+
+	typeName := parent + goName(selection.Key())
+
+	if err := g.structType(out, typeName, def, selection.Selections, scope); err != nil {
+		return "", err
+	}
+
+	return goType + typeName, nil
+}
+
+// markUsed adds the fragments and variables of selections which were already
+// checked
+func (g *generator) markUsed(selections []*Selection, scope *selectionScope) error {
+	for _, selection := range selections {
+		if selection.Fragment != "" && !scope.fragments[selection.Fragment] {
+			scope.fragments[selection.Fragment] = true
+
+			if err := g.markUsed(g.doc.Fragments[selection.Fragment].Selections, scope); err != nil {
+				return err
+			}
+		}
+
+		for _, arg := range selection.Args {
+			for _, name := range arg.Value.Variables() {
+				if _, declared := scope.variables[name]; !declared {
+					return errors.New(lineOf(selection) + "$" + name + " is not declared")
+				}
+
+				scope.used[name] = true
+			}
+		}
+
+		if err := g.markUsed(selection.Selections, scope); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (g *generator) checkArguments(field *FieldDef, selection *Selection, scope *selectionScope) error {
+	passed := make(map[string]bool)
+
+	for _, arg := range selection.Args {
+		def := field.Arg(arg.Name)
+
+		if def == nil {
+			return errors.New(lineOf(selection) + "unknown argument " + arg.Name + " of " + field.Name)
+		}
+
+		passed[arg.Name] = true
+
+		// Variables passed as is are checked against the argument
+		if arg.Value.Variable != "" {
+			variable, declared := scope.variables[arg.Value.Variable]
+
+			if !declared {
+				return errors.New(lineOf(selection) + "$" + arg.Value.Variable + " is not declared")
+			}
+
+			if !isAssignable(variable, def.Type) {
+				return errors.New(lineOf(selection) + "$" + variable.Name + " of type " + variable.Type.String() + " is passed as " + arg.Name + " of type " + def.Type.String())
+			}
+		}
+
+		for _, name := range arg.Value.Variables() {
+			if _, declared := scope.variables[name]; !declared {
+				return errors.New(lineOf(selection) + "$" + name + " is not declared")
+			}
+
+			scope.used[name] = true
+		}
+	}
+
+	for _, def := range field.Args {
+		if def.Type.NonNull && !passed[def.Name] {
+			return errors.New(lineOf(selection) + "the argument " + def.Name + " of " + field.Name + " is required")
+		}
+	}
+
+	return nil
+}
+
+// isAssignable tells if the variable can be passed as an argument of that type
+func isAssignable(variable *VariableDef, to *TypeRef) bool {
+	from := variable.Type
+
+	if to.NonNull && !from.NonNull && variable.Default == nil {
+		return false
+	}
+
+	return sameType(from, to)
+}
+
+// The nullability of the elements of lists is not checked
+func sameType(from, to *TypeRef) bool {
+	if from.IsList() != to.IsList() {
+		return false
+	}
+
+	if from.IsList() {
+		return sameType(from.Elem, to.Elem)
+	}
+
+	return from.Name == to.Name
+}
+
+// inputField returns the struct field of a variable or of a field of an input
+// type, the nullable ones are omitted when they're not set
+func (g *generator) inputField(name string, ref *TypeRef) string {
+	def := g.schema.Type(ref.NamedType())
+	goType := scalarType(def)
+
+	if def.Kind == INPUT {
+		goType = goName(def.Name)
+
+		if !g.declared[goType] {
+			g.declared[goType] = true
+			g.inputs = append(g.inputs, def.Name)
+		}
+	}
+
+	goType = strings.Repeat("[]", listDepth(ref)) + goType
+	tag := name
+
+	if !ref.NonNull {
+		tag += ",omitempty"
+
+		if !ref.IsList() {
+			goType = "*" + goType
+		}
+	}
+
+	return goName(name) + " " + goType + " `json:\"" + tag + "\"`\n"
+}
+
+func (g *generator) inputType(def *TypeDef) {
+	g.decls.WriteString("type " + goName(def.Name) + " struct {\n")
+
+	for _, field := range def.Fields {
+		g.decls.WriteString(g.inputField(field.Name, field.Type))
+	}
+
+	g.decls.WriteString("}\n\n")
+}
+
+// The enums are strings, the custom scalars are decoded as JSON values
+func scalarType(def *TypeDef) string {
+	if goType, isBuiltin := GO_SCALARS[def.Name]; isBuiltin {
+		return goType
+	}
+
+	if def.Kind == ENUM {
+		return "string"
+	}
+
+	return "interface{}"
+}
+
+func listDepth(ref *TypeRef) int {
+	if ref.IsList() {
+		return 1 + listDepth(ref.Elem)
+	}
+
+	return 0
+}
+
+// unexported lowers the leading capitals, SSHPublicKeys gives sshPublicKeys
+func unexported(name string) string {
+	upper := 0
+
+	for upper < len(name) && name[upper] >= 'A' && name[upper] <= 'Z' {
+		upper++
+	}
+
+	if upper > 1 && upper < len(name) {
+		upper--
+	}
+
+	return strings.ToLower(name[:upper]) + name[upper:]
+}
+
+func lineOf(selection *Selection) string {
+	return "line " + strconv.Itoa(selection.line) + ": "
+}
@@ -0,0 +1,235 @@
+package codegen
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testSchema = `
+"""
+The root
+"""
+type Query {
+	games(id: String, status: [Int!]): [Game] @deprecated(reason: "none")
+	game(id: String!): Game
+}
+
+type Mutation {
+	updateGame(id: String!, game: GameInput!): Game
+}
+
+# Comments are ignored
+type Game implements Node & Named {
+	id: String!
+	tps: Int
+	ratio: Float
+	running: Boolean
+	kind: Kind
+	arena: Arena
+}
+
+type Arena {
+	name: String
+	tags: [String]
+}
+
+enum Kind {
+	DEATHMATCH
+	RACE
+}
+
+scalar Time
+
+input GameInput {
+	tps: Int = 10
+	kind: Kind
+	arena: ArenaInput
+}
+
+input ArenaInput {
+	name: String!
+}
+
+directive @deprecated(reason: String = "No longer supported") on FIELD_DEFINITION | ENUM_VALUE
+`
+
+func generate(t *testing.T, operations string) (string, error) {
+	schema, err := ParseSchema(testSchema)
+	assert.Nil(t, err)
+
+	doc := NewDocument()
+
+	if err := doc.Parse(operations); err != nil {
+		return "", err
+	}
+
+	src, err := Generate(schema, doc, "api")
+
+	return string(src), err
+}
+
+func TestGenerate(t *testing.T) {
+	src, err := generate(t, `
+fragment ArenaFields on Arena {
+	name
+}
+
+query Games($status: [Int!] = [1, 2]) {
+	games(status: $status) {
+		id
+		tps
+		...GameFields
+		arena {
+			...ArenaFields
+		}
+	}
+}
+
+fragment GameFields on Game {
+	id
+	running
+	kind
+}
+
+mutation UpdateGame($id: String!, $game: GameInput!) {
+	updated: updateGame(id: $id, game: $game) {
+		__typename
+		ratio
+	}
+}
+`)
+
+	assert.Nil(t, err)
+
+	expected := []string{
+		"// Code generated by graphql-gen. DO NOT EDIT.",
+		"type GamesVariables struct {\n\tStatus []int `json:\"status,omitempty\"`\n}",
+		"Games []GamesResponseGames `json:\"games\"`",
+		// Selected twice, declared once
+		"type GamesResponseGames struct {\n\tId      string      `json:\"id\"`\n\tTps     int         `json:\"tps\"`\n\tRunning bool        `json:\"running\"`\n\tKind    string      `json:\"kind\"`\n\tArena   ArenaFields `json:\"arena\"`\n}",
+		"type ArenaFields struct {\n\tName string `json:\"name\"`\n}",
+		"Updated UpdateGameResponseUpdated `json:\"updated\"`",
+		"Typename string  `json:\"__typename\"`",
+		"Id   string    `json:\"id\"`",
+		"Game GameInput `json:\"game\"`",
+		"Tps   *int        `json:\"tps,omitempty\"`",
+		"Arena *ArenaInput `json:\"arena,omitempty\"`",
+		"type ArenaInput struct {\n\tName string `json:\"name\"`\n}",
+		"func Games(ctx context.Context, client graphql.Requester, variables GamesVariables) (*GamesResponse, error) {",
+		"graphql.NewQuery(gamesQuery).SetVariablesStruct(variables)",
+	}
+
+	for _, declaration := range expected {
+		assert.Contains(t, src, declaration)
+	}
+
+	// The query is sent with its fragments
+	assert.Contains(t, src, "const gamesQuery = `\nquery Games($status: [Int!] = [1, 2]) {")
+	assert.Contains(t, src, "}\n\nfragment ArenaFields on Arena {\n\tname\n}\n\nfragment GameFields on Game {")
+}
+
+func TestGenerateErrors(t *testing.T) {
+	examples := map[string]string{
+		`query Games { games { name } }`:                                                     "Games: line 1: unknown field name on Game",
+		`query Games { games(name: "a") { id } }`:                                            "Games: line 1: unknown argument name of games",
+		`query Games { game { id } }`:                                                        "Games: line 1: the argument id of game is required",
+		`query Games($id: String) { game(id: $id) { id } }`:                                  "Games: line 1: $id of type String is passed as id of type String!",
+		`query Games($id: Int!) { game(id: $id) { id } }`:                                    "Games: line 1: $id of type Int! is passed as id of type String!",
+		`query Games($id: String!) { games { id } }`:                                         "Games: $id is not used",
+		`query Games { game(id: $id) { id } }`:                                               "Games: line 1: $id is not declared",
+		`query Games { games }`:                                                              "Games: line 1: the fields of Query.games must be selected",
+		`query Games { games { id { name } } }`:                                              "Games: line 1: Game.id has no fields to select",
+		`query Games { games { ...Missing } }`:                                               "Games: line 1: unknown fragment Missing",
+		`query Games { games { ...A } } fragment A on Arena { name }`:                        "Games: line 1: fragment A is on Arena, not Game",
+		`query Games { games { ...A } } fragment A on Game { ...A }`:                         "Games: line 1: fragment A spreads itself",
+		`query Games($game: Game) { games { id } }`:                                          "Games: invalid type Game of $game",
+		`query Games { games { id: tps } }`:                                                  "",
+		`query Games { games { id id: tps } }`:                                               "Games: line 1: \"id\" selects both id and tps",
+		`query Games { games { arena { name } } } query Games2 { games { id } }`:             "",
+		`query Games { games { ... on Game { id } } }`:                                       "line 1: inline fragments are not supported",
+		`{ games { id } }`:                                                                   "line 1: expected a name, got \"{\"",
+		`query { games { id } }`:                                                             "line 1: the query must be named, the name of its function",
+		`query Games { games { id } } query Games { games { id } }`:                          "line 1: operation Games is defined twice",
+		"query Games { games { id } }\nquery Game { game(id: \"a) { id } }":                  "line 2: unterminated string",
+		`query Games { games { arena { name } } } query Games2 { gamesArena: games { id } }`: "",
+	}
+
+	queries := make([]string, 0)
+	for query := range examples {
+		queries = append(queries, query)
+	}
+	sort.Strings(queries)
+
+	for _, query := range queries {
+		_, err := generate(t, query)
+
+		if examples[query] == "" {
+			assert.Nil(t, err, query)
+		} else {
+			assert.EqualError(t, err, examples[query], query)
+		}
+	}
+}
+
+func TestGenerateTypeNameCollision(t *testing.T) {
+	_, err := generate(t, `
+query Games { games { arena { name } } }
+query GamesResponseGames { games { id } }
+`)
+
+	assert.EqualError(t, err, "GamesResponseGames: the name GamesResponseGames would be declared twice, rename the operation or use an alias")
+}
+
+func TestParseSchemaErrors(t *testing.T) {
+	_, err := ParseSchema(`type Query { games: [Game] }`)
+	assert.EqualError(t, err, "unknown type Game of Query.games")
+
+	_, err = ParseSchema(`type Query { games(arena: Arena): String } type Arena { name: String }`)
+	assert.EqualError(t, err, "invalid type Arena of argument arena of Query.games")
+
+	_, err = ParseSchema(`type Game { id: String }`)
+	assert.EqualError(t, err, "the schema has no Query type")
+
+	_, err = ParseSchema(`type Query { id: String } type Query { id: String }`)
+	assert.EqualError(t, err, "line 1: type Query is defined twice")
+
+	_, err = ParseSchema(`type Query { id String }`)
+	assert.EqualError(t, err, "line 1: expected \":\", got \"String\"")
+
+	_, err = ParseSchema(`extend type Query { id: String }`)
+	assert.EqualError(t, err, "line 1: unsupported definition \"extend\"")
+}
+
+// The operations of the backends must be generated again when they or the
+// schema change
+func TestGeneratedOperationsAreUpToDate(t *testing.T) {
+	rawSchema, err := ioutil.ReadFile("../schema.graphql")
+	assert.Nil(t, err)
+
+	schema, err := ParseSchema(string(rawSchema))
+	assert.Nil(t, err)
+
+	files, err := filepath.Glob("../api/*.graphql")
+	assert.Nil(t, err)
+	sort.Strings(files)
+
+	doc := NewDocument()
+
+	for _, file := range files {
+		src, err := ioutil.ReadFile(file)
+		assert.Nil(t, err)
+		assert.Nil(t, doc.Parse(string(src)), file)
+	}
+
+	generated, err := Generate(schema, doc, "api")
+	assert.Nil(t, err)
+
+	current, err := ioutil.ReadFile("../api/operations.go")
+	assert.Nil(t, err)
+
+	assert.Equal(t, string(current), string(generated), "run go generate ./common/graphql/api")
+}
@@ -0,0 +1,226 @@
+package codegen
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenName
+	tokenPunctuator
+	tokenString
+	tokenNumber
+)
+
+type token struct {
+	kind  tokenKind
+	value string
+	start int
+	line  int
+}
+
+// lexer splits GraphQL documents, the commas and comments are ignored like
+// whitespace
+type lexer struct {
+	src  string
+	pos  int
+	line int
+	next token
+
+	// Sticky, the following tokens are EOF
+	err error
+
+	// End of the last token read
+	end int
+}
+
+func newLexer(src string) (*lexer, error) {
+	l := &lexer{src: src, line: 1}
+
+	if err := l.advance(); err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// The errors of the lexer come first, they cause the parsing errors
+func (l *lexer) errorf(msg string) error {
+	if l.err != nil {
+		return l.err
+	}
+
+	return errors.New("line " + strconv.Itoa(l.next.line) + ": " + msg)
+}
+
+func (l *lexer) peek() token {
+	return l.next
+}
+
+func (l *lexer) is(value string) bool {
+	return l.next.kind != tokenString && l.next.value == value
+}
+
+// read returns the current token and reads the next one
+func (l *lexer) read() (token, error) {
+	current := l.next
+	l.end = current.start + len(current.value)
+
+	return current, l.advance()
+}
+
+func (l *lexer) expect(value string) error {
+	if !l.is(value) {
+		return l.errorf("expected \"" + value + "\", got \"" + l.next.value + "\"")
+	}
+
+	_, err := l.read()
+	return err
+}
+
+func (l *lexer) expectName() (string, error) {
+	if l.next.kind != tokenName {
+		return "", l.errorf("expected a name, got \"" + l.next.value + "\"")
+	}
+
+	tok, err := l.read()
+	return tok.value, err
+}
+
+// skip consumes the token if it has this value
+func (l *lexer) skip(value string) (bool, error) {
+	if !l.is(value) {
+		return false, nil
+	}
+
+	_, err := l.read()
+	return true, err
+}
+
+func (l *lexer) skipIgnored() {
+	for l.pos < len(l.src) {
+		switch c := l.src[l.pos]; {
+		case c == '\n':
+			l.line++
+			l.pos++
+
+		case c == ' ' || c == '\t' || c == '\r' || c == ',':
+			l.pos++
+
+		case c == '#':
+			for l.pos < len(l.src) && l.src[l.pos] != '\n' {
+				l.pos++
+			}
+
+		default:
+			return
+		}
+	}
+}
+
+func (l *lexer) advance() error {
+	if l.err != nil {
+		return l.err
+	}
+
+	if err := l.scan(); err != nil {
+		l.err = err
+		l.next = token{kind: tokenEOF, line: l.line}
+	}
+
+	return l.err
+}
+
+func (l *lexer) scan() error {
+	l.skipIgnored()
+
+	start := l.pos
+	l.next = token{start: start, line: l.line}
+
+	if l.pos >= len(l.src) {
+		l.next.kind = tokenEOF
+		return nil
+	}
+
+	c := l.src[l.pos]
+
+	switch {
+	case strings.HasPrefix(l.src[l.pos:], "..."):
+		l.pos += 3
+		l.next.kind = tokenPunctuator
+
+	case strings.IndexByte("!$():=@[]{}|&", c) >= 0:
+		l.pos++
+		l.next.kind = tokenPunctuator
+
+	case c == '_' || isLetter(c):
+		for l.pos < len(l.src) && (l.src[l.pos] == '_' || isLetter(l.src[l.pos]) || isDigit(l.src[l.pos])) {
+			l.pos++
+		}
+		l.next.kind = tokenName
+
+	case c == '-' || isDigit(c):
+		l.pos++
+		for l.pos < len(l.src) && (isDigit(l.src[l.pos]) || strings.IndexByte(".eE+-", l.src[l.pos]) >= 0) {
+			l.pos++
+		}
+		l.next.kind = tokenNumber
+
+	case c == '"':
+		if err := l.readString(); err != nil {
+			return err
+		}
+		l.next.kind = tokenString
+
+	default:
+		return errors.New("line " + strconv.Itoa(l.line) + ": unexpected character " + strconv.Quote(string(c)))
+	}
+
+	l.next.value = l.src[start:l.pos]
+
+	return nil
+}
+
+// The strings are kept quoted, they're only descriptions and default values
+func (l *lexer) readString() error {
+	if strings.HasPrefix(l.src[l.pos:], `"""`) {
+		end := strings.Index(l.src[l.pos+3:], `"""`)
+
+		if end < 0 {
+			return errors.New("line " + strconv.Itoa(l.line) + ": unterminated block string")
+		}
+
+		l.line += strings.Count(l.src[l.pos:l.pos+3+end], "\n")
+		l.pos += end + 6
+
+		return nil
+	}
+
+	for l.pos++; l.pos < len(l.src); l.pos++ {
+		switch l.src[l.pos] {
+		case '\\':
+			l.pos++
+
+		case '\n':
+			return errors.New("line " + strconv.Itoa(l.line) + ": unterminated string")
+
+		case '"':
+			l.pos++
+			return nil
+		}
+	}
+
+	return errors.New("line " + strconv.Itoa(l.line) + ": unterminated string")
+}
+
+func isLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
@@ -0,0 +1,675 @@
+package codegen
+
+import (
+	"errors"
+	"strings"
+)
+
+type TypeKind string
+
+const (
+	OBJECT    TypeKind = "type"
+	INTERFACE TypeKind = "interface"
+	INPUT     TypeKind = "input"
+	ENUM      TypeKind = "enum"
+	SCALAR    TypeKind = "scalar"
+	UNION     TypeKind = "union"
+)
+
+var BUILTIN_SCALARS = []string{"Int", "Float", "String", "Boolean", "ID"}
+
+// TypeRef is a named type, or a list of Elem
+type TypeRef struct {
+	Name    string
+	Elem    *TypeRef
+	NonNull bool
+}
+
+func (t *TypeRef) IsList() bool {
+	return t.Elem != nil
+}
+
+// NamedType returns the name of the type of the elements of lists
+func (t *TypeRef) NamedType() string {
+	if t.IsList() {
+		return t.Elem.NamedType()
+	}
+
+	return t.Name
+}
+
+func (t *TypeRef) String() string {
+	res := t.Name
+
+	if t.IsList() {
+		res = "[" + t.Elem.String() + "]"
+	}
+
+	if t.NonNull {
+		res += "!"
+	}
+
+	return res
+}
+
+type ArgumentDef struct {
+	Name string
+	Type *TypeRef
+}
+
+type FieldDef struct {
+	Name string
+	Args []*ArgumentDef
+	Type *TypeRef
+}
+
+func (f *FieldDef) Arg(name string) *ArgumentDef {
+	for _, arg := range f.Args {
+		if arg.Name == name {
+			return arg
+		}
+	}
+
+	return nil
+}
+
+type TypeDef struct {
+	Name   string
+	Kind   TypeKind
+	Fields []*FieldDef
+}
+
+func (t *TypeDef) Field(name string) *FieldDef {
+	for _, field := range t.Fields {
+		if field.Name == name {
+			return field
+		}
+	}
+
+	return nil
+}
+
+// IsComposite tells if the fields of the type are selected
+func (t *TypeDef) IsComposite() bool {
+	return t.Kind == OBJECT || t.Kind == INTERFACE || t.Kind == UNION
+}
+
+func (t *TypeDef) IsInput() bool {
+	return t.Kind == INPUT || t.Kind == ENUM || t.Kind == SCALAR
+}
+
+type Schema struct {
+	Types    map[string]*TypeDef
+	Query    string
+	Mutation string
+}
+
+func (s *Schema) Type(name string) *TypeDef {
+	return s.Types[name]
+}
+
+// ParseSchema reads a schema in the GraphQL schema language, the directives
+// and descriptions are ignored
+func ParseSchema(src string) (*Schema, error) {
+	l, err := newLexer(src)
+	if err != nil {
+		return nil, err
+	}
+
+	schema := &Schema{
+		Types:    make(map[string]*TypeDef),
+		Query:    "Query",
+		Mutation: "Mutation",
+	}
+
+	for _, name := range BUILTIN_SCALARS {
+		schema.Types[name] = &TypeDef{Name: name, Kind: SCALAR}
+	}
+
+	for l.peek().kind != tokenEOF {
+		if l.peek().kind == tokenString {
+			if _, err := l.read(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		keyword, err := l.expectName()
+		if err != nil {
+			return nil, err
+		}
+
+		switch TypeKind(keyword) {
+		case OBJECT, INTERFACE, INPUT:
+			err = parseObjectDef(l, schema, TypeKind(keyword))
+
+		case ENUM:
+			err = parseEnumDef(l, schema)
+
+		case SCALAR:
+			err = parseScalarDef(l, schema)
+
+		case UNION:
+			err = parseUnionDef(l, schema)
+
+		default:
+			switch keyword {
+			case "schema":
+				err = parseSchemaDef(l, schema)
+
+			case "directive":
+				err = parseDirectiveDef(l)
+
+			default:
+				err = l.errorf("unsupported definition \"" + keyword + "\"")
+			}
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if l.err != nil {
+		return nil, l.err
+	}
+
+	return schema, schema.check()
+}
+
+// check verifies that the types are defined and used where they can be
+func (s *Schema) check() error {
+	if s.Type(s.Query) == nil {
+		return errors.New("the schema has no " + s.Query + " type")
+	}
+
+	for _, def := range s.Types {
+		for _, field := range def.Fields {
+			fieldType := s.Type(field.Type.NamedType())
+
+			if fieldType == nil {
+				return errors.New("unknown type " + field.Type.NamedType() + " of " + def.Name + "." + field.Name)
+			}
+
+			if def.Kind == INPUT && !fieldType.IsInput() {
+				return errors.New(def.Name + "." + field.Name + " is not an input type")
+			}
+
+			for _, arg := range field.Args {
+				argType := s.Type(arg.Type.NamedType())
+
+				if argType == nil || !argType.IsInput() {
+					return errors.New("invalid type " + arg.Type.NamedType() + " of argument " + arg.Name + " of " + def.Name + "." + field.Name)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func (s *Schema) define(l *lexer, def *TypeDef) error {
+	if _, exists := s.Types[def.Name]; exists {
+		return l.errorf("type " + def.Name + " is defined twice")
+	}
+
+	s.Types[def.Name] = def
+
+	return nil
+}
+
+func parseObjectDef(l *lexer, schema *Schema, kind TypeKind) error {
+	name, err := l.expectName()
+	if err != nil {
+		return err
+	}
+
+	def := &TypeDef{Name: name, Kind: kind}
+
+	if implements, err := l.skip("implements"); err != nil {
+		return err
+	} else if implements {
+		l.skip("&")
+
+		for l.peek().kind == tokenName {
+			l.read()
+			l.skip("&")
+		}
+	}
+
+	if err := skipDirectives(l); err != nil {
+		return err
+	}
+
+	if err := l.expect("{"); err != nil {
+		return err
+	}
+
+	for !l.is("}") {
+		field, err := parseFieldDef(l)
+		if err != nil {
+			return err
+		}
+
+		def.Fields = append(def.Fields, field)
+	}
+
+	if err := l.expect("}"); err != nil {
+		return err
+	}
+
+	return schema.define(l, def)
+}
+
+func parseFieldDef(l *lexer) (*FieldDef, error) {
+	if l.peek().kind == tokenString {
+		l.read()
+	}
+
+	name, err := l.expectName()
+	if err != nil {
+		return nil, err
+	}
+
+	field := &FieldDef{Name: name}
+
+	if opened, err := l.skip("("); err != nil {
+		return nil, err
+	} else if opened {
+		for !l.is(")") {
+			if l.peek().kind == tokenString {
+				l.read()
+			}
+
+			arg, err := parseInputValueDef(l)
+			if err != nil {
+				return nil, err
+			}
+
+			field.Args = append(field.Args, arg)
+		}
+
+		if err := l.expect(")"); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := l.expect(":"); err != nil {
+		return nil, err
+	}
+
+	if field.Type, err = parseTypeRef(l); err != nil {
+		return nil, err
+	}
+
+	// The default values of the fields of inputs
+	if hasDefault, err := l.skip("="); err != nil {
+		return nil, err
+	} else if hasDefault {
+		if _, err := parseValue(l); err != nil {
+			return nil, err
+		}
+	}
+
+	return field, skipDirectives(l)
+}
+
+func parseInputValueDef(l *lexer) (*ArgumentDef, error) {
+	name, err := l.expectName()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := l.expect(":"); err != nil {
+		return nil, err
+	}
+
+	argType, err := parseTypeRef(l)
+	if err != nil {
+		return nil, err
+	}
+
+	if hasDefault, err := l.skip("="); err != nil {
+		return nil, err
+	} else if hasDefault {
+		if _, err := parseValue(l); err != nil {
+			return nil, err
+		}
+	}
+
+	return &ArgumentDef{Name: name, Type: argType}, skipDirectives(l)
+}
+
+func parseEnumDef(l *lexer, schema *Schema) error {
+	name, err := l.expectName()
+	if err != nil {
+		return err
+	}
+
+	if err := skipDirectives(l); err != nil {
+		return err
+	}
+
+	if err := l.expect("{"); err != nil {
+		return err
+	}
+
+	for !l.is("}") {
+		if l.peek().kind == tokenString {
+			l.read()
+		}
+
+		if _, err := l.expectName(); err != nil {
+			return err
+		}
+
+		if err := skipDirectives(l); err != nil {
+			return err
+		}
+	}
+
+	if err := l.expect("}"); err != nil {
+		return err
+	}
+
+	return schema.define(l, &TypeDef{Name: name, Kind: ENUM})
+}
+
+func parseScalarDef(l *lexer, schema *Schema) error {
+	name, err := l.expectName()
+	if err != nil {
+		return err
+	}
+
+	if err := skipDirectives(l); err != nil {
+		return err
+	}
+
+	return schema.define(l, &TypeDef{Name: name, Kind: SCALAR})
+}
+
+func parseUnionDef(l *lexer, schema *Schema) error {
+	name, err := l.expectName()
+	if err != nil {
+		return err
+	}
+
+	if err := skipDirectives(l); err != nil {
+		return err
+	}
+
+	if err := l.expect("="); err != nil {
+		return err
+	}
+
+	l.skip("|")
+
+	for {
+		if _, err := l.expectName(); err != nil {
+			return err
+		}
+
+		if more, err := l.skip("|"); err != nil {
+			return err
+		} else if !more {
+			break
+		}
+	}
+
+	return schema.define(l, &TypeDef{Name: name, Kind: UNION})
+}
+
+func parseSchemaDef(l *lexer, schema *Schema) error {
+	if err := l.expect("{"); err != nil {
+		return err
+	}
+
+	for !l.is("}") {
+		operation, err := l.expectName()
+		if err != nil {
+			return err
+		}
+
+		if err := l.expect(":"); err != nil {
+			return err
+		}
+
+		typeName, err := l.expectName()
+		if err != nil {
+			return err
+		}
+
+		switch operation {
+		case "query":
+			schema.Query = typeName
+
+		case "mutation":
+			schema.Mutation = typeName
+		}
+	}
+
+	return l.expect("}")
+}
+
+// directive @name(args) on LOCATION | LOCATION
+func parseDirectiveDef(l *lexer) error {
+	if err := l.expect("@"); err != nil {
+		return err
+	}
+
+	if _, err := l.expectName(); err != nil {
+		return err
+	}
+
+	if opened, err := l.skip("("); err != nil {
+		return err
+	} else if opened {
+		for !l.is(")") {
+			if l.peek().kind == tokenString {
+				l.read()
+			}
+
+			if _, err := parseInputValueDef(l); err != nil {
+				return err
+			}
+		}
+
+		if err := l.expect(")"); err != nil {
+			return err
+		}
+	}
+
+	l.skip("repeatable")
+
+	if err := l.expect("on"); err != nil {
+		return err
+	}
+
+	l.skip("|")
+
+	for {
+		if _, err := l.expectName(); err != nil {
+			return err
+		}
+
+		if more, err := l.skip("|"); err != nil {
+			return err
+		} else if !more {
+			return nil
+		}
+	}
+}
+
+func parseTypeRef(l *lexer) (*TypeRef, error) {
+	ref := &TypeRef{}
+
+	if opened, err := l.skip("["); err != nil {
+		return nil, err
+	} else if opened {
+		elem, err := parseTypeRef(l)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := l.expect("]"); err != nil {
+			return nil, err
+		}
+
+		ref.Elem = elem
+	} else {
+		name, err := l.expectName()
+		if err != nil {
+			return nil, err
+		}
+
+		ref.Name = name
+	}
+
+	nonNull, err := l.skip("!")
+	ref.NonNull = nonNull
+
+	return ref, err
+}
+
+// Value is a literal, a variable, a list or an object
+type Value struct {
+	Variable string
+	Literal  string
+	List     []*Value
+	Object   map[string]*Value
+}
+
+// Variables returns the names of the variables used in the value
+func (v *Value) Variables() []string {
+	res := make([]string, 0)
+
+	if v.Variable != "" {
+		res = append(res, v.Variable)
+	}
+
+	for _, item := range v.List {
+		res = append(res, item.Variables()...)
+	}
+
+	for _, item := range v.Object {
+		res = append(res, item.Variables()...)
+	}
+
+	return res
+}
+
+func parseValue(l *lexer) (*Value, error) {
+	switch {
+	case l.is("$"):
+		l.read()
+
+		name, err := l.expectName()
+		return &Value{Variable: name}, err
+
+	case l.is("["):
+		l.read()
+		value := &Value{List: make([]*Value, 0)}
+
+		for !l.is("]") {
+			item, err := parseValue(l)
+			if err != nil {
+				return nil, err
+			}
+
+			value.List = append(value.List, item)
+		}
+
+		return value, l.expect("]")
+
+	case l.is("{"):
+		l.read()
+		value := &Value{Object: make(map[string]*Value)}
+
+		for !l.is("}") {
+			name, err := l.expectName()
+			if err != nil {
+				return nil, err
+			}
+
+			if err := l.expect(":"); err != nil {
+				return nil, err
+			}
+
+			if value.Object[name], err = parseValue(l); err != nil {
+				return nil, err
+			}
+		}
+
+		return value, l.expect("}")
+	}
+
+	switch l.peek().kind {
+	case tokenName, tokenNumber, tokenString:
+		tok, err := l.read()
+		return &Value{Literal: tok.value}, err
+	}
+
+	return nil, l.errorf("expected a value, got \"" + l.peek().value + "\"")
+}
+
+func skipDirectives(l *lexer) error {
+	for l.is("@") {
+		l.read()
+
+		if _, err := l.expectName(); err != nil {
+			return err
+		}
+
+		if l.is("(") {
+			if _, err := parseArguments(l); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+type Argument struct {
+	Name  string
+	Value *Value
+}
+
+func parseArguments(l *lexer) ([]Argument, error) {
+	if err := l.expect("("); err != nil {
+		return nil, err
+	}
+
+	args := make([]Argument, 0)
+
+	for !l.is(")") {
+		name, err := l.expectName()
+		if err != nil {
+			return nil, err
+		}
+
+		if err := l.expect(":"); err != nil {
+			return nil, err
+		}
+
+		value, err := parseValue(l)
+		if err != nil {
+			return nil, err
+		}
+
+		args = append(args, Argument{Name: name, Value: value})
+	}
+
+	return args, l.expect(")")
+}
+
+// goName exports a GraphQL name
+func goName(name string) string {
+	name = strings.TrimLeft(name, "_")
+
+	if name == "" {
+		return "Typename"
+	}
+
+	return strings.ToUpper(name[:1]) + name[1:]
+}
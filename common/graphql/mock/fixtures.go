@@ -0,0 +1,35 @@
+package mock
+
+import (
+	"encoding/json"
+	"io/ioutil"
+)
+
+// Fixtures are the records served by the mock, as the GraphQL API returns
+// them: the owner of an agent, or the arena of a game, are nested objects.
+// The deployments of the agents are in their "deployments" field.
+type Fixtures struct {
+	Games         []map[string]interface{} `json:"games"`
+	Agents        []map[string]interface{} `json:"agents"`
+	Users         []map[string]interface{} `json:"users"`
+	SSHPublicKeys []map[string]interface{} `json:"sshpublickeys"`
+}
+
+func ParseFixtures(data []byte) (*Fixtures, error) {
+	var fixtures Fixtures
+
+	if err := json.Unmarshal(data, &fixtures); err != nil {
+		return nil, err
+	}
+
+	return &fixtures, nil
+}
+
+func LoadFixtures(path string) (*Fixtures, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseFixtures(data)
+}
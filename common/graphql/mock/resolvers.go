@@ -0,0 +1,201 @@
+package mock
+
+import (
+	"errors"
+	"strconv"
+)
+
+type resolver func(s *Server, args map[string]interface{}) (interface{}, error)
+
+var queries = map[string]resolver{
+	"games": func(s *Server, args map[string]interface{}) (interface{}, error) {
+		return filter(s.fixtures.Games, args, map[string][]string{
+			"id": {"id"},
+		}), nil
+	},
+	"users": func(s *Server, args map[string]interface{}) (interface{}, error) {
+		return filter(s.fixtures.Users, args, map[string][]string{
+			"username": {"username"},
+			"email":    {"email"},
+		}), nil
+	},
+	"agents": func(s *Server, args map[string]interface{}) (interface{}, error) {
+		return filter(s.fixtures.Agents, args, map[string][]string{
+			"username": {"owner", "username"},
+			"name":     {"name"},
+			"id":       {"id"},
+		}), nil
+	},
+	"sshpublickeys": func(s *Server, args map[string]interface{}) (interface{}, error) {
+		return filter(s.fixtures.SSHPublicKeys, args, map[string][]string{
+			"username":    {"owner", "username"},
+			"fingerprint": {"fingerprint"},
+		}), nil
+	},
+}
+
+var mutations = map[string]resolver{
+	"updateGame": func(s *Server, args map[string]interface{}) (interface{}, error) {
+		game := find(s.fixtures.Games, "id", args["id"])
+		if game == nil {
+			return nil, errors.New("Unknown game " + toString(args["id"]))
+		}
+
+		merge(game, args["game"])
+
+		return game, nil
+	},
+	"createAgentDeployment": func(s *Server, args map[string]interface{}) (interface{}, error) {
+		input, _ := args["agentDeployment"].(map[string]interface{})
+
+		agent := find(s.fixtures.Agents, "id", input["agentId"])
+		if agent == nil {
+			return nil, errors.New("Unknown agent " + toString(input["agentId"]))
+		}
+
+		deployment := map[string]interface{}{"id": s.nextId()}
+		merge(deployment, input)
+		delete(deployment, "agentId")
+
+		agent["deployments"] = append(toInterfaces(objects(agent["deployments"])), deployment)
+		s.deployments[deployment["id"].(string)] = deployment
+
+		return deployment, nil
+	},
+	"updateAgentDeployment": func(s *Server, args map[string]interface{}) (interface{}, error) {
+		deployment, ok := s.deployments[toString(args["id"])]
+		if !ok {
+			return nil, errors.New("Unknown agent deployment " + toString(args["id"]))
+		}
+
+		merge(deployment, args["agentDeployment"])
+
+		return deployment, nil
+	},
+	"createSSHPublicKey": func(s *Server, args map[string]interface{}) (interface{}, error) {
+		input, _ := args["key"].(map[string]interface{})
+
+		owner := find(s.fixtures.Users, "id", input["ownerid"])
+		if owner == nil {
+			return nil, errors.New("Unknown user " + toString(input["ownerid"]))
+		}
+
+		key := map[string]interface{}{"id": s.nextId(), "owner": owner}
+		merge(key, input)
+		delete(key, "ownerid")
+
+		s.fixtures.SSHPublicKeys = append(s.fixtures.SSHPublicKeys, key)
+
+		return key, nil
+	},
+}
+
+// nextId returns an id unused by the fixtures
+func (s *Server) nextId() string {
+	s.lastId++
+	return "mock-" + strconv.Itoa(s.lastId)
+}
+
+// filter returns the records matching the arguments, given with the path of
+// the field they match; the null arguments match all the records
+func filter(records []map[string]interface{}, args map[string]interface{}, fields map[string][]string) []interface{} {
+	res := make([]interface{}, 0)
+
+	for _, record := range records {
+		matches := true
+
+		for arg, path := range fields {
+			if args[arg] != nil && toString(lookup(record, path)) != toString(args[arg]) {
+				matches = false
+			}
+		}
+
+		if matches {
+			res = append(res, record)
+		}
+	}
+
+	return res
+}
+
+func find(records []map[string]interface{}, field string, value interface{}) map[string]interface{} {
+	if value == nil {
+		return nil
+	}
+
+	for _, record := range records {
+		if toString(record[field]) == toString(value) {
+			return record
+		}
+	}
+
+	return nil
+}
+
+func lookup(record map[string]interface{}, path []string) interface{} {
+	var value interface{} = record
+
+	for _, field := range path {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+
+		value = object[field]
+	}
+
+	return value
+}
+
+func merge(record map[string]interface{}, input interface{}) {
+	fields, _ := input.(map[string]interface{})
+
+	for field, value := range fields {
+		record[field] = value
+	}
+}
+
+// objects returns the objects of a list of the fixtures
+func objects(value interface{}) []map[string]interface{} {
+	res := make([]map[string]interface{}, 0)
+
+	switch typed := value.(type) {
+	case []map[string]interface{}:
+		return typed
+	case []interface{}:
+		for _, item := range typed {
+			if object, ok := item.(map[string]interface{}); ok {
+				res = append(res, object)
+			}
+		}
+	}
+
+	return res
+}
+
+func toInterfaces(records []map[string]interface{}) []interface{} {
+	res := make([]interface{}, len(records))
+
+	for i, record := range records {
+		res[i] = record
+	}
+
+	return res
+}
+
+// toString compares the ids of the fixtures, which may be numbers, with the
+// ones of the arguments
+func toString(value interface{}) string {
+	switch typed := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return typed
+	case float64:
+		return strconv.FormatFloat(typed, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(typed)
+	}
+
+	return ""
+}
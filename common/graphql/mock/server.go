@@ -0,0 +1,306 @@
+package mock
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/bytearena/backends/common/graphql/codegen"
+)
+
+// Server is an in-memory stand-in of the GraphQL API, implementing the part of
+// the schema the backends use. The mutations change its fixtures.
+type Server struct {
+	fixtures    *Fixtures
+	deployments map[string]map[string]interface{}
+	schema      []byte
+	token       string
+	lastId      int
+
+	lock sync.Mutex
+}
+
+type request struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+type response struct {
+	Data   interface{}     `json:"data"`
+	Errors []responseError `json:"errors,omitempty"`
+}
+
+type responseError struct {
+	Message string `json:"message"`
+}
+
+func NewServer(fixtures *Fixtures) *Server {
+	server := &Server{
+		fixtures:    fixtures,
+		deployments: make(map[string]map[string]interface{}),
+	}
+
+	for _, agent := range fixtures.Agents {
+		for _, deployment := range objects(agent["deployments"]) {
+			server.deployments[toString(deployment["id"])] = deployment
+		}
+	}
+
+	return server
+}
+
+// SetSchema sets the schema served on /schema
+func (s *Server) SetSchema(schema []byte) *Server {
+	s.schema = schema
+	return s
+}
+
+// SetToken makes the requests without this bearer token fail with a 401
+func (s *Server) SetToken(token string) *Server {
+	s.token = token
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.token != "" && r.Header.Get("Authorization") != "Bearer "+s.token {
+		writeResponse(w, http.StatusUnauthorized, response{Errors: []responseError{{Message: "Invalid token"}}})
+		return
+	}
+
+	if r.URL.Path == "/schema" {
+		if s.schema == nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Write(s.schema)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		writeResponse(w, http.StatusMethodNotAllowed, response{Errors: []responseError{{Message: "The queries are posted"}}})
+		return
+	}
+
+	var req request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeResponse(w, http.StatusBadRequest, response{Errors: []responseError{{Message: "Invalid request; " + err.Error()}}})
+		return
+	}
+
+	var res response
+
+	data, err := s.execute(req)
+	if err != nil {
+		res.Errors = []responseError{{Message: err.Error()}}
+	} else {
+		res.Data = data
+	}
+
+	writeResponse(w, http.StatusOK, res)
+}
+
+func writeResponse(w http.ResponseWriter, status int, res response) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(res)
+}
+
+func (s *Server) execute(req request) (map[string]interface{}, error) {
+	doc := codegen.NewDocument()
+
+	if err := doc.Parse(req.Query); err != nil {
+		return nil, err
+	}
+
+	if len(doc.Operations) == 0 {
+		return nil, errors.New("The document has no operation")
+	}
+
+	operation := doc.Operations[0]
+
+	if req.OperationName != "" {
+		operation = nil
+
+		for _, candidate := range doc.Operations {
+			if candidate.Name == req.OperationName {
+				operation = candidate
+			}
+		}
+
+		if operation == nil {
+			return nil, errors.New("Unknown operation " + req.OperationName)
+		}
+	}
+
+	variables := make(map[string]interface{})
+
+	for _, def := range operation.Variables {
+		if value, ok := req.Variables[def.Name]; ok {
+			variables[def.Name] = value
+		} else if def.Default != nil {
+			value, err := argumentValue(def.Default, nil)
+			if err != nil {
+				return nil, err
+			}
+
+			variables[def.Name] = value
+		}
+	}
+
+	roots := queries
+	if operation.Kind == "mutation" {
+		roots = mutations
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	data := make(map[string]interface{})
+
+	for _, selection := range operation.Selections {
+		resolve, ok := roots[selection.Name]
+		if !ok {
+			return nil, errors.New("Cannot " + operation.Kind + " field \"" + selection.Name + "\"")
+		}
+
+		args := make(map[string]interface{})
+
+		for _, arg := range selection.Args {
+			value, err := argumentValue(arg.Value, variables)
+			if err != nil {
+				return nil, err
+			}
+
+			args[arg.Name] = value
+		}
+
+		value, err := resolve(s, args)
+		if err != nil {
+			return nil, err
+		}
+
+		if data[selection.Key()], err = selectFields(value, selection.Selections, doc.Fragments); err != nil {
+			return nil, err
+		}
+	}
+
+	return data, nil
+}
+
+// selectFields copies the selected fields of the records, the fields missing
+// from the fixtures are null
+func selectFields(value interface{}, selections []*codegen.Selection, fragments map[string]*codegen.Fragment) (interface{}, error) {
+	if selections == nil || value == nil {
+		return value, nil
+	}
+
+	switch typed := value.(type) {
+	case []interface{}:
+		res := make([]interface{}, len(typed))
+
+		for i, item := range typed {
+			var err error
+			if res[i], err = selectFields(item, selections, fragments); err != nil {
+				return nil, err
+			}
+		}
+
+		return res, nil
+
+	case []map[string]interface{}:
+		return selectFields(toInterfaces(typed), selections, fragments)
+
+	case map[string]interface{}:
+		res := make(map[string]interface{})
+		return res, selectObjectFields(typed, res, selections, fragments)
+	}
+
+	return nil, errors.New("Cannot select the fields of a scalar")
+}
+
+func selectObjectFields(object, res map[string]interface{}, selections []*codegen.Selection, fragments map[string]*codegen.Fragment) error {
+	for _, selection := range selections {
+		if selection.Fragment != "" {
+			fragment, ok := fragments[selection.Fragment]
+			if !ok {
+				return errors.New("Unknown fragment " + selection.Fragment)
+			}
+
+			if err := selectObjectFields(object, res, fragment.Selections, fragments); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		value, err := selectFields(object[selection.Name], selection.Selections, fragments)
+		if err != nil {
+			return errors.New(selection.Name + ": " + err.Error())
+		}
+
+		res[selection.Key()] = value
+	}
+
+	return nil
+}
+
+func argumentValue(value *codegen.Value, variables map[string]interface{}) (interface{}, error) {
+	switch {
+	case value.Variable != "":
+		return variables[value.Variable], nil
+
+	case value.List != nil:
+		res := make([]interface{}, len(value.List))
+
+		for i, item := range value.List {
+			var err error
+			if res[i], err = argumentValue(item, variables); err != nil {
+				return nil, err
+			}
+		}
+
+		return res, nil
+
+	case value.Object != nil:
+		res := make(map[string]interface{})
+
+		for name, item := range value.Object {
+			var err error
+			if res[name], err = argumentValue(item, variables); err != nil {
+				return nil, err
+			}
+		}
+
+		return res, nil
+	}
+
+	literal := value.Literal
+
+	switch {
+	case strings.HasPrefix(literal, `"""`):
+		return strings.TrimSuffix(strings.TrimPrefix(literal, `"""`), `"""`), nil
+
+	case strings.HasPrefix(literal, `"`):
+		var res string
+		err := json.Unmarshal([]byte(literal), &res)
+		return res, err
+
+	case literal == "null":
+		return nil, nil
+
+	case literal == "true" || literal == "false":
+		return literal == "true", nil
+	}
+
+	if number, err := strconv.ParseFloat(literal, 64); err == nil {
+		return number, nil
+	}
+
+	// Enum value
+	return literal, nil
+}
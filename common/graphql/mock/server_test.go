@@ -0,0 +1,108 @@
+package mock
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bytearena/backends/common/graphql"
+	"github.com/bytearena/backends/common/graphql/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func startServer(t *testing.T) (*httptest.Server, *graphql.Client) {
+	fixtures, err := LoadFixtures("../../../cmd/graphql-mock/fixtures.json")
+	assert.Nil(t, err)
+
+	server := httptest.NewServer(NewServer(fixtures).SetToken("secret").SetSchema([]byte("type Query {}")))
+
+	return server, graphql.NewClient(server.URL).SetAuthenticator(graphql.BearerToken("secret"))
+}
+
+func TestQueries(t *testing.T) {
+	server, client := startServer(t)
+	defer server.Close()
+
+	games, err := api.Games(context.Background(), client, api.GamesVariables{})
+	assert.Nil(t, err)
+	assert.Len(t, games.Games, 1)
+	assert.Equal(t, "Death valley", games.Games[0].Arena.Name)
+	assert.Equal(t, "jane/seeker", games.Games[0].Contestants[0].Agent.Image.Name)
+
+	games, err = api.Games(context.Background(), client, api.GamesVariables{Gameid: graphql.String("404")})
+	assert.Nil(t, err)
+	assert.Len(t, games.Games, 0)
+
+	// Fragments
+	agents, err := api.Agents(context.Background(), client, api.AgentsVariables{
+		Username: graphql.String("jane"),
+		Reponame: graphql.String("seeker"),
+	})
+	assert.Nil(t, err)
+	assert.Len(t, agents.Agents, 1)
+	assert.Equal(t, "jane@example.com", agents.Agents[0].Owner.Email)
+	assert.Equal(t, "refs/heads/master", agents.Agents[0].GitRepository.Ref)
+
+	users, err := api.Users(context.Background(), client, api.UsersVariables{Email: graphql.String("jane@example.com")})
+	assert.Nil(t, err)
+	assert.Len(t, users.Users, 1)
+	assert.Equal(t, "jane", users.Users[0].Username)
+
+	schema, err := client.FetchSchema(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, "type Query {}", string(schema))
+}
+
+func TestMutations(t *testing.T) {
+	server, client := startServer(t)
+	defer server.Close()
+
+	_, err := api.UpdateGame(context.Background(), client, api.UpdateGameVariables{
+		Id:   graphql.String("100"),
+		Game: api.GameInputUpdate{RunStatus: graphql.Int(1)},
+	})
+	assert.Nil(t, err)
+
+	games, err := api.Games(context.Background(), client, api.GamesVariables{Gameid: graphql.String("100")})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, games.Games[0].RunStatus)
+
+	created, err := api.CreateAgentDeployment(context.Background(), client, api.CreateAgentDeploymentVariables{
+		AgentDeployment: api.AgentDeploymentInputCreate{AgentId: "10", BuildStatus: graphql.Int(0)},
+	})
+	assert.Nil(t, err)
+	assert.NotEqual(t, "", created.CreateAgentDeployment.Id)
+
+	_, err = api.UpdateAgentDeployment(context.Background(), client, api.UpdateAgentDeploymentVariables{
+		Id:              created.CreateAgentDeployment.Id,
+		AgentDeployment: api.AgentDeploymentInputUpdate{BuildStatus: graphql.Int(2), BuildError: graphql.Bool(true)},
+	})
+	assert.Nil(t, err)
+
+	var deployments struct {
+		Agents []struct {
+			Deployments []struct {
+				Id          string `json:"id"`
+				BuildStatus int    `json:"buildStatus"`
+				BuildError  bool   `json:"buildError"`
+			} `json:"deployments"`
+		} `json:"agents"`
+	}
+
+	err = graphql.Request(context.Background(), client, graphql.NewQuery(`query Deployments { agents(id: "10") { deployments { id buildStatus buildError } } }`), &deployments)
+	assert.Nil(t, err)
+	assert.Len(t, deployments.Agents[0].Deployments, 1)
+	assert.Equal(t, 2, deployments.Agents[0].Deployments[0].BuildStatus)
+	assert.True(t, deployments.Agents[0].Deployments[0].BuildError)
+
+	_, err = api.UpdateGame(context.Background(), client, api.UpdateGameVariables{Id: graphql.String("404")})
+	assert.EqualError(t, err, "GraphQL errors: Unknown game 404")
+}
+
+func TestUnauthorized(t *testing.T) {
+	server, _ := startServer(t)
+	defer server.Close()
+
+	_, err := api.Games(context.Background(), graphql.NewClient(server.URL), api.GamesVariables{})
+	assert.EqualError(t, err, "GraphQL server emitted an HTTP status code 401; GraphQL errors: Invalid token")
+}
@@ -0,0 +1,19 @@
+package graphql
+
+// The nullable variables of the generated operations are pointers
+
+func String(value string) *string {
+	return &value
+}
+
+func Int(value int) *int {
+	return &value
+}
+
+func Bool(value bool) *bool {
+	return &value
+}
+
+func Float(value float64) *float64 {
+	return &value
+}
@@ -1,88 +1,56 @@
 package queries
 
 import (
-	"encoding/json"
-
+	"context"
 	"errors"
 
 	"github.com/bytearena/backends/common/graphql"
+	"github.com/bytearena/backends/common/graphql/api"
 	graphqltypes "github.com/bytearena/backends/common/graphql/types"
+	"github.com/bytearena/backends/common/maps"
 	"github.com/bytearena/core/common/types"
+	"github.com/bytearena/core/common/utils"
 )
 
-const gameQuery = `
-query ($gameid: String = null) {
-	games(id: $gameid) {
-		id
-		launchedAt
-		endedAt
-		tps
-		arena {
-			id
-			name
-			kind
-			maxContestants
-		}
-		contestants {
-			id
-			agent {
-				id
-				name
-				owner {
-					id
-					name
-					username
-				}
-				image {
-					name
-					tag
-					registry
-				}
-			}
-		}
-	}
-}
-`
-
-func FetchGames(graphqlclient graphql.Client) ([]types.GameDescriptionInterface, error) {
-	data, err := graphqlclient.RequestSync(
-		graphql.NewQuery(gameQuery),
-	)
+// FetchGames skips the games whose map can't be loaded
+func FetchGames(graphqlclient graphql.Client, store *maps.Store) ([]types.GameDescriptionInterface, error) {
+	response, err := api.Games(context.Background(), &graphqlclient, api.GamesVariables{})
 
 	if err != nil {
-		return nil, errors.New("Could not fetch games from GraphQL")
-	}
-
-	var apiresponse struct {
-		Games []types.GameType `json:"games"`
+		return nil, errors.New("Could not fetch games from GraphQL: " + err.Error())
 	}
-	json.Unmarshal(data, &apiresponse)
 
 	res := make([]types.GameDescriptionInterface, 0)
-	for _, game := range apiresponse.Games {
-		res = append(res, graphqltypes.NewGameDescriptionGQL(game))
+	for _, game := range response.Games {
+		description, err := graphqltypes.NewGameDescriptionGQL(game, store)
+		if err != nil {
+			utils.Debug("graphql", "Skipping game "+game.Id+"; "+err.Error())
+			continue
+		}
+
+		res = append(res, description)
 	}
 
 	return res, nil
 }
 
-func FetchGameById(graphqlclient graphql.Client, gameid string) (types.GameDescriptionInterface, error) {
-
-	data, err := graphqlclient.RequestSync(
-		graphql.NewQuery(gameQuery).SetVariables(graphql.Variables{
-			"gameid": gameid,
-		}),
-	)
+func FetchGameById(graphqlclient graphql.Client, store *maps.Store, gameid string) (types.GameDescriptionInterface, error) {
+	response, err := api.Games(context.Background(), &graphqlclient, api.GamesVariables{
+		Gameid: graphql.String(gameid),
+	})
 
 	if err != nil {
-		return nil, errors.New("Could not fetch game '" + gameid + "' from GraphQL")
+		return nil, errors.New("Could not fetch game '" + gameid + "' from GraphQL: " + err.Error())
 	}
 
-	var apiresponse struct {
-		Games []types.GameType `json:"games"`
+	if len(response.Games) == 0 {
+		return nil, errors.New("Game '" + gameid + "' does not exist")
+	}
+
+	game, err := graphqltypes.NewGameDescriptionGQL(response.Games[0], store)
+	if err != nil {
+		return nil, err
 	}
-	json.Unmarshal(data, &apiresponse)
-	game := graphqltypes.NewGameDescriptionGQL(apiresponse.Games[0])
 
 	return game, nil
 }
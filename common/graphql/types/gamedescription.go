@@ -1,48 +1,30 @@
 package types
 
 import (
-	"encoding/json"
-	"io/ioutil"
-	"net/http"
-
+	"github.com/bytearena/backends/common/graphql/api"
+	"github.com/bytearena/backends/common/maps"
 	coretypes "github.com/bytearena/core/common/types"
 	"github.com/bytearena/core/common/types/mapcontainer"
-	"github.com/bytearena/core/common/utils"
 )
 
 type GameDescriptionGQL struct {
-	gqlgame      coretypes.GameType
+	gqlgame      api.GamesResponseGames
 	mapContainer *mapcontainer.MapContainer
 }
 
-func FetchUrl(url string) ([]byte, error) {
-	resp, err := http.Get(url)
+// NewGameDescriptionGQL loads the map of the game. The GraphQL API doesn't
+// give the maps of the arenas yet, every game is in the default map.
+func NewGameDescriptionGQL(game api.GamesResponseGames, store *maps.Store) (*GameDescriptionGQL, error) {
+	mapContainer, err := store.Get(maps.DEFAULT_MAP)
 
-	if err != nil && resp.StatusCode != 200 {
+	if err != nil {
 		return nil, err
 	}
 
-	defer resp.Body.Close()
-
-	body, err := ioutil.ReadAll(resp.Body)
-
-	return body, nil
-}
-
-func NewGameDescriptionGQL(game coretypes.GameType) *GameDescriptionGQL {
-
-	// TODO(jerome): parametrize this
-	jsonsource, err := utils.FetchUrl("https://static.bytearena.com/assets/bytearena/maps/deathmatch/desert/death-valley/map.json")
-	utils.Check(err, "Could not fetch map")
-
-	var mapContainer mapcontainer.MapContainer
-	err = json.Unmarshal(jsonsource, &mapContainer)
-	utils.Check(err, "Could not load map JSON")
-
 	return &GameDescriptionGQL{
-		mapContainer: &mapContainer,
+		mapContainer: mapContainer,
 		gqlgame:      game,
-	}
+	}, nil
 }
 
 func (a *GameDescriptionGQL) GetId() string {
@@ -10,9 +10,10 @@ import (
 )
 
 type HealthCheckServer struct {
-	Checkers map[string]HealthCheckHandler
-	port     string
-	listener *http.Server
+	Checkers       map[string]HealthCheckHandler
+	DetailCheckers map[string]HealthCheckDetailHandler
+	port           string
+	listener       *http.Server
 }
 
 type HealthChecks struct {
@@ -28,12 +29,36 @@ type HealthCheckHttpResponse struct {
 
 type HealthCheckHandler func() error
 
+// HealthCheckDetailHandler also reports a detail when healthy, like the
+// progress of a long running operation
+type HealthCheckDetailHandler func() (string, error)
+
 func (server *HealthCheckServer) /* @manglo:ignore */ ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	res := HealthCheckHttpResponse{
 		Checks:     make([]HealthChecks, 0),
 		StatusCode: 200,
 	}
 
+	for name, checker := range server.DetailCheckers {
+		detail, err := checker()
+
+		if err == nil {
+			res.Checks = append(res.Checks, HealthChecks{
+				Status: true,
+				Name:   name,
+				Detail: detail,
+			})
+		} else {
+			res.StatusCode = http.StatusInternalServerError
+
+			res.Checks = append(res.Checks, HealthChecks{
+				Status: false,
+				Name:   name,
+				Detail: err.Error(),
+			})
+		}
+	}
+
 	for name, checker := range server.Checkers {
 		err := checker()
 
@@ -71,8 +96,9 @@ func (server *HealthCheckServer) /* @manglo:ignore */ ServeHTTP(w http.ResponseW
 
 func NewHealthCheckServer() *HealthCheckServer {
 	return &HealthCheckServer{
-		port:     "8099",
-		Checkers: make(map[string]HealthCheckHandler, 0),
+		port:           "8099",
+		Checkers:       make(map[string]HealthCheckHandler, 0),
+		DetailCheckers: make(map[string]HealthCheckDetailHandler, 0),
 	}
 }
 
@@ -110,3 +136,7 @@ func (server *HealthCheckServer) Stop() {
 func (server *HealthCheckServer) Register(name string, handler HealthCheckHandler) {
 	server.Checkers[name] = handler
 }
+
+func (server *HealthCheckServer) RegisterWithDetail(name string, handler HealthCheckDetailHandler) {
+	server.DetailCheckers[name] = handler
+}
@@ -0,0 +1,93 @@
+package maps
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// CacheRepository keeps the maps of another repository in a directory, by
+// checksum. The maps without a checksum are not cached.
+type CacheRepository struct {
+	dir    string
+	source Repository
+}
+
+func NewCacheRepository(dir string, source Repository) (*CacheRepository, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	return &CacheRepository{dir: dir, source: source}, nil
+}
+
+func (r *CacheRepository) Fetch(ref Ref) ([]byte, error) {
+	if ref.Sha256 == "" {
+		return r.source.Fetch(ref)
+	}
+
+	checksum := strings.ToLower(ref.Sha256)
+
+	if _, err := hex.DecodeString(checksum); err != nil || len(checksum) != sha256.Size*2 {
+		return nil, errors.New("Invalid checksum " + ref.Sha256 + " of map " + ref.Path)
+	}
+
+	file := filepath.Join(r.dir, checksum+".json")
+
+	// A corrupted entry is fetched again
+	if data, err := ioutil.ReadFile(file); err == nil && Verify(data, checksum) == nil {
+		return data, nil
+	}
+
+	data, err := r.source.Fetch(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := Verify(data, checksum); err != nil {
+		return nil, errors.New("Map " + ref.Path + ": " + err.Error())
+	}
+
+	return data, writeFile(file, data)
+}
+
+// writeFile renames a temporary file, the readers never see a partial map
+func writeFile(file string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(file), ".map")
+	if err != nil {
+		return err
+	}
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), file)
+}
+
+// Verify returns an error when the checksum is set and doesn't match the data
+func Verify(data []byte, checksum string) error {
+	if checksum == "" {
+		return nil
+	}
+
+	sum := sha256.Sum256(data)
+	actual := hex.EncodeToString(sum[:])
+
+	if actual != strings.ToLower(checksum) {
+		return errors.New("checksum mismatch, expected " + checksum + ", got " + actual)
+	}
+
+	return nil
+}
@@ -0,0 +1,232 @@
+package maps
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/bytearena/core/common/types/mapcontainer"
+	"github.com/stretchr/testify/assert"
+)
+
+const testMap = `{"meta": {"readme": "Test map", "kind": "deathmatch"}}`
+
+func checksum(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "maps")
+	assert.Nil(t, err)
+
+	return dir
+}
+
+// countingRepository counts the fetches, and fails when it has no map
+type countingRepository struct {
+	data    string
+	fetches int32
+}
+
+func (r *countingRepository) Fetch(ref Ref) ([]byte, error) {
+	atomic.AddInt32(&r.fetches, 1)
+
+	if r.data == "" {
+		return nil, errors.New("unavailable")
+	}
+
+	return []byte(r.data), nil
+}
+
+// blockingRepository serves the maps once released
+type blockingRepository struct {
+	started chan Ref
+	release chan struct{}
+	fetches int32
+}
+
+func (r *blockingRepository) Fetch(ref Ref) ([]byte, error) {
+	atomic.AddInt32(&r.fetches, 1)
+
+	r.started <- ref
+	<-r.release
+
+	return []byte(testMap), nil
+}
+
+func TestDirectoryRepository(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	assert.Nil(t, os.MkdirAll(filepath.Join(dir, "maps", "deathmatch"), 0755))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "maps", "deathmatch", "map.json"), []byte(testMap), 0644))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "secret.json"), []byte(testMap), 0644))
+
+	repository, err := NewRepository(filepath.Join(dir, "maps"))
+	assert.Nil(t, err)
+
+	loaded, err := NewStore(repository).Get(Ref{Path: "deathmatch/map.json"})
+	assert.Nil(t, err)
+	assert.Equal(t, "deathmatch", loaded.Meta.Kind)
+
+	// The path can't leave the directory
+	_, err = repository.Fetch(Ref{Path: "../secret.json"})
+	assert.True(t, os.IsNotExist(err))
+
+	_, err = NewDirectoryRepository(filepath.Join(dir, "secret.json"))
+	assert.EqualError(t, err, filepath.Join(dir, "secret.json")+" is not a directory")
+}
+
+func TestHTTPRepository(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/maps/deathmatch/map.json" {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Write([]byte(testMap))
+	}))
+	defer server.Close()
+
+	repository, err := NewRepository(server.URL + "/maps/")
+	assert.Nil(t, err)
+
+	data, err := repository.Fetch(Ref{Path: "deathmatch/map.json"})
+	assert.Nil(t, err)
+	assert.Equal(t, testMap, string(data))
+
+	data, err = repository.Fetch(Ref{Path: server.URL + "/maps/deathmatch/map.json"})
+	assert.Nil(t, err)
+	assert.Equal(t, testMap, string(data))
+
+	_, err = repository.Fetch(Ref{Path: "missing.json"})
+	assert.EqualError(t, err, "HTTP error, status 404 for "+server.URL+"/maps/missing.json")
+}
+
+func TestCacheRepository(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	source := &countingRepository{data: testMap}
+	ref := Ref{Path: "map.json", Sha256: checksum(testMap)}
+
+	cache, err := NewCacheRepository(dir, source)
+	assert.Nil(t, err)
+
+	data, err := cache.Fetch(ref)
+	assert.Nil(t, err)
+	assert.Equal(t, testMap, string(data))
+
+	// Served from the directory
+	source.data = ""
+
+	data, err = cache.Fetch(ref)
+	assert.Nil(t, err)
+	assert.Equal(t, testMap, string(data))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&source.fetches))
+
+	// A corrupted entry is fetched again
+	file := filepath.Join(dir, ref.Sha256+".json")
+	assert.Nil(t, ioutil.WriteFile(file, []byte("{}"), 0644))
+
+	source.data = testMap
+
+	data, err = cache.Fetch(ref)
+	assert.Nil(t, err)
+	assert.Equal(t, testMap, string(data))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&source.fetches))
+
+	// The source serving another map isn't cached
+	source.data = `{}`
+
+	_, err = cache.Fetch(Ref{Path: "other.json", Sha256: checksum(testMap[1:])})
+	assert.EqualError(t, err, "Map other.json: checksum mismatch, expected "+checksum(testMap[1:])+", got "+checksum(`{}`))
+
+	_, err = cache.Fetch(Ref{Path: "other.json", Sha256: "../../etc"})
+	assert.EqualError(t, err, "Invalid checksum ../../etc of map other.json")
+}
+
+func TestStore(t *testing.T) {
+	source := &countingRepository{data: testMap}
+	store := NewStore(source)
+
+	first, err := store.Get(Ref{Path: "map.json", Sha256: checksum(testMap)})
+	assert.Nil(t, err)
+	assert.Equal(t, "Test map", first.Meta.Readme)
+
+	second, err := store.Get(Ref{Path: "map.json", Sha256: checksum(testMap)})
+	assert.Nil(t, err)
+	assert.True(t, first == second)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&source.fetches))
+
+	_, err = store.Get(Ref{Path: "map.json", Sha256: checksum("{}")})
+	assert.EqualError(t, err, "Map map.json: checksum mismatch, expected "+checksum("{}")+", got "+checksum(testMap))
+
+	// The errors aren't kept
+	source.data = ""
+
+	_, err = store.Get(Ref{})
+	assert.EqualError(t, err, "Could not fetch map "+DEFAULT_MAP.Path+"; unavailable")
+
+	source.data = "{"
+
+	_, err = store.Get(Ref{Path: "broken.json"})
+	assert.Error(t, err)
+	assert.Equal(t, int32(4), atomic.LoadInt32(&source.fetches))
+}
+
+func TestStoreFetchesOutsideOfTheLock(t *testing.T) {
+	source := &blockingRepository{
+		started: make(chan Ref, 10),
+		release: make(chan struct{}),
+	}
+
+	store := NewStore(source)
+
+	close(source.release)
+
+	cached, err := store.Get(Ref{Path: "cached.json"})
+	assert.Nil(t, err)
+	assert.Equal(t, "cached.json", (<-source.started).Path)
+
+	source.release = make(chan struct{})
+
+	var wg sync.WaitGroup
+	results := make([]*mapcontainer.MapContainer, 3)
+
+	for i := range results {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			loaded, err := store.Get(Ref{Path: "slow.json"})
+			assert.Nil(t, err)
+
+			results[i] = loaded
+		}(i)
+	}
+
+	assert.Equal(t, "slow.json", (<-source.started).Path)
+
+	// The loaded maps are served while another one is being fetched
+	loaded, err := store.Get(Ref{Path: "cached.json"})
+	assert.Nil(t, err)
+	assert.True(t, cached == loaded)
+
+	close(source.release)
+	wg.Wait()
+
+	// The concurrent callers shared a single fetch
+	assert.Equal(t, int32(2), atomic.LoadInt32(&source.fetches))
+	assert.True(t, results[0] == results[1] && results[1] == results[2])
+}
@@ -0,0 +1,119 @@
+package maps
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var DEFAULT_HTTP_TIMEOUT = 30 * time.Second
+
+// Ref is the map of an arena, as referenced by the GraphQL API
+type Ref struct {
+	// Path of the map in the repository, or its URL
+	Path string
+	// Hexadecimal SHA-256 checksum of the map, optional
+	Sha256 string
+}
+
+func (ref Ref) String() string {
+	if ref.Sha256 == "" {
+		return ref.Path
+	}
+
+	return ref.Path + "@" + ref.Sha256
+}
+
+// Repository returns the JSON of the maps
+type Repository interface {
+	Fetch(ref Ref) ([]byte, error)
+}
+
+// NewRepository returns the HTTP repository of the base URL, or the
+// repository of the directory
+func NewRepository(source string) (Repository, error) {
+	if isURL(source) {
+		return NewHTTPRepository(source), nil
+	}
+
+	return NewDirectoryRepository(source)
+}
+
+func isURL(path string) bool {
+	return strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://")
+}
+
+// DirectoryRepository reads the maps from a directory, such as maps/
+type DirectoryRepository struct {
+	dir string
+}
+
+func NewDirectoryRepository(dir string) (*DirectoryRepository, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	if !info.IsDir() {
+		return nil, errors.New(dir + " is not a directory")
+	}
+
+	return &DirectoryRepository{dir: dir}, nil
+}
+
+func (r *DirectoryRepository) Fetch(ref Ref) ([]byte, error) {
+	if ref.Path == "" || isURL(ref.Path) {
+		return nil, errors.New("Invalid map path \"" + ref.Path + "\"")
+	}
+
+	// The path can't leave the directory
+	return ioutil.ReadFile(filepath.Join(r.dir, filepath.FromSlash(cleanPath(ref.Path))))
+}
+
+// HTTPRepository downloads the maps, their paths are relative to its base URL
+// unless they're URLs
+type HTTPRepository struct {
+	baseurl    string
+	httpclient *http.Client
+}
+
+func NewHTTPRepository(baseurl string) *HTTPRepository {
+	return &HTTPRepository{
+		baseurl:    strings.TrimSuffix(baseurl, "/"),
+		httpclient: &http.Client{Timeout: DEFAULT_HTTP_TIMEOUT},
+	}
+}
+
+func (r *HTTPRepository) Fetch(ref Ref) ([]byte, error) {
+	if ref.Path == "" {
+		return nil, errors.New("Invalid map path \"\"")
+	}
+
+	url := ref.Path
+	if !isURL(url) {
+		url = r.baseurl + cleanPath(url)
+	}
+
+	resp, err := r.httpclient.Get(url)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("HTTP error, status " + strconv.Itoa(resp.StatusCode) + " for " + url)
+	}
+
+	return ioutil.ReadAll(resp.Body)
+}
+
+// cleanPath returns the path rooted, without ..
+func cleanPath(path string) string {
+	return filepath.ToSlash(filepath.Clean("/" + filepath.FromSlash(path)))
+}
@@ -0,0 +1,116 @@
+package maps
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+
+	"github.com/bytearena/core/common/types/mapcontainer"
+)
+
+// DEFAULT_MAP is the map of the games, the GraphQL API gives no map yet
+var DEFAULT_MAP = Ref{Path: "deathmatch/desert/death-valley/map.json"}
+
+var DEFAULT_SOURCE = "https://static.bytearena.com/assets/bytearena/maps/"
+
+// Store loads the maps from a repository, and keeps them in memory: the games
+// are fetched again periodically, their maps aren't
+type Store struct {
+	repository Repository
+	maps       map[string]*mapcontainer.MapContainer
+	fetching   map[string]*pendingFetch
+	lock       sync.Mutex
+}
+
+// pendingFetch is shared by the callers asking for a map being fetched
+type pendingFetch struct {
+	done   chan struct{}
+	loaded *mapcontainer.MapContainer
+	err    error
+}
+
+func NewStore(repository Repository) *Store {
+	return &Store{
+		repository: repository,
+		maps:       make(map[string]*mapcontainer.MapContainer),
+		fetching:   make(map[string]*pendingFetch),
+	}
+}
+
+// Get returns the map, checked against its checksum. A map is fetched once
+// at a time, without blocking the maps already loaded.
+func (s *Store) Get(ref Ref) (*mapcontainer.MapContainer, error) {
+	if ref.Path == "" {
+		ref = DEFAULT_MAP
+	}
+
+	key := ref.String()
+
+	s.lock.Lock()
+
+	if loaded, ok := s.maps[key]; ok {
+		s.lock.Unlock()
+		return loaded, nil
+	}
+
+	if pending, ok := s.fetching[key]; ok {
+		s.lock.Unlock()
+		<-pending.done
+
+		return pending.loaded, pending.err
+	}
+
+	pending := &pendingFetch{done: make(chan struct{})}
+	s.fetching[key] = pending
+	s.lock.Unlock()
+
+	pending.loaded, pending.err = s.load(ref)
+
+	s.lock.Lock()
+	delete(s.fetching, key)
+
+	// The errors aren't kept
+	if pending.err == nil {
+		s.maps[key] = pending.loaded
+	}
+
+	s.lock.Unlock()
+	close(pending.done)
+
+	return pending.loaded, pending.err
+}
+
+func (s *Store) load(ref Ref) (*mapcontainer.MapContainer, error) {
+	data, err := s.repository.Fetch(ref)
+	if err != nil {
+		return nil, errors.New("Could not fetch map " + ref.Path + "; " + err.Error())
+	}
+
+	if err := Verify(data, ref.Sha256); err != nil {
+		return nil, errors.New("Map " + ref.Path + ": " + err.Error())
+	}
+
+	var loaded mapcontainer.MapContainer
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		return nil, errors.New("Could not load map JSON " + ref.Path + "; " + err.Error())
+	}
+
+	return &loaded, nil
+}
+
+// OpenStore returns the store of the repository of the source, a base URL or
+// a directory, cached in cacheDir when it's set
+func OpenStore(source, cacheDir string) (*Store, error) {
+	repository, err := NewRepository(source)
+	if err != nil {
+		return nil, err
+	}
+
+	if cacheDir != "" {
+		if repository, err = NewCacheRepository(cacheDir, repository); err != nil {
+			return nil, err
+		}
+	}
+
+	return NewStore(repository), nil
+}
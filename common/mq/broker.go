@@ -0,0 +1,70 @@
+package mq
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strconv"
+
+	"github.com/bytearena/core/common/mq"
+)
+
+// VerifiedCallback gets the component which signed the message, it's empty
+// when the messages of the topic are not verified
+type VerifiedCallback func(msg mq.BrokerMessage, component string)
+
+// Broker is implemented by every transport of the MQ
+type Broker interface {
+	mq.ClientInterface
+
+	// SubscribeWithContext is Subscribe with a handle to unsubscribe and a
+	// buffer between the transport and the handlers
+	SubscribeWithContext(ctx context.Context, channel string, topic string, onmessage mq.SubscriptionCallback, options SubscriptionOptions) (*Subscription, error)
+	SubscribeVerified(channel string, topic string, onmessage VerifiedCallback) error
+
+	// PublishSigned signs with the config instead of the one of the client
+	PublishSigned(config SigningConfig, channel, topic string, payload interface{}) error
+
+	// DeleteTopic drops the messages kept for a topic and its consumer
+	// groups, for the topics of a component which is gone
+	DeleteTopic(channel, topic string) error
+
+	Ping() error
+	Stop()
+
+	// Health returns an error while the transport can't deliver messages
+	Health() error
+
+	EnableSigning(config SigningConfig)
+	EnableSigningFromEnv(component string) error
+	Authorize(channel, topic string, components ...string)
+	Trust(channel, topic string, keyring Keyring)
+}
+
+// NewBroker connects with the transport set in MQ_TRANSPORT: "pubsub" by
+// default, or "streams" to keep the messages of the consumers which are down.
+// With streams the consumers of a group share the messages, the group is
+// MQ_GROUP or the given one.
+func NewBroker(host, group string) (Broker, error) {
+	transport := os.Getenv("MQ_TRANSPORT")
+
+	switch transport {
+	case "", "pubsub":
+		return NewClient(host)
+
+	case "streams":
+		if envGroup := os.Getenv("MQ_GROUP"); envGroup != "" {
+			group = envGroup
+		}
+
+		hostname, err := os.Hostname()
+
+		if err != nil {
+			return nil, err
+		}
+
+		return NewStreamClient(host, group, hostname+"-"+strconv.Itoa(os.Getpid()))
+	}
+
+	return nil, errors.New("Unknown MQ_TRANSPORT " + transport + ", expected pubsub or streams")
+}
@@ -1,9 +1,11 @@
 package mq
 
 import (
-	"encoding/json"
+	"context"
 	"errors"
+	"strconv"
 	"sync"
+	"time"
 
 	"github.com/bytearena/core/common/mq"
 	"github.com/bytearena/core/common/utils"
@@ -11,150 +13,564 @@ import (
 	"github.com/go-redis/redis"
 )
 
-type brokerAction struct {
-	Action  string      `json:"action"`
-	Channel string      `json:"channel"`
-	Topic   string      `json:"topic"`
-	Data    interface{} `json:"data"`
+var (
+	// The delay between two reconnection attempts doubles up to the max
+	RECONNECT_MIN_BACKOFF = 100 * time.Millisecond
+	RECONNECT_MAX_BACKOFF = 30 * time.Second
+
+	// A connection which doesn't answer is considered down
+	PING_FREQ = 5 * time.Second
+)
+
+type ConnectionState int
+
+const (
+	CONNECTION_UP ConnectionState = iota
+	CONNECTION_DOWN
+)
+
+func (state ConnectionState) String() string {
+	if state == CONNECTION_UP {
+		return "up"
+	}
+
+	return "down"
 }
 
-type Client struct {
-	conn          *redis.Client
-	subscriptions map[string]*redis.PubSub
-	mu            sync.Mutex
-	host          string
-	isClosed      bool
+type ConnectionStateCallback func(state ConnectionState)
+
+// pubsubConn is a connection to Redis, a new one is dialed after a failure
+type pubsubConn interface {
+	Publish(channel, payload string) error
+	Subscribe(channel string) (pubsubReceiver, error)
+	Ping() error
+	Close() error
 }
 
-func NewClient(host string) (*Client, error) {
-	c := &Client{
-		conn:          nil,
-		subscriptions: make(map[string]*redis.PubSub, 0),
-		host:          host,
-		isClosed:      false,
+type pubsubReceiver interface {
+	ReceiveMessage() (string, error)
+	Close() error
+}
+
+type redisPubSubConn struct {
+	conn *redis.Client
+}
+
+func (c redisPubSubConn) Publish(channel, payload string) error {
+	return c.conn.Publish(channel, payload).Err()
+}
+
+func (c redisPubSubConn) Subscribe(channel string) (pubsubReceiver, error) {
+	return redisPubSubReceiver{c.conn.Subscribe(channel)}, nil
+}
+
+func (c redisPubSubConn) Ping() error {
+	return c.conn.Ping().Err()
+}
+
+func (c redisPubSubConn) Close() error {
+	return c.conn.Close()
+}
+
+type redisPubSubReceiver struct {
+	pubsub *redis.PubSub
+}
+
+func (r redisPubSubReceiver) ReceiveMessage() (string, error) {
+	msg, err := r.pubsub.ReceiveMessage()
+
+	if err != nil {
+		return "", err
 	}
 
-	hasConnected := c.connect()
-	utils.Assert(hasConnected, "Error: cannot connect to messagebroker host "+host)
+	return msg.Payload, nil
+}
 
-	return c, nil
+func (r redisPubSubReceiver) Close() error {
+	return r.pubsub.Close()
 }
 
-func channelAndTopicToString(channel, topic string) string {
-	return channel + "." + topic
+type subscription struct {
+	channel   string
+	topic     string
+	verifier  *verifier
+	onmessage VerifiedCallback
+	handle    *Subscription
+
+	// nil while disconnected
+	receiver pubsubReceiver
+}
+
+// Client delivers the messages through Redis PubSub. It reconnects when the
+// connection is lost and subscribes again to its topics, the messages
+// published meanwhile are lost.
+type Client struct {
+	dial          func() (pubsubConn, error)
+	conn          pubsubConn
+	subscriptions []*subscription
+	mu            sync.Mutex
+
+	state          ConnectionState
+	lastError      error
+	downSince      time.Time
+	stateCallbacks []ConnectionStateCallback
+	lostChan       chan struct{}
+
+	stopChan chan struct{}
+	stopOnce sync.Once
+	routines sync.WaitGroup
+
+	envelope
 }
 
-func (client *Client) connect() bool {
-	conn := redis.NewClient(&redis.Options{
-		Addr:     client.host + ":6379",
-		Password: "", // no password set
-		DB:       0,  // use default DB
+func NewClient(host string) (*Client, error) {
+	return newClient(func() (pubsubConn, error) {
+		conn := redis.NewClient(&redis.Options{
+			Addr:     host + ":6379",
+			Password: "", // no password set
+			DB:       0,  // use default DB
+		})
+
+		if conn == nil {
+			return nil, errors.New("Cannot connect to messagebroker host " + host)
+		}
+
+		return redisPubSubConn{conn}, nil
 	})
+}
 
-	if conn == nil {
-		return false
+func newClient(dial func() (pubsubConn, error)) (*Client, error) {
+	client := &Client{
+		dial:          dial,
+		subscriptions: make([]*subscription, 0),
+		state:         CONNECTION_UP,
+		lostChan:      make(chan struct{}, 1),
+		stopChan:      make(chan struct{}),
+	}
+
+	conn, err := client.connect()
+
+	if err != nil {
+		return nil, err
 	}
 
 	client.conn = conn
 
-	return true
+	client.routines.Add(1)
+	go client.supervise()
+
+	return client, nil
+}
+
+// connect dials and checks that the connection is usable
+func (client *Client) connect() (pubsubConn, error) {
+	conn, err := client.dial()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := conn.Ping(); err != nil {
+		conn.Close()
+		return nil, errors.New("Could not ping messagebroker: " + err.Error())
+	}
+
+	return conn, nil
+}
+
+func (client *Client) isStopped() bool {
+	select {
+	case <-client.stopChan:
+		return true
+	default:
+		return false
+	}
 }
 
 func (client *Client) Stop() {
-	client.isClosed = true
+	client.stopOnce.Do(func() {
+		close(client.stopChan)
+
+		client.mu.Lock()
+
+		// Stop all current Redis PubSub subscriptions
+		for _, sub := range client.subscriptions {
+			if sub.receiver != nil {
+				sub.receiver.Close()
+			}
+
+			sub.handle.dispatcher.stop()
+		}
+
+		err := client.conn.Close()
+
+		client.mu.Unlock()
+
+		utils.Check(err, "Unable to Redis client connection")
+
+		client.routines.Wait()
+	})
+}
+
+// OnConnectionState calls the callback each time the connection is lost or
+// established again
+func (client *Client) OnConnectionState(callback ConnectionStateCallback) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	client.stateCallbacks = append(client.stateCallbacks, callback)
+}
+
+func (client *Client) GetConnectionState() ConnectionState {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	return client.state
+}
+
+// Health returns an error while the connection is down, unlike Ping it
+// doesn't wait on Redis
+func (client *Client) Health() error {
+	client.mu.Lock()
+	defer client.mu.Unlock()
 
-	// Stop all current Redis PubSub subscriptions
-	for _, pubsub := range client.subscriptions {
-		pubsub.Close()
+	if client.state == CONNECTION_UP {
+		return nil
 	}
 
-	err := client.conn.Close()
-	utils.Check(err, "Unable to Redis client connection")
+	return errors.New("Disconnected from messagebroker since " + client.downSince.Format(time.RFC3339) + ": " + client.lastError.Error())
 }
 
-/* <mq.MessageBrokerClientInterface> */
-func (client *Client) Subscribe(channel string, topic string, onmessage mq.SubscriptionCallback) error {
+// Must be called with the lock held
+func (client *Client) setState(state ConnectionState) []ConnectionStateCallback {
+	client.state = state
+
+	if state == CONNECTION_DOWN {
+		client.downSince = time.Now()
+	}
+
+	callbacks := make([]ConnectionStateCallback, len(client.stateCallbacks))
+	copy(callbacks, client.stateCallbacks)
+
+	return callbacks
+}
+
+func notifyState(callbacks []ConnectionStateCallback, state ConnectionState) {
+	for _, callback := range callbacks {
+		callback(state)
+	}
+}
+
+// connectionLost triggers a reconnection unless one is already running
+func (client *Client) connectionLost(err error) {
 	client.mu.Lock()
 
-	channelName := channelAndTopicToString(channel, topic)
+	if client.state == CONNECTION_DOWN || client.isStopped() {
+		client.mu.Unlock()
+		return
+	}
 
-	pubsub := client.conn.Subscribe(channelName)
+	client.lastError = err
+	callbacks := client.setState(CONNECTION_DOWN)
 
 	client.mu.Unlock()
 
-	if pubsub == nil {
-		return errors.New("Could not subscribe to channel " + channelName)
+	utils.RecoverableError("mqclient", "Lost connection to messagebroker: "+err.Error())
+	notifyState(callbacks, CONNECTION_DOWN)
+
+	select {
+	case client.lostChan <- struct{}{}:
+	default:
 	}
+}
 
-	utils.Debug("mq", "Subscribed to bus "+channelName)
+// supervise pings the connection and reconnects when it's lost
+func (client *Client) supervise() {
+	defer client.routines.Done()
 
-	client.subscriptions[channelName] = pubsub
+	ticker := time.NewTicker(PING_FREQ)
+	defer ticker.Stop()
 
-	/*
-		Handle message loop
-	*/
-	go func() {
-		for {
-			if client.isClosed == true {
-				break
-			}
+	for {
+		select {
+		case <-client.stopChan:
+			return
 
-			msg, err := pubsub.ReceiveMessage()
+		case <-client.lostChan:
+			client.reconnect()
 
-			if err != nil {
-				utils.RecoverableError("mqclient", "Could not receive message: "+err.Error())
-				continue
+		case <-ticker.C:
+			if err := client.Ping(); err != nil {
+				client.connectionLost(err)
 			}
+		}
+	}
+}
 
-			var mqMessage mq.BrokerMessage
+func reconnectBackoff(attempt int) time.Duration {
+	backoff := RECONNECT_MIN_BACKOFF
 
-			err = json.Unmarshal([]byte(msg.Payload), &mqMessage)
+	for i := 0; i < attempt && backoff < RECONNECT_MAX_BACKOFF; i++ {
+		backoff *= 2
+	}
+
+	if backoff > RECONNECT_MAX_BACKOFF {
+		return RECONNECT_MAX_BACKOFF
+	}
+
+	return backoff
+}
+
+func (client *Client) reconnect() {
+	for attempt := 0; ; attempt++ {
+		select {
+		case <-time.After(reconnectBackoff(attempt)):
+		case <-client.stopChan:
+			return
+		}
 
-			if err != nil {
-				utils.RecoverableError("mqclient", "Received invalid message; "+err.Error()+";"+msg.Payload)
-				continue
+		conn, err := client.connect()
+
+		if err == nil {
+			err = client.replaceConn(conn)
+		}
+
+		if err == nil {
+			return
+		}
+
+		client.mu.Lock()
+		client.lastError = err
+		client.mu.Unlock()
+
+		utils.Debug("mqclient", "Reconnection attempt "+strconv.Itoa(attempt+1)+" failed: "+err.Error())
+	}
+}
+
+// replaceConn subscribes again to every topic on the new connection
+func (client *Client) replaceConn(conn pubsubConn) error {
+	client.mu.Lock()
+
+	if client.isStopped() {
+		client.mu.Unlock()
+		conn.Close()
+
+		return nil
+	}
+
+	receivers := make([]pubsubReceiver, len(client.subscriptions))
+
+	for i, sub := range client.subscriptions {
+		receiver, err := conn.Subscribe(channelAndTopicToString(sub.channel, sub.topic))
+
+		if err != nil {
+			client.mu.Unlock()
+
+			for _, receiver := range receivers[:i] {
+				receiver.Close()
 			}
 
-			onmessage(mqMessage)
+			conn.Close()
+
+			return errors.New("Could not subscribe again to " + channelAndTopicToString(sub.channel, sub.topic) + ": " + err.Error())
 		}
-	}()
+
+		receivers[i] = receiver
+	}
+
+	client.conn.Close()
+	client.conn = conn
+
+	for i, sub := range client.subscriptions {
+		if sub.receiver != nil {
+			sub.receiver.Close()
+		}
+
+		client.listen(sub, receivers[i])
+	}
+
+	callbacks := client.setState(CONNECTION_UP)
+
+	client.mu.Unlock()
+
+	utils.Debug("mqclient", "Reconnected to messagebroker, subscribed again to "+strconv.Itoa(len(receivers))+" topics")
+	notifyState(callbacks, CONNECTION_UP)
 
 	return nil
 }
 
-func (client *Client) Publish(channel, topic string, payload interface{}) error {
+// Must be called with the lock held
+func (client *Client) listen(sub *subscription, receiver pubsubReceiver) {
+	sub.receiver = receiver
+
+	client.routines.Add(1)
+	go client.receive(sub, receiver)
+}
+
+func (client *Client) receive(sub *subscription, receiver pubsubReceiver) {
+	defer client.routines.Done()
+
+	for {
+		payload, err := receiver.ReceiveMessage()
+
+		if err != nil {
+			if client.isStopped() {
+				return
+			}
+
+			// The receiver was replaced after a reconnection
+			client.mu.Lock()
+			isCurrent := sub.receiver == receiver
+			client.mu.Unlock()
+
+			if isCurrent {
+				client.connectionLost(err)
+			}
+
+			return
+		}
+
+		mqMessage, component, err := client.decode([]byte(payload), sub.channel, sub.topic, sub.verifier)
+
+		if err != nil {
+			utils.RecoverableError("mqclient", err.Error())
+			continue
+		}
+
+		sub.handle.dispatcher.push(func() {
+			sub.onmessage(mqMessage, component)
+		})
+	}
+}
+
+/* <mq.MessageBrokerClientInterface> */
+func (client *Client) Subscribe(channel string, topic string, onmessage mq.SubscriptionCallback) error {
+	_, err := client.SubscribeWithContext(context.Background(), channel, topic, onmessage, DEFAULT_SUBSCRIPTION_OPTIONS)
+
+	return err
+}
+
+func (client *Client) SubscribeVerified(channel string, topic string, onmessage VerifiedCallback) error {
+	_, err := client.subscribe(context.Background(), channel, topic, onmessage, DEFAULT_SUBSCRIPTION_OPTIONS)
+
+	return err
+}
+
+// SubscribeWithContext subscribes until the context is done or the returned
+// subscription is unsubscribed
+func (client *Client) SubscribeWithContext(ctx context.Context, channel string, topic string, onmessage mq.SubscriptionCallback, options SubscriptionOptions) (*Subscription, error) {
+	return client.subscribe(ctx, channel, topic, func(msg mq.BrokerMessage, component string) {
+		onmessage(msg)
+	}, options)
+}
+
+func (client *Client) subscribe(ctx context.Context, channel string, topic string, onmessage VerifiedCallback, options SubscriptionOptions) (*Subscription, error) {
 	channelName := channelAndTopicToString(channel, topic)
 
+	sub := &subscription{
+		channel:   channel,
+		topic:     topic,
+		verifier:  client.newVerifier(channel, topic),
+		onmessage: onmessage,
+	}
+
 	client.mu.Lock()
+	defer client.mu.Unlock()
 
-	brokerAction := brokerAction{
-		Action:  "pub",
-		Channel: channel,
-		Topic:   topic,
-		Data:    payload,
+	var receiver pubsubReceiver
+
+	// The subscription is made on reconnection
+	if client.state == CONNECTION_DOWN {
+		utils.Debug("mq", "Disconnected, will subscribe to bus "+channelName+" on reconnection")
+	} else {
+		var err error
+		receiver, err = client.conn.Subscribe(channelName)
+
+		if err != nil {
+			return nil, errors.New("Could not subscribe to channel " + channelName + ": " + err.Error())
+		}
+
+		utils.Debug("mq", "Subscribed to bus "+channelName)
 	}
 
-	jsonPayload, err := json.Marshal(brokerAction)
+	sub.handle = newSubscription(ctx, channelName, options, func() {
+		client.unsubscribe(sub)
+	})
+
+	client.subscriptions = append(client.subscriptions, sub)
+
+	if receiver != nil {
+		client.listen(sub, receiver)
+	}
+
+	return sub.handle, nil
+}
+
+func (client *Client) unsubscribe(sub *subscription) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	for i, other := range client.subscriptions {
+		if other == sub {
+			client.subscriptions = append(client.subscriptions[:i], client.subscriptions[i+1:]...)
+			break
+		}
+	}
+
+	// The receive loop stops without reporting a connection loss
+	if receiver := sub.receiver; receiver != nil {
+		sub.receiver = nil
+		receiver.Close()
+	}
+
+	utils.Debug("mq", "Unsubscribed from bus "+channelAndTopicToString(sub.channel, sub.topic))
+}
+
+func (client *Client) Publish(channel, topic string, payload interface{}) error {
+	jsonPayload, err := client.encode(channel, topic, payload)
 
 	if err != nil {
-		client.mu.Unlock()
 		return err
 	}
 
-	res := client.conn.Publish(channelName, string(jsonPayload))
-	client.mu.Unlock()
+	return client.send(channel, topic, jsonPayload)
+}
+
+func (client *Client) PublishSigned(config SigningConfig, channel, topic string, payload interface{}) error {
+	jsonPayload, err := encodeSigned(&config, channel, topic, payload)
+
+	if err != nil {
+		return err
+	}
 
-	if res.Err() != nil {
-		return res.Err()
+	return client.send(channel, topic, jsonPayload)
+}
+
+func (client *Client) send(channel, topic string, jsonPayload []byte) error {
+	channelName := channelAndTopicToString(channel, topic)
+
+	if err := client.Health(); err != nil {
+		return err
 	}
 
+	client.mu.Lock()
+	conn := client.conn
+	client.mu.Unlock()
+
+	return conn.Publish(channelName, string(jsonPayload))
+}
+
+// DeleteTopic has nothing to do, Pub/Sub doesn't keep the messages
+func (client *Client) DeleteTopic(channel, topic string) error {
 	return nil
 }
 
 func (client *Client) Ping() error {
-	_, err := client.conn.Ping().Result()
+	client.mu.Lock()
+	conn := client.conn
+	client.mu.Unlock()
 
-	return err
+	return conn.Ping()
 }
 
 /* </mq.MessageBrokerClientInterface> */
@@ -0,0 +1,303 @@
+package mq
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// memoryRedis is a Redis PubSub which can be restarted, the connections
+// opened before a restart are broken
+type memoryRedis struct {
+	mutex     sync.Mutex
+	isRunning bool
+	epoch     int
+	receivers map[string][]*memoryRedisReceiver
+	dials     int
+}
+
+func newMemoryRedis() *memoryRedis {
+	return &memoryRedis{
+		isRunning: true,
+		receivers: make(map[string][]*memoryRedisReceiver),
+	}
+}
+
+func (m *memoryRedis) stop() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.isRunning = false
+	m.epoch++
+
+	for _, receivers := range m.receivers {
+		for _, receiver := range receivers {
+			receiver.close()
+		}
+	}
+
+	m.receivers = make(map[string][]*memoryRedisReceiver)
+}
+
+func (m *memoryRedis) start() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.isRunning = true
+}
+
+func (m *memoryRedis) getDials() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.dials
+}
+
+func (m *memoryRedis) dial() (pubsubConn, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.dials++
+
+	if !m.isRunning {
+		return nil, errors.New("connection refused")
+	}
+
+	return &memoryRedisConn{redis: m, epoch: m.epoch}, nil
+}
+
+type memoryRedisConn struct {
+	redis  *memoryRedis
+	epoch  int
+	closed bool
+}
+
+// Must be called with the lock of the server held
+func (c *memoryRedisConn) check() error {
+	if c.closed {
+		return errors.New("use of closed connection")
+	}
+
+	if !c.redis.isRunning || c.epoch != c.redis.epoch {
+		return errors.New("connection reset by peer")
+	}
+
+	return nil
+}
+
+func (c *memoryRedisConn) Publish(channel, payload string) error {
+	c.redis.mutex.Lock()
+	defer c.redis.mutex.Unlock()
+
+	if err := c.check(); err != nil {
+		return err
+	}
+
+	for _, receiver := range c.redis.receivers[channel] {
+		select {
+		case receiver.messages <- payload:
+		default:
+		}
+	}
+
+	return nil
+}
+
+func (c *memoryRedisConn) Subscribe(channel string) (pubsubReceiver, error) {
+	c.redis.mutex.Lock()
+	defer c.redis.mutex.Unlock()
+
+	if err := c.check(); err != nil {
+		return nil, err
+	}
+
+	receiver := &memoryRedisReceiver{
+		messages: make(chan string, 16),
+		closed:   make(chan struct{}),
+	}
+
+	c.redis.receivers[channel] = append(c.redis.receivers[channel], receiver)
+
+	return receiver, nil
+}
+
+func (c *memoryRedisConn) Ping() error {
+	c.redis.mutex.Lock()
+	defer c.redis.mutex.Unlock()
+
+	return c.check()
+}
+
+func (c *memoryRedisConn) Close() error {
+	c.redis.mutex.Lock()
+	defer c.redis.mutex.Unlock()
+
+	c.closed = true
+
+	return nil
+}
+
+type memoryRedisReceiver struct {
+	messages  chan string
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (r *memoryRedisReceiver) close() {
+	r.closeOnce.Do(func() {
+		close(r.closed)
+	})
+}
+
+func (r *memoryRedisReceiver) ReceiveMessage() (string, error) {
+	select {
+	case payload := <-r.messages:
+		return payload, nil
+	case <-r.closed:
+		return "", errors.New("EOF")
+	}
+}
+
+func (r *memoryRedisReceiver) Close() error {
+	r.close()
+
+	return nil
+}
+
+type recordedStates struct {
+	mutex  sync.Mutex
+	states []ConnectionState
+}
+
+func (r *recordedStates) callback(state ConnectionState) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.states = append(r.states, state)
+}
+
+func (r *recordedStates) get() []ConnectionState {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	res := make([]ConnectionState, len(r.states))
+	copy(res, r.states)
+
+	return res
+}
+
+func waitForState(t *testing.T, client *Client, state ConnectionState) {
+	deadline := time.Now().Add(2 * time.Second)
+
+	for client.GetConnectionState() != state && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	assert.Equal(t, state, client.GetConnectionState())
+}
+
+func fastReconnection() func() {
+	minBackoff, maxBackoff, pingFreq := RECONNECT_MIN_BACKOFF, RECONNECT_MAX_BACKOFF, PING_FREQ
+
+	RECONNECT_MIN_BACKOFF = 5 * time.Millisecond
+	RECONNECT_MAX_BACKOFF = 20 * time.Millisecond
+	PING_FREQ = 10 * time.Millisecond
+
+	return func() {
+		RECONNECT_MIN_BACKOFF, RECONNECT_MAX_BACKOFF, PING_FREQ = minBackoff, maxBackoff, pingFreq
+	}
+}
+
+func TestClientRefusesUnreachableBroker(t *testing.T) {
+	redis := newMemoryRedis()
+	redis.stop()
+
+	_, err := newClient(redis.dial)
+	assert.Error(t, err)
+}
+
+func TestClientReconnectsAndResubscribes(t *testing.T) {
+	defer fastReconnection()()
+
+	redis := newMemoryRedis()
+
+	publisher, err := newClient(redis.dial)
+	assert.Nil(t, err)
+	defer publisher.Stop()
+
+	consumer, err := newClient(redis.dial)
+	assert.Nil(t, err)
+	defer consumer.Stop()
+
+	var states recordedStates
+	consumer.OnConnectionState(states.callback)
+
+	var consumerReceived received
+	assert.Nil(t, consumer.Subscribe("game", "launch", consumerReceived.callback))
+
+	assert.Nil(t, publisher.Publish("game", "launch", "before"))
+	consumerReceived.wait(t, 1)
+
+	redis.stop()
+
+	waitForState(t, consumer, CONNECTION_DOWN)
+	waitForState(t, publisher, CONNECTION_DOWN)
+	assert.Error(t, consumer.Health())
+	assert.Error(t, consumer.Publish("game", "launch", "lost"))
+
+	// Made once the connection is back
+	var lateReceived received
+	assert.Nil(t, consumer.Subscribe("game", "stopped", lateReceived.callback))
+
+	redis.start()
+
+	waitForState(t, consumer, CONNECTION_UP)
+	waitForState(t, publisher, CONNECTION_UP)
+	assert.Nil(t, consumer.Health())
+
+	assert.Nil(t, publisher.Publish("game", "launch", "after"))
+	assert.Nil(t, publisher.Publish("game", "stopped", "late"))
+
+	assert.Equal(t, []string{`"before"`, `"after"`}, consumerReceived.wait(t, 2))
+	assert.Equal(t, []string{`"late"`}, lateReceived.wait(t, 1))
+	assert.Equal(t, []ConnectionState{CONNECTION_DOWN, CONNECTION_UP}, states.get())
+}
+
+func TestClientBacksOffWhileDisconnected(t *testing.T) {
+	defer fastReconnection()()
+
+	redis := newMemoryRedis()
+
+	client, err := newClient(redis.dial)
+	assert.Nil(t, err)
+	defer client.Stop()
+
+	redis.stop()
+	waitForState(t, client, CONNECTION_DOWN)
+
+	dials := redis.getDials()
+	time.Sleep(200 * time.Millisecond)
+
+	// About 10 attempts at the max backoff, not one per receive loop
+	attempts := redis.getDials() - dials
+	assert.True(t, attempts > 3 && attempts < 20, strconv.Itoa(attempts)+" reconnection attempts")
+}
+
+func TestReconnectBackoff(t *testing.T) {
+	defer func(minBackoff, maxBackoff time.Duration) {
+		RECONNECT_MIN_BACKOFF, RECONNECT_MAX_BACKOFF = minBackoff, maxBackoff
+	}(RECONNECT_MIN_BACKOFF, RECONNECT_MAX_BACKOFF)
+
+	RECONNECT_MIN_BACKOFF = 100 * time.Millisecond
+	RECONNECT_MAX_BACKOFF = 1 * time.Second
+
+	assert.Equal(t, 100*time.Millisecond, reconnectBackoff(0))
+	assert.Equal(t, 200*time.Millisecond, reconnectBackoff(1))
+	assert.Equal(t, 800*time.Millisecond, reconnectBackoff(3))
+	assert.Equal(t, 1*time.Second, reconnectBackoff(4))
+	assert.Equal(t, 1*time.Second, reconnectBackoff(100))
+}
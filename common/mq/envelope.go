@@ -0,0 +1,161 @@
+package mq
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/bytearena/core/common/mq"
+)
+
+type brokerAction struct {
+	Action  string          `json:"action"`
+	Channel string          `json:"channel"`
+	Topic   string          `json:"topic"`
+	Data    json.RawMessage `json:"data"`
+	Auth    *MessageAuth    `json:"auth,omitempty"`
+}
+
+type signedBrokerMessage struct {
+	mq.BrokerMessage
+	Auth *MessageAuth `json:"auth"`
+}
+
+func channelAndTopicToString(channel, topic string) string {
+	return channel + "." + topic
+}
+
+// envelope encodes the messages the same way for every transport, and signs
+// or verifies them
+type envelope struct {
+	mutex      sync.Mutex
+	signing    *SigningConfig
+	authorized map[string][]string
+	trusted    map[string]Keyring
+}
+
+// EnableSigning must be called before subscribing, the subscriptions only
+// verify the messages if the config has a keyring
+func (e *envelope) EnableSigning(config SigningConfig) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.signing = &config
+}
+
+// EnableSigningFromEnv is EnableSigning with SigningConfigFromEnv
+func (e *envelope) EnableSigningFromEnv(component string) error {
+	config, err := SigningConfigFromEnv(component)
+
+	if err != nil {
+		return err
+	}
+
+	if config != nil {
+		e.EnableSigning(*config)
+	}
+
+	return nil
+}
+
+// Authorize restricts the components which can publish on a topic, it must be
+// called before subscribing
+func (e *envelope) Authorize(channel, topic string, components ...string) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if e.authorized == nil {
+		e.authorized = make(map[string][]string)
+	}
+
+	channelName := channelAndTopicToString(channel, topic)
+	e.authorized[channelName] = append(e.authorized[channelName], components...)
+}
+
+// Trust verifies the messages of a topic with the keyring instead of the one
+// of the signing config, even if there is none. It must be called before
+// subscribing.
+func (e *envelope) Trust(channel, topic string, keyring Keyring) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if e.trusted == nil {
+		e.trusted = make(map[string]Keyring)
+	}
+
+	e.trusted[channelAndTopicToString(channel, topic)] = keyring
+}
+
+// Returns nil if the messages of the subscription are not verified
+func (e *envelope) newVerifier(channel, topic string) *verifier {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	channelName := channelAndTopicToString(channel, topic)
+	keyring, isTrusted := e.trusted[channelName]
+
+	if !isTrusted && e.signing != nil {
+		keyring = e.signing.Keyring
+	}
+
+	if keyring == nil {
+		return nil
+	}
+
+	return newVerifier(keyring, e.authorized[channelName])
+}
+
+func (e *envelope) encode(channel, topic string, payload interface{}) ([]byte, error) {
+	e.mutex.Lock()
+	signing := e.signing
+	e.mutex.Unlock()
+
+	return encodeSigned(signing, channel, topic, payload)
+}
+
+// encodeSigned signs the message with the config unless it's nil
+func encodeSigned(signing *SigningConfig, channel, topic string, payload interface{}) ([]byte, error) {
+	data, err := json.Marshal(payload)
+
+	if err != nil {
+		return nil, err
+	}
+
+	action := brokerAction{
+		Action:  "pub",
+		Channel: channel,
+		Topic:   topic,
+		Data:    data,
+	}
+
+	if signing != nil {
+		action.Auth, err = signMessage(*signing, channel, topic, data, time.Now())
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return json.Marshal(action)
+}
+
+// decode returns the component which signed the message, it's empty when the
+// message is not verified
+func (e *envelope) decode(raw []byte, channel, topic string, messageVerifier *verifier) (mq.BrokerMessage, string, error) {
+	var message signedBrokerMessage
+
+	if err := json.Unmarshal(raw, &message); err != nil {
+		return mq.BrokerMessage{}, "", errors.New("Received invalid message; " + err.Error() + ";" + string(raw))
+	}
+
+	if messageVerifier == nil {
+		return message.BrokerMessage, "", nil
+	}
+
+	if err := messageVerifier.verify(message.Auth, channel, topic, message.Data, time.Now()); err != nil {
+		return mq.BrokerMessage{}, "", errors.New("Refused message on " + channelAndTopicToString(channel, topic) + ": " + err.Error())
+	}
+
+	return message.BrokerMessage, message.Auth.Component, nil
+}
@@ -0,0 +1,297 @@
+package mq
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/bytearena/core/common/types"
+)
+
+// OptionalInt is published as a number or as a string, an empty string
+// means it's not set
+type OptionalInt struct {
+	Value int
+	IsSet bool
+}
+
+func (i *OptionalInt) UnmarshalJSON(data []byte) error {
+	var raw interface{}
+
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	switch value := raw.(type) {
+	case nil:
+		*i = OptionalInt{}
+
+	case float64:
+		*i = OptionalInt{Value: int(value), IsSet: true}
+
+	case string:
+		if value == "" {
+			*i = OptionalInt{}
+			return nil
+		}
+
+		res, err := strconv.Atoi(value)
+
+		if err != nil {
+			return errors.New("Invalid number " + value)
+		}
+
+		*i = OptionalInt{Value: res, IsSet: true}
+
+	default:
+		return errors.New("Invalid number " + string(data))
+	}
+
+	return nil
+}
+
+func (i OptionalInt) MarshalJSON() ([]byte, error) {
+	if !i.IsSet {
+		return []byte("null"), nil
+	}
+
+	return json.Marshal(i.Value)
+}
+
+func requireFields(fields ...string) error {
+	for i := 0; i < len(fields); i += 2 {
+		if fields[i+1] == "" {
+			return errors.New("Missing " + fields[i])
+		}
+	}
+
+	return nil
+}
+
+// GameLaunch queues a game on arena-master
+type GameLaunch struct {
+	Id       string      `json:"id"`
+	Priority OptionalInt `json:"priority"`
+	Profile  string      `json:"profile,omitempty"`
+}
+
+func (m *GameLaunch) Validate() error {
+	return requireFields("id", m.Id)
+}
+
+// ArenaLaunch is sent to the arena-server running the game, on
+// <arena id>.launch
+type ArenaLaunch struct {
+	Id string `json:"id"`
+}
+
+func (m *ArenaLaunch) Validate() error {
+	return requireFields("id", m.Id)
+}
+
+type GameLaunched struct {
+	Id              string `json:"id"`
+	ArenaServerUUID string `json:"arenaserveruuid"`
+}
+
+func (m *GameLaunched) Validate() error {
+	return requireFields("id", m.Id, "arenaserveruuid", m.ArenaServerUUID)
+}
+
+type GameHandshake struct {
+	ArenaServerUUID string `json:"arenaserveruuid"`
+}
+
+func (m *GameHandshake) Validate() error {
+	return requireFields("arenaserveruuid", m.ArenaServerUUID)
+}
+
+type GameStopped struct {
+	Id              string `json:"id"`
+	ArenaServerUUID string `json:"arenaserveruuid"`
+}
+
+func (m *GameStopped) Validate() error {
+	return requireFields("id", m.Id, "arenaserveruuid", m.ArenaServerUUID)
+}
+
+type HealthcheckRequest struct{}
+
+func (m *HealthcheckRequest) Validate() error {
+	return nil
+}
+
+// GameHealth is the reply of an arena-server to a healthcheck
+type GameHealth struct {
+	Id     string `json:"id"`
+	Health string `json:"health"`
+}
+
+func (m *GameHealth) Validate() error {
+	if m.Health != "OK" && m.Health != "NOK" {
+		return errors.New("Invalid health " + m.Health)
+	}
+
+	return requireFields("id", m.Id)
+}
+
+type ArenaAdd struct{}
+
+func (m *ArenaAdd) Validate() error {
+	return nil
+}
+
+// ArenaHalt carries the id of a VM
+type ArenaHalt struct {
+	Id string `json:"id"`
+}
+
+func (m *ArenaHalt) Validate() error {
+	return requireFields("id", m.Id)
+}
+
+// MasterDrain carries an optional deadline, as a duration
+type MasterDrain struct {
+	Deadline string `json:"deadline,omitempty"`
+}
+
+func (m *MasterDrain) Validate() error {
+	if m.Deadline == "" {
+		return nil
+	}
+
+	_, err := time.ParseDuration(m.Deadline)
+
+	return err
+}
+
+type DrainStatus struct {
+	Draining  bool   `json:"draining"`
+	Done      bool   `json:"done"`
+	Remaining int    `json:"remaining"`
+	Deadline  string `json:"deadline"`
+	Status    string `json:"status"`
+}
+
+func (m *DrainStatus) Validate() error {
+	return requireFields("status", m.Status)
+}
+
+// StateTransition is published by arena-master for each transition of a VM
+type StateTransition struct {
+	Id    string `json:"id"`
+	From  string `json:"from"`
+	To    string `json:"to"`
+	Cause string `json:"cause"`
+	Time  string `json:"time"`
+}
+
+func (m *StateTransition) Validate() error {
+	return requireFields("id", m.Id, "time", m.Time)
+}
+
+// DebugRequest optionally targets a single VM
+type DebugRequest struct {
+	Id string `json:"id,omitempty"`
+}
+
+func (m *DebugRequest) Validate() error {
+	return nil
+}
+
+// AgentSubmitted is published by the API
+type AgentSubmitted struct {
+	Id string `json:"id"`
+}
+
+func (m *AgentSubmitted) Validate() error {
+	return requireFields("id", m.Id)
+}
+
+// AgentRepoInit is read by the API, it keeps the format of the messages of
+// core with the agent id in the payload
+type AgentRepoInit struct {
+	types.MQMessage
+}
+
+func NewAgentRepoInit(message *types.MQMessage, agentid string) *AgentRepoInit {
+	return &AgentRepoInit{*message.SetPayload(types.MQPayload{
+		"agentid": agentid,
+	})}
+}
+
+func (m *AgentRepoInit) Validate() error {
+	if m.Payload == nil {
+		return errors.New("Missing agentid")
+	}
+
+	agentid, _ := (*m.Payload)["agentid"].(string)
+
+	return requireFields("agentid", agentid)
+}
+
+type VizMessage struct {
+	GameID          string
+	ArenaServerUUID string
+}
+
+// VizBatch is published by the arena-servers of core, the raw data is kept
+// for the recordings
+type VizBatch struct {
+	Messages []VizMessage
+	Data     json.RawMessage
+}
+
+func (m *VizBatch) UnmarshalJSON(data []byte) error {
+	m.Data = append(json.RawMessage{}, data...)
+
+	return json.Unmarshal(data, &m.Messages)
+}
+
+func (m *VizBatch) MarshalJSON() ([]byte, error) {
+	if m.Data != nil {
+		return m.Data, nil
+	}
+
+	return json.Marshal(m.Messages)
+}
+
+func (m *VizBatch) Validate() error {
+	if len(m.Messages) == 0 {
+		return errors.New("Empty batch")
+	}
+
+	return requireFields("GameID", m.Messages[0].GameID, "ArenaServerUUID", m.Messages[0].ArenaServerUUID)
+}
+
+func init() {
+	for _, schema := range []Schema{
+		{Channel: "game", Topic: "launch", Version: 1, New: func() Message { return &GameLaunch{} }},
+		{Channel: "game", Topic: "*.launch", Version: 1, New: func() Message { return &ArenaLaunch{} }},
+		{Channel: "game", Topic: "launched", Version: 1, New: func() Message { return &GameLaunched{} }},
+		{Channel: "game", Topic: "handshake", Version: 1, New: func() Message { return &GameHandshake{} }},
+		{Channel: "game", Topic: "stopped", Version: 1, New: func() Message { return &GameStopped{} }},
+		{Channel: "game", Topic: "healthcheck", Version: 1, New: func() Message { return &HealthcheckRequest{} }},
+		{Channel: "game", Topic: "healthcheck-res", Version: 1, New: func() Message { return &GameHealth{} }},
+
+		{Channel: "arena", Topic: "add", Version: 1, New: func() Message { return &ArenaAdd{} }},
+		{Channel: "arena", Topic: "halt", Version: 1, New: func() Message { return &ArenaHalt{} }},
+
+		{Channel: "arenamaster", Topic: "drain", Version: 1, New: func() Message { return &MasterDrain{} }},
+		{Channel: "arenamaster", Topic: "drain-status", Version: 1, New: func() Message { return &DrainStatus{} }},
+		{Channel: "arenamaster", Topic: "state", Version: 1, New: func() Message { return &StateTransition{} }},
+
+		{Channel: "debug", Topic: "getvmstatus", Version: 1, New: func() Message { return &DebugRequest{} }},
+		{Channel: "debug", Topic: "getstateevents", Version: 1, New: func() Message { return &DebugRequest{} }},
+		{Channel: "debug", Topic: "getlaunchqueue", Version: 1, New: func() Message { return &DebugRequest{} }},
+
+		{Channel: "agent", Topic: "submitted", Raw: true, New: func() Message { return &AgentSubmitted{} }},
+		{Channel: "agent", Topic: "repo-init-success", Raw: true, New: func() Message { return &AgentRepoInit{} }},
+		{Channel: "agent", Topic: "repo-init-fail", Raw: true, New: func() Message { return &AgentRepoInit{} }},
+
+		{Channel: "viz", Topic: "message", Raw: true, New: func() Message { return &VizBatch{} }},
+	} {
+		RegisterSchema(schema)
+	}
+}
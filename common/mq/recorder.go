@@ -0,0 +1,254 @@
+package mq
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/bytearena/core/common/mq"
+	"github.com/bytearena/core/common/utils"
+
+	"github.com/go-redis/redis"
+)
+
+var (
+	// Viz batches can be large
+	MAX_RECORDED_MESSAGE_SIZE = 16 * 1024 * 1024
+)
+
+// RecordedMessage is a line of a recording
+type RecordedMessage struct {
+	Time    time.Time       `json:"time"`
+	Channel string          `json:"channel"`
+	Topic   string          `json:"topic"`
+	Data    json.RawMessage `json:"data"`
+
+	// The publisher of signed messages
+	Auth *MessageAuth `json:"auth,omitempty"`
+}
+
+// RecordPattern returns the pattern matching the topics of the channel,
+// both can contain globs
+func RecordPattern(channel, topic string) string {
+	return channelAndTopicToString(channel, topic)
+}
+
+// Recorder receives the messages published through Redis PubSub on topics
+// matching patterns, the streams transport is not recorded
+type Recorder struct {
+	receiver pubsubReceiver
+	conn     pubsubConn
+}
+
+func NewRecorder(host string, patterns ...string) (*Recorder, error) {
+	conn := redis.NewClient(&redis.Options{
+		Addr:     host + ":6379",
+		Password: "", // no password set
+		DB:       0,  // use default DB
+	})
+
+	if err := conn.Ping().Err(); err != nil {
+		conn.Close()
+		return nil, errors.New("Cannot connect to messagebroker host " + host + ": " + err.Error())
+	}
+
+	return &Recorder{
+		receiver: redisPubSubReceiver{conn.PSubscribe(patterns...)},
+		conn:     redisPubSubConn{conn},
+	}, nil
+}
+
+// MessageFilter keeps the messages with a field of that value, the fields
+// of the payload of an envelope come first
+type MessageFilter struct {
+	Field string
+	Value string
+}
+
+func (f MessageFilter) Match(message RecordedMessage) bool {
+	value, found := message.Field(f.Field)
+
+	return found && value == f.Value
+}
+
+// Field returns a field of the data as a string, numbers are formatted as
+// in JSON
+func (m RecordedMessage) Field(name string) (string, bool) {
+	var fields map[string]interface{}
+
+	if err := json.Unmarshal(m.Data, &fields); err != nil {
+		return "", false
+	}
+
+	if payload, isEnvelope := fields["payload"].(map[string]interface{}); isEnvelope {
+		if value, found := payload[name]; found {
+			return formatField(value), true
+		}
+	}
+
+	value, found := fields[name]
+
+	if !found {
+		return "", false
+	}
+
+	return formatField(value), true
+}
+
+func formatField(value interface{}) string {
+	switch value := value.(type) {
+	case string:
+		return value
+
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	}
+
+	res, _ := json.Marshal(value)
+
+	return string(res)
+}
+
+// Watch calls the handler for the messages matching every filter until the
+// context is done or count messages were handled, 0 for no limit. The
+// messages are not verified.
+func (r *Recorder) Watch(ctx context.Context, count int, filters []MessageFilter, handler func(message RecordedMessage) error) (int, error) {
+	done := make(chan struct{})
+	defer close(done)
+
+	// Unblocks the receiver
+	go func() {
+		select {
+		case <-ctx.Done():
+			r.receiver.Close()
+		case <-done:
+		}
+	}()
+
+	handled := 0
+
+	for count == 0 || handled < count {
+		payload, err := r.receiver.ReceiveMessage()
+
+		if err != nil {
+			if ctx.Err() != nil {
+				return handled, nil
+			}
+
+			return handled, err
+		}
+
+		var action brokerAction
+
+		if err := json.Unmarshal([]byte(payload), &action); err != nil {
+			utils.RecoverableError("recorder", "Received invalid message; "+err.Error())
+			continue
+		}
+
+		message := RecordedMessage{
+			Time:    time.Now(),
+			Channel: action.Channel,
+			Topic:   action.Topic,
+			Data:    action.Data,
+			Auth:    action.Auth,
+		}
+
+		if !matchFilters(filters, message) {
+			continue
+		}
+
+		if err := handler(message); err != nil {
+			return handled, err
+		}
+
+		handled++
+	}
+
+	return handled, nil
+}
+
+func matchFilters(filters []MessageFilter, message RecordedMessage) bool {
+	for _, filter := range filters {
+		if !filter.Match(message) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Record writes a line per message, see Watch
+func (r *Recorder) Record(ctx context.Context, w io.Writer, count int, filters ...MessageFilter) (int, error) {
+	encoder := json.NewEncoder(w)
+
+	return r.Watch(ctx, count, filters, func(message RecordedMessage) error {
+		return encoder.Encode(message)
+	})
+}
+
+func (r *Recorder) Stop() {
+	r.receiver.Close()
+
+	if r.conn != nil {
+		r.conn.Close()
+	}
+}
+
+// Replay publishes the recorded messages with their original timing divided
+// by speed, as fast as possible if speed is 0. The messages are signed again
+// by the client, the original signatures would be refused as replayed.
+func Replay(ctx context.Context, client mq.ClientInterface, r io.Reader, speed float64) (int, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), MAX_RECORDED_MESSAGE_SIZE)
+
+	var first time.Time
+	started := time.Now()
+	replayed := 0
+	line := 0
+
+	for scanner.Scan() {
+		line++
+
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var message RecordedMessage
+
+		if err := json.Unmarshal(scanner.Bytes(), &message); err != nil {
+			return replayed, errors.New("Invalid message on line " + strconv.Itoa(line) + ": " + err.Error())
+		}
+
+		if first.IsZero() {
+			first = message.Time
+		}
+
+		if speed > 0 {
+			delay := time.Duration(float64(message.Time.Sub(first))/speed) - time.Since(started)
+
+			if delay > 0 {
+				select {
+				case <-time.After(delay):
+				case <-ctx.Done():
+					return replayed, ctx.Err()
+				}
+			}
+		}
+
+		if err := ctx.Err(); err != nil {
+			return replayed, err
+		}
+
+		if err := client.Publish(message.Channel, message.Topic, message.Data); err != nil {
+			return replayed, errors.New("Could not publish line " + strconv.Itoa(line) + ": " + err.Error())
+		}
+
+		replayed++
+	}
+
+	return replayed, scanner.Err()
+}
@@ -0,0 +1,161 @@
+package mq
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	redis := newMemoryRedis()
+
+	publisher, err := newClient(redis.dial)
+	assert.Nil(t, err)
+	defer publisher.Stop()
+
+	publisher.EnableSigning(SigningConfig{Component: "arena-master-cli", Key: []byte("cli-key")})
+
+	conn, err := redis.dial()
+	assert.Nil(t, err)
+
+	receiver, err := conn.Subscribe(RecordPattern("game", "launch"))
+	assert.Nil(t, err)
+
+	recorder := &Recorder{receiver: receiver}
+	defer recorder.Stop()
+
+	var recording bytes.Buffer
+	recorded := make(chan int)
+
+	go func() {
+		count, err := recorder.Record(context.Background(), &recording, 2)
+		assert.Nil(t, err)
+
+		recorded <- count
+	}()
+
+	assert.Nil(t, publisher.Publish("game", "launch", map[string]string{"id": "1"}))
+	assert.Nil(t, publisher.Publish("game", "launch", map[string]string{"id": "2"}))
+
+	select {
+	case count := <-recorded:
+		assert.Equal(t, 2, count)
+	case <-time.After(2 * time.Second):
+		t.Fatal("Messages were not recorded")
+	}
+
+	lines := strings.Split(strings.TrimSpace(recording.String()), "\n")
+	assert.Len(t, lines, 2)
+
+	var message RecordedMessage
+	assert.Nil(t, json.Unmarshal([]byte(lines[0]), &message))
+	assert.Equal(t, "game", message.Channel)
+	assert.Equal(t, "launch", message.Topic)
+	assert.Equal(t, `{"id":"1"}`, string(message.Data))
+	assert.Equal(t, "arena-master-cli", message.Auth.Component)
+
+	// Replayed on another broker
+	devRedis := newMemoryRedis()
+
+	replayer, err := newClient(devRedis.dial)
+	assert.Nil(t, err)
+	defer replayer.Stop()
+
+	consumer, err := newClient(devRedis.dial)
+	assert.Nil(t, err)
+	defer consumer.Stop()
+
+	var replayed received
+	assert.Nil(t, consumer.Subscribe("game", "launch", replayed.callback))
+
+	count, err := Replay(context.Background(), replayer, &recording, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, count)
+
+	assert.Equal(t, []string{`{"id":"1"}`, `{"id":"2"}`}, replayed.wait(t, 2))
+}
+
+func TestRecordUntilDone(t *testing.T) {
+	redis := newMemoryRedis()
+
+	conn, err := redis.dial()
+	assert.Nil(t, err)
+
+	receiver, err := conn.Subscribe(RecordPattern("game", "*"))
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	var recording bytes.Buffer
+
+	count, err := (&Recorder{receiver: receiver}).Record(ctx, &recording, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, count)
+}
+
+func TestReplayTiming(t *testing.T) {
+	redis := newMemoryRedis()
+
+	client, err := newClient(redis.dial)
+	assert.Nil(t, err)
+	defer client.Stop()
+
+	start := time.Now()
+	var recording bytes.Buffer
+	encoder := json.NewEncoder(&recording)
+
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, encoder.Encode(RecordedMessage{
+			Time:    start.Add(time.Duration(i) * 200 * time.Millisecond),
+			Channel: "game",
+			Topic:   "stopped",
+			Data:    json.RawMessage(`{}`),
+		}))
+	}
+
+	// Twice as fast
+	replayStart := time.Now()
+	count, err := Replay(context.Background(), client, bytes.NewReader(recording.Bytes()), 2)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 3, count)
+	assert.True(t, time.Since(replayStart) >= 200*time.Millisecond)
+	assert.True(t, time.Since(replayStart) < 400*time.Millisecond)
+
+	// Stops when the context is done
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	count, err = Replay(ctx, client, bytes.NewReader(recording.Bytes()), 1)
+
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Equal(t, 1, count)
+
+	_, err = Replay(context.Background(), client, strings.NewReader("not json\n"), 0)
+	assert.EqualError(t, err, "Invalid message on line 1: invalid character 'o' in literal null (expecting 'u')")
+}
+
+func TestMessageFilter(t *testing.T) {
+	envelope := RecordedMessage{Data: json.RawMessage(`{"from": "arena-server", "payload": {"id": "42", "priority": 2, "from": "payload"}}`)}
+	raw := RecordedMessage{Data: json.RawMessage(`[{"GameID": "42"}]`)}
+
+	assert.True(t, MessageFilter{"id", "42"}.Match(envelope))
+	assert.True(t, MessageFilter{"priority", "2"}.Match(envelope))
+	assert.True(t, MessageFilter{"from", "payload"}.Match(envelope))
+	assert.False(t, MessageFilter{"id", "4"}.Match(envelope))
+	assert.False(t, MessageFilter{"GameID", "42"}.Match(raw))
+
+	// The fields of the payload come first
+	value, found := envelope.Field("from")
+	assert.True(t, found)
+	assert.Equal(t, "payload", value)
+
+	_, found = envelope.Field("missing")
+	assert.False(t, found)
+}
@@ -0,0 +1,249 @@
+package mq
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/bytearena/core/common/mq"
+	"github.com/bytearena/core/common/utils"
+)
+
+const (
+	// The replies to a requester are published on rpc:reply.<requester>
+	RPC_CHANNEL = "rpc"
+)
+
+var (
+	// Deadline of the requests made with a context without one
+	RPC_TIMEOUT = 10 * time.Second
+
+	// Replies received beyond that many by a pending request are dropped
+	RPC_MAX_REPLIES = 256
+)
+
+func RPCReplyTopic(requester string) string {
+	return "reply." + requester
+}
+
+type rpcRequest struct {
+	Id        string          `json:"id"`
+	Requester string          `json:"requester"`
+	Deadline  time.Time       `json:"deadline"`
+	Payload   json.RawMessage `json:"payload"`
+}
+
+// Reply is the answer of a responder, Error is set if its handler failed
+type Reply struct {
+	Id        string          `json:"id"`
+	Responder string          `json:"responder"`
+	Payload   json.RawMessage `json:"payload,omitempty"`
+	Error     string          `json:"error,omitempty"`
+
+	// The component which signed the reply, empty if the replies are not
+	// verified
+	Signer string `json:"-"`
+}
+
+// RequestHandler returns the payload of the reply
+type RequestHandler func(payload json.RawMessage) (interface{}, error)
+
+// RPC correlates requests published on a topic with the replies of the
+// components handling it. The name identifies the instance as a requester
+// and as a responder, with the streams transport it must be unique.
+type RPC struct {
+	broker  Broker
+	name    string
+	mutex   sync.Mutex
+	pending map[string]chan Reply
+}
+
+// NewRPC subscribes to the replies, their publishers can be restricted with
+// Authorize on RPCReplyTopic(name) before
+func NewRPC(broker Broker, name string) (*RPC, error) {
+	rpc := &RPC{
+		broker:  broker,
+		name:    name,
+		pending: make(map[string]chan Reply),
+	}
+
+	err := broker.SubscribeVerified(RPC_CHANNEL, RPCReplyTopic(name), rpc.onReply)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return rpc, nil
+}
+
+func (rpc *RPC) onReply(msg mq.BrokerMessage, component string) {
+	var reply Reply
+
+	if err := json.Unmarshal(msg.Data, &reply); err != nil {
+		utils.RecoverableError("rpc", "Invalid reply: "+err.Error())
+		return
+	}
+
+	reply.Signer = component
+
+	rpc.mutex.Lock()
+	replies, isPending := rpc.pending[reply.Id]
+	rpc.mutex.Unlock()
+
+	// The request is over
+	if !isPending {
+		utils.Debug("rpc", "Ignoring reply from "+reply.Responder+" to request "+reply.Id)
+		return
+	}
+
+	select {
+	case replies <- reply:
+	default:
+		utils.RecoverableError("rpc", "Too many replies to request "+reply.Id+", dropping the one from "+reply.Responder)
+	}
+}
+
+// Handle subscribes to the requests of a topic, the handler runs in the
+// goroutine of the subscription
+func (rpc *RPC) Handle(channel, topic string, handler RequestHandler) error {
+	return rpc.broker.Subscribe(channel, topic, func(msg mq.BrokerMessage) {
+		var request rpcRequest
+
+		if err := json.Unmarshal(msg.Data, &request); err != nil || request.Id == "" || request.Requester == "" {
+			utils.RecoverableError("rpc", "Invalid request on "+channel+":"+topic)
+			return
+		}
+
+		if time.Now().After(request.Deadline) {
+			utils.Debug("rpc", "Ignoring expired request "+request.Id+" from "+request.Requester)
+			return
+		}
+
+		reply := Reply{
+			Id:        request.Id,
+			Responder: rpc.name,
+		}
+
+		res, err := handler(request.Payload)
+
+		if err == nil {
+			reply.Payload, err = json.Marshal(res)
+		}
+
+		if err != nil {
+			reply.Error = err.Error()
+		}
+
+		if err := rpc.broker.Publish(RPC_CHANNEL, RPCReplyTopic(request.Requester), reply); err != nil {
+			utils.RecoverableError("rpc", "Could not reply to "+request.Requester+": "+err.Error())
+		}
+	})
+}
+
+// publish registers the request before publishing it, the caller must call
+// done once it doesn't wait for replies anymore
+func (rpc *RPC) publish(ctx context.Context, channel, topic string, payload interface{}) (replies chan Reply, done func(), err error) {
+	id, err := newNonce()
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	data, err := json.Marshal(payload)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	deadline, _ := ctx.Deadline()
+
+	replies = make(chan Reply, RPC_MAX_REPLIES)
+	done = func() {
+		rpc.mutex.Lock()
+		delete(rpc.pending, id)
+		rpc.mutex.Unlock()
+	}
+
+	rpc.mutex.Lock()
+	rpc.pending[id] = replies
+	rpc.mutex.Unlock()
+
+	err = rpc.broker.Publish(channel, topic, rpcRequest{
+		Id:        id,
+		Requester: rpc.name,
+		Deadline:  deadline,
+		Payload:   data,
+	})
+
+	if err != nil {
+		done()
+		return nil, nil, err
+	}
+
+	return replies, done, nil
+}
+
+func withDefaultTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, hasDeadline := ctx.Deadline(); hasDeadline {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, RPC_TIMEOUT)
+}
+
+// Request returns the payload of the first reply
+func (rpc *RPC) Request(ctx context.Context, channel, topic string, payload interface{}) (json.RawMessage, error) {
+	ctx, cancel := withDefaultTimeout(ctx)
+	defer cancel()
+
+	replies, done, err := rpc.publish(ctx, channel, topic, payload)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer done()
+
+	select {
+	case reply := <-replies:
+		if reply.Error != "" {
+			return nil, errors.New(reply.Responder + " failed: " + reply.Error)
+		}
+
+		return reply.Payload, nil
+
+	case <-ctx.Done():
+		return nil, errors.New("No reply to " + channel + ":" + topic + ": " + ctx.Err().Error())
+	}
+}
+
+// Gather collects the replies of every responder until the deadline, or
+// until it received expected replies if expected is not 0
+func (rpc *RPC) Gather(ctx context.Context, channel, topic string, payload interface{}, expected int) ([]Reply, error) {
+	ctx, cancel := withDefaultTimeout(ctx)
+	defer cancel()
+
+	replies, done, err := rpc.publish(ctx, channel, topic, payload)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer done()
+
+	res := make([]Reply, 0)
+
+	for expected == 0 || len(res) < expected {
+		select {
+		case reply := <-replies:
+			res = append(res, reply)
+
+		case <-ctx.Done():
+			return res, nil
+		}
+	}
+
+	return res, nil
+}
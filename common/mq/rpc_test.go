@@ -0,0 +1,147 @@
+package mq
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestRPC(t *testing.T, redis *memoryRedis, name string) (*RPC, func()) {
+	client, err := newClient(redis.dial)
+	assert.Nil(t, err)
+
+	rpc, err := NewRPC(client, name)
+	assert.Nil(t, err)
+
+	return rpc, client.Stop
+}
+
+func echoHandler(payload json.RawMessage) (interface{}, error) {
+	var value string
+	err := json.Unmarshal(payload, &value)
+
+	return value, err
+}
+
+func TestRPCRequest(t *testing.T) {
+	redis := newMemoryRedis()
+
+	requester, stopRequester := newTestRPC(t, redis, "cli")
+	defer stopRequester()
+
+	responder, stopResponder := newTestRPC(t, redis, "master")
+	defer stopResponder()
+
+	assert.Nil(t, responder.Handle("debug", "echo", echoHandler))
+	assert.Nil(t, responder.Handle("debug", "fail", func(payload json.RawMessage) (interface{}, error) {
+		return nil, errors.New("state is locked")
+	}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	// Concurrent requests get their own reply
+	var wg sync.WaitGroup
+
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+
+		go func(value string) {
+			defer wg.Done()
+
+			res, err := requester.Request(ctx, "debug", "echo", value)
+
+			assert.Nil(t, err)
+			assert.Equal(t, `"`+value+`"`, string(res))
+		}(strconv.Itoa(i))
+	}
+
+	wg.Wait()
+
+	_, err := requester.Request(ctx, "debug", "fail", nil)
+	assert.EqualError(t, err, "master failed: state is locked")
+
+	requester.mutex.Lock()
+	assert.Len(t, requester.pending, 0)
+	requester.mutex.Unlock()
+}
+
+func TestRPCRequestDeadline(t *testing.T) {
+	redis := newMemoryRedis()
+
+	requester, stopRequester := newTestRPC(t, redis, "cli")
+	defer stopRequester()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	_, err := requester.Request(ctx, "debug", "nobody", nil)
+	assert.Error(t, err)
+
+	// The responder doesn't handle requests which already expired
+	responder, stopResponder := newTestRPC(t, redis, "master")
+	defer stopResponder()
+
+	handled := make(chan struct{}, 1)
+
+	assert.Nil(t, responder.Handle("debug", "slow", func(payload json.RawMessage) (interface{}, error) {
+		handled <- struct{}{}
+		return nil, nil
+	}))
+
+	assert.Nil(t, requester.broker.Publish("debug", "slow", rpcRequest{
+		Id:        "expired",
+		Requester: "cli",
+		Deadline:  time.Now().Add(-time.Second),
+	}))
+
+	select {
+	case <-handled:
+		t.Error("Expired request was handled")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestRPCGather(t *testing.T) {
+	redis := newMemoryRedis()
+
+	requester, stopRequester := newTestRPC(t, redis, "master")
+	defer stopRequester()
+
+	for _, name := range []string{"arena-1", "arena-2", "arena-3"} {
+		responder, stopResponder := newTestRPC(t, redis, name)
+		defer stopResponder()
+
+		assert.Nil(t, responder.Handle("game", "healthcheck", func(payload json.RawMessage) (interface{}, error) {
+			return "OK", nil
+		}))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	replies, err := requester.Gather(ctx, "game", "healthcheck", nil, 0)
+	assert.Nil(t, err)
+
+	responders := make(map[string]string)
+
+	for _, reply := range replies {
+		responders[reply.Responder] = string(reply.Payload)
+	}
+
+	assert.Equal(t, map[string]string{"arena-1": `"OK"`, "arena-2": `"OK"`, "arena-3": `"OK"`}, responders)
+
+	// Returns as soon as enough replies were received
+	start := time.Now()
+
+	replies, err = requester.Gather(context.Background(), "game", "healthcheck", nil, 2)
+	assert.Nil(t, err)
+	assert.Len(t, replies, 2)
+	assert.True(t, time.Since(start) < RPC_TIMEOUT)
+}
@@ -0,0 +1,256 @@
+package mq
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bytearena/core/common/mq"
+	"github.com/bytearena/core/common/utils"
+)
+
+// Message is the payload of a topic, it is validated before being published
+// and once received
+type Message interface {
+	Validate() error
+}
+
+type Schema struct {
+	Channel string
+
+	// A topic starting with "*." matches the topics with any prefix, like
+	// the launch topic of each arena
+	Topic string
+
+	// Incremented on incompatible changes, the messages of a later version
+	// are rejected
+	Version int
+
+	// Raw messages are published without envelope by components outside of
+	// this repository, they are not versioned
+	Raw bool
+
+	New func() Message
+}
+
+// MessageEnvelope is compatible with types.MQMessage, messages without
+// version are of the first one
+type MessageEnvelope struct {
+	From      string          `json:"from"`
+	Message   string          `json:"message"`
+	Version   int             `json:"version,omitempty"`
+	Payload   json.RawMessage `json:"payload"`
+	Timestamp string          `json:"timestamp"`
+}
+
+// Rejection is published on <topic>-error for the messages which could not
+// be decoded or handled
+type Rejection struct {
+	Error string `json:"error"`
+	Data  string `json:"data,omitempty"`
+}
+
+func (r *Rejection) Validate() error {
+	if r.Error == "" {
+		return errors.New("Missing error")
+	}
+
+	return nil
+}
+
+const (
+	ERROR_TOPIC_SUFFIX = "-error"
+)
+
+var (
+	schemasMutex sync.Mutex
+	schemas      = make(map[string]Schema)
+)
+
+// RegisterSchema is called by the packages defining messages, on init
+func RegisterSchema(schema Schema) {
+	schemasMutex.Lock()
+	defer schemasMutex.Unlock()
+
+	key := channelAndTopicToString(schema.Channel, schema.Topic)
+	_, exists := schemas[key]
+
+	utils.Assert(!exists, "Schema of "+key+" registered twice")
+	utils.Assert(schema.Version > 0 || schema.Raw, "Schema of "+key+" has no version")
+
+	schemas[key] = schema
+}
+
+func LookupSchema(channel, topic string) (Schema, bool) {
+	if strings.HasSuffix(topic, ERROR_TOPIC_SUFFIX) {
+		return Schema{
+			Channel: channel,
+			Topic:   topic,
+			Raw:     true,
+			New:     func() Message { return &Rejection{} },
+		}, true
+	}
+
+	schemasMutex.Lock()
+	defer schemasMutex.Unlock()
+
+	if schema, found := schemas[channelAndTopicToString(channel, topic)]; found {
+		return schema, true
+	}
+
+	if dot := strings.LastIndex(topic, "."); dot >= 0 {
+		schema, found := schemas[channelAndTopicToString(channel, "*"+topic[dot:])]
+		return schema, found
+	}
+
+	return Schema{}, false
+}
+
+func findSchema(channel, topic string) (Schema, error) {
+	schema, found := LookupSchema(channel, topic)
+
+	if !found {
+		return schema, errors.New("No schema for " + channel + ":" + topic)
+	}
+
+	return schema, nil
+}
+
+// EncodeMessage validates the message and wraps it in an envelope unless
+// the schema is raw
+func EncodeMessage(from, channel, topic string, message Message) (json.RawMessage, error) {
+	schema, err := findSchema(channel, topic)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if reflect.TypeOf(message) != reflect.TypeOf(schema.New()) {
+		return nil, errors.New("Invalid message type " + reflect.TypeOf(message).String() + " for " + channel + ":" + topic)
+	}
+
+	if err := message.Validate(); err != nil {
+		return nil, errors.New("Invalid message for " + channel + ":" + topic + ": " + err.Error())
+	}
+
+	payload, err := json.Marshal(message)
+
+	if err != nil || schema.Raw {
+		return payload, err
+	}
+
+	return json.Marshal(MessageEnvelope{
+		From:      from,
+		Message:   topic,
+		Version:   schema.Version,
+		Payload:   payload,
+		Timestamp: time.Now().Format(time.RFC3339),
+	})
+}
+
+// DecodeMessage returns the validated message of the schema of the topic
+func DecodeMessage(channel, topic string, data []byte) (Message, error) {
+	schema, err := findSchema(channel, topic)
+
+	if err != nil {
+		return nil, err
+	}
+
+	message := schema.New()
+	payload := data
+
+	if !schema.Raw {
+		var envelope MessageEnvelope
+
+		if err := json.Unmarshal(data, &envelope); err != nil {
+			return nil, errors.New("Invalid envelope for " + channel + ":" + topic + ": " + err.Error())
+		}
+
+		if envelope.Version > schema.Version {
+			return nil, errors.New("Unsupported version " + strconv.Itoa(envelope.Version) + " of " + channel + ":" + topic + ", expected at most " + strconv.Itoa(schema.Version))
+		}
+
+		// Messages with a missing payload are treated as empty
+		payload = envelope.Payload
+
+		if len(payload) == 0 || string(payload) == "null" {
+			payload = []byte("{}")
+		}
+	}
+
+	if err := json.Unmarshal(payload, message); err != nil {
+		return nil, errors.New("Invalid payload for " + channel + ":" + topic + ": " + err.Error())
+	}
+
+	if err := message.Validate(); err != nil {
+		return nil, errors.New("Invalid message for " + channel + ":" + topic + ": " + err.Error())
+	}
+
+	return message, nil
+}
+
+func PublishMessage(client mq.ClientInterface, from, channel, topic string, message Message) error {
+	data, err := EncodeMessage(from, channel, topic, message)
+
+	if err != nil {
+		return err
+	}
+
+	return client.Publish(channel, topic, data)
+}
+
+// SubscribeMessages decodes the messages of the topic with its schema, the
+// rejected ones are published on <topic>-error
+func SubscribeMessages(client mq.ClientInterface, channel, topic string, handler func(message Message)) error {
+	if _, err := findSchema(channel, topic); err != nil {
+		return err
+	}
+
+	return client.Subscribe(channel, topic, func(msg mq.BrokerMessage) {
+		message, err := DecodeMessage(channel, topic, msg.Data)
+
+		if err != nil {
+			Reject(client, channel, topic, string(msg.Data), err.Error())
+			return
+		}
+
+		handler(message)
+	})
+}
+
+// SubscribeSignedMessages is SubscribeMessages passing the component which
+// signed each message, it's empty when the topic is not verified
+func SubscribeSignedMessages(client Broker, channel, topic string, handler func(message Message, component string)) error {
+	if _, err := findSchema(channel, topic); err != nil {
+		return err
+	}
+
+	return client.SubscribeVerified(channel, topic, func(msg mq.BrokerMessage, component string) {
+		message, err := DecodeMessage(channel, topic, msg.Data)
+
+		if err != nil {
+			Reject(client, channel, topic, string(msg.Data), err.Error())
+			return
+		}
+
+		handler(message, component)
+	})
+}
+
+// Reject reports a message which could not be handled to its publisher
+func Reject(client mq.ClientInterface, channel, topic, data, reason string) {
+	utils.RecoverableError(channel+"-"+topic, reason)
+
+	err := PublishMessage(client, "", channel, topic+ERROR_TOPIC_SUFFIX, &Rejection{
+		Error: reason,
+		Data:  data,
+	})
+
+	if err != nil {
+		utils.RecoverableError(channel+"-"+topic, "Could not reply error: "+err.Error())
+	}
+}
@@ -0,0 +1,183 @@
+package mq
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bytearena/core/common/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncodeAndDecodeMessage(t *testing.T) {
+	data, err := EncodeMessage("arena-master-cli", "game", "launch", &GameLaunch{
+		Id:       "42",
+		Priority: OptionalInt{Value: 2, IsSet: true},
+	})
+	assert.Nil(t, err)
+
+	var envelope MessageEnvelope
+	assert.Nil(t, json.Unmarshal(data, &envelope))
+	assert.Equal(t, "arena-master-cli", envelope.From)
+	assert.Equal(t, 1, envelope.Version)
+
+	message, err := DecodeMessage("game", "launch", data)
+	assert.Nil(t, err)
+	assert.Equal(t, &GameLaunch{Id: "42", Priority: OptionalInt{Value: 2, IsSet: true}}, message)
+
+	_, err = EncodeMessage("arena-master-cli", "game", "launch", &GameLaunch{})
+	assert.EqualError(t, err, "Invalid message for game:launch: Missing id")
+
+	_, err = EncodeMessage("arena-master-cli", "game", "launch", &ArenaLaunch{Id: "42"})
+	assert.Error(t, err)
+
+	_, err = EncodeMessage("arena-master-cli", "game", "unknown", &ArenaLaunch{Id: "42"})
+	assert.Error(t, err)
+}
+
+// Components outside of this repository publish types.MQMessage without
+// version
+func TestDecodeUnversionedMessage(t *testing.T) {
+	data, err := json.Marshal(types.NewMQMessage("arena-server", "stopped").SetPayload(types.MQPayload{
+		"id":              "42",
+		"arenaserveruuid": "b7ca4fb1-8a80-4a1c-a4ee-0fd6c1ea0ba9",
+	}))
+	assert.Nil(t, err)
+
+	message, err := DecodeMessage("game", "stopped", data)
+	assert.Nil(t, err)
+	assert.Equal(t, "b7ca4fb1-8a80-4a1c-a4ee-0fd6c1ea0ba9", message.(*GameStopped).ArenaServerUUID)
+
+	// A missing payload is treated as empty
+	data, err = json.Marshal(types.NewMQMessage("arena-master-cli", "add"))
+	assert.Nil(t, err)
+
+	_, err = DecodeMessage("arena", "add", data)
+	assert.Nil(t, err)
+
+	_, err = DecodeMessage("arena", "halt", data)
+	assert.EqualError(t, err, "Invalid message for arena:halt: Missing id")
+}
+
+func TestDecodeLaterVersion(t *testing.T) {
+	_, err := DecodeMessage("arena", "halt", []byte(`{"version": 2, "payload": {"id": "3"}}`))
+	assert.EqualError(t, err, "Unsupported version 2 of arena:halt, expected at most 1")
+}
+
+func TestDecodeRawMessage(t *testing.T) {
+	data := []byte(`[{"GameID":"42","ArenaServerUUID":"b7ca4fb1","Objects":[]}]`)
+
+	message, err := DecodeMessage("viz", "message", data)
+	assert.Nil(t, err)
+
+	batch := message.(*VizBatch)
+	assert.Equal(t, []VizMessage{{GameID: "42", ArenaServerUUID: "b7ca4fb1"}}, batch.Messages)
+
+	encoded, err := EncodeMessage("arena-server", "viz", "message", batch)
+	assert.Nil(t, err)
+	assert.Equal(t, string(data), string(encoded))
+
+	_, err = DecodeMessage("viz", "message", []byte(`[]`))
+	assert.Error(t, err)
+}
+
+func TestAgentRepoInitKeepsCoreFormat(t *testing.T) {
+	expected, err := json.Marshal(types.NewMQError("dotgit-mq-consumer", "Could not init").SetPayload(types.MQPayload{
+		"agentid": "42",
+	}))
+	assert.Nil(t, err)
+
+	encoded, err := EncodeMessage("dotgit-mq-consumer", "agent", "repo-init-fail", NewAgentRepoInit(types.NewMQError("dotgit-mq-consumer", "Could not init"), "42"))
+	assert.Nil(t, err)
+	assert.Equal(t, string(expected), string(encoded))
+
+	message, err := DecodeMessage("agent", "repo-init-fail", encoded)
+	assert.Nil(t, err)
+	assert.Equal(t, "42", (*message.(*AgentRepoInit).Payload)["agentid"])
+
+	_, err = DecodeMessage("agent", "repo-init-success", []byte(`{"from":"dotgit-mq-consumer"}`))
+	assert.Error(t, err)
+}
+
+func TestLookupSchema(t *testing.T) {
+	schema, found := LookupSchema("game", "b7ca4fb1-8a80-4a1c-a4ee-0fd6c1ea0ba9.launch")
+	assert.True(t, found)
+	assert.Equal(t, &ArenaLaunch{}, schema.New())
+
+	schema, found = LookupSchema("game", "launch-error")
+	assert.True(t, found)
+	assert.Equal(t, &Rejection{}, schema.New())
+
+	_, found = LookupSchema("game", "unknown")
+	assert.False(t, found)
+}
+
+func TestOptionalInt(t *testing.T) {
+	examples := map[string]OptionalInt{
+		`{"id": "1", "priority": 3}`:    {Value: 3, IsSet: true},
+		`{"id": "1", "priority": "-1"}`: {Value: -1, IsSet: true},
+		`{"id": "1", "priority": ""}`:   {},
+		`{"id": "1", "priority": null}`: {},
+		`{"id": "1"}`:                   {},
+	}
+
+	for payload, expected := range examples {
+		var message GameLaunch
+
+		assert.Nil(t, json.Unmarshal([]byte(payload), &message))
+		assert.Equal(t, expected, message.Priority)
+	}
+
+	var message GameLaunch
+	assert.Error(t, json.Unmarshal([]byte(`{"id": "1", "priority": "high"}`), &message))
+}
+
+func TestSubscribeMessagesRejects(t *testing.T) {
+	redis := newMemoryRedis()
+
+	client, err := newClient(redis.dial)
+	assert.Nil(t, err)
+	defer client.Stop()
+
+	var mutex sync.Mutex
+	var launches []string
+	var rejected received
+
+	err = SubscribeMessages(client, "game", "launch", func(message Message) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		launches = append(launches, message.(*GameLaunch).Id)
+	})
+	assert.Nil(t, err)
+
+	assert.Nil(t, client.Subscribe("game", "launch-error", rejected.callback))
+
+	assert.Nil(t, client.Publish("game", "launch", json.RawMessage(`{"payload": {"priority": 1}}`)))
+	assert.Nil(t, PublishMessage(client, "arena-master-cli", "game", "launch", &GameLaunch{Id: "42"}))
+
+	message, err := DecodeMessage("game", "launch-error", []byte(rejected.wait(t, 1)[0]))
+	assert.Nil(t, err)
+	assert.Equal(t, "Invalid message for game:launch: Missing id", message.(*Rejection).Error)
+
+	// Handled in order, after the rejected one
+	deadline := time.Now().Add(2 * time.Second)
+
+	for time.Now().Before(deadline) {
+		mutex.Lock()
+		length := len(launches)
+		mutex.Unlock()
+
+		if length > 0 {
+			break
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	assert.Equal(t, []string{"42"}, launches)
+}
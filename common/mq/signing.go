@@ -0,0 +1,285 @@
+package mq
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// Messages older than that, or from the future, are refused
+	MAX_MESSAGE_AGE = 30 * time.Second
+)
+
+const (
+	// Each arena-server signs with a key derived from its arena id
+	ARENA_SERVER_COMPONENT_PREFIX = "arena-server."
+
+	// arena-master signs the messages to an arena with the key of the arena
+	ARENA_MASTER_COMPONENT = "arena-master"
+)
+
+// MessageAuth is added to each message published by a client with a signing
+// key, the signature covers every other field of the message
+type MessageAuth struct {
+	Component string `json:"component"`
+	Timestamp int64  `json:"timestamp"`
+	Nonce     string `json:"nonce"`
+	Signature string `json:"signature"`
+}
+
+// Keyring returns the key of a component to verify its messages
+type Keyring interface {
+	Key(component string) ([]byte, bool)
+}
+
+type StaticKeyring map[string][]byte
+
+func (k StaticKeyring) Key(component string) ([]byte, bool) {
+	key, ok := k[component]
+
+	return key, ok
+}
+
+// PrefixKeyring derives the key of every component starting with Prefix from
+// a master key, each arena-server gets its own key this way
+type PrefixKeyring struct {
+	Prefix string
+	Master []byte
+}
+
+func (k PrefixKeyring) Key(component string) ([]byte, bool) {
+	if !strings.HasPrefix(component, k.Prefix) || len(component) == len(k.Prefix) {
+		return nil, false
+	}
+
+	return DeriveKey(k.Master, component), true
+}
+
+// Keyrings looks up the keyrings in order
+type Keyrings []Keyring
+
+func (k Keyrings) Key(component string) ([]byte, bool) {
+	for _, keyring := range k {
+		if key, ok := keyring.Key(component); ok {
+			return key, true
+		}
+	}
+
+	return nil, false
+}
+
+// DeriveKey returns the hex encoded key of a component from a master key
+func DeriveKey(master []byte, component string) []byte {
+	mac := hmac.New(sha256.New, master)
+	mac.Write([]byte(component))
+
+	return []byte(hex.EncodeToString(mac.Sum(nil)))
+}
+
+// SigningConfig enables the signature of the published messages, and their
+// verification when a keyring is set
+type SigningConfig struct {
+	Component string
+	Key       []byte
+
+	// Nil to accept any message
+	Keyring Keyring
+}
+
+func computeSignature(key []byte, auth MessageAuth, channel, topic string, data []byte) string {
+	mac := hmac.New(sha256.New, key)
+
+	mac.Write([]byte(auth.Component + "\n" + strconv.FormatInt(auth.Timestamp, 10) + "\n" + auth.Nonce + "\n"))
+	mac.Write([]byte(channel + "\n" + topic + "\n"))
+	mac.Write(data)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func newNonce() (string, error) {
+	nonce := make([]byte, 16)
+
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(nonce), nil
+}
+
+func signMessage(config SigningConfig, channel, topic string, data []byte, now time.Time) (*MessageAuth, error) {
+	nonce, err := newNonce()
+
+	if err != nil {
+		return nil, err
+	}
+
+	auth := MessageAuth{
+		Component: config.Component,
+		Timestamp: now.UnixNano(),
+		Nonce:     nonce,
+	}
+
+	auth.Signature = computeSignature(config.Key, auth, channel, topic, data)
+
+	return &auth, nil
+}
+
+// verifier checks the messages of a subscription, the nonces seen in the
+// last MAX_MESSAGE_AGE are kept to refuse replays
+type verifier struct {
+	mutex      sync.Mutex
+	keyring    Keyring
+	authorized []string
+	nonces     map[string]time.Time
+}
+
+func newVerifier(keyring Keyring, authorized []string) *verifier {
+	return &verifier{
+		keyring:    keyring,
+		authorized: authorized,
+		nonces:     make(map[string]time.Time),
+	}
+}
+
+// Components ending with a dot authorize every component with this prefix
+func (v *verifier) isAuthorized(component string) bool {
+	if len(v.authorized) == 0 {
+		return true
+	}
+
+	for _, authorized := range v.authorized {
+		if component == authorized {
+			return true
+		}
+
+		if strings.HasSuffix(authorized, ".") && strings.HasPrefix(component, authorized) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (v *verifier) verify(auth *MessageAuth, channel, topic string, data []byte, now time.Time) error {
+	if auth == nil {
+		return errors.New("Unsigned message")
+	}
+
+	key, hasKey := v.keyring.Key(auth.Component)
+
+	if !hasKey {
+		return errors.New("Unknown component " + auth.Component)
+	}
+
+	expected := computeSignature(key, *auth, channel, topic, data)
+
+	if !hmac.Equal([]byte(expected), []byte(auth.Signature)) {
+		return errors.New("Invalid signature from " + auth.Component)
+	}
+
+	if !v.isAuthorized(auth.Component) {
+		return errors.New(auth.Component + " is not allowed to publish on " + channelAndTopicToString(channel, topic))
+	}
+
+	age := now.Sub(time.Unix(0, auth.Timestamp))
+
+	if age > MAX_MESSAGE_AGE || age < -MAX_MESSAGE_AGE {
+		return errors.New("Expired message from " + auth.Component)
+	}
+
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	for nonce, seen := range v.nonces {
+		if now.Sub(seen) > 2*MAX_MESSAGE_AGE {
+			delete(v.nonces, nonce)
+		}
+	}
+
+	if _, seen := v.nonces[auth.Nonce]; seen {
+		return errors.New("Replayed message from " + auth.Component)
+	}
+
+	v.nonces[auth.Nonce] = now
+
+	return nil
+}
+
+// Parses "name=key,name=key"
+func parseKeys(raw string) (map[string][]byte, error) {
+	res := make(map[string][]byte)
+
+	for _, pair := range strings.Split(raw, ",") {
+		pair = strings.TrimSpace(pair)
+
+		if pair == "" {
+			continue
+		}
+
+		parts := strings.SplitN(pair, "=", 2)
+
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, errors.New("Invalid key " + parts[0] + ", expected name=key")
+		}
+
+		res[parts[0]] = []byte(parts[1])
+	}
+
+	return res, nil
+}
+
+// SigningConfigFromEnv reads the key of the component in MQ_KEY, the keys of
+// the trusted components in MQ_KEYS and the master keys of the derived ones
+// in MQ_DERIVED_KEYS, like "arena-server.=secret". It returns nil when
+// MQ_KEY is not set.
+func SigningConfigFromEnv(component string) (*SigningConfig, error) {
+	key := os.Getenv("MQ_KEY")
+
+	if key == "" {
+		return nil, nil
+	}
+
+	config := &SigningConfig{
+		Component: component,
+		Key:       []byte(key),
+	}
+
+	trusted, err := parseKeys(os.Getenv("MQ_KEYS"))
+
+	if err != nil {
+		return nil, errors.New("MQ_KEYS: " + err.Error())
+	}
+
+	derived, err := parseKeys(os.Getenv("MQ_DERIVED_KEYS"))
+
+	if err != nil {
+		return nil, errors.New("MQ_DERIVED_KEYS: " + err.Error())
+	}
+
+	if len(trusted) == 0 && len(derived) == 0 {
+		return config, nil
+	}
+
+	// A component trusts itself
+	trusted[component] = config.Key
+	keyrings := Keyrings{StaticKeyring(trusted)}
+
+	for prefix, master := range derived {
+		keyrings = append(keyrings, PrefixKeyring{
+			Prefix: prefix,
+			Master: master,
+		})
+	}
+
+	config.Keyring = keyrings
+
+	return config, nil
+}
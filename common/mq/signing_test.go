@@ -0,0 +1,228 @@
+package mq
+
+import (
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	testKeyring = Keyrings{
+		StaticKeyring{"arena-master": []byte("master-key")},
+		PrefixKeyring{Prefix: "arena-server.", Master: []byte("arena-key")},
+	}
+
+	masterConfig = SigningConfig{
+		Component: "arena-master",
+		Key:       []byte("master-key"),
+	}
+)
+
+func TestSignedMessages(t *testing.T) {
+	now := time.Now()
+	data := []byte(`{"id":"42"}`)
+
+	arenaConfig := SigningConfig{
+		Component: "arena-server.1",
+		Key:       DeriveKey([]byte("arena-key"), "arena-server.1"),
+	}
+
+	forged := SigningConfig{
+		Component: "arena-master",
+		Key:       []byte("guessed"),
+	}
+
+	examples := []struct {
+		config  SigningConfig
+		channel string
+		topic   string
+		sentAt  time.Time
+		valid   bool
+	}{
+		{masterConfig, "game", "launch", now, true},
+		{arenaConfig, "game", "launch", now, true},
+		{forged, "game", "launch", now, false},
+		{masterConfig, "game", "stopped", now, false},
+		{masterConfig, "game", "launch", now.Add(-time.Hour), false},
+		{masterConfig, "game", "launch", now.Add(time.Hour), false},
+	}
+
+	for _, example := range examples {
+		auth, err := signMessage(example.config, example.channel, example.topic, data, example.sentAt)
+		assert.Nil(t, err)
+
+		err = newVerifier(testKeyring, nil).verify(auth, "game", "launch", data, now)
+
+		if example.valid {
+			assert.Nil(t, err)
+		} else {
+			assert.Error(t, err)
+		}
+	}
+
+	auth, _ := signMessage(masterConfig, "game", "launch", data, now)
+	assert.Error(t, newVerifier(testKeyring, nil).verify(auth, "game", "launch", []byte(`{"id":"43"}`), now))
+	assert.Error(t, newVerifier(testKeyring, nil).verify(nil, "game", "launch", data, now))
+}
+
+func TestReplayedMessages(t *testing.T) {
+	now := time.Now()
+	data := []byte(`{}`)
+	v := newVerifier(testKeyring, nil)
+
+	auth, _ := signMessage(masterConfig, "arena", "halt", data, now)
+
+	assert.Nil(t, v.verify(auth, "arena", "halt", data, now))
+	assert.Error(t, v.verify(auth, "arena", "halt", data, now.Add(time.Second)))
+
+	other, _ := signMessage(masterConfig, "arena", "halt", data, now)
+	assert.Nil(t, v.verify(other, "arena", "halt", data, now.Add(time.Second)))
+}
+
+func TestAuthorizedComponents(t *testing.T) {
+	now := time.Now()
+	data := []byte(`{}`)
+	v := newVerifier(testKeyring, []string{"arena-server."})
+
+	arenaConfig := SigningConfig{
+		Component: "arena-server.2",
+		Key:       DeriveKey([]byte("arena-key"), "arena-server.2"),
+	}
+
+	auth, _ := signMessage(arenaConfig, "game", "stopped", data, now)
+	assert.Nil(t, v.verify(auth, "game", "stopped", data, now))
+
+	auth, _ = signMessage(masterConfig, "game", "stopped", data, now)
+	assert.Error(t, v.verify(auth, "game", "stopped", data, now))
+
+	_, hasKey := testKeyring.Key("arena-server.")
+	assert.False(t, hasKey)
+}
+
+func TestSigningConfigFromEnv(t *testing.T) {
+	defer os.Unsetenv("MQ_KEY")
+	defer os.Unsetenv("MQ_KEYS")
+	defer os.Unsetenv("MQ_DERIVED_KEYS")
+
+	config, err := SigningConfigFromEnv("arena-master")
+	assert.Nil(t, err)
+	assert.Nil(t, config)
+
+	os.Setenv("MQ_KEY", "master-key")
+
+	config, err = SigningConfigFromEnv("arena-master")
+	assert.Nil(t, err)
+	assert.Nil(t, config.Keyring)
+
+	os.Setenv("MQ_KEYS", "arena-master-cli=cli-key")
+	os.Setenv("MQ_DERIVED_KEYS", "arena-server.=arena-key")
+
+	config, err = SigningConfigFromEnv("arena-master")
+	assert.Nil(t, err)
+
+	key, _ := config.Keyring.Key("arena-master-cli")
+	assert.Equal(t, []byte("cli-key"), key)
+
+	key, _ = config.Keyring.Key("arena-server.3")
+	assert.Equal(t, DeriveKey([]byte("arena-key"), "arena-server.3"), key)
+
+	os.Setenv("MQ_KEYS", "arena-master-cli")
+
+	_, err = SigningConfigFromEnv("arena-master")
+	assert.Error(t, err)
+}
+
+func TestArenaLaunchKey(t *testing.T) {
+	now := time.Now()
+	data := []byte(`{"id":"42"}`)
+
+	arenaKey := DeriveKey([]byte("arena-key"), ARENA_SERVER_COMPONENT_PREFIX+"a")
+	otherKey := DeriveKey([]byte("arena-key"), ARENA_SERVER_COMPONENT_PREFIX+"b")
+
+	// The keyring trusted by arena a on its launch topic
+	v := newVerifier(StaticKeyring{ARENA_MASTER_COMPONENT: arenaKey}, []string{ARENA_MASTER_COMPONENT})
+
+	examples := []struct {
+		config SigningConfig
+		valid  bool
+	}{
+		{SigningConfig{Component: ARENA_MASTER_COMPONENT, Key: arenaKey}, true},
+		{masterConfig, false},
+		{SigningConfig{Component: ARENA_MASTER_COMPONENT, Key: otherKey}, false},
+		{SigningConfig{Component: ARENA_SERVER_COMPONENT_PREFIX + "a", Key: arenaKey}, false},
+	}
+
+	for _, example := range examples {
+		auth, err := signMessage(example.config, "game", "a.launch", data, now)
+		assert.Nil(t, err)
+
+		err = v.verify(auth, "game", "a.launch", data, now)
+
+		if example.valid {
+			assert.Nil(t, err)
+		} else {
+			assert.Error(t, err)
+		}
+	}
+}
+
+func arenaConfig(arenaId string) SigningConfig {
+	return SigningConfig{
+		Component: ARENA_SERVER_COMPONENT_PREFIX + arenaId,
+		Key:       DeriveKey([]byte("arena-key"), ARENA_SERVER_COMPONENT_PREFIX+arenaId),
+	}
+}
+
+func TestSignerOfMessages(t *testing.T) {
+	redis := newMemoryRedis()
+
+	master, err := newClient(redis.dial)
+	assert.Nil(t, err)
+	defer master.Stop()
+
+	config := masterConfig
+	config.Keyring = testKeyring
+	master.EnableSigning(config)
+	master.Authorize("game", "handshake", ARENA_SERVER_COMPONENT_PREFIX)
+
+	arena, err := newClient(redis.dial)
+	assert.Nil(t, err)
+	defer arena.Stop()
+
+	arena.EnableSigning(arenaConfig("a"))
+
+	var mutex sync.Mutex
+	signers := make(map[string]string)
+
+	assert.Nil(t, SubscribeSignedMessages(master, "game", "handshake", func(message Message, component string) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		signers[message.(*GameHandshake).ArenaServerUUID] = component
+	}))
+
+	// Arena a can sign a message about arena b, the handler has to refuse it
+	assert.Nil(t, PublishMessage(arena, "arena-server.a", "game", "handshake", &GameHandshake{ArenaServerUUID: "b"}))
+
+	deadline := time.Now().Add(2 * time.Second)
+
+	for time.Now().Before(deadline) {
+		mutex.Lock()
+		count := len(signers)
+		mutex.Unlock()
+
+		if count > 0 {
+			break
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	assert.Equal(t, map[string]string{"b": "arena-server.a"}, signers)
+}
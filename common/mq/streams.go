@@ -0,0 +1,677 @@
+package mq
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bytearena/core/common/mq"
+	"github.com/bytearena/core/common/utils"
+
+	"github.com/go-redis/redis"
+)
+
+var (
+	// Streams are trimmed to about that many entries
+	STREAM_MAX_LEN = 10000
+
+	STREAM_BATCH_SIZE = 10
+	STREAM_BLOCK      = 2 * time.Second
+
+	// Entries not acknowledged by a consumer for that long are claimed by
+	// another consumer of the group
+	STREAM_CLAIM_AFTER = 1 * time.Minute
+	STREAM_CLAIM_FREQ  = 30 * time.Second
+
+	// Entries delivered that many times are acknowledged without being handled
+	STREAM_MAX_DELIVERIES = 5
+
+	STREAM_RETRY_AFTER = 1 * time.Second
+)
+
+// streamConn runs raw commands, the stream commands are not wrapped by the
+// Redis client. A nil reply is returned without error.
+type streamConn interface {
+	Do(args ...interface{}) (interface{}, error)
+	Close() error
+}
+
+type redisStreamConn struct {
+	conn *redis.Client
+}
+
+func (c redisStreamConn) Do(args ...interface{}) (interface{}, error) {
+	cmd := redis.NewCmd(args...)
+	c.conn.Process(cmd)
+
+	res, err := cmd.Result()
+
+	if err == redis.Nil {
+		return nil, nil
+	}
+
+	return res, err
+}
+
+func (c redisStreamConn) Close() error {
+	return c.conn.Close()
+}
+
+type streamEntry struct {
+	id      string
+	payload []byte
+}
+
+// StreamClient delivers the messages through Redis Streams. Each topic is a
+// stream and each group of consumers receives every message of the topics it
+// subscribed to, at least once: a message is acknowledged after its callback
+// returned, and the messages of a consumer which died are claimed by another
+// consumer of the group.
+type StreamClient struct {
+	conn     streamConn
+	group    string
+	consumer string
+
+	stopChan      chan struct{}
+	stopOnce      sync.Once
+	subscriptions sync.WaitGroup
+
+	envelope
+}
+
+// NewStreamClient connects to Redis, the consumer must be unique in the group
+func NewStreamClient(host, group, consumer string) (*StreamClient, error) {
+	conn := redis.NewClient(&redis.Options{
+		Addr:     host + ":6379",
+		Password: "", // no password set
+		DB:       0,  // use default DB
+
+		// Each subscription blocks a connection while reading
+		PoolSize:    64,
+		ReadTimeout: STREAM_BLOCK + 5*time.Second,
+	})
+
+	if conn == nil {
+		return nil, errors.New("Cannot connect to messagebroker host " + host)
+	}
+
+	return newStreamClient(redisStreamConn{conn}, group, consumer), nil
+}
+
+func newStreamClient(conn streamConn, group, consumer string) *StreamClient {
+	return &StreamClient{
+		conn:     conn,
+		group:    group,
+		consumer: consumer,
+		stopChan: make(chan struct{}),
+	}
+}
+
+func streamKey(channel, topic string) string {
+	return "mq:" + channelAndTopicToString(channel, topic)
+}
+
+func (client *StreamClient) isStopped() bool {
+	select {
+	case <-client.stopChan:
+		return true
+	default:
+		return false
+	}
+}
+
+func (client *StreamClient) Stop() {
+	client.stopOnce.Do(func() {
+		close(client.stopChan)
+
+		// Interrupts the blocked reads
+		err := client.conn.Close()
+		utils.Check(err, "Unable to Redis client connection")
+
+		client.subscriptions.Wait()
+	})
+}
+
+func (client *StreamClient) Ping() error {
+	_, err := client.conn.Do("PING")
+
+	return err
+}
+
+// Health pings Redis, the blocked reads retry until it's back
+func (client *StreamClient) Health() error {
+	return client.Ping()
+}
+
+/* <mq.MessageBrokerClientInterface> */
+func (client *StreamClient) Subscribe(channel string, topic string, onmessage mq.SubscriptionCallback) error {
+	_, err := client.SubscribeWithContext(context.Background(), channel, topic, onmessage, DEFAULT_SUBSCRIPTION_OPTIONS)
+
+	return err
+}
+
+func (client *StreamClient) SubscribeVerified(channel string, topic string, onmessage VerifiedCallback) error {
+	_, err := client.subscribe(context.Background(), channel, topic, onmessage, DEFAULT_SUBSCRIPTION_OPTIONS)
+
+	return err
+}
+
+// SubscribeWithContext subscribes until the context is done or the returned
+// subscription is unsubscribed. The messages dropped by the overflow policy
+// are not acknowledged, this consumer claims them again after
+// STREAM_CLAIM_AFTER.
+func (client *StreamClient) SubscribeWithContext(ctx context.Context, channel string, topic string, onmessage mq.SubscriptionCallback, options SubscriptionOptions) (*Subscription, error) {
+	return client.subscribe(ctx, channel, topic, func(msg mq.BrokerMessage, component string) {
+		onmessage(msg)
+	}, options)
+}
+
+func (client *StreamClient) subscribe(ctx context.Context, channel string, topic string, onmessage VerifiedCallback, options SubscriptionOptions) (*Subscription, error) {
+	key := streamKey(channel, topic)
+
+	// Only the messages published after the creation of the group are
+	// delivered to it
+	_, err := client.conn.Do("XGROUP", "CREATE", key, client.group, "$", "MKSTREAM")
+
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return nil, errors.New("Could not create group " + client.group + " on " + key + ": " + err.Error())
+	}
+
+	utils.Debug("mq", "Subscribed to stream "+key+" in group "+client.group)
+
+	subscription := streamSubscription{
+		client:    client,
+		key:       key,
+		channel:   channel,
+		topic:     topic,
+		verifier:  client.newVerifier(channel, topic),
+		onmessage: onmessage,
+		handling:  newHandlingEntries(),
+	}
+
+	subscription.handle = newSubscription(ctx, key, options, func() {
+		utils.Debug("mq", "Unsubscribed from stream "+key)
+	})
+
+	client.subscriptions.Add(1)
+	go func() {
+		defer client.subscriptions.Done()
+		subscription.run()
+	}()
+
+	return subscription.handle, nil
+}
+
+func (client *StreamClient) Publish(channel, topic string, payload interface{}) error {
+	data, err := client.encode(channel, topic, payload)
+
+	if err != nil {
+		return err
+	}
+
+	return client.add(channel, topic, data)
+}
+
+func (client *StreamClient) PublishSigned(config SigningConfig, channel, topic string, payload interface{}) error {
+	data, err := encodeSigned(&config, channel, topic, payload)
+
+	if err != nil {
+		return err
+	}
+
+	return client.add(channel, topic, data)
+}
+
+func (client *StreamClient) add(channel, topic string, data []byte) error {
+
+	_, err := client.conn.Do("XADD", streamKey(channel, topic), "MAXLEN", "~", STREAM_MAX_LEN, "*", "message", string(data))
+
+	return err
+}
+
+/* </mq.MessageBrokerClientInterface> */
+
+// DeleteTopic deletes the stream of the topic with its consumer groups.
+// MAXLEN only caps the entries, the streams of the components which are gone
+// have to be deleted.
+func (client *StreamClient) DeleteTopic(channel, topic string) error {
+	_, err := client.conn.Do("DEL", streamKey(channel, topic))
+
+	return err
+}
+
+type streamSubscription struct {
+	client    *StreamClient
+	key       string
+	channel   string
+	topic     string
+	verifier  *verifier
+	onmessage VerifiedCallback
+	handle    *Subscription
+	handling  *handlingEntries
+}
+
+// handlingEntries are the entries of the subscription buffered or being
+// handled, the other pending entries of the consumer were dropped
+type handlingEntries struct {
+	mutex sync.Mutex
+	ids   map[string]bool
+}
+
+func newHandlingEntries() *handlingEntries {
+	return &handlingEntries{
+		ids: make(map[string]bool),
+	}
+}
+
+// add returns false if the entry is already being handled
+func (h *handlingEntries) add(id string) bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.ids[id] {
+		return false
+	}
+
+	h.ids[id] = true
+
+	return true
+}
+
+func (h *handlingEntries) remove(id string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	delete(h.ids, id)
+}
+
+func (h *handlingEntries) has(id string) bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	return h.ids[id]
+}
+
+func (s streamSubscription) isStopped() bool {
+	return s.client.isStopped() || s.handle.dispatcher.isStopped()
+}
+
+func (s streamSubscription) run() {
+	defer s.handle.dispatcher.stop()
+
+	// Messages delivered to this consumer before a restart and not
+	// acknowledged come first, they are acknowledged asynchronously so the
+	// next read starts after the last one
+	lastPending := "0"
+
+	for !s.isStopped() {
+		entries, err := s.read(lastPending)
+
+		if err != nil {
+			s.retryLater(err)
+			continue
+		}
+
+		if len(entries) == 0 {
+			break
+		}
+
+		lastPending = entries[len(entries)-1].id
+		s.dispatch(entries)
+	}
+
+	lastClaim := time.Now()
+
+	for !s.isStopped() {
+		entries, err := s.read(">")
+
+		if err != nil {
+			s.retryLater(err)
+			continue
+		}
+
+		s.dispatch(entries)
+
+		if time.Since(lastClaim) >= STREAM_CLAIM_FREQ {
+			s.claim()
+			lastClaim = time.Now()
+		}
+	}
+}
+
+func (s streamSubscription) retryLater(err error) {
+	if s.isStopped() {
+		return
+	}
+
+	utils.RecoverableError("mqclient", "Could not read "+s.key+": "+err.Error())
+
+	select {
+	case <-time.After(STREAM_RETRY_AFTER):
+	case <-s.client.stopChan:
+	case <-s.handle.dispatcher.stopChan:
+	}
+}
+
+func (s streamSubscription) read(id string) ([]streamEntry, error) {
+	args := []interface{}{"XREADGROUP", "GROUP", s.client.group, s.client.consumer, "COUNT", STREAM_BATCH_SIZE}
+
+	if id == ">" {
+		args = append(args, "BLOCK", int64(STREAM_BLOCK/time.Millisecond))
+	}
+
+	res, err := s.client.conn.Do(append(args, "STREAMS", s.key, id)...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return parseStreamsReply(res)
+}
+
+func (s streamSubscription) ack(ids ...string) {
+	if len(ids) == 0 {
+		return
+	}
+
+	args := []interface{}{"XACK", s.key, s.client.group}
+
+	for _, id := range ids {
+		args = append(args, id)
+	}
+
+	if _, err := s.client.conn.Do(args...); err != nil {
+		utils.RecoverableError("mqclient", "Could not acknowledge on "+s.key+": "+err.Error())
+	}
+}
+
+// Invalid messages are acknowledged, they would be refused again
+func (s streamSubscription) dispatch(entries []streamEntry) {
+	for _, entry := range entries {
+		if entry.payload == nil {
+			s.ack(entry.id)
+			continue
+		}
+
+		message, component, err := s.client.decode(entry.payload, s.channel, s.topic, s.verifier)
+
+		if err != nil {
+			utils.RecoverableError("mqclient", err.Error())
+			s.ack(entry.id)
+			continue
+		}
+
+		id := entry.id
+
+		if !s.handling.add(id) {
+			continue
+		}
+
+		s.handle.dispatcher.pushJob(job{
+			run: func() {
+				s.onmessage(message, component)
+				s.ack(id)
+				s.handling.remove(id)
+			},
+			dropped: func() {
+				s.handling.remove(id)
+			},
+		})
+	}
+}
+
+// claim takes over the entries other consumers of the group didn't
+// acknowledge in time, and the entries this consumer dropped. The pending
+// entries are listed by batches.
+func (s streamSubscription) claim() {
+	start := "-"
+
+	for {
+		res, err := s.client.conn.Do("XPENDING", s.key, s.client.group, start, "+", STREAM_BATCH_SIZE)
+
+		if err != nil {
+			utils.RecoverableError("mqclient", "Could not list pending entries of "+s.key+": "+err.Error())
+			return
+		}
+
+		pending, err := parsePendingReply(res)
+
+		if err != nil {
+			utils.RecoverableError("mqclient", err.Error())
+			return
+		}
+
+		s.claimEntries(pending)
+
+		if len(pending) < STREAM_BATCH_SIZE {
+			return
+		}
+
+		start, err = nextStreamId(pending[len(pending)-1].id)
+
+		if err != nil {
+			utils.RecoverableError("mqclient", err.Error())
+			return
+		}
+	}
+}
+
+func (s streamSubscription) claimEntries(pending []pendingEntry) {
+	claimAfter := int64(STREAM_CLAIM_AFTER / time.Millisecond)
+	args := []interface{}{"XCLAIM", s.key, s.client.group, s.client.consumer, claimAfter}
+	claimed := 0
+
+	for _, entry := range pending {
+		if entry.idle < claimAfter || s.handling.has(entry.id) {
+			continue
+		}
+
+		if entry.deliveries >= int64(STREAM_MAX_DELIVERIES) {
+			utils.RecoverableError("mqclient", "Dropping message "+entry.id+" of "+s.key+" after "+strconv.FormatInt(entry.deliveries, 10)+" deliveries")
+			s.ack(entry.id)
+			continue
+		}
+
+		args = append(args, entry.id)
+		claimed++
+	}
+
+	if claimed == 0 {
+		return
+	}
+
+	res, err := s.client.conn.Do(args...)
+
+	if err != nil {
+		utils.RecoverableError("mqclient", "Could not claim entries of "+s.key+": "+err.Error())
+		return
+	}
+
+	entries, err := parseEntries(res)
+
+	if err != nil {
+		utils.RecoverableError("mqclient", err.Error())
+		return
+	}
+
+	s.dispatch(entries)
+}
+
+// nextStreamId is the smallest id after the given one, the exclusive ranges
+// of XPENDING need Redis 6.2
+func nextStreamId(id string) (string, error) {
+	parts := strings.Split(id, "-")
+
+	if len(parts) != 2 {
+		return "", errors.New("Invalid stream id " + id)
+	}
+
+	seq, err := strconv.ParseUint(parts[1], 10, 64)
+
+	if err != nil {
+		return "", errors.New("Invalid stream id " + id)
+	}
+
+	return parts[0] + "-" + strconv.FormatUint(seq+1, 10), nil
+}
+
+func replyString(value interface{}) (string, bool) {
+	switch res := value.(type) {
+	case string:
+		return res, true
+	case []byte:
+		return string(res), true
+	}
+
+	return "", false
+}
+
+func replyInt(value interface{}) (int64, bool) {
+	switch res := value.(type) {
+	case int64:
+		return res, true
+	case string:
+		parsed, err := strconv.ParseInt(res, 10, 64)
+		return parsed, err == nil
+	}
+
+	return 0, false
+}
+
+var errInvalidReply = errors.New("Invalid reply from the messagebroker")
+
+// Parses [[key, entries], ...] of XREADGROUP
+func parseStreamsReply(res interface{}) ([]streamEntry, error) {
+	if res == nil {
+		return nil, nil
+	}
+
+	streams, ok := res.([]interface{})
+
+	if !ok {
+		return nil, errInvalidReply
+	}
+
+	entries := make([]streamEntry, 0)
+
+	for _, rawStream := range streams {
+		stream, ok := rawStream.([]interface{})
+
+		if !ok || len(stream) != 2 {
+			return nil, errInvalidReply
+		}
+
+		streamEntries, err := parseEntries(stream[1])
+
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, streamEntries...)
+	}
+
+	return entries, nil
+}
+
+// Parses [[id, [field, value, ...]], ...], the fields of deleted entries are
+// nil
+func parseEntries(res interface{}) ([]streamEntry, error) {
+	if res == nil {
+		return nil, nil
+	}
+
+	rawEntries, ok := res.([]interface{})
+
+	if !ok {
+		return nil, errInvalidReply
+	}
+
+	entries := make([]streamEntry, 0, len(rawEntries))
+
+	for _, rawEntry := range rawEntries {
+		// XCLAIM replies nil for the entries deleted in the meantime
+		if rawEntry == nil {
+			continue
+		}
+
+		entry, ok := rawEntry.([]interface{})
+
+		if !ok || len(entry) != 2 {
+			return nil, errInvalidReply
+		}
+
+		id, ok := replyString(entry[0])
+
+		if !ok {
+			return nil, errInvalidReply
+		}
+
+		res := streamEntry{
+			id: id,
+		}
+
+		fields, _ := entry[1].([]interface{})
+
+		for i := 0; i+1 < len(fields); i += 2 {
+			if field, _ := replyString(fields[i]); field == "message" {
+				value, _ := replyString(fields[i+1])
+				res.payload = []byte(value)
+			}
+		}
+
+		entries = append(entries, res)
+	}
+
+	return entries, nil
+}
+
+type pendingEntry struct {
+	id         string
+	consumer   string
+	idle       int64
+	deliveries int64
+}
+
+// Parses [[id, consumer, idle, deliveries], ...] of XPENDING
+func parsePendingReply(res interface{}) ([]pendingEntry, error) {
+	if res == nil {
+		return nil, nil
+	}
+
+	rows, ok := res.([]interface{})
+
+	if !ok {
+		return nil, errInvalidReply
+	}
+
+	entries := make([]pendingEntry, 0, len(rows))
+
+	for _, rawRow := range rows {
+		row, ok := rawRow.([]interface{})
+
+		if !ok || len(row) != 4 {
+			return nil, errInvalidReply
+		}
+
+		id, okId := replyString(row[0])
+		consumer, okConsumer := replyString(row[1])
+		idle, okIdle := replyInt(row[2])
+		deliveries, okDeliveries := replyInt(row[3])
+
+		if !okId || !okConsumer || !okIdle || !okDeliveries {
+			return nil, errInvalidReply
+		}
+
+		entries = append(entries, pendingEntry{
+			id:         id,
+			consumer:   consumer,
+			idle:       idle,
+			deliveries: deliveries,
+		})
+	}
+
+	return entries, nil
+}
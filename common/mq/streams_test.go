@@ -0,0 +1,673 @@
+package mq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bytearena/core/common/mq"
+	"github.com/stretchr/testify/assert"
+)
+
+type memoryPending struct {
+	consumer    string
+	deliveredAt time.Time
+	deliveries  int64
+}
+
+type memoryGroup struct {
+	lastDelivered int
+	pending       map[string]*memoryPending
+}
+
+type memoryStream struct {
+	entries []streamEntry
+	groups  map[string]*memoryGroup
+}
+
+// memoryStreams implements the stream commands used by StreamClient
+type memoryStreams struct {
+	mutex   sync.Mutex
+	streams map[string]*memoryStream
+	nextId  int
+}
+
+func newMemoryStreams() *memoryStreams {
+	return &memoryStreams{
+		streams: make(map[string]*memoryStream),
+	}
+}
+
+func (m *memoryStreams) conn() *memoryConn {
+	return &memoryConn{streams: m}
+}
+
+type memoryConn struct {
+	streams *memoryStreams
+	closed  bool
+}
+
+func (c *memoryConn) Close() error {
+	c.streams.mutex.Lock()
+	defer c.streams.mutex.Unlock()
+
+	c.closed = true
+
+	return nil
+}
+
+func (c *memoryConn) Do(args ...interface{}) (interface{}, error) {
+	m := c.streams
+	m.mutex.Lock()
+
+	if c.closed {
+		m.mutex.Unlock()
+		return nil, errors.New("connection closed")
+	}
+
+	res, err, blocked := m.do(args)
+	m.mutex.Unlock()
+
+	if blocked {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	return res, err
+}
+
+func entryNumber(id string) int {
+	res, _ := strconv.Atoi(strings.Split(id, "-")[0])
+
+	return res
+}
+
+func compareEntryIds(a, b string) int {
+	for i := 0; i < 2; i++ {
+		x, _ := strconv.Atoi(strings.Split(a, "-")[i])
+		y, _ := strconv.Atoi(strings.Split(b, "-")[i])
+
+		if x != y {
+			return x - y
+		}
+	}
+
+	return 0
+}
+
+func entryReply(entry streamEntry) interface{} {
+	return []interface{}{entry.id, []interface{}{"message", string(entry.payload)}}
+}
+
+func (m *memoryStreams) do(args []interface{}) (interface{}, error, bool) {
+	strs := make([]string, len(args))
+
+	for i, arg := range args {
+		strs[i] = fmt.Sprint(arg)
+	}
+
+	switch strs[0] {
+	case "XREADGROUP":
+		if !m.hasGroup(strs[len(strs)-2], strs[2]) {
+			return nil, errors.New("NOGROUP No such key or consumer group"), false
+		}
+
+	case "XACK", "XPENDING", "XCLAIM":
+		if !m.hasGroup(strs[1], strs[2]) {
+			return nil, errors.New("NOGROUP No such key or consumer group"), false
+		}
+	}
+
+	switch strs[0] {
+	case "PING":
+		return "PONG", nil, false
+
+	case "DEL":
+		if _, ok := m.streams[strs[1]]; !ok {
+			return int64(0), nil, false
+		}
+
+		delete(m.streams, strs[1])
+
+		return int64(1), nil, false
+
+	case "XGROUP":
+		key, group := strs[2], strs[3]
+
+		if _, ok := m.streams[key]; !ok {
+			m.streams[key] = &memoryStream{groups: make(map[string]*memoryGroup)}
+		}
+
+		stream := m.streams[key]
+
+		if _, exists := stream.groups[group]; exists {
+			return nil, errors.New("BUSYGROUP Consumer Group name already exists"), false
+		}
+
+		stream.groups[group] = &memoryGroup{
+			lastDelivered: len(stream.entries),
+			pending:       make(map[string]*memoryPending),
+		}
+
+		return "OK", nil, false
+
+	case "XADD":
+		key := strs[1]
+
+		if _, ok := m.streams[key]; !ok {
+			m.streams[key] = &memoryStream{groups: make(map[string]*memoryGroup)}
+		}
+
+		m.nextId++
+		id := strconv.Itoa(m.nextId) + "-0"
+
+		m.streams[key].entries = append(m.streams[key].entries, streamEntry{
+			id:      id,
+			payload: []byte(strs[len(strs)-1]),
+		})
+
+		return id, nil, false
+
+	case "XREADGROUP":
+		group, consumer, count := strs[2], strs[3], 0
+		count, _ = strconv.Atoi(strs[5])
+		key, id := strs[len(strs)-2], strs[len(strs)-1]
+
+		stream := m.streams[key]
+		g := stream.groups[group]
+		entries := make([]interface{}, 0)
+
+		if id == ">" {
+			for g.lastDelivered < len(stream.entries) && len(entries) < count {
+				entry := stream.entries[g.lastDelivered]
+				g.lastDelivered++
+				g.pending[entry.id] = &memoryPending{consumer: consumer, deliveredAt: time.Now(), deliveries: 1}
+				entries = append(entries, entryReply(entry))
+			}
+
+			if len(entries) == 0 {
+				return nil, nil, true
+			}
+		} else {
+			for _, entry := range stream.entries {
+				if entryNumber(entry.id) <= entryNumber(id) {
+					continue
+				}
+
+				if p, isPending := g.pending[entry.id]; isPending && p.consumer == consumer && len(entries) < count {
+					entries = append(entries, entryReply(entry))
+				}
+			}
+		}
+
+		return []interface{}{[]interface{}{key, entries}}, nil, false
+
+	case "XACK":
+		g := m.streams[strs[1]].groups[strs[2]]
+
+		for _, id := range strs[3:] {
+			delete(g.pending, id)
+		}
+
+		return int64(len(strs) - 3), nil, false
+
+	case "XPENDING":
+		stream := m.streams[strs[1]]
+		g := stream.groups[strs[2]]
+		start := strs[3]
+		count, _ := strconv.Atoi(strs[5])
+		rows := make([]interface{}, 0)
+
+		for _, entry := range stream.entries {
+			if start != "-" && compareEntryIds(entry.id, start) < 0 || len(rows) >= count {
+				continue
+			}
+
+			if p, isPending := g.pending[entry.id]; isPending {
+				idle := int64(time.Since(p.deliveredAt) / time.Millisecond)
+				rows = append(rows, []interface{}{entry.id, p.consumer, idle, p.deliveries})
+			}
+		}
+
+		return rows, nil, false
+
+	case "XCLAIM":
+		stream := m.streams[strs[1]]
+		g := stream.groups[strs[2]]
+		consumer := strs[3]
+		entries := make([]interface{}, 0)
+
+		for _, entry := range stream.entries {
+			for _, id := range strs[5:] {
+				if p, isPending := g.pending[id]; isPending && id == entry.id {
+					p.consumer = consumer
+					p.deliveredAt = time.Now()
+					p.deliveries++
+					entries = append(entries, entryReply(entry))
+				}
+			}
+		}
+
+		return entries, nil, false
+	}
+
+	return nil, errors.New("unknown command " + strs[0]), false
+}
+
+func (m *memoryStreams) hasGroup(key, group string) bool {
+	stream, ok := m.streams[key]
+
+	if !ok {
+		return false
+	}
+
+	_, ok = stream.groups[group]
+
+	return ok
+}
+
+func (m *memoryStreams) exists(key string) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	_, ok := m.streams[key]
+
+	return ok
+}
+
+// Reads without acknowledging, like a consumer crashing while handling
+func (m *memoryStreams) readWithoutAck(key, group, consumer string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.do([]interface{}{"XREADGROUP", "GROUP", group, consumer, "COUNT", 100, "STREAMS", key, ">"})
+}
+
+func (m *memoryStreams) pending(key, group string) int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return len(m.streams[key].groups[group].pending)
+}
+
+type received struct {
+	mutex    sync.Mutex
+	messages []string
+}
+
+func (r *received) callback(msg mq.BrokerMessage) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.messages = append(r.messages, string(msg.Data))
+}
+
+func (r *received) wait(t *testing.T, count int) []string {
+	deadline := time.Now().Add(2 * time.Second)
+
+	for time.Now().Before(deadline) {
+		r.mutex.Lock()
+		length := len(r.messages)
+		r.mutex.Unlock()
+
+		if length >= count {
+			break
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	assert.Len(t, r.messages, count)
+
+	res := make([]string, len(r.messages))
+	copy(res, r.messages)
+
+	return res
+}
+
+func TestStreamsDeliverToEachGroup(t *testing.T) {
+	streams := newMemoryStreams()
+
+	master := newStreamClient(streams.conn(), "arena-master", "master-1")
+	viz := newStreamClient(streams.conn(), "viz-server", "viz-1")
+	publisher := newStreamClient(streams.conn(), "arena-server", "arena-1")
+
+	defer master.Stop()
+	defer viz.Stop()
+	defer publisher.Stop()
+
+	var masterReceived, vizReceived received
+
+	assert.Nil(t, master.Subscribe("game", "stopped", masterReceived.callback))
+	assert.Nil(t, viz.Subscribe("game", "stopped", vizReceived.callback))
+
+	assert.Nil(t, publisher.Publish("game", "stopped", map[string]string{"id": "1"}))
+	assert.Nil(t, publisher.Publish("game", "stopped", map[string]string{"id": "2"}))
+
+	expected := []string{`{"id":"1"}`, `{"id":"2"}`}
+
+	assert.Equal(t, expected, masterReceived.wait(t, 2))
+	assert.Equal(t, expected, vizReceived.wait(t, 2))
+	assert.Equal(t, 0, streams.pending("mq:game.stopped", "arena-master"))
+}
+
+func TestStreamsKeepMessagesWhileConsumerIsDown(t *testing.T) {
+	streams := newMemoryStreams()
+
+	publisher := newStreamClient(streams.conn(), "cli", "cli-1")
+	defer publisher.Stop()
+
+	consumer := newStreamClient(streams.conn(), "arena-master", "master-1")
+	assert.Nil(t, consumer.Subscribe("game", "launch", func(msg mq.BrokerMessage) {}))
+	consumer.Stop()
+
+	assert.Nil(t, publisher.Publish("game", "launch", map[string]string{"id": "42"}))
+
+	var restartedReceived received
+
+	restarted := newStreamClient(streams.conn(), "arena-master", "master-2")
+	defer restarted.Stop()
+
+	assert.Nil(t, restarted.Subscribe("game", "launch", restartedReceived.callback))
+
+	assert.Equal(t, []string{`{"id":"42"}`}, restartedReceived.wait(t, 1))
+}
+
+func TestStreamsRedeliverUnacknowledgedMessages(t *testing.T) {
+	defer func(claimAfter, claimFreq time.Duration) {
+		STREAM_CLAIM_AFTER = claimAfter
+		STREAM_CLAIM_FREQ = claimFreq
+	}(STREAM_CLAIM_AFTER, STREAM_CLAIM_FREQ)
+
+	STREAM_CLAIM_AFTER = 0
+	STREAM_CLAIM_FREQ = 0
+
+	streams := newMemoryStreams()
+	key := "mq:agent.submitted"
+
+	publisher := newStreamClient(streams.conn(), "api", "api-1")
+	defer publisher.Stop()
+
+	// Entries of this consumer are redelivered to it when it restarts
+	first := newStreamClient(streams.conn(), "dotgit", "dotgit-1")
+	assert.Nil(t, first.Subscribe("agent", "submitted", func(msg mq.BrokerMessage) {}))
+	first.Stop()
+
+	assert.Nil(t, publisher.Publish("agent", "submitted", "a"))
+	streams.readWithoutAck(key, "dotgit", "dotgit-1")
+
+	var restartedReceived received
+
+	restarted := newStreamClient(streams.conn(), "dotgit", "dotgit-1")
+	assert.Nil(t, restarted.Subscribe("agent", "submitted", restartedReceived.callback))
+
+	assert.Equal(t, []string{`"a"`}, restartedReceived.wait(t, 1))
+	restarted.Stop()
+
+	// Entries of a consumer which is gone are claimed by another one
+	assert.Nil(t, publisher.Publish("agent", "submitted", "b"))
+	streams.readWithoutAck(key, "dotgit", "dotgit-gone")
+
+	var otherReceived received
+
+	other := newStreamClient(streams.conn(), "dotgit", "dotgit-2")
+	defer other.Stop()
+
+	assert.Nil(t, other.Subscribe("agent", "submitted", otherReceived.callback))
+
+	assert.Equal(t, []string{`"b"`}, otherReceived.wait(t, 1))
+	assert.Equal(t, 0, streams.pending(key, "dotgit"))
+}
+
+// With a single consumer in the group, the entries dropped by the overflow
+// policy are claimed again by the consumer which dropped them
+func TestStreamsRedeliverDroppedMessages(t *testing.T) {
+	defer func(claimAfter, claimFreq time.Duration, maxDeliveries int) {
+		STREAM_CLAIM_AFTER = claimAfter
+		STREAM_CLAIM_FREQ = claimFreq
+		STREAM_MAX_DELIVERIES = maxDeliveries
+	}(STREAM_CLAIM_AFTER, STREAM_CLAIM_FREQ, STREAM_MAX_DELIVERIES)
+
+	STREAM_CLAIM_AFTER = 0
+	STREAM_CLAIM_FREQ = 0
+
+	// The entry is dropped again while the first one is being handled
+	STREAM_MAX_DELIVERIES = 1000000
+
+	streams := newMemoryStreams()
+	key := "mq:game.launch"
+
+	publisher := newStreamClient(streams.conn(), "cli", "cli-1")
+	defer publisher.Stop()
+
+	consumer := newStreamClient(streams.conn(), "arena-master", "master-1")
+	defer consumer.Stop()
+
+	var consumerReceived received
+	release := make(chan bool)
+
+	subscription, err := consumer.SubscribeWithContext(context.Background(), "game", "launch", func(msg mq.BrokerMessage) {
+		if string(msg.Data) == `"a"` {
+			<-release
+		}
+
+		consumerReceived.callback(msg)
+	}, SubscriptionOptions{Workers: 1, BufferSize: 0, Overflow: OVERFLOW_DROP_NEWEST})
+
+	assert.Nil(t, err)
+
+	assert.Nil(t, publisher.Publish("game", "launch", "a"))
+
+	deadline := time.Now().Add(2 * time.Second)
+
+	for streams.pending(key, "arena-master") == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	assert.Nil(t, publisher.Publish("game", "launch", "b"))
+
+	for subscription.Dropped() == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	assert.NotEqual(t, uint64(0), subscription.Dropped())
+
+	close(release)
+
+	assert.Equal(t, []string{`"a"`, `"b"`}, consumerReceived.wait(t, 2))
+
+	for streams.pending(key, "arena-master") > 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	assert.Equal(t, 0, streams.pending(key, "arena-master"))
+}
+
+func TestStreamsDropPoisonMessages(t *testing.T) {
+	defer func(claimAfter, claimFreq time.Duration) {
+		STREAM_CLAIM_AFTER = claimAfter
+		STREAM_CLAIM_FREQ = claimFreq
+	}(STREAM_CLAIM_AFTER, STREAM_CLAIM_FREQ)
+
+	STREAM_CLAIM_AFTER = 0
+	STREAM_CLAIM_FREQ = 0
+
+	streams := newMemoryStreams()
+	key := "mq:agent.submitted"
+
+	publisher := newStreamClient(streams.conn(), "api", "api-1")
+	defer publisher.Stop()
+
+	consumer := newStreamClient(streams.conn(), "dotgit", "dotgit-1")
+	assert.Nil(t, consumer.Subscribe("agent", "submitted", func(msg mq.BrokerMessage) {}))
+	consumer.Stop()
+
+	assert.Nil(t, publisher.Publish("agent", "submitted", "poison"))
+	streams.readWithoutAck(key, "dotgit", "dotgit-gone")
+
+	streams.mutex.Lock()
+	streams.streams[key].groups["dotgit"].pending["1-0"].deliveries = int64(STREAM_MAX_DELIVERIES)
+	streams.mutex.Unlock()
+
+	var otherReceived received
+
+	other := newStreamClient(streams.conn(), "dotgit", "dotgit-2")
+	defer other.Stop()
+
+	assert.Nil(t, other.Subscribe("agent", "submitted", otherReceived.callback))
+
+	deadline := time.Now().Add(2 * time.Second)
+
+	for streams.pending(key, "dotgit") > 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	assert.Equal(t, 0, streams.pending(key, "dotgit"))
+	otherReceived.wait(t, 0)
+}
+
+func TestStreamsVerifySignatures(t *testing.T) {
+	streams := newMemoryStreams()
+
+	publisher := newStreamClient(streams.conn(), "cli", "cli-1")
+	publisher.EnableSigning(masterConfig)
+	defer publisher.Stop()
+
+	forger := newStreamClient(streams.conn(), "cli", "cli-2")
+	forger.EnableSigning(SigningConfig{Component: "arena-master", Key: []byte("guessed")})
+	defer forger.Stop()
+
+	consumer := newStreamClient(streams.conn(), "arena-master", "master-1")
+	consumer.EnableSigning(SigningConfig{Component: "arena-master", Key: []byte("master-key"), Keyring: testKeyring})
+	defer consumer.Stop()
+
+	var consumerReceived received
+
+	assert.Nil(t, consumer.Subscribe("arena", "halt", consumerReceived.callback))
+
+	assert.Nil(t, forger.Publish("arena", "halt", "forged"))
+	assert.Nil(t, publisher.Publish("arena", "halt", "signed"))
+
+	assert.Equal(t, []string{`"signed"`}, consumerReceived.wait(t, 1))
+}
+
+func TestStreamsDeleteTopic(t *testing.T) {
+	defer func(retryAfter time.Duration) { STREAM_RETRY_AFTER = retryAfter }(STREAM_RETRY_AFTER)
+	STREAM_RETRY_AFTER = 10 * time.Millisecond
+
+	streams := newMemoryStreams()
+	key := "mq:game.arena-1.launch"
+
+	master := newStreamClient(streams.conn(), "arena-master", "master-1")
+	defer master.Stop()
+
+	arena := newStreamClient(streams.conn(), "arena-server.arena-1", "arena-1")
+	assert.Nil(t, arena.Subscribe("game", "arena-1.launch", func(msg mq.BrokerMessage) {}))
+
+	assert.Nil(t, master.Publish("game", "arena-1.launch", "launch"))
+	assert.True(t, streams.exists(key))
+
+	// The arena was halted
+	assert.Nil(t, master.DeleteTopic("game", "arena-1.launch"))
+	assert.False(t, streams.exists(key))
+
+	arena.Stop()
+	assert.False(t, streams.exists(key))
+
+	assert.Nil(t, master.DeleteTopic("game", "arena-1.launch"))
+}
+
+func TestParseStreamReplies(t *testing.T) {
+	entries, err := parseStreamsReply([]interface{}{
+		[]interface{}{"mq:game.launch", []interface{}{
+			[]interface{}{"1-0", []interface{}{"message", "{}"}},
+			[]interface{}{[]byte("2-0"), nil},
+		}},
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, []streamEntry{{id: "1-0", payload: []byte("{}")}, {id: "2-0"}}, entries)
+
+	_, err = parseStreamsReply("OK")
+	assert.Error(t, err)
+
+	pending, err := parsePendingReply([]interface{}{
+		[]interface{}{"1-0", "master-1", int64(1200), int64(2)},
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, []pendingEntry{{id: "1-0", consumer: "master-1", idle: 1200, deliveries: 2}}, pending)
+
+	_, err = parsePendingReply([]interface{}{[]interface{}{"1-0"}})
+	assert.Error(t, err)
+}
+
+func TestStreamsClaimEveryPendingEntry(t *testing.T) {
+	defer func(claimAfter, claimFreq time.Duration) {
+		STREAM_CLAIM_AFTER = claimAfter
+		STREAM_CLAIM_FREQ = claimFreq
+	}(STREAM_CLAIM_AFTER, STREAM_CLAIM_FREQ)
+
+	STREAM_CLAIM_AFTER = time.Hour
+	STREAM_CLAIM_FREQ = 0
+
+	streams := newMemoryStreams()
+	key := "mq:agent.submitted"
+
+	publisher := newStreamClient(streams.conn(), "api", "api-1")
+	defer publisher.Stop()
+
+	consumer := newStreamClient(streams.conn(), "dotgit", "dotgit-1")
+	assert.Nil(t, consumer.Subscribe("agent", "submitted", func(msg mq.BrokerMessage) {}))
+	consumer.Stop()
+
+	count := 3*STREAM_BATCH_SIZE + 1
+
+	for i := 0; i < count; i++ {
+		assert.Nil(t, publisher.Publish("agent", "submitted", strconv.Itoa(i)))
+	}
+
+	streams.readWithoutAck(key, "dotgit", "dotgit-gone")
+	assert.Equal(t, count, streams.pending(key, "dotgit"))
+
+	// A whole batch of XPENDING is still being handled by another consumer,
+	// the entries after it are claimable
+	streams.mutex.Lock()
+
+	for i, entry := range streams.streams[key].entries {
+		pending := streams.streams[key].groups["dotgit"].pending[entry.id]
+
+		if i < STREAM_BATCH_SIZE {
+			pending.consumer = "dotgit-busy"
+		} else {
+			pending.deliveredAt = time.Now().Add(-2 * time.Hour)
+		}
+	}
+
+	streams.mutex.Unlock()
+
+	var otherReceived received
+
+	other := newStreamClient(streams.conn(), "dotgit", "dotgit-2")
+	defer other.Stop()
+
+	assert.Nil(t, other.Subscribe("agent", "submitted", otherReceived.callback))
+
+	otherReceived.wait(t, count-STREAM_BATCH_SIZE)
+}
+
+func TestNextStreamId(t *testing.T) {
+	id, err := nextStreamId("1526919030474-55")
+	assert.Nil(t, err)
+	assert.Equal(t, "1526919030474-56", id)
+
+	_, err = nextStreamId("1526919030474")
+	assert.Error(t, err)
+}
@@ -0,0 +1,206 @@
+package mq
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/bytearena/core/common/utils"
+)
+
+// OverflowPolicy is applied to the messages received while the buffer of a
+// subscription is full
+type OverflowPolicy int
+
+const (
+	// Waits for a worker, the following messages of the topic wait too
+	OVERFLOW_BLOCK OverflowPolicy = iota
+
+	// Drops the received message
+	OVERFLOW_DROP_NEWEST
+
+	// Drops the oldest buffered message to make room for the received one
+	OVERFLOW_DROP_OLDEST
+)
+
+type SubscriptionOptions struct {
+	// Number of handlers running at the same time, more than one doesn't
+	// keep the order of the messages
+	Workers int
+
+	// Number of messages waiting for a worker
+	BufferSize int
+
+	Overflow OverflowPolicy
+}
+
+var (
+	// Used by Subscribe, a slow handler doesn't stall the topic until its
+	// buffer is full
+	DEFAULT_SUBSCRIPTION_OPTIONS = SubscriptionOptions{
+		Workers:    1,
+		BufferSize: 64,
+		Overflow:   OVERFLOW_BLOCK,
+	}
+)
+
+// job is a handler waiting for a worker, dropped is called if the overflow
+// policy drops it
+type job struct {
+	run     func()
+	dropped func()
+}
+
+// dispatcher runs the handlers of a subscription on its workers
+type dispatcher struct {
+	name     string
+	overflow OverflowPolicy
+	queue    chan job
+	dropped  uint64
+	stopChan chan struct{}
+	stopOnce sync.Once
+}
+
+func newDispatcher(name string, options SubscriptionOptions) *dispatcher {
+	if options.Workers < 1 {
+		options.Workers = 1
+	}
+
+	if options.BufferSize < 0 {
+		options.BufferSize = 0
+	}
+
+	d := &dispatcher{
+		name:     name,
+		overflow: options.Overflow,
+		queue:    make(chan job, options.BufferSize),
+		stopChan: make(chan struct{}),
+	}
+
+	for i := 0; i < options.Workers; i++ {
+		go d.work()
+	}
+
+	return d
+}
+
+func (d *dispatcher) work() {
+	for {
+		select {
+		case job := <-d.queue:
+			job.run()
+
+		case <-d.stopChan:
+			return
+		}
+	}
+}
+
+func (d *dispatcher) drop(job job) {
+	dropped := atomic.AddUint64(&d.dropped, 1)
+	utils.Debug("mq", "Dropped a message of "+d.name+", "+strconv.FormatUint(dropped, 10)+" so far")
+
+	if job.dropped != nil {
+		job.dropped()
+	}
+}
+
+// push returns false if the job was dropped
+func (d *dispatcher) push(run func()) bool {
+	return d.pushJob(job{run: run})
+}
+
+func (d *dispatcher) pushJob(job job) bool {
+	switch d.overflow {
+	case OVERFLOW_DROP_NEWEST:
+		select {
+		case d.queue <- job:
+			return true
+		case <-d.stopChan:
+			return false
+		default:
+			d.drop(job)
+			return false
+		}
+
+	case OVERFLOW_DROP_OLDEST:
+		for {
+			select {
+			case d.queue <- job:
+				return true
+			case <-d.stopChan:
+				return false
+			default:
+			}
+
+			select {
+			case oldest := <-d.queue:
+				d.drop(oldest)
+			default:
+			}
+		}
+	}
+
+	select {
+	case d.queue <- job:
+		return true
+	case <-d.stopChan:
+		return false
+	}
+}
+
+// stop discards the buffered messages, the running handlers finish
+func (d *dispatcher) stop() {
+	d.stopOnce.Do(func() {
+		close(d.stopChan)
+	})
+}
+
+func (d *dispatcher) isStopped() bool {
+	select {
+	case <-d.stopChan:
+		return true
+	default:
+		return false
+	}
+}
+
+// Subscription is returned by SubscribeWithContext
+type Subscription struct {
+	dispatcher  *dispatcher
+	unsubscribe func()
+	once        sync.Once
+}
+
+// newSubscription unsubscribes when the context is done
+func newSubscription(ctx context.Context, name string, options SubscriptionOptions, unsubscribe func()) *Subscription {
+	s := &Subscription{
+		dispatcher:  newDispatcher(name, options),
+		unsubscribe: unsubscribe,
+	}
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.Unsubscribe()
+		case <-s.dispatcher.stopChan:
+		}
+	}()
+
+	return s
+}
+
+// Unsubscribe stops receiving the messages of the topic, the buffered ones
+// are discarded
+func (s *Subscription) Unsubscribe() {
+	s.once.Do(func() {
+		s.dispatcher.stop()
+		s.unsubscribe()
+	})
+}
+
+// Dropped returns the number of messages dropped by the overflow policy
+func (s *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dispatcher.dropped)
+}
@@ -0,0 +1,242 @@
+package mq
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bytearena/core/common/mq"
+	"github.com/stretchr/testify/assert"
+)
+
+type handledJobs struct {
+	mutex sync.Mutex
+	jobs  []int
+}
+
+func (h *handledJobs) job(id int, release chan struct{}) func() {
+	return func() {
+		if release != nil {
+			<-release
+		}
+
+		h.mutex.Lock()
+		defer h.mutex.Unlock()
+
+		h.jobs = append(h.jobs, id)
+	}
+}
+
+func (h *handledJobs) wait(t *testing.T, count int) []int {
+	deadline := time.Now().Add(2 * time.Second)
+
+	for time.Now().Before(deadline) {
+		h.mutex.Lock()
+		length := len(h.jobs)
+		h.mutex.Unlock()
+
+		if length >= count {
+			break
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	res := make([]int, len(h.jobs))
+	copy(res, h.jobs)
+
+	return res
+}
+
+// The first job blocks the only worker until released
+func fillDispatcher(overflow OverflowPolicy) (*dispatcher, *handledJobs) {
+	d := newDispatcher("test", SubscriptionOptions{Workers: 1, BufferSize: 1, Overflow: overflow})
+	handled := &handledJobs{}
+	release := make(chan struct{})
+
+	d.push(handled.job(1, release))
+
+	// Waits for the worker to take the first job
+	for len(d.queue) > 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	d.push(handled.job(2, nil))
+	d.push(handled.job(3, nil))
+	d.push(handled.job(4, nil))
+
+	close(release)
+
+	return d, handled
+}
+
+func TestDispatcherDropNewest(t *testing.T) {
+	d, handled := fillDispatcher(OVERFLOW_DROP_NEWEST)
+	defer d.stop()
+
+	assert.Equal(t, []int{1, 2}, handled.wait(t, 2))
+	assert.Equal(t, uint64(2), d.dropped)
+}
+
+func TestDispatcherDropOldest(t *testing.T) {
+	d, handled := fillDispatcher(OVERFLOW_DROP_OLDEST)
+	defer d.stop()
+
+	assert.Equal(t, []int{1, 4}, handled.wait(t, 2))
+	assert.Equal(t, uint64(2), d.dropped)
+}
+
+func TestDispatcherBlock(t *testing.T) {
+	d := newDispatcher("test", SubscriptionOptions{Workers: 1, BufferSize: 0, Overflow: OVERFLOW_BLOCK})
+	defer d.stop()
+
+	handled := &handledJobs{}
+	release := make(chan struct{})
+
+	d.push(handled.job(1, release))
+
+	pushed := make(chan struct{})
+
+	go func() {
+		d.push(handled.job(2, nil))
+		close(pushed)
+	}()
+
+	select {
+	case <-pushed:
+		t.Error("Push didn't wait for the worker")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(release)
+	<-pushed
+
+	assert.Equal(t, []int{1, 2}, handled.wait(t, 2))
+	assert.Equal(t, uint64(0), d.dropped)
+}
+
+func TestClientUnsubscribe(t *testing.T) {
+	redis := newMemoryRedis()
+
+	client, err := newClient(redis.dial)
+	assert.Nil(t, err)
+	defer client.Stop()
+
+	var kept, unsubscribed, cancelled received
+
+	_, err = client.SubscribeWithContext(context.Background(), "game", "stopped", kept.callback, DEFAULT_SUBSCRIPTION_OPTIONS)
+	assert.Nil(t, err)
+
+	subscription, err := client.SubscribeWithContext(context.Background(), "game", "stopped", unsubscribed.callback, DEFAULT_SUBSCRIPTION_OPTIONS)
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	_, err = client.SubscribeWithContext(ctx, "game", "stopped", cancelled.callback, DEFAULT_SUBSCRIPTION_OPTIONS)
+	assert.Nil(t, err)
+
+	assert.Nil(t, client.Publish("game", "stopped", "first"))
+
+	unsubscribed.wait(t, 1)
+	cancelled.wait(t, 1)
+
+	subscription.Unsubscribe()
+	cancel()
+
+	// The context is watched asynchronously
+	deadline := time.Now().Add(2 * time.Second)
+
+	for time.Now().Before(deadline) {
+		client.mu.Lock()
+		length := len(client.subscriptions)
+		client.mu.Unlock()
+
+		if length == 1 {
+			break
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	assert.Nil(t, client.Publish("game", "stopped", "second"))
+
+	assert.Equal(t, []string{`"first"`, `"second"`}, kept.wait(t, 2))
+	assert.Equal(t, []string{`"first"`}, unsubscribed.wait(t, 1))
+	assert.Equal(t, []string{`"first"`}, cancelled.wait(t, 1))
+	assert.Equal(t, CONNECTION_UP, client.GetConnectionState())
+}
+
+func TestClientSlowHandlerDropsMessages(t *testing.T) {
+	redis := newMemoryRedis()
+
+	client, err := newClient(redis.dial)
+	assert.Nil(t, err)
+	defer client.Stop()
+
+	started := make(chan struct{}, 8)
+	release := make(chan struct{})
+	var slow received
+
+	subscription, err := client.SubscribeWithContext(context.Background(), "viz", "message", func(msg mq.BrokerMessage) {
+		started <- struct{}{}
+		<-release
+		slow.callback(msg)
+	}, SubscriptionOptions{Workers: 1, BufferSize: 2, Overflow: OVERFLOW_DROP_NEWEST})
+	assert.Nil(t, err)
+
+	var other received
+	assert.Nil(t, client.Subscribe("viz", "other", other.callback))
+
+	// One handled, two buffered and the others dropped
+	assert.Nil(t, client.Publish("viz", "message", 0))
+	<-started
+
+	for i := 1; i < 8; i++ {
+		assert.Nil(t, client.Publish("viz", "message", i))
+	}
+
+	// The other topics are not stalled
+	assert.Nil(t, client.Publish("viz", "other", "fast"))
+	other.wait(t, 1)
+
+	deadline := time.Now().Add(2 * time.Second)
+
+	for subscription.Dropped() < 5 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	close(release)
+
+	assert.Equal(t, []string{"0", "1", "2"}, slow.wait(t, 3))
+	assert.Equal(t, uint64(5), subscription.Dropped())
+}
+
+func TestStreamsUnsubscribe(t *testing.T) {
+	streams := newMemoryStreams()
+
+	publisher := newStreamClient(streams.conn(), "cli", "cli-1")
+	defer publisher.Stop()
+
+	consumer := newStreamClient(streams.conn(), "arena-master", "master-1")
+	defer consumer.Stop()
+
+	var consumerReceived received
+
+	subscription, err := consumer.SubscribeWithContext(context.Background(), "arena", "add", consumerReceived.callback, DEFAULT_SUBSCRIPTION_OPTIONS)
+	assert.Nil(t, err)
+
+	assert.Nil(t, publisher.Publish("arena", "add", "first"))
+	consumerReceived.wait(t, 1)
+
+	subscription.Unsubscribe()
+
+	assert.Nil(t, publisher.Publish("arena", "add", "second"))
+	time.Sleep(50 * time.Millisecond)
+
+	// Kept for the group until a consumer subscribes again
+	assert.Equal(t, []string{`"first"`}, consumerReceived.wait(t, 1))
+}
@@ -3,10 +3,12 @@
 
     ID=$(cat /sys/class/net/eth0/address)
 
+    # The id and the MQ key are provided by the metadata server when set
     /usr/bin/arena-server \
         --mqhost "${MQHOST}" \
         --apiurl "${APIURL}" \
         --id "$ID" \
+        --metadataUrl "${METADATA_URL}" \
         --timeout "${GAME_TIMEOUT}" \
         --registryAddr "${REGISTRY_ADDR}" \
         --arenaAddr "${ARENA_ADDR}"
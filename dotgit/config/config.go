@@ -7,10 +7,14 @@ import (
 	"io/ioutil"
 	"os"
 	"strings"
+
+	"github.com/bytearena/backends/common/graphql"
 )
 
 type Config struct {
 	DatabaseURI         string
+	DatabaseToken       string
+	DatabaseTokenFile   string
 	GitRepositoriesPath string
 	MqHost              string
 
@@ -26,6 +30,29 @@ func (conf Config) GetDatabaseURI() string {
 	return conf.DatabaseURI
 }
 
+// GetDatabaseAuth returns the credentials of the GraphQL API, read from the
+// environment if the config doesn't have them
+func (conf Config) GetDatabaseAuth() (graphql.Authenticator, error) {
+	if conf.DatabaseToken == "" && conf.DatabaseTokenFile == "" {
+		return graphql.AuthenticatorFromEnv()
+	}
+
+	return graphql.MakeAuthenticator(conf.DatabaseToken, conf.DatabaseTokenFile)
+}
+
+// GetDatabaseAuthEnv returns the environment passing the credentials of the
+// GraphQL API to the git hooks
+func (conf Config) GetDatabaseAuthEnv() []string {
+	if conf.DatabaseToken == "" && conf.DatabaseTokenFile == "" {
+		return []string{}
+	}
+
+	return []string{
+		"GRAPHQL_TOKEN=" + conf.DatabaseToken,
+		"GRAPHQL_TOKEN_FILE=" + conf.DatabaseTokenFile,
+	}
+}
+
 func (conf Config) GetGitRepositoriesPath() string {
 	return conf.GitRepositoriesPath
 }
@@ -57,6 +84,10 @@ func loadConfig(configpath string) (*Config, error) {
 		return nil, errors.New("DatabaseURI is missing in config file: " + configpath)
 	}
 
+	if _, err := graphql.MakeAuthenticator(config.DatabaseToken, config.DatabaseTokenFile); err != nil {
+		return nil, errors.New("Invalid DatabaseToken or DatabaseTokenFile in config file: " + configpath + "; " + err.Error())
+	}
+
 	if strings.TrimSpace(config.GetGitRepositoriesPath()) == "" {
 		return nil, errors.New("GitRepositoriesPath is missing in config file: " + configpath)
 	}
@@ -3,91 +3,20 @@ package database
 // test command : go build && DOTGIT_CONFIG=../../dev.conf SSH_ORIGINAL_COMMAND="git-upload-pack 'netgusto/repo-name.git'" ./dotgit-ssh netgusto
 
 import (
-	"encoding/json"
+	"context"
 	"errors"
+	"strconv"
 
 	"github.com/bytearena/backends/common/graphql"
-	graphqltype "github.com/bytearena/backends/common/graphql/types"
-	coretype "github.com/bytearena/core/common/types"
+	"github.com/bytearena/backends/common/graphql/api"
 
 	"github.com/bytearena/backends/dotgit/protocol"
 	"github.com/bytearena/backends/dotgit/utils"
 )
 
-const fragmentUser = `
-fragment userFields on User {
-	id
-	name
-	username
-	email
-	universalReader
-	universalWriter
-}
-`
-
-const fetchUserQuery = fragmentUser + `
-query ($username: String) {
-	users(username: $username) {
-		...userFields
-	}
-}
-`
-
-const fetchRepoQuery = fragmentUser + `
-query ($username: String, $reponame: String, $id: String) {
-	agents(username: $username, name: $reponame, id: $id) {
-		id
-		name
-		gitRepository {
-			cloneURL
-			username
-			name
-			ref
-		}
-		image {
-			name
-			tag
-			registry
-		}
-		owner {
-			...userFields
-		}
-	}
-}
-`
-
-const fetchSSHPublicKeyQuery = fragmentUser + `
-query ($username: String, $fingerprint: String) {
-	sshpublickeys(username: $username, fingerprint: $fingerprint) {
-		owner {
-			...userFields
-		}
-		name
-		type
-		key
-		fingerprint
-		comment
-	}
-}
-`
-
-const createSSHPublicKeyMutation = fragmentUser + `
-mutation ($key: SSHPublicKeyInputCreate!) {
-	createSSHPublicKey(key: $key) {
-		owner {
-			...userFields
-		}
-		name
-		type
-		key
-		fingerprint
-		comment
-	}
-}
-`
-
 type GraphqlDatabase struct {
 	client *graphql.Client
+	auth   graphql.Authenticator
 }
 
 func NewGraphQLDatabase() *GraphqlDatabase {
@@ -95,10 +24,15 @@ func NewGraphQLDatabase() *GraphqlDatabase {
 }
 
 func (db *GraphqlDatabase) Connect(connURI string) error {
-	db.client = graphql.NewClient(connURI)
+	db.client = graphql.NewClient(connURI).SetAuthenticator(db.auth)
 	return nil
 }
 
+// SetAuthenticator sets the credentials of the API, before Connect
+func (db *GraphqlDatabase) SetAuthenticator(auth graphql.Authenticator) {
+	db.auth = auth
+}
+
 func (db *GraphqlDatabase) ActivateDebug() {}
 
 func (db *GraphqlDatabase) Close() {}
@@ -107,112 +41,74 @@ func (db *GraphqlDatabase) Migrate() {}
 
 func (db *GraphqlDatabase) CreateTables() {}
 
-func (db *GraphqlDatabase) findUser(variables graphql.Variables) (protocol.User, error) {
-	data, err := db.client.RequestSync(
-		graphql.NewQuery(fetchUserQuery).SetVariables(variables),
-	)
+func (db *GraphqlDatabase) findUser(variables api.UsersVariables) (protocol.User, error) {
+	response, err := api.Users(context.Background(), db.client, variables)
 
-	if err != nil {
-		return protocol.User{}, errors.New("There was an error fetching user")
-	}
-
-	var apiresponse struct {
-		Users []graphqltype.UserType `json:"users"`
-	}
-	err = json.Unmarshal(data, &apiresponse)
-	if err != nil || len(apiresponse.Users) > 1 {
+	if err != nil || len(response.Users) > 1 {
 		return protocol.User{}, errors.New("There was an error fetching user")
 	}
 
-	if len(apiresponse.Users) == 0 {
+	if len(response.Users) == 0 {
 		return protocol.User{}, errors.New("User not found")
 	}
 
-	apiuser := apiresponse.Users[0]
+	apiuser := response.Users[0]
 
 	return utils.GqlUserToUser(apiuser), nil
 }
 
 func (db *GraphqlDatabase) FindUserByUsername(username string) (protocol.User, error) {
-	return db.findUser(graphql.Variables{"username": username})
+	return db.findUser(api.UsersVariables{Username: graphql.String(username)})
 }
 
 func (db *GraphqlDatabase) FindUserByEmail(email string) (protocol.User, error) {
-	return db.findUser(graphql.Variables{"email": email})
+	return db.findUser(api.UsersVariables{Email: graphql.String(email)})
 }
 
 func (db *GraphqlDatabase) FindRepository(user protocol.User, reponame string) (protocol.GitRepository, error) {
-
-	data, err := db.client.RequestSync(
-		graphql.NewQuery(fetchRepoQuery).SetVariables(graphql.Variables{
-			"username": user.Username,
-			"reponame": reponame,
-		}),
-	)
-
-	if err != nil {
-		return protocol.GitRepository{}, errors.New("There was an error fetching repository; " + err.Error())
-	}
-
-	return processFoundRepository(data)
+	return db.findRepository(api.AgentsVariables{
+		Username: graphql.String(user.Username),
+		Reponame: graphql.String(reponame),
+	})
 }
 
 func (db *GraphqlDatabase) FindRepositoryById(id string) (protocol.GitRepository, error) {
-	data, err := db.client.RequestSync(
-		graphql.NewQuery(fetchRepoQuery).SetVariables(graphql.Variables{
-			"id": id,
-		}),
-	)
+	return db.findRepository(api.AgentsVariables{Id: graphql.String(id)})
+}
+
+func (db *GraphqlDatabase) findRepository(variables api.AgentsVariables) (protocol.GitRepository, error) {
+	response, err := api.Agents(context.Background(), db.client, variables)
 
 	if err != nil {
 		return protocol.GitRepository{}, errors.New("There was an error fetching repository; " + err.Error())
 	}
 
-	return processFoundRepository(data)
-}
-
-func processFoundRepository(data json.RawMessage) (protocol.GitRepository, error) {
-	var apiresponse struct {
-		Agents []coretype.AgentType `json:"agents"`
-	}
-	err := json.Unmarshal(data, &apiresponse)
-	if err != nil || len(apiresponse.Agents) > 1 {
-		return protocol.GitRepository{}, errors.New("There was an error fetching agent; " + err.Error())
+	if len(response.Agents) > 1 {
+		return protocol.GitRepository{}, errors.New("There was an error fetching agent; several agents match")
 	}
 
-	if len(apiresponse.Agents) == 0 {
+	if len(response.Agents) == 0 {
 		return protocol.GitRepository{}, errors.New("Agent not found")
 	}
 
-	apiagent := apiresponse.Agents[0]
+	apiagent := response.Agents[0]
 	return utils.GqlAgentToRepo(apiagent), nil
 }
 
 func (db *GraphqlDatabase) FindPublicKeyByFingerprint(fingerprint string) (protocol.GitPublicKey, error) {
+	response, err := api.SSHPublicKeys(context.Background(), db.client, api.SSHPublicKeysVariables{
+		Fingerprint: graphql.String(fingerprint),
+	})
 
-	data, err := db.client.RequestSync(
-		graphql.NewQuery(fetchSSHPublicKeyQuery).SetVariables(graphql.Variables{
-			"fingerprint": fingerprint,
-		}),
-	)
-
-	if err != nil {
-		return protocol.GitPublicKey{}, errors.New("There was an error fetching public key")
-	}
-
-	var apiresponse struct {
-		SSHPublicKeys []graphqltype.SSHPublicKeyType `json:"sshpublickeys"`
-	}
-	err = json.Unmarshal(data, &apiresponse)
-	if err != nil || len(apiresponse.SSHPublicKeys) > 1 {
+	if err != nil || len(response.Sshpublickeys) > 1 {
 		return protocol.GitPublicKey{}, errors.New("There was an error fetching public key")
 	}
 
-	if len(apiresponse.SSHPublicKeys) == 0 {
+	if len(response.Sshpublickeys) == 0 {
 		return protocol.GitPublicKey{}, errors.New("Public key not found")
 	}
 
-	apipubkey := apiresponse.SSHPublicKeys[0]
+	apipubkey := response.Sshpublickeys[0]
 	return utils.GqlPubKeyToPubKey(apipubkey), nil
 }
 
@@ -227,18 +123,16 @@ func (db *GraphqlDatabase) CreateRepository(repo protocol.GitRepository) error {
 }
 
 func (db *GraphqlDatabase) CreatePublicKey(key protocol.GitPublicKey) error {
-	_, err := db.client.RequestSync(
-		graphql.NewQuery(createSSHPublicKeyMutation).SetVariables(graphql.Variables{
-			"key": graphql.Variables{
-				"ownerid":     key.Owner.ID,
-				"name":        key.KeyName,
-				"type":        key.KeyType,
-				"key":         key.Key,
-				"fingerprint": key.Fingerprint,
-				"comment":     key.Comment,
-			},
-		}),
-	)
+	_, err := api.CreateSSHPublicKey(context.Background(), db.client, api.CreateSSHPublicKeyVariables{
+		Key: api.SSHPublicKeyInputCreate{
+			Ownerid:     strconv.Itoa(int(key.Owner.ID)),
+			Name:        graphql.String(key.KeyName),
+			Type:        graphql.String(key.KeyType),
+			Key:         graphql.String(key.Key),
+			Fingerprint: graphql.String(key.Fingerprint),
+			Comment:     graphql.String(key.Comment),
+		},
+	})
 
 	return err
 }
@@ -0,0 +1,98 @@
+package database
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bytearena/backends/common/graphql"
+	"github.com/bytearena/backends/common/graphql/mock"
+	"github.com/bytearena/backends/dotgit/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+const fixtures = `{
+	"users": [{"id": "1", "username": "jane", "email": "jane@example.com", "universalReader": true}],
+	"agents": [{
+		"id": "10",
+		"name": "seeker",
+		"owner": {"id": "1", "username": "jane"},
+		"gitRepository": {"cloneURL": "git@example.com:jane/seeker.git", "name": "seeker", "ref": "refs/heads/master"}
+	}],
+	"sshpublickeys": [{"owner": {"id": "1", "username": "jane"}, "name": "laptop", "fingerprint": "SHA256:a"}]
+}`
+
+func connect(t *testing.T) (*httptest.Server, *GraphqlDatabase) {
+	records, err := mock.ParseFixtures([]byte(fixtures))
+	assert.Nil(t, err)
+
+	server := httptest.NewServer(mock.NewServer(records).SetToken("secret"))
+
+	db := NewGraphQLDatabase()
+	db.SetAuthenticator(graphql.BearerToken("secret"))
+	assert.Nil(t, db.Connect(server.URL))
+
+	return server, db
+}
+
+func TestFindUser(t *testing.T) {
+	server, db := connect(t)
+	defer server.Close()
+
+	user, err := db.FindUserByUsername("jane")
+	assert.Nil(t, err)
+	assert.Equal(t, uint(1), user.ID)
+	assert.True(t, user.UniversalReader)
+
+	user, err = db.FindUserByEmail("jane@example.com")
+	assert.Nil(t, err)
+	assert.Equal(t, "jane", user.Username)
+
+	_, err = db.FindUserByUsername("john")
+	assert.EqualError(t, err, "User not found")
+}
+
+func TestFindRepository(t *testing.T) {
+	server, db := connect(t)
+	defer server.Close()
+
+	repo, err := db.FindRepository(protocol.User{Username: "jane"}, "seeker")
+	assert.Nil(t, err)
+	assert.Equal(t, protocol.GitRepository{
+		ID:       10,
+		CloneURL: "git@example.com:jane/seeker.git",
+		Ref:      "refs/heads/master",
+		Name:     "seeker",
+		Owner:    protocol.User{ID: 1, Username: "jane"},
+		OwnerID:  1,
+	}, repo)
+
+	repo, err = db.FindRepositoryById("10")
+	assert.Nil(t, err)
+	assert.Equal(t, "seeker", repo.Name)
+
+	_, err = db.FindRepository(protocol.User{Username: "john"}, "seeker")
+	assert.EqualError(t, err, "Agent not found")
+}
+
+func TestPublicKeys(t *testing.T) {
+	server, db := connect(t)
+	defer server.Close()
+
+	key, err := db.FindPublicKeyByFingerprint("SHA256:a")
+	assert.Nil(t, err)
+	assert.Equal(t, "laptop", key.KeyName)
+	assert.Equal(t, 1, key.OwnerID)
+
+	err = db.CreatePublicKey(protocol.GitPublicKey{
+		Owner:       protocol.User{ID: 1},
+		KeyName:     "desktop",
+		KeyType:     "ssh-rsa",
+		Fingerprint: "SHA256:b",
+	})
+	assert.Nil(t, err)
+
+	key, err = db.FindPublicKeyByFingerprint("SHA256:b")
+	assert.Nil(t, err)
+	assert.Equal(t, "desktop", key.KeyName)
+	assert.Equal(t, "jane", key.Owner.Username)
+}
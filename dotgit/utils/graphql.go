@@ -3,13 +3,12 @@ package utils
 import (
 	"strconv"
 
-	graphqltype "github.com/bytearena/backends/common/graphql/types"
-	coretype "github.com/bytearena/core/common/types"
+	"github.com/bytearena/backends/common/graphql/api"
 
 	"github.com/bytearena/backends/dotgit/protocol"
 )
 
-func GqlUserToUser(gqluser graphqltype.UserType) protocol.User {
+func GqlUserToUser(gqluser api.UserFields) protocol.User {
 	intownerid, _ := strconv.Atoi(gqluser.Id)
 
 	return protocol.User{
@@ -22,23 +21,23 @@ func GqlUserToUser(gqluser graphqltype.UserType) protocol.User {
 	}
 }
 
-func GqlAgentToRepo(gqlagent coretype.AgentType) protocol.GitRepository {
+func GqlAgentToRepo(gqlagent api.AgentsResponseAgents) protocol.GitRepository {
 
 	intid, _ := strconv.Atoi(gqlagent.Id)
-	//owner := GqlUserToUser(*gqlagent.Owner)
+	owner := GqlUserToUser(gqlagent.Owner)
 
 	return protocol.GitRepository{
-		ID: uint(intid),
-		// Name:     gqlagent.GitRepository.Name,
-		// CloneURL: gqlagent.GitRepository.CloneURL,
-		// Ref:      gqlagent.GitRepository.Ref,
-		// OwnerID: int(owner.ID),
-		// Owner:   owner,
+		ID:       uint(intid),
+		Name:     gqlagent.GitRepository.Name,
+		CloneURL: gqlagent.GitRepository.CloneURL,
+		Ref:      gqlagent.GitRepository.Ref,
+		OwnerID:  int(owner.ID),
+		Owner:    owner,
 	}
 }
 
-func GqlPubKeyToPubKey(gqlpubkey graphqltype.SSHPublicKeyType) protocol.GitPublicKey {
-	owner := GqlUserToUser(*gqlpubkey.Owner)
+func GqlPubKeyToPubKey(gqlpubkey api.SSHPublicKeysResponseSshpublickeys) protocol.GitPublicKey {
+	owner := GqlUserToUser(gqlpubkey.Owner)
 
 	return protocol.GitPublicKey{
 		OwnerID:     int(owner.ID),